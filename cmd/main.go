@@ -1,14 +1,34 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"wallet_service/internal/adjustment"
+	"wallet_service/internal/audit"
+	"wallet_service/internal/auth"
+	"wallet_service/internal/bonus"
+	"wallet_service/internal/idempotency"
+	"wallet_service/internal/metrics"
+	"wallet_service/internal/payment"
+	"wallet_service/internal/provider"
+	"wallet_service/internal/ratelimit"
 	"wallet_service/internal/wallet"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -29,20 +49,244 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	// Wallet mutations, bonus awards and status changes and admin actions
+	// are recorded in the audit log and sealed into its hash chain every
+	// AUDIT_SEAL_INTERVAL.
+	auditLog := audit.NewPostgresLog(db)
+	sealCtx, stopSealing := context.WithCancel(context.Background())
+	go auditLog.Run(sealCtx, envDuration("AUDIT_SEAL_INTERVAL", audit.DefaultSealInterval))
+
 	//walletrepo
 
-	walletRepo := wallet.NewWalletRepositoryImpl(db)
+	walletRepo := wallet.NewAuditedWalletRepository(db, auditLog)
 	walletService := wallet.NewService(walletRepo)
 
+	//bonus
+
+	bonusRepo := bonus.NewBonusRepository(db)
+	bonusRepo.SetAuditLog(auditLog)
+	bonusService := bonus.NewBonusService(db, bonusRepo)
+	bonusService.SetClockSkewTolerance(envDuration("BET_CLOCK_SKEW", bonus.DefaultClockSkewTolerance))
+	bonusService.SetWallet(walletService)
+	metrics.RegisterHub(bonusService.NotificationHub())
+
+	// NOTIFY_MILESTONES are always announced; other progress updates reach a
+	// player at most once per NOTIFY_THROTTLE.
+	milestones, err := bonus.ParseMilestones(envString("NOTIFY_MILESTONES", "25,50,75,100"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	bonusService.SetNotificationRules(bonus.NotificationRules{
+		Milestones: milestones,
+		Throttle:   envDuration("NOTIFY_THROTTLE", bonus.DefaultNotifyThrottle),
+	})
+
+	timeline := bonus.NewTimelineService(bonus.NewTimelineRepository(db), bonusService)
+	templateRepo := bonus.NewTemplateRepository(db)
+	freeSpins := bonus.NewFreeSpinService(db, bonus.NewFreeSpinRepository(db), templateRepo, bonusService)
+	bonusCodes := bonus.NewBonusCodeService(db, bonus.NewBonusCodeRepository(db), templateRepo, bonusService, freeSpins)
+	walletService.SetDepositHook(bonus.NewDepositMatcher(templateRepo, bonusCodes, bonusService))
+
+	// BONUS_WITHDRAWAL_RULE decides what a main wallet withdrawal does to an
+	// active bonus: forfeit it, block the withdrawal, or allow it.
+	withdrawalPolicy, err := bonus.NewWithdrawalPolicy(bonusService, envString("BONUS_WITHDRAWAL_RULE", bonus.WithdrawalRuleForfeit))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	walletService.SetWithdrawalGuard(withdrawalPolicy)
+
+	settleCtx, stopSettlement := context.WithCancel(context.Background())
+	go freeSpins.RunSettlement(settleCtx, envDuration("FREE_SPIN_SETTLE_INTERVAL", bonus.DefaultFreeSpinSettleInterval))
+
+	// PROGRESS_STORE=redis keeps live wagering counters in Redis and
+	// checkpoints them to Postgres.
+	var redisProgress *bonus.RedisProgressStore
+	if os.Getenv("PROGRESS_STORE") == "redis" {
+		redisAddr := os.Getenv("REDIS_ADDR")
+		if redisAddr == "" {
+			redisAddr = "localhost:6380"
+		}
+		redisProgress = bonus.NewRedisProgressStore(redis.NewClient(&redis.Options{Addr: redisAddr}), db, bonusRepo)
+		bonusService.SetProgressStore(redisProgress)
+	}
+	checkpointCtx, stopCheckpoints := context.WithCancel(context.Background())
+	if redisProgress != nil {
+		go redisProgress.Run(checkpointCtx, envDuration("PROGRESS_CHECKPOINT_INTERVAL", bonus.DefaultCheckpointInterval))
+	}
+
+	// BONUS_REMINDER_WINDOWS are how long before expiry players with
+	// wagering left are reminded, once per window.
+	reminderWindows := bonus.DefaultReminderWindows
+	if spec := os.Getenv("BONUS_REMINDER_WINDOWS"); spec != "" {
+		if reminderWindows, err = bonus.ParseReminderWindows(spec); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	outbox := bonus.NewOutboxRepository(db)
+	expiry := bonus.NewExpiryWatcher(bonusService, outbox, reminderWindows)
+	expiryCtx, stopExpiry := context.WithCancel(context.Background())
+	go expiry.Run(expiryCtx, envDuration("BONUS_EXPIRY_INTERVAL", bonus.DefaultExpiryInterval))
+
+	// OUTBOX_WEBHOOK_URL relays outbox notifications to a delivery
+	// channel; without it they stay in the table for another reader.
+	if url := os.Getenv("OUTBOX_WEBHOOK_URL"); url != "" {
+		relay := bonus.NewOutboxRelay(outbox, bonus.NewWebhookPublisher(url), envInt("OUTBOX_BATCH_SIZE", bonus.DefaultOutboxBatchSize))
+		go relay.Run(expiryCtx, envDuration("OUTBOX_INTERVAL", bonus.DefaultOutboxInterval))
+	}
+
+	// WAGERING_BATCH_WINDOW switches to write-behind batching of progress
+	// updates; by default every bet is its own transaction. Batching writes
+	// to Postgres directly, so it does not apply with the Redis store.
+	var processor bonus.BetProcessor = bonusService
+	var batcher *bonus.WageringBatcher
+	if window := envDuration("WAGERING_BATCH_WINDOW", 0); window > 0 && redisProgress == nil {
+		batcher = bonus.NewWageringBatcher(bonusService, bonus.BatcherConfig{
+			Window:       window,
+			MaxBatchSize: envInt("WAGERING_BATCH_SIZE", bonus.DefaultMaxBatchSize),
+		})
+		processor = batcher
+	}
+
+	deadLetters := bonus.NewDeadLetterQueue(processor, bonus.NewDeadLetterRepository(db))
+
+	ingestor := bonus.NewIngestor(deadLetters, bonus.IngestorConfig{
+		Workers:   envInt("INGEST_WORKERS", bonus.DefaultIngestWorkers),
+		QueueSize: envInt("INGEST_QUEUE_SIZE", bonus.DefaultIngestQueueSize),
+	})
+	ingestor.Start()
+
+	// CASHBACK_TIERS enables cashback on net losses, paid for every closed
+	// CASHBACK_PERIOD.
+	var cashback *bonus.CashbackEngine
+	if spec := os.Getenv("CASHBACK_TIERS"); spec != "" {
+		tiers, err := bonus.ParseCashbackTiers(spec)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		mode := os.Getenv("CASHBACK_MODE")
+		if mode == "" {
+			mode = bonus.CashbackModeWallet
+		}
+		templateID := os.Getenv("CASHBACK_BONUS_TEMPLATE_ID")
+		if templateID != "" {
+			if _, err := uuid.Parse(templateID); err != nil {
+				log.Fatalln("invalid CASHBACK_BONUS_TEMPLATE_ID:", err)
+			}
+		}
+		cashback = bonus.NewCashbackEngine(bonus.NewCashbackRepository(db), bonusService, walletService, bonus.CashbackConfig{
+			Tiers:              tiers,
+			Currency:           envString("CASHBACK_CURRENCY", "USD"),
+			Period:             envDuration("CASHBACK_PERIOD", 7*24*time.Hour),
+			PayoutMode:         mode,
+			WageringMultiplier: envDecimal("CASHBACK_WAGERING_MULTIPLIER", decimal.NewFromInt(1)),
+			BonusValidity:      envDuration("CASHBACK_BONUS_VALIDITY", 7*24*time.Hour),
+			BonusTemplateID:    templateID,
+		})
+	}
+	cashbackCtx, stopCashback := context.WithCancel(context.Background())
+	if cashback != nil {
+		go cashback.RunPeriodic(cashbackCtx, envDuration("CASHBACK_RUN_INTERVAL", time.Hour))
+	}
+
 	r := gin.Default()
+	r.Use(audit.RequestContext())
+
+	// METRICS_ADDR is the listener of /metrics, kept apart from the API so
+	// that only the monitoring network need reach it.
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", metrics.Handler())
+	go func() {
+		if err := http.ListenAndServe(envString("METRICS_ADDR", "localhost:9090"), metricsMux); err != nil {
+			log.Fatal(err)
+		}
+	}()
 
-	r.POST("/transaction", func(c *gin.Context) {
+	// Every route but the signed provider and PSP callbacks needs a JWT
+	// whose roles allow it. AUTH_DISABLED turns the checks off for local
+	// development.
+	verifier, err := openVerifier()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	authz := auth.NewAuthorizer(verifier)
+
+	// Mutating requests with an Idempotency-Key replay their stored
+	// response when retried.
+	idempotent := idempotency.NewMiddleware(idempotency.NewPostgresStore(db), idempotency.Config{
+		TTL:         envDuration("IDEMPOTENCY_TTL", idempotency.DefaultTTL),
+		LockTimeout: envDuration("IDEMPOTENCY_LOCK_TIMEOUT", idempotency.DefaultLockTimeout),
+	})
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	go idempotent.Run(purgeCtx, idempotency.DefaultPurgeInterval)
+
+	api := r.Group("", authz.Authenticate(), idempotent.Handler())
+	readers := []string{auth.RoleOperator, auth.RoleAuditor}
+
+	// RATE_LIMITS overrides the per client and per player token buckets of
+	// the wallet routes; RATE_LIMIT_STORE=redis shares them between
+	// instances.
+	rateLimits, err := ratelimit.ParseLimits(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	var limiter ratelimit.Limiter = ratelimit.NewMemoryLimiter()
+	if os.Getenv("RATE_LIMIT_STORE") == "redis" {
+		limiter = ratelimit.NewRedisLimiter(redis.NewClient(&redis.Options{Addr: envString("REDIS_ADDR", "localhost:6380")}))
+	}
+	limits := ratelimit.NewPolicy(limiter, rateLimits)
+
+	consumeCtx, stopConsumers := context.WithCancel(context.Background())
+	sources, broker, err := openBetSources(consumeCtx, api.Group("", authz.Require(auth.RoleProvider, auth.RoleOperator)), bonus.NewPostgresOffsetStore(db))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, source := range sources {
+		consumer := bonus.NewConsumer(source, ingestor)
+		consumer.SetDeadLetterSink(deadLetters)
+		consumer.SetMaxAttempts(envInt("BET_MAX_ATTEMPTS", bonus.DefaultRedeliveryPolicy.MaxAttempts))
+		go func(name string) {
+			if err := consumer.Run(consumeCtx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("Bet consumer %s stopped: %v", name, err)
+			}
+		}(source.Name())
+	}
+
+	// PSP_BASE_URL moves deposits and withdrawals to the payment provider
+	// flow, where only a verified webhook credits a deposit.
+	var payments *payment.Service
+	if baseURL := os.Getenv("PSP_BASE_URL"); baseURL != "" {
+		webhookSecret := os.Getenv("PSP_WEBHOOK_SECRET")
+		if webhookSecret == "" {
+			log.Fatalln("PSP_WEBHOOK_SECRET is required with PSP_BASE_URL")
+		}
+		psp := payment.NewHTTPProvider(envString("PSP_NAME", "psp"), baseURL, os.Getenv("PSP_API_KEY"), []byte(webhookSecret))
+		payments = payment.NewService(payment.NewPaymentRepository(db), psp, walletService)
+	}
+
+	api.POST("/transaction", authz.Require(auth.RoleProvider, auth.RoleOperator), limits.Route("transaction"), func(c *gin.Context) {
 
 		var req wallet.TransactionRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		// Only bets and wins, and deposits and withdrawals without a PSP,
+		// are posted directly; every other type is written by the service
+		// that owns it
+		switch req.TransactionType {
+		case wallet.TransactionTypeBet, wallet.TransactionTypeWin:
+		case wallet.TransactionTypeDeposit, wallet.TransactionTypeWithdrawal:
+			if payments != nil {
+				c.JSON(http.StatusForbidden, gin.H{"error": "deposits and withdrawals go through /payments"})
+				return
+			}
+		case wallet.TransactionTypeAdjustment:
+			c.JSON(http.StatusForbidden, gin.H{"error": "adjustments need approval through /admin/adjustments"})
+			return
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("transaction_type %q cannot be posted to /transaction", req.TransactionType)})
+			return
+		}
 
 		result, err := walletService.ProcessTransaction(c.Request.Context(), req)
 		if err != nil {
@@ -50,6 +294,14 @@ func main() {
 				c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
 				return
 			}
+			if errors.Is(err, wallet.ErrInvalidAmount) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, wallet.ErrWithdrawalBlocked) || errors.Is(err, wallet.ErrReferenceConflict) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -57,7 +309,7 @@ func main() {
 
 	})
 
-	r.GET("/balance/:player_id", func(c *gin.Context) {
+	api.GET("/balance/:player_id", authz.RequirePlayer("player_id", auth.RoleProvider, auth.RoleOperator, auth.RoleAuditor), limits.Route("balance"), func(c *gin.Context) {
 		playerId := c.Param("player_id")
 		walletType := c.DefaultQuery("type", "main")
 		currency := c.DefaultQuery("currency", "USD")
@@ -75,8 +327,753 @@ func main() {
 
 	})
 
+	api.POST("/bets", authz.Require(auth.RoleProvider, auth.RoleOperator), limits.Route("bets"), func(c *gin.Context) {
+		var bet bonus.BetEvent
+		if err := c.ShouldBindJSON(&bet); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// With the broker source, bets go through its log and are redelivered
+		// until they are applied or dead-lettered.
+		if broker != nil {
+			offset := broker.Publish(bet)
+			c.JSON(http.StatusAccepted, gin.H{"bet_id": bet.BetID, "status": "queued", "offset": offset})
+			return
+		}
+		// Nothing redelivers a bet submitted here, so one that fails is
+		// dead-lettered straight away.
+		err := ingestor.TrySubmitWithCallback(bet, func(procErr error) {
+			if procErr == nil {
+				return
+			}
+			if err := deadLetters.DeadLetter(context.Background(), bet, procErr, 1); err != nil {
+				log.Printf("Failed to dead-letter bet: bet_id=%s: %v", bet.BetID, err)
+			}
+		})
+		if err != nil {
+			if err == bonus.ErrQueueFull {
+				c.Header("Retry-After", "1")
+			}
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"bet_id": bet.BetID, "status": "queued"})
+	})
+
+	api.GET("/bets/stats", authz.Require(readers...), func(c *gin.Context) {
+		c.JSON(http.StatusOK, ingestor.Stats())
+	})
+
+	api.POST("/bonuses/:id/forfeit", func(c *gin.Context) {
+		var req struct {
+			PlayerID string `json:"player_id" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !authz.AllowsPlayer(c, req.PlayerID, auth.RoleOperator) {
+			auth.Forbid(c)
+			return
+		}
+		forfeited, err := bonusService.ForfeitBonus(c.Request.Context(), req.PlayerID, c.Param("id"), "player")
+		if err != nil {
+			switch err {
+			case bonus.ErrBonusNotFound:
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			case bonus.ErrBonusNotActive:
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+		c.JSON(http.StatusOK, forfeited)
+	})
+
+	// Wagering history of a bonus. format=csv exports every event unless a
+	// limit is given.
+	api.GET("/bonuses/:id/events", func(c *gin.Context) {
+		csvExport := c.Query("format") == "csv"
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+		if err != nil || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a non-negative integer"})
+			return
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return
+		}
+		if csvExport && limit == 0 {
+			limit = -1
+		}
+
+		// Authorize before reading any events. Players get 403 for bonuses
+		// that do not exist too, so they cannot probe for other players'.
+		owner, err := timeline.BonusOwner(c.Request.Context(), c.Param("id"))
+		if err != nil && err != bonus.ErrBonusNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !authz.AllowsPlayer(c, owner, readers...) {
+			auth.Forbid(c)
+			return
+		}
+		if err == bonus.ErrBonusNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		events, err := timeline.GetTimeline(c.Request.Context(), c.Param("id"), limit, offset)
+		if err != nil {
+			if err == bonus.ErrBonusNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if csvExport {
+			c.Header("Content-Type", "text/csv")
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=bonus-%s-events.csv", events.PlayerBonusID))
+			if err := bonus.WriteTimelineCSV(c.Writer, events); err != nil {
+				log.Printf("Failed to write wagering events CSV: bonus_id=%s: %v", events.PlayerBonusID, err)
+			}
+			return
+		}
+		c.JSON(http.StatusOK, events)
+	})
+
+	api.GET("/free-spins/:grant_id", func(c *gin.Context) {
+		grant, err := freeSpins.GetGrant(c.Request.Context(), c.Param("grant_id"))
+		if err != nil {
+			freeSpinError(c, err)
+			return
+		}
+		if !authz.AllowsPlayer(c, grant.PlayerID, readers...) {
+			auth.Forbid(c)
+			return
+		}
+		c.JSON(http.StatusOK, grant)
+	})
+
+	// Game provider callback, one per free spin played
+	api.POST("/free-spins/rounds", authz.Require(auth.RoleProvider, auth.RoleOperator), func(c *gin.Context) {
+		var spin bonus.SpinResult
+		if err := c.ShouldBindJSON(&spin); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		grant, err := freeSpins.RecordSpin(c.Request.Context(), spin)
+		if err != nil {
+			freeSpinError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, grant)
+	})
+
+	api.POST("/bonus-codes/redeem", func(c *gin.Context) {
+		var req bonus.RedeemRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !authz.AllowsPlayer(c, req.PlayerID, auth.RoleOperator) {
+			auth.Forbid(c)
+			return
+		}
+		redemption, err := bonusCodes.Redeem(c.Request.Context(), req)
+		if err != nil {
+			bonusCodeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, redemption)
+	})
+
+	// PROVIDER_SECRETS enables the seamless-wallet API that game providers
+	// call with signed requests.
+	if spec := os.Getenv("PROVIDER_SECRETS"); spec != "" {
+		secrets, err := provider.ParseSecrets(spec)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		launchSecret := os.Getenv("PROVIDER_LAUNCH_SECRET")
+		if launchSecret == "" {
+			log.Fatalln("PROVIDER_LAUNCH_SECRET is required with PROVIDER_SECRETS")
+		}
+		var nonces provider.NonceStore = provider.NewMemoryNonceStore()
+		if os.Getenv("PROVIDER_NONCE_STORE") == "redis" {
+			nonces = provider.NewRedisNonceStore(redis.NewClient(&redis.Options{Addr: envString("REDIS_ADDR", "localhost:6380")}))
+		}
+		tokens := provider.NewLaunchTokens([]byte(launchSecret), envDuration("LAUNCH_TOKEN_TTL", provider.DefaultLaunchTokenTTL))
+		providerAPI := provider.NewServer(
+			provider.NewAdapter(walletService, tokens),
+			provider.NewVerifier(secrets, nonces, envDuration("PROVIDER_REPLAY_WINDOW", provider.DefaultReplayWindow)),
+		)
+		r.POST("/provider/:call", limits.Route("provider"), gin.WrapH(providerAPI))
+
+		// Token handed to a game at launch, exchanged by the provider with
+		// an authenticate call
+		api.POST("/games/launch-token", func(c *gin.Context) {
+			var req struct {
+				PlayerID string `json:"player_id" binding:"required"`
+				Currency string `json:"currency" binding:"required"`
+			}
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if !authz.AllowsPlayer(c, req.PlayerID, auth.RoleOperator) {
+				auth.Forbid(c)
+				return
+			}
+			token, err := tokens.Issue(req.PlayerID, req.Currency)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"token": token})
+		})
+	}
+
+	if payments != nil {
+		api.POST("/payments/deposits", limits.Route("payments"), func(c *gin.Context) {
+			var req payment.IntentRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if !authz.AllowsPlayer(c, req.PlayerID, auth.RoleOperator) {
+				auth.Forbid(c)
+				return
+			}
+			intent, err := payments.CreateDeposit(c.Request.Context(), req)
+			if err != nil {
+				paymentError(c, err)
+				return
+			}
+			c.JSON(http.StatusCreated, intent)
+		})
+
+		api.POST("/payments/withdrawals", limits.Route("payments"), func(c *gin.Context) {
+			var req payment.IntentRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if !authz.AllowsPlayer(c, req.PlayerID, auth.RoleOperator) {
+				auth.Forbid(c)
+				return
+			}
+			intent, err := payments.CreatePayout(c.Request.Context(), req)
+			if err != nil {
+				if intent != nil {
+					// Funds are held and the payout may still complete
+					c.JSON(http.StatusAccepted, gin.H{"intent": intent, "error": err.Error()})
+					return
+				}
+				paymentError(c, err)
+				return
+			}
+			c.JSON(http.StatusCreated, intent)
+		})
+
+		api.GET("/payments/:intent_id", func(c *gin.Context) {
+			intent, err := payments.GetIntent(c.Request.Context(), c.Param("intent_id"))
+			if err != nil {
+				paymentError(c, err)
+				return
+			}
+			if !authz.AllowsPlayer(c, intent.PlayerID, readers...) {
+				auth.Forbid(c)
+				return
+			}
+			c.JSON(http.StatusOK, intent)
+		})
+
+		// Called by the PSP; the body is read raw because the signature
+		// covers its exact bytes
+		r.POST("/payments/webhook", func(c *gin.Context) {
+			body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			ctx := audit.WithActor(c.Request.Context(), "psp:"+envString("PSP_NAME", "psp"))
+			intent, err := payments.HandleWebhook(ctx, c.Request.Header, body)
+			if err != nil {
+				paymentError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"intent_id": intent.IntentID, "status": intent.Status})
+		})
+	}
+
+	// Operators run admin actions; auditors may only read
+	admin := api.Group("/admin", authz.Require(readers...), authz.RequireWrite(auth.RoleOperator), audit.AdminActions(auditLog))
+
+	admin.POST("/bonus-codes", func(c *gin.Context) {
+		var code bonus.BonusCode
+		if err := c.ShouldBindJSON(&code); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		code.Active = true
+		code.Redemptions = 0
+		if err := bonusCodes.CreateCode(c.Request.Context(), &code); err != nil {
+			bonusCodeError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, code)
+	})
+
+	admin.GET("/bonus-codes/:code", func(c *gin.Context) {
+		code, err := bonusCodes.GetCode(c.Request.Context(), c.Param("code"))
+		if err != nil {
+			bonusCodeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, code)
+	})
+
+	admin.POST("/free-spins", func(c *gin.Context) {
+		var req struct {
+			GrantID    string `json:"grant_id"`
+			PlayerID   string `json:"player_id" binding:"required"`
+			TemplateID string `json:"template_id" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		grant, err := freeSpins.GrantFreeSpins(c.Request.Context(), req.GrantID, req.PlayerID, req.TemplateID)
+		if err != nil {
+			freeSpinError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, grant)
+	})
+
+	admin.GET("/dead-letters", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		dls, err := deadLetters.List(c.Request.Context(), c.DefaultQuery("status", bonus.DeadLetterStatusPending), c.Query("reason"), limit, offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"dead_letters": dls})
+	})
+
+	admin.GET("/dead-letters/:id", func(c *gin.Context) {
+		dl, err := deadLetters.Get(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			deadLetterError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, dl)
+	})
+
+	admin.PUT("/dead-letters/:id", func(c *gin.Context) {
+		var bet bonus.BetEvent
+		if err := c.ShouldBindJSON(&bet); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		dl, err := deadLetters.Fix(c.Request.Context(), c.Param("id"), bet)
+		if err != nil {
+			deadLetterError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, dl)
+	})
+
+	admin.POST("/dead-letters/:id/replay", func(c *gin.Context) {
+		dl, err := deadLetters.Replay(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			deadLetterError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, dl)
+	})
+
+	admin.POST("/dead-letters/:id/discard", func(c *gin.Context) {
+		dl, err := deadLetters.Discard(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			deadLetterError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, dl)
+	})
+
+	admin.POST("/dead-letters/replay", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		dls, err := deadLetters.ReplayAll(c.Request.Context(), c.Query("reason"), limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "dead_letters": dls})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"dead_letters": dls})
+	})
+
+	admin.GET("/bonus-templates", func(c *gin.Context) {
+		templates, err := templateRepo.ListTemplates(c.Request.Context(), c.DefaultQuery("type", bonus.BonusTypeDepositMatch), c.DefaultQuery("currency", "USD"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"templates": templates})
+	})
+
+	admin.POST("/bonus-templates", func(c *gin.Context) {
+		var template bonus.BonusTemplate
+		if err := c.ShouldBindJSON(&template); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		template.TemplateID = uuid.NewString()
+		template.Active = true
+		if err := templateRepo.CreateTemplate(c.Request.Context(), &template); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, template)
+	})
+
+	// Manual adjustments are requested by one operator and posted only
+	// once a different operator approves them.
+	adjustments := adjustment.NewService(adjustment.NewAdjustmentRepository(db), walletService)
+
+	admin.POST("/adjustments", func(c *gin.Context) {
+		var req adjustment.Request
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		actor := auth.Identify(c)
+		if actor == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "adjustments need an authenticated operator"})
+			return
+		}
+		adj, err := adjustments.RequestAdjustment(c.Request.Context(), actor.Subject, req)
+		if err != nil {
+			adjustmentError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, adj)
+	})
+
+	admin.GET("/adjustments", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		adjs, err := adjustments.ListAdjustments(c.Request.Context(), c.Query("status"), limit, offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"adjustments": adjs})
+	})
+
+	admin.GET("/adjustments/:id", func(c *gin.Context) {
+		adj, err := adjustments.GetAdjustment(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			adjustmentError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, adj)
+	})
+
+	reviewAdjustment := func(review func(ctx context.Context, id string, reviewer string, note string) (*adjustment.Adjustment, error)) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			var req struct {
+				Note string `json:"note"`
+			}
+			if c.Request.ContentLength != 0 {
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+			}
+			actor := auth.Identify(c)
+			if actor == nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "adjustments need an authenticated operator"})
+				return
+			}
+			adj, err := review(c.Request.Context(), c.Param("id"), actor.Subject, req.Note)
+			if err != nil {
+				adjustmentError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, adj)
+		}
+	}
+	admin.POST("/adjustments/:id/approve", reviewAdjustment(adjustments.Approve))
+	admin.POST("/adjustments/:id/reject", reviewAdjustment(adjustments.Reject))
+
+	admin.POST("/cashback/run", func(c *gin.Context) {
+		if cashback == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cashback is not enabled"})
+			return
+		}
+		var req struct {
+			PeriodStart time.Time `json:"period_start" binding:"required"`
+			PeriodEnd   time.Time `json:"period_end" binding:"required"`
+			DryRun      bool      `json:"dry_run"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var payouts []bonus.CashbackPayout
+		var err error
+		if req.DryRun {
+			payouts, err = cashback.Calculate(c.Request.Context(), req.PeriodStart, req.PeriodEnd)
+		} else {
+			payouts, err = cashback.Run(c.Request.Context(), req.PeriodStart, req.PeriodEnd)
+		}
+		if err != nil {
+			if errors.Is(err, bonus.ErrInvalidCashbackPeriod) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "payouts": payouts})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"payouts": payouts, "dry_run": req.DryRun})
+	})
+
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
 	fmt.Println("Server started on :8080")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal(err)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	fmt.Println("Shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+	stopConsumers()
+	stopCashback()
+	stopSettlement()
+	stopExpiry()
+	stopPurge()
+	for _, source := range sources {
+		source.Close()
+	}
+	if err := ingestor.Shutdown(ctx); err != nil {
+		log.Printf("Ingestor did not drain: %v", err)
+	}
+	if batcher != nil {
+		if err := batcher.Flush(ctx); err != nil {
+			log.Printf("Wagering batches not flushed: %v", err)
+		}
+	}
+	stopCheckpoints()
+	if redisProgress != nil {
+		if _, err := redisProgress.Checkpoint(ctx); err != nil {
+			log.Printf("Final wagering checkpoint failed: %v", err)
+		}
+	}
+	stopSealing()
+	if err := auditLog.SealAll(ctx); err != nil {
+		log.Printf("Final audit sealing failed: %v", err)
+	}
+}
+
+func deadLetterError(c *gin.Context, err error) {
+	switch err {
+	case bonus.ErrDeadLetterNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case bonus.ErrDeadLetterNotPending, bonus.ErrDeadLetterBetMismatch:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func freeSpinError(c *gin.Context, err error) {
+	switch err {
+	case bonus.ErrFreeSpinGrantNotFound, bonus.ErrTemplateNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case bonus.ErrInvalidSpin, bonus.ErrNotFreeSpinTemplate:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case bonus.ErrFreeSpinsNotActive, bonus.ErrFreeSpinsExpired, bonus.ErrNoFreeSpinsLeft, bonus.ErrGameNotEligible:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func bonusCodeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, bonus.ErrBonusCodeNotFound), errors.Is(err, bonus.ErrTemplateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, bonus.ErrBonusCodeNotEligible):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, bonus.ErrBonusCodeNotActive), errors.Is(err, bonus.ErrBonusCodeExhausted), errors.Is(err, bonus.ErrBonusCodePlayerLimit):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, bonus.ErrBonusCodeNeedsDeposit):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func paymentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, payment.ErrInvalidRequest), errors.Is(err, payment.ErrInvalidAmount), errors.Is(err, payment.ErrInvalidWebhook):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, payment.ErrInvalidWebhookSignature), errors.Is(err, payment.ErrWebhookExpired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, payment.ErrIntentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, wallet.ErrInsufficientFunds):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	case errors.Is(err, wallet.ErrWithdrawalBlocked), errors.Is(err, wallet.ErrReferenceConflict), errors.Is(err, payment.ErrWebhookMismatch), errors.Is(err, payment.ErrProviderMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, payment.ErrProviderUnavailable):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func adjustmentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, adjustment.ErrInvalidAdjustment):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, adjustment.ErrAdjustmentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, wallet.ErrInsufficientFunds):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	case errors.Is(err, adjustment.ErrAdjustmentNotPending), errors.Is(err, adjustment.ErrSelfApproval), errors.Is(err, wallet.ErrReferenceConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// openBetSources opens the bet event sources listed in BET_SOURCES, a comma
+// separated list of "http", "stdin", "file:<path>" and "broker". The broker
+// is returned as well, so that /bets can publish to it.
+func openBetSources(ctx context.Context, r gin.IRoutes, offsets bonus.OffsetStore) ([]bonus.BetEventSource, *bonus.MemoryBroker, error) {
+	group := os.Getenv("BET_CONSUMER_GROUP")
+	if group == "" {
+		group = "wagering"
+	}
+
+	var sources []bonus.BetEventSource
+	var broker *bonus.MemoryBroker
+	for _, spec := range strings.Split(os.Getenv("BET_SOURCES"), ",") {
+		spec = strings.TrimSpace(spec)
+		switch {
+		case spec == "":
+			continue
+		case spec == "http":
+			src, err := bonus.NewHTTPSource(ctx, "http", offsets, group)
+			if err != nil {
+				return nil, nil, err
+			}
+			r.POST("/bet-events", gin.WrapH(src))
+			sources = append(sources, src)
+		case spec == "stdin":
+			src, err := bonus.NewJSONLinesSource(ctx, "stdin", os.Stdin, offsets, group)
+			if err != nil {
+				return nil, nil, err
+			}
+			sources = append(sources, src)
+		case strings.HasPrefix(spec, "file:"):
+			path := strings.TrimPrefix(spec, "file:")
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			src, err := bonus.NewJSONLinesSource(ctx, spec, f, offsets, group)
+			if err != nil {
+				f.Close()
+				return nil, nil, err
+			}
+			sources = append(sources, src)
+		case spec == "broker":
+			if broker != nil {
+				continue
+			}
+			// The broker log starts empty on every run, so its offsets must
+			// not outlive it either.
+			broker = bonus.NewMemoryBroker("broker")
+			src, err := broker.Subscribe(ctx, bonus.NewMemoryOffsetStore(), group)
+			if err != nil {
+				return nil, nil, err
+			}
+			sources = append(sources, src)
+		default:
+			return nil, nil, fmt.Errorf("unknown bet source %q", spec)
+		}
+	}
+	return sources, broker, nil
+}
+
+// openVerifier builds the JWT verifier from AUTH_JWKS_FILE or AUTH_JWKS_URL.
+// It returns nil, disabling authentication, only with AUTH_DISABLED=true.
+func openVerifier() (*auth.Verifier, error) {
+	var keys auth.KeySet
+	switch {
+	case os.Getenv("AUTH_JWKS_FILE") != "":
+		static, err := auth.LoadKeyFile(os.Getenv("AUTH_JWKS_FILE"))
+		if err != nil {
+			return nil, err
+		}
+		keys = static
+	case os.Getenv("AUTH_JWKS_URL") != "":
+		keys = auth.NewRemoteKeySet(os.Getenv("AUTH_JWKS_URL"), envDuration("AUTH_JWKS_REFRESH", auth.DefaultJWKSRefresh))
+	case os.Getenv("AUTH_DISABLED") == "true":
+		log.Println("Authentication is disabled: every caller has every role")
+		return nil, nil
+	default:
+		return nil, errors.New("AUTH_JWKS_FILE or AUTH_JWKS_URL is required; set AUTH_DISABLED=true to run without authentication")
+	}
+	return auth.NewVerifier(keys, auth.VerifierConfig{
+		Issuer:   os.Getenv("AUTH_ISSUER"),
+		Audience: os.Getenv("AUTH_AUDIENCE"),
+		Leeway:   envDuration("AUTH_LEEWAY", auth.DefaultLeeway),
+	}), nil
+}
+
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envString(name string, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func envDecimal(name string, def decimal.Decimal) decimal.Decimal {
+	if v, err := decimal.NewFromString(os.Getenv(name)); err == nil {
+		return v
+	}
+	return def
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return v
 	}
+	return def
 }
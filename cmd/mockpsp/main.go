@@ -0,0 +1,37 @@
+// Command mockpsp runs the mock payment provider for local development.
+// Point the wallet service at it with PSP_BASE_URL and settle payments by
+// hand:
+//
+//	go run ./cmd/mockpsp -addr :8090 -webhook http://localhost:8080/payments/webhook
+//	curl -X POST 'localhost:8090/payments/<id>/settle?status=succeeded'
+//
+// The API key and webhook secret are read from PSP_API_KEY and
+// PSP_WEBHOOK_SECRET, the same variables the wallet service uses.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"wallet_service/internal/payment"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to listen on")
+	webhook := flag.String("webhook", "http://localhost:8080/payments/webhook", "URL webhooks are posted to")
+	flag.Parse()
+
+	_ = godotenv.Load()
+	secret := os.Getenv("PSP_WEBHOOK_SECRET")
+	if secret == "" {
+		log.Fatalln("PSP_WEBHOOK_SECRET is required")
+	}
+
+	psp := payment.NewMockPSP(os.Getenv("PSP_API_KEY"), []byte(secret), *webhook)
+	fmt.Println("Mock PSP started on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, psp))
+}
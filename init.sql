@@ -42,6 +42,7 @@ CREATE TABLE player_bonus (
     bonus_id UUID NOT NULL,
     status VARCHAR(20) NOT NULL DEFAULT 'active',
     bonus_amount NUMERIC(20, 2) NOT NULL,
+    currency VARCHAR(3) NOT NULL DEFAULT 'USD',
     wagering_required NUMERIC(20, 2) NOT NULL,
     wagering_completed NUMERIC(20, 2) NOT NULL DEFAULT 0,
     expires_at TIMESTAMP NOT NULL,
@@ -73,14 +74,321 @@ CREATE TABLE wagering_events (
     bet_amount NUMERIC(20, 2) NOT NULL,
     contribution_percentage NUMERIC(5, 4) NOT NULL,
     wagering_contribution NUMERIC(20, 2) NOT NULL,
+    placed_at TIMESTAMP NOT NULL DEFAULT NOW(),
     created_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
 CREATE INDEX idx_wagering_events_bonus ON wagering_events(player_bonus_id);
+CREATE INDEX idx_wagering_events_placed ON wagering_events(player_bonus_id, placed_at);
 CREATE INDEX idx_wagering_events_bet ON wagering_events(bet_id);
 
+CREATE TABLE consumer_offsets (
+    consumer VARCHAR(100) NOT NULL,
+    source VARCHAR(255) NOT NULL,
+    committed_offset BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (consumer, source)
+);
+
+CREATE TABLE dead_letter_bets (
+    dead_letter_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    bet_id VARCHAR(255) NOT NULL UNIQUE,
+    player_id VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    error TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    last_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT chk_dead_letter_status CHECK (status IN ('pending', 'replayed', 'discarded'))
+);
+
+CREATE INDEX idx_dead_letter_bets_status ON dead_letter_bets(status, reason);
+CREATE INDEX idx_dead_letter_bets_player ON dead_letter_bets(player_id);
+
+CREATE TABLE bonus_templates (
+    template_id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL,
+    bonus_type VARCHAR(20) NOT NULL,
+    requires_code BOOLEAN NOT NULL DEFAULT FALSE,
+    currency VARCHAR(3) NOT NULL,
+    amount NUMERIC(20, 2) NOT NULL DEFAULT 0,
+    match_percentage NUMERIC(6, 4) NOT NULL DEFAULT 0,
+    max_bonus NUMERIC(20, 2) NOT NULL DEFAULT 0,
+    min_deposit NUMERIC(20, 2) NOT NULL DEFAULT 0,
+    first_deposit_only BOOLEAN NOT NULL DEFAULT FALSE,
+    spin_count INTEGER NOT NULL DEFAULT 0,
+    spin_value NUMERIC(20, 2) NOT NULL DEFAULT 0,
+    eligible_games JSONB,
+    wagering_multiplier NUMERIC(10, 2) NOT NULL,
+    validity_hours INTEGER NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT chk_bonus_template_type CHECK (bonus_type IN ('deposit_match', 'free_spins', 'no_deposit'))
+);
+
+CREATE INDEX idx_bonus_templates_type ON bonus_templates(bonus_type, currency) WHERE active;
+
+CREATE TABLE first_deposit_claims (
+    player_id UUID NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    reference_id VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (player_id, currency)
+);
+
+CREATE TABLE bonus_codes (
+    code VARCHAR(50) PRIMARY KEY,
+    template_id UUID NOT NULL REFERENCES bonus_templates(template_id),
+    max_redemptions INTEGER NOT NULL DEFAULT 0,
+    max_per_player INTEGER NOT NULL DEFAULT 1,
+    redemptions INTEGER NOT NULL DEFAULT 0,
+    valid_from TIMESTAMP,
+    valid_until TIMESTAMP,
+    countries JSONB,
+    currencies JSONB,
+    new_players_only BOOLEAN NOT NULL DEFAULT FALSE,
+    min_deposits INTEGER NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT chk_bonus_code_cap CHECK (max_redemptions = 0 OR redemptions <= max_redemptions)
+);
+
+CREATE TABLE bonus_code_redemptions (
+    redemption_id UUID PRIMARY KEY,
+    code VARCHAR(50) NOT NULL REFERENCES bonus_codes(code),
+    player_id UUID NOT NULL,
+    template_id UUID NOT NULL,
+    bonus_type VARCHAR(20) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    award_id UUID NOT NULL,
+    reference VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_bonus_code_redemptions_player ON bonus_code_redemptions(code, player_id);
+
+CREATE TABLE free_spin_grants (
+    grant_id UUID PRIMARY KEY,
+    player_id UUID NOT NULL,
+    template_id UUID NOT NULL REFERENCES bonus_templates(template_id),
+    currency VARCHAR(3) NOT NULL,
+    spin_count INTEGER NOT NULL,
+    spins_used INTEGER NOT NULL DEFAULT 0,
+    spin_value NUMERIC(20, 2) NOT NULL,
+    eligible_games JSONB NOT NULL,
+    winnings NUMERIC(20, 2) NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    player_bonus_id UUID,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT chk_free_spin_status CHECK (status IN ('active', 'converting', 'completed', 'expired')),
+    CONSTRAINT chk_free_spins_used CHECK (spins_used <= spin_count)
+);
+
+CREATE INDEX idx_free_spin_grants_player ON free_spin_grants(player_id);
+CREATE INDEX idx_free_spin_grants_status ON free_spin_grants(status, expires_at);
+
+CREATE TABLE free_spin_rounds (
+    round_id VARCHAR(255) PRIMARY KEY,
+    grant_id UUID NOT NULL REFERENCES free_spin_grants(grant_id),
+    game_id UUID NOT NULL,
+    win_amount NUMERIC(20, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_free_spin_rounds_grant ON free_spin_rounds(grant_id);
+
+CREATE TABLE cashback_payouts (
+    payout_id UUID PRIMARY KEY,
+    player_id UUID NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    period_end TIMESTAMP NOT NULL,
+    total_bets NUMERIC(20, 2) NOT NULL,
+    total_wins NUMERIC(20, 2) NOT NULL,
+    net_loss NUMERIC(20, 2) NOT NULL,
+    percentage NUMERIC(5, 4) NOT NULL,
+    amount NUMERIC(20, 2) NOT NULL,
+    payout_mode VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    reference VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    paid_at TIMESTAMP,
+    UNIQUE(player_id, currency, period_start, period_end),
+    CONSTRAINT chk_cashback_status CHECK (status IN ('pending', 'paid'))
+);
+
+CREATE INDEX idx_cashback_payouts_period ON cashback_payouts(period_start, period_end);
+
+CREATE TABLE cashback_periods (
+    currency VARCHAR(3) NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    period_end TIMESTAMP NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    error TEXT,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (currency, period_start, period_end),
+    CONSTRAINT chk_cashback_period_status CHECK (status IN ('completed', 'failed'))
+);
+
+CREATE TABLE outbox_notifications (
+    notification_id UUID PRIMARY KEY,
+    dedupe_key VARCHAR(255) NOT NULL UNIQUE,
+    player_id UUID NOT NULL,
+    event_type VARCHAR(30) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    published_at TIMESTAMP
+);
+
+CREATE INDEX idx_outbox_notifications_unpublished ON outbox_notifications(created_at) WHERE published_at IS NULL;
+
+-- Payment tables
+CREATE TABLE payment_intents (
+    intent_id UUID PRIMARY KEY,
+    kind VARCHAR(20) NOT NULL,
+    player_id UUID NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    amount NUMERIC(20, 2) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    provider VARCHAR(50) NOT NULL,
+    provider_reference VARCHAR(255),
+    psp_transaction_id VARCHAR(255),
+    redirect_url TEXT,
+    bonus_code VARCHAR(50),
+    country VARCHAR(2),
+    failure_reason TEXT,
+    wallet_transaction_id UUID,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    CONSTRAINT chk_payment_intent_kind CHECK (kind IN ('deposit', 'payout')),
+    CONSTRAINT chk_payment_intent_status CHECK (status IN ('pending', 'succeeded', 'failed')),
+    CONSTRAINT chk_payment_intent_amount CHECK (amount > 0)
+);
+
+CREATE INDEX idx_payment_intents_player ON payment_intents(player_id, created_at);
+
+CREATE TABLE payment_webhooks (
+    psp_transaction_id VARCHAR(255) PRIMARY KEY,
+    intent_id UUID NOT NULL REFERENCES payment_intents(intent_id),
+    status VARCHAR(20) NOT NULL,
+    payload JSONB NOT NULL,
+    received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_payment_webhooks_intent ON payment_webhooks(intent_id);
+
+CREATE TABLE idempotency_keys (
+    scope VARCHAR(255) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'in_flight',
+    response_status INT,
+    content_type VARCHAR(255),
+    response_body BYTEA,
+    locked_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (scope, idempotency_key),
+    CONSTRAINT chk_idempotency_status CHECK (status IN ('in_flight', 'completed'))
+);
+
+CREATE INDEX idx_idempotency_keys_expires ON idempotency_keys(expires_at);
+
+-- Manual adjustments, posted as 'adjustment' transactions once approved
+CREATE TABLE adjustments (
+    adjustment_id UUID PRIMARY KEY,
+    player_id UUID NOT NULL,
+    wallet_type VARCHAR(20) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    amount NUMERIC(20, 2) NOT NULL,
+    reason_code VARCHAR(30) NOT NULL,
+    justification TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    requested_by VARCHAR(255) NOT NULL,
+    reviewed_by VARCHAR(255),
+    review_note TEXT,
+    transaction_id UUID REFERENCES transactions(transaction_id),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reviewed_at TIMESTAMP,
+    CONSTRAINT chk_adjustment_status CHECK (status IN ('pending', 'approved', 'rejected')),
+    CONSTRAINT chk_adjustment_reason CHECK (reason_code IN ('goodwill', 'chargeback', 'correction')),
+    CONSTRAINT chk_adjustment_amount CHECK (amount <> 0),
+    CONSTRAINT chk_adjustment_reviewer CHECK (reviewed_by IS NULL OR reviewed_by <> requested_by)
+);
+
+CREATE INDEX idx_adjustments_status ON adjustments(status, created_at);
+
+-- Audit log. Entries are inserted with the change they record and sealed
+-- into a hash chain by the service; afterwards they cannot change.
+CREATE TABLE audit_entries (
+    entry_id BIGSERIAL PRIMARY KEY,
+    seq BIGINT UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    source_ip VARCHAR(45) NOT NULL DEFAULT '',
+    request_id VARCHAR(128) NOT NULL DEFAULT '',
+    action VARCHAR(100) NOT NULL,
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    before_value TEXT NOT NULL DEFAULT '',
+    after_value TEXT NOT NULL DEFAULT '',
+    prev_hash VARCHAR(64),
+    hash VARCHAR(64),
+    CONSTRAINT chk_audit_sealed CHECK ((seq IS NULL) = (hash IS NULL) AND (seq IS NULL) = (prev_hash IS NULL))
+);
+
+CREATE INDEX idx_audit_entries_unsealed ON audit_entries(entry_id) WHERE seq IS NULL;
+CREATE INDEX idx_audit_entries_entity ON audit_entries(entity_type, entity_id);
+
+-- Only sealing may update an entry, once; nothing may delete one
+CREATE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.seq IS NULL AND NEW.seq IS NOT NULL
+        AND (NEW.entry_id, NEW.created_at, NEW.actor, NEW.source_ip, NEW.request_id, NEW.action,
+             NEW.entity_type, NEW.entity_id, NEW.before_value, NEW.after_value)
+        IS NOT DISTINCT FROM (OLD.entry_id, OLD.created_at, OLD.actor, OLD.source_ip, OLD.request_id, OLD.action,
+             OLD.entity_type, OLD.entity_id, OLD.before_value, OLD.after_value) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_audit_entries_append_only
+    BEFORE UPDATE OR DELETE ON audit_entries
+    FOR EACH ROW EXECUTE FUNCTION audit_entries_append_only();
+
+CREATE TRIGGER trg_audit_entries_no_truncate
+    BEFORE TRUNCATE ON audit_entries
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_entries_append_only();
+
 -- Seed data for games (for testing)
 INSERT INTO games (game_id, game_name, game_type, contribution) VALUES
     ('11111111-1111-1111-1111-111111111111', 'Slots Game', 'slots', 1.0000),
     ('22222222-2222-2222-2222-222222222222', 'Blackjack', 'table_games', 0.1000),
     ('33333333-3333-3333-3333-333333333333', 'Live Roulette', 'live_casino', 0.5000);
+
+-- Seed data for bonus templates (for testing)
+INSERT INTO bonus_templates (template_id, name, bonus_type, requires_code, currency, match_percentage, max_bonus, min_deposit, first_deposit_only, wagering_multiplier, validity_hours) VALUES
+    ('44444444-4444-4444-4444-444444444444', 'Welcome 100% up to $200', 'deposit_match', FALSE, 'USD', 1.0000, 200.00, 20.00, TRUE, 35.00, 720),
+    ('55555555-5555-5555-5555-555555555555', 'Reload 50% up to $100', 'deposit_match', TRUE, 'USD', 0.5000, 100.00, 20.00, FALSE, 30.00, 168);
+
+INSERT INTO bonus_templates (template_id, name, bonus_type, currency, spin_count, spin_value, eligible_games, wagering_multiplier, validity_hours) VALUES
+    ('66666666-6666-6666-6666-666666666666', '50 Free Spins', 'free_spins', 'USD', 50, 0.20, '["11111111-1111-1111-1111-111111111111"]', 40.00, 72);
+
+INSERT INTO bonus_templates (template_id, name, bonus_type, requires_code, currency, amount, wagering_multiplier, validity_hours) VALUES
+    ('77777777-7777-7777-7777-777777777777', '$10 No Deposit', 'no_deposit', TRUE, 'USD', 10.00, 50.00, 168);
+
+INSERT INTO bonus_codes (code, template_id, max_redemptions, max_per_player, new_players_only, min_deposits) VALUES
+    ('RELOAD50', '55555555-5555-5555-5555-555555555555', 0, 5, FALSE, 1),
+    ('FREE10', '77777777-7777-7777-7777-777777777777', 1000, 1, TRUE, 0);
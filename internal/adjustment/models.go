@@ -0,0 +1,53 @@
+package adjustment
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Adjustment is a manual change to a wallet balance. An operator requests
+// it and a different operator approves it; only then is it posted as an
+// "adjustment" wallet transaction. Adjustments never count towards bonus
+// wagering or trigger deposit bonuses.
+type Adjustment struct {
+	AdjustmentID  string          `gorm:"column:adjustment_id;primaryKey;type:uuid" json:"adjustment_id"`
+	PlayerID      string          `gorm:"column:player_id;type:uuid;not null" json:"player_id"`
+	WalletType    string          `gorm:"column:wallet_type;type:varchar(20);not null" json:"wallet_type"`
+	Currency      string          `gorm:"column:currency;type:varchar(3);not null" json:"currency"`
+	Amount        decimal.Decimal `gorm:"column:amount;type:numeric(20,2);not null" json:"amount"` // positive credits, negative debits
+	ReasonCode    string          `gorm:"column:reason_code;type:varchar(30);not null" json:"reason_code"`
+	Justification string          `gorm:"column:justification;type:text;not null" json:"justification"`
+	Status        string          `gorm:"column:status;type:varchar(20);not null;default:'pending'" json:"status"` // "pending", "approved", "rejected"
+	RequestedBy   string          `gorm:"column:requested_by;type:varchar(255);not null" json:"requested_by"`
+	ReviewedBy    string          `gorm:"column:reviewed_by;type:varchar(255)" json:"reviewed_by,omitempty"`
+	ReviewNote    string          `gorm:"column:review_note;type:text" json:"review_note,omitempty"`
+	TransactionID *string         `gorm:"column:transaction_id;type:uuid" json:"transaction_id,omitempty"` // set once the approved adjustment is posted
+	CreatedAt     time.Time       `gorm:"column:created_at;not null;default:now()" json:"created_at"`
+	ReviewedAt    *time.Time      `gorm:"column:reviewed_at" json:"reviewed_at,omitempty"`
+}
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+)
+
+// Reason codes an adjustment must give.
+const (
+	ReasonGoodwill   = "goodwill"
+	ReasonChargeback = "chargeback"
+	ReasonCorrection = "correction"
+)
+
+var ReasonCodes = []string{ReasonGoodwill, ReasonChargeback, ReasonCorrection}
+
+// Request asks for an adjustment.
+type Request struct {
+	PlayerID      string          `json:"player_id" binding:"required"`
+	WalletType    string          `json:"wallet_type"`
+	Currency      string          `json:"currency" binding:"required"`
+	Amount        decimal.Decimal `json:"amount" binding:"required"`
+	ReasonCode    string          `json:"reason_code" binding:"required"`
+	Justification string          `json:"justification" binding:"required"`
+}
@@ -0,0 +1,136 @@
+package adjustment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAdjustmentNotFound   = errors.New("adjustment not found")
+	ErrAdjustmentNotPending = errors.New("adjustment is not pending")
+	ErrSelfApproval         = errors.New("an adjustment must be approved by someone other than its requester")
+	ErrInvalidAdjustment    = errors.New("invalid adjustment")
+)
+
+type AdjustmentRepository interface {
+	CreateAdjustment(ctx context.Context, adj *Adjustment) error
+	GetAdjustment(ctx context.Context, adjustmentID string) (*Adjustment, error)
+	ListAdjustments(ctx context.Context, status string, limit int, offset int) ([]Adjustment, error)
+	// ClaimApproval moves a pending adjustment to approved by approver. An
+	// approved adjustment that was never posted can be claimed again by the
+	// same approver, to finish an approval that failed halfway.
+	ClaimApproval(ctx context.Context, adjustmentID string, approver string, note string) error
+	// ReleaseApproval returns an approved adjustment that could not be
+	// posted to pending.
+	ReleaseApproval(ctx context.Context, adjustmentID string) error
+	SetTransaction(ctx context.Context, adjustmentID string, transactionID string) error
+	Reject(ctx context.Context, adjustmentID string, reviewer string, note string) error
+}
+
+type AdjustmentRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewAdjustmentRepository(db *gorm.DB) *AdjustmentRepositoryImpl {
+	return &AdjustmentRepositoryImpl{db: db}
+}
+
+func (r *AdjustmentRepositoryImpl) CreateAdjustment(ctx context.Context, adj *Adjustment) error {
+	if err := r.db.WithContext(ctx).Create(adj).Error; err != nil {
+		return fmt.Errorf("failed to create adjustment: %w", err)
+	}
+	return nil
+}
+
+func (r *AdjustmentRepositoryImpl) GetAdjustment(ctx context.Context, adjustmentID string) (*Adjustment, error) {
+	var adj Adjustment
+	err := r.db.WithContext(ctx).Where("adjustment_id = ?", adjustmentID).First(&adj).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAdjustmentNotFound
+		}
+		return nil, fmt.Errorf("failed to get adjustment: %w", err)
+	}
+	return &adj, nil
+}
+
+func (r *AdjustmentRepositoryImpl) ListAdjustments(ctx context.Context, status string, limit int, offset int) ([]Adjustment, error) {
+	var adjs []Adjustment
+	q := r.db.WithContext(ctx).Order("created_at DESC").Limit(limit).Offset(offset)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if err := q.Find(&adjs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list adjustments: %w", err)
+	}
+	return adjs, nil
+}
+
+func (r *AdjustmentRepositoryImpl) ClaimApproval(ctx context.Context, adjustmentID string, approver string, note string) error {
+	res := r.db.WithContext(ctx).
+		Model(&Adjustment{}).
+		Where("adjustment_id = ? AND (status = ? OR (status = ? AND transaction_id IS NULL AND reviewed_by = ?))",
+			adjustmentID, StatusPending, StatusApproved, approver).
+		Updates(map[string]interface{}{
+			"status":      StatusApproved,
+			"reviewed_by": approver,
+			"review_note": note,
+			"reviewed_at": gorm.Expr("NOW()"),
+		})
+	if res.Error != nil {
+		return fmt.Errorf("failed to approve adjustment: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrAdjustmentNotPending
+	}
+	return nil
+}
+
+func (r *AdjustmentRepositoryImpl) ReleaseApproval(ctx context.Context, adjustmentID string) error {
+	err := r.db.WithContext(ctx).
+		Model(&Adjustment{}).
+		Where("adjustment_id = ? AND status = ? AND transaction_id IS NULL", adjustmentID, StatusApproved).
+		Updates(map[string]interface{}{
+			"status":      StatusPending,
+			"reviewed_by": nil,
+			"review_note": nil,
+			"reviewed_at": nil,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to release adjustment approval: %w", err)
+	}
+	return nil
+}
+
+func (r *AdjustmentRepositoryImpl) SetTransaction(ctx context.Context, adjustmentID string, transactionID string) error {
+	err := r.db.WithContext(ctx).
+		Model(&Adjustment{}).
+		Where("adjustment_id = ?", adjustmentID).
+		Update("transaction_id", transactionID).Error
+	if err != nil {
+		return fmt.Errorf("failed to record adjustment transaction: %w", err)
+	}
+	return nil
+}
+
+func (r *AdjustmentRepositoryImpl) Reject(ctx context.Context, adjustmentID string, reviewer string, note string) error {
+	res := r.db.WithContext(ctx).
+		Model(&Adjustment{}).
+		Where("adjustment_id = ? AND status = ?", adjustmentID, StatusPending).
+		Updates(map[string]interface{}{
+			"status":      StatusRejected,
+			"reviewed_by": reviewer,
+			"review_note": note,
+			"reviewed_at": gorm.Expr("NOW()"),
+		})
+	if res.Error != nil {
+		return fmt.Errorf("failed to reject adjustment: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrAdjustmentNotPending
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package adjustment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+)
+
+// Wallet is the part of wallet.Service that posts adjustments.
+type Wallet interface {
+	ProcessTransaction(ctx context.Context, req wallet.TransactionRequest) (*wallet.TransactionResponse, error)
+}
+
+// Service runs the maker-checker workflow of manual balance adjustments.
+type Service struct {
+	repo   AdjustmentRepository
+	wallet Wallet
+}
+
+func NewService(repo AdjustmentRepository, w Wallet) *Service {
+	return &Service{repo: repo, wallet: w}
+}
+
+// ReferenceID is the wallet reference an adjustment is posted under.
+func ReferenceID(adjustmentID string) string {
+	return "adjustment:" + adjustmentID
+}
+
+// RequestAdjustment records a pending adjustment requested by requester.
+// Nothing moves until another operator approves it.
+func (s *Service) RequestAdjustment(ctx context.Context, requester string, req Request) (*Adjustment, error) {
+	if err := validate(requester, req); err != nil {
+		return nil, err
+	}
+	if req.WalletType == "" {
+		req.WalletType = wallet.WalletTypeMain
+	}
+	adj := &Adjustment{
+		AdjustmentID:  uuid.NewString(),
+		PlayerID:      req.PlayerID,
+		WalletType:    req.WalletType,
+		Currency:      req.Currency,
+		Amount:        req.Amount,
+		ReasonCode:    req.ReasonCode,
+		Justification: strings.TrimSpace(req.Justification),
+		Status:        StatusPending,
+		RequestedBy:   requester,
+	}
+	if err := s.repo.CreateAdjustment(ctx, adj); err != nil {
+		return nil, err
+	}
+	log.Printf("Adjustment requested: adjustment=%s player=%s amount=%s %s reason=%s by=%s",
+		adj.AdjustmentID, adj.PlayerID, adj.Amount, adj.Currency, adj.ReasonCode, requester)
+	return adj, nil
+}
+
+func (s *Service) GetAdjustment(ctx context.Context, adjustmentID string) (*Adjustment, error) {
+	return s.repo.GetAdjustment(ctx, adjustmentID)
+}
+
+func (s *Service) ListAdjustments(ctx context.Context, status string, limit int, offset int) ([]Adjustment, error) {
+	return s.repo.ListAdjustments(ctx, status, limit, offset)
+}
+
+// Approve posts a pending adjustment. The approver must not be the
+// requester. If the wallet refuses the adjustment, e.g. a debit larger than
+// the balance, it goes back to pending and can be rejected.
+func (s *Service) Approve(ctx context.Context, adjustmentID string, approver string, note string) (*Adjustment, error) {
+	if approver == "" {
+		return nil, fmt.Errorf("%w: approver is required", ErrInvalidAdjustment)
+	}
+	adj, err := s.repo.GetAdjustment(ctx, adjustmentID)
+	if err != nil {
+		return nil, err
+	}
+	if adj.RequestedBy == approver {
+		return nil, ErrSelfApproval
+	}
+	if err := s.repo.ClaimApproval(ctx, adjustmentID, approver, note); err != nil {
+		return nil, err
+	}
+
+	res, err := s.wallet.ProcessTransaction(ctx, wallet.TransactionRequest{
+		PlayerID:        adj.PlayerID,
+		WalletType:      adj.WalletType,
+		TransactionType: wallet.TransactionTypeAdjustment,
+		Amount:          adj.Amount,
+		ReferenceID:     ReferenceID(adj.AdjustmentID),
+		Currency:        adj.Currency,
+	})
+	if err != nil {
+		if errors.Is(err, wallet.ErrInsufficientFunds) || errors.Is(err, wallet.ErrReferenceConflict) {
+			if releaseErr := s.repo.ReleaseApproval(ctx, adjustmentID); releaseErr != nil {
+				log.Printf("Failed to release adjustment approval: adjustment=%s: %v", adjustmentID, releaseErr)
+			}
+		}
+		// Otherwise the approval stays claimed and the approver retries
+		return nil, err
+	}
+	if err := s.repo.SetTransaction(ctx, adjustmentID, res.TransactionID); err != nil {
+		return nil, err
+	}
+	log.Printf("Adjustment approved: adjustment=%s player=%s amount=%s %s requested_by=%s approved_by=%s transaction=%s",
+		adj.AdjustmentID, adj.PlayerID, adj.Amount, adj.Currency, adj.RequestedBy, approver, res.TransactionID)
+	return s.repo.GetAdjustment(ctx, adjustmentID)
+}
+
+// Reject closes a pending adjustment without posting it. Requesters may
+// withdraw their own requests.
+func (s *Service) Reject(ctx context.Context, adjustmentID string, reviewer string, note string) (*Adjustment, error) {
+	if reviewer == "" {
+		return nil, fmt.Errorf("%w: reviewer is required", ErrInvalidAdjustment)
+	}
+	if err := s.repo.Reject(ctx, adjustmentID, reviewer, note); err != nil {
+		if errors.Is(err, ErrAdjustmentNotPending) {
+			if _, getErr := s.repo.GetAdjustment(ctx, adjustmentID); getErr != nil {
+				return nil, getErr
+			}
+		}
+		return nil, err
+	}
+	log.Printf("Adjustment rejected: adjustment=%s by=%s", adjustmentID, reviewer)
+	return s.repo.GetAdjustment(ctx, adjustmentID)
+}
+
+func validate(requester string, req Request) error {
+	if requester == "" {
+		return fmt.Errorf("%w: requester is required", ErrInvalidAdjustment)
+	}
+	if _, err := uuid.Parse(req.PlayerID); err != nil {
+		return fmt.Errorf("%w: player_id must be a UUID", ErrInvalidAdjustment)
+	}
+	if req.Currency == "" {
+		return fmt.Errorf("%w: currency is required", ErrInvalidAdjustment)
+	}
+	if req.Amount.IsZero() || req.Amount.Exponent() < -2 {
+		return fmt.Errorf("%w: amount must be non-zero with at most two decimals", ErrInvalidAdjustment)
+	}
+	if req.WalletType != "" && req.WalletType != wallet.WalletTypeMain && req.WalletType != wallet.WalletTypeBonus {
+		return fmt.Errorf("%w: unknown wallet_type %q", ErrInvalidAdjustment, req.WalletType)
+	}
+	valid := false
+	for _, code := range ReasonCodes {
+		valid = valid || req.ReasonCode == code
+	}
+	if !valid {
+		return fmt.Errorf("%w: reason_code must be one of %s", ErrInvalidAdjustment, strings.Join(ReasonCodes, ", "))
+	}
+	if strings.TrimSpace(req.Justification) == "" {
+		return fmt.Errorf("%w: justification is required", ErrInvalidAdjustment)
+	}
+	return nil
+}
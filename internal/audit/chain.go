@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrChainBroken = errors.New("audit chain broken")
+
+// Chain verifies sealed entries fed to it in sequence order. Any edited,
+// removed or reordered entry breaks it.
+type Chain struct {
+	seq    int64
+	head   string
+	anchor *anchor
+}
+
+type anchor struct {
+	seq     int64
+	hash    string
+	reached bool
+}
+
+func NewChain() *Chain {
+	return &Chain{head: GenesisHash}
+}
+
+// Expect requires the entry at seq to have hash, such as a head hash
+// recorded earlier outside the database. This catches a chain rewritten
+// from scratch or cut short.
+func (c *Chain) Expect(seq int64, hash string) {
+	c.anchor = &anchor{seq: seq, hash: hash}
+}
+
+// Add checks the next entry.
+func (c *Chain) Add(e AuditEntry) error {
+	next := c.seq + 1
+	switch {
+	case e.Seq == nil || e.PrevHash == nil || e.Hash == nil:
+		return fmt.Errorf("%w: entry %d is not sealed", ErrChainBroken, e.EntryID)
+	case *e.Seq != next:
+		return fmt.Errorf("%w: expected seq %d, found %d", ErrChainBroken, next, *e.Seq)
+	case *e.PrevHash != c.head:
+		return fmt.Errorf("%w: seq %d does not follow seq %d", ErrChainBroken, next, c.seq)
+	case *e.Hash != e.ComputeHash(next, c.head):
+		return fmt.Errorf("%w: seq %d was modified", ErrChainBroken, next)
+	}
+	if c.anchor != nil && c.anchor.seq == next {
+		if *e.Hash != c.anchor.hash {
+			return fmt.Errorf("%w: seq %d does not match the expected hash", ErrChainBroken, next)
+		}
+		c.anchor.reached = true
+	}
+	c.seq, c.head = next, *e.Hash
+	return nil
+}
+
+// Finish reports whether an expected entry was never reached.
+func (c *Chain) Finish() error {
+	if c.anchor != nil && !c.anchor.reached {
+		return fmt.Errorf("%w: chain ends at seq %d before expected seq %d", ErrChainBroken, c.seq, c.anchor.seq)
+	}
+	return nil
+}
+
+// Head returns the last verified seq and its hash.
+func (c *Chain) Head() (int64, string) {
+	return c.seq, c.head
+}
+
+// VerifyChain checks a complete chain.
+func VerifyChain(entries []AuditEntry) error {
+	chain := NewChain()
+	for _, e := range entries {
+		if err := chain.Add(e); err != nil {
+			return err
+		}
+	}
+	return chain.Finish()
+}
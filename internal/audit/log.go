@@ -0,0 +1,203 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultSealInterval = time.Second
+	DefaultSealBatch    = 500
+
+	// sealLockKey is the advisory lock that keeps sealers of several
+	// instances from extending the chain at the same time.
+	sealLockKey = 74301182049
+)
+
+// PostgresLog keeps the audit log in audit_entries. The table only accepts
+// inserts and the one update that seals an entry; see init.sql.
+type PostgresLog struct {
+	db *gorm.DB
+}
+
+func NewPostgresLog(db *gorm.DB) *PostgresLog {
+	return &PostgresLog{db: db}
+}
+
+func (l *PostgresLog) Record(ctx context.Context, tx *gorm.DB, event Event) error {
+	entry, err := NewEntry(ctx, event)
+	if err != nil {
+		return err
+	}
+	if tx == nil {
+		tx = l.db
+	}
+	if err := tx.WithContext(ctx).Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// Seal chains up to limit unsealed entries after the current head and
+// returns how many it sealed. Entries are sealed in the order they were
+// inserted as far as they have committed; a later one is sealed next time.
+func (l *PostgresLog) Seal(ctx context.Context, limit int) (int, error) {
+	sealed := 0
+	err := l.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", sealLockKey).Error; err != nil {
+			return err
+		}
+		var heads []AuditEntry
+		if err := tx.Where("seq IS NOT NULL").Order("seq DESC").Limit(1).Find(&heads).Error; err != nil {
+			return err
+		}
+		seq, head := int64(0), GenesisHash
+		if len(heads) == 1 {
+			seq, head = *heads[0].Seq, *heads[0].Hash
+		}
+
+		var pending []AuditEntry
+		if err := tx.Where("seq IS NULL").Order("entry_id").Limit(limit).Find(&pending).Error; err != nil {
+			return err
+		}
+		for i := range pending {
+			seq++
+			pending[i].seal(seq, head)
+			err := tx.Model(&AuditEntry{}).
+				Where("entry_id = ? AND seq IS NULL", pending[i].EntryID).
+				Updates(map[string]interface{}{
+					"seq":       seq,
+					"prev_hash": head,
+					"hash":      *pending[i].Hash,
+				}).Error
+			if err != nil {
+				return err
+			}
+			head = *pending[i].Hash
+		}
+		sealed = len(pending)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to seal audit entries: %w", err)
+	}
+	return sealed, nil
+}
+
+// Run seals new entries every interval until ctx is cancelled.
+func (l *PostgresLog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.SealAll(ctx); err != nil {
+				log.Printf("Audit sealing failed: %v", err)
+			}
+		}
+	}
+}
+
+// SealAll seals entries until none are left.
+func (l *PostgresLog) SealAll(ctx context.Context) error {
+	for {
+		n, err := l.Seal(ctx, DefaultSealBatch)
+		if err != nil || n < DefaultSealBatch {
+			return err
+		}
+	}
+}
+
+// Verify feeds every sealed entry to chain in sequence order and stops at
+// the first that breaks it.
+func (l *PostgresLog) Verify(ctx context.Context, chain *Chain) error {
+	last := int64(0)
+	for {
+		var batch []AuditEntry
+		err := l.db.WithContext(ctx).
+			Where("seq IS NOT NULL AND seq > ?", last).
+			Order("seq").
+			Limit(DefaultSealBatch).
+			Find(&batch).Error
+		if err != nil {
+			return fmt.Errorf("failed to read audit entries: %w", err)
+		}
+		for _, e := range batch {
+			if err := chain.Add(e); err != nil {
+				return err
+			}
+			last = *e.Seq
+		}
+		if len(batch) < DefaultSealBatch {
+			return chain.Finish()
+		}
+	}
+}
+
+// Unsealed returns how many entries wait for sealing and when the oldest
+// was recorded.
+func (l *PostgresLog) Unsealed(ctx context.Context) (int64, *time.Time, error) {
+	var res struct {
+		Count  int64
+		Oldest *time.Time
+	}
+	err := l.db.WithContext(ctx).
+		Model(&AuditEntry{}).
+		Select("COUNT(*) AS count, MIN(created_at) AS oldest").
+		Where("seq IS NULL").
+		Scan(&res).Error
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to count unsealed audit entries: %w", err)
+	}
+	return res.Count, res.Oldest, nil
+}
+
+// MemoryLog keeps the audit log in process memory, for tests.
+type MemoryLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+}
+
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{}
+}
+
+func (l *MemoryLog) Record(ctx context.Context, tx *gorm.DB, event Event) error {
+	entry, err := NewEntry(ctx, event)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.EntryID = int64(len(l.entries) + 1)
+	l.entries = append(l.entries, *entry)
+	return nil
+}
+
+// Seal chains every unsealed entry.
+func (l *MemoryLog) Seal() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq, head := int64(0), GenesisHash
+	for i := range l.entries {
+		e := &l.entries[i]
+		if e.Seq == nil {
+			e.seal(seq+1, head)
+		}
+		seq, head = *e.Seq, *e.Hash
+	}
+}
+
+// Entries returns a copy of the log.
+func (l *MemoryLog) Entries() []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]AuditEntry(nil), l.entries...)
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	HeaderRequestID = "X-Request-ID"
+
+	maxRequestIDLength = 128
+	maxRecordedBody    = 64 << 10
+)
+
+// RequestContext gives every request an ID, taken from X-Request-ID when
+// the caller sent a usable one, echoes it back and attaches it and the
+// client IP to the request context for audit entries.
+func RequestContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(HeaderRequestID)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.NewString()
+		}
+		c.Header(HeaderRequestID, requestID)
+		c.Request = c.Request.WithContext(WithRequest(c.Request.Context(), requestID, c.ClientIP()))
+		c.Next()
+	}
+}
+
+// AdminActions records every mutating request that reaches the routes it
+// guards, with its body and the status it was answered with. Changes the
+// request makes are recorded by the services as well.
+func AdminActions(rec Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxRecordedBody+1))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+
+		c.Next()
+
+		request := map[string]interface{}{"query": c.Request.URL.RawQuery}
+		switch {
+		case len(body) > maxRecordedBody:
+			request["body_truncated"] = string(body[:maxRecordedBody])
+		case json.Valid(body):
+			request["body"] = json.RawMessage(body)
+		case len(body) > 0:
+			request["body"] = string(body)
+		}
+		event := Event{
+			Action:     "admin." + c.Request.Method + " " + c.FullPath(),
+			EntityType: "admin_request",
+			EntityID:   c.Request.URL.Path,
+			Before:     request,
+			After:      map[string]int{"status": c.Writer.Status()},
+		}
+		if err := rec.Record(context.WithoutCancel(c.Request.Context()), nil, event); err != nil {
+			log.Printf("Admin action not audited: %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+		}
+	}
+}
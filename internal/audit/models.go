@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// GenesisHash is the previous hash of the first entry of the chain.
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// AuditEntry is one record of the audit log. It is inserted together with
+// the change it describes and sealed into the hash chain shortly after;
+// Seq, PrevHash and Hash stay empty until then. Before and After hold JSON
+// exactly as it was hashed.
+type AuditEntry struct {
+	EntryID    int64     `gorm:"column:entry_id;primaryKey;autoIncrement" json:"entry_id"`
+	Seq        *int64    `gorm:"column:seq" json:"seq,omitempty"`
+	CreatedAt  time.Time `gorm:"column:created_at;not null" json:"created_at"`
+	Actor      string    `gorm:"column:actor;type:varchar(255);not null" json:"actor"`
+	SourceIP   string    `gorm:"column:source_ip;type:varchar(45);not null" json:"source_ip"`
+	RequestID  string    `gorm:"column:request_id;type:varchar(128);not null" json:"request_id"`
+	Action     string    `gorm:"column:action;type:varchar(100);not null" json:"action"` // e.g. "wallet.deposit", "bonus.status", "admin.POST /admin/adjustments"
+	EntityType string    `gorm:"column:entity_type;type:varchar(50);not null" json:"entity_type"`
+	EntityID   string    `gorm:"column:entity_id;type:varchar(255);not null" json:"entity_id"`
+	Before     string    `gorm:"column:before_value;type:text;not null" json:"before,omitempty"`
+	After      string    `gorm:"column:after_value;type:text;not null" json:"after,omitempty"`
+	PrevHash   *string   `gorm:"column:prev_hash;type:varchar(64)" json:"prev_hash,omitempty"`
+	Hash       *string   `gorm:"column:hash;type:varchar(64)" json:"hash,omitempty"`
+}
+
+// ComputeHash is the hash the entry gets at position seq after prevHash.
+func (e *AuditEntry) ComputeHash(seq int64, prevHash string) string {
+	canonical, _ := json.Marshal(struct {
+		Seq        int64  `json:"seq"`
+		PrevHash   string `json:"prev_hash"`
+		EntryID    int64  `json:"entry_id"`
+		CreatedAt  string `json:"created_at"`
+		Actor      string `json:"actor"`
+		SourceIP   string `json:"source_ip"`
+		RequestID  string `json:"request_id"`
+		Action     string `json:"action"`
+		EntityType string `json:"entity_type"`
+		EntityID   string `json:"entity_id"`
+		Before     string `json:"before"`
+		After      string `json:"after"`
+	}{
+		seq, prevHash, e.EntryID, e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.Actor, e.SourceIP, e.RequestID, e.Action, e.EntityType, e.EntityID, e.Before, e.After,
+	})
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// seal links the entry into the chain at seq after prevHash.
+func (e *AuditEntry) seal(seq int64, prevHash string) {
+	hash := e.ComputeHash(seq, prevHash)
+	e.Seq, e.PrevHash, e.Hash = &seq, &prevHash, &hash
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	DefaultJWKSRefresh = 15 * time.Minute
+
+	// minJWKSRefetch limits how often an unknown key ID makes RemoteKeySet
+	// fetch the endpoint again, so forged kids cannot hammer it.
+	minJWKSRefetch = 30 * time.Second
+)
+
+var ErrUnknownKey = errors.New("no verification key for token")
+
+// KeySet supplies the public keys tokens are verified with.
+type KeySet interface {
+	// Keys returns the keys with the given key ID, or every key when kid
+	// is empty.
+	Keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error)
+}
+
+// StaticKeySet is a key set read once, e.g. from a local file.
+type StaticKeySet struct {
+	keys jose.JSONWebKeySet
+}
+
+// LoadKeyFile reads a JWKS document, or a single JWK, from path. Private
+// keys are reduced to their public half.
+func LoadKeyFile(path string) (*StaticKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	set, err := parseKeySet(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+	return &StaticKeySet{keys: *set}, nil
+}
+
+func NewStaticKeySet(keys ...jose.JSONWebKey) *StaticKeySet {
+	return &StaticKeySet{keys: jose.JSONWebKeySet{Keys: publicKeys(keys)}}
+}
+
+func (s *StaticKeySet) Keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	return findKeys(&s.keys, kid)
+}
+
+// RemoteKeySet fetches keys from a JWKS endpoint and caches them for the
+// refresh interval. A token signed with a key ID that is not cached makes
+// it fetch again early, which picks up rotated keys.
+type RemoteKeySet struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+
+	mu        sync.Mutex
+	keys      *jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+func NewRemoteKeySet(url string, refresh time.Duration) *RemoteKeySet {
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	return &RemoteKeySet{url: url, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *RemoteKeySet) Keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	age := time.Since(s.fetchedAt)
+	if s.keys == nil || age > s.refresh {
+		if err := s.fetch(ctx); err != nil {
+			if s.keys == nil {
+				return nil, err
+			}
+			// Keep serving the cached keys while the endpoint is down
+			return findKeys(s.keys, kid)
+		}
+		return findKeys(s.keys, kid)
+	}
+
+	keys, err := findKeys(s.keys, kid)
+	if errors.Is(err, ErrUnknownKey) && age > minJWKSRefetch {
+		if err := s.fetch(ctx); err != nil {
+			return nil, err
+		}
+		return findKeys(s.keys, kid)
+	}
+	return keys, err
+}
+
+func (s *RemoteKeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: %s", res.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	set, err := parseKeySet(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+	s.keys = set
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+func parseKeySet(data []byte) (*jose.JSONWebKeySet, error) {
+	var set jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &set); err == nil && len(set.Keys) > 0 {
+		set.Keys = publicKeys(set.Keys)
+		return &set, nil
+	}
+	var key jose.JSONWebKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	if !key.Valid() {
+		return nil, errors.New("no keys found")
+	}
+	return &jose.JSONWebKeySet{Keys: publicKeys([]jose.JSONWebKey{key})}, nil
+}
+
+func publicKeys(keys []jose.JSONWebKey) []jose.JSONWebKey {
+	public := make([]jose.JSONWebKey, 0, len(keys))
+	for _, key := range keys {
+		if !key.IsPublic() {
+			key = key.Public()
+		}
+		if key.Valid() {
+			public = append(public, key)
+		}
+	}
+	return public
+}
+
+func findKeys(set *jose.JSONWebKeySet, kid string) ([]jose.JSONWebKey, error) {
+	keys := set.Keys
+	if kid != "" {
+		keys = set.Key(kid)
+	}
+	if len(keys) == 0 {
+		return nil, ErrUnknownKey
+	}
+	return keys, nil
+}
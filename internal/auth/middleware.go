@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const identityContextKey = "auth.identity"
+
+// Authorizer authenticates requests with a Verifier and checks roles. An
+// Authorizer without a verifier lets every request through, for local
+// development with AUTH_DISABLED.
+type Authorizer struct {
+	verifier *Verifier
+}
+
+func NewAuthorizer(verifier *Verifier) *Authorizer {
+	return &Authorizer{verifier: verifier}
+}
+
+func (a *Authorizer) Enabled() bool {
+	return a.verifier != nil
+}
+
+// Authenticate requires a valid "Authorization: Bearer <jwt>" header and
+// stores the caller in the gin and request contexts.
+func (a *Authorizer) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.Header("WWW-Authenticate", `Bearer`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrMissingToken.Error()})
+			return
+		}
+		identity, err := a.verifier.Verify(c.Request.Context(), token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(identityContextKey, identity)
+		c.Request = c.Request.WithContext(WithIdentity(c.Request.Context(), identity))
+		c.Next()
+	}
+}
+
+// Require lets through callers with any of the roles.
+func (a *Authorizer) Require(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Next()
+			return
+		}
+		if identity := Identify(c); identity == nil || !identity.HasRole(roles...) {
+			Forbid(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireWrite lets reads (GET and HEAD) through and requires any of the
+// roles for everything else. It keeps auditors read-only.
+func (a *Authorizer) RequireWrite(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		a.Require(roles...)(c)
+	}
+}
+
+// RequirePlayer lets through players whose ID is the path parameter, and
+// callers with any of the roles.
+func (a *Authorizer) RequirePlayer(param string, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.AllowsPlayer(c, c.Param(param), roles...) {
+			Forbid(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// AllowsPlayer reports whether the caller may act on playerID's data: they
+// are that player, or have any of the roles. Handlers use it for player IDs
+// that come from the body or from a loaded record.
+func (a *Authorizer) AllowsPlayer(c *gin.Context, playerID string, roles ...string) bool {
+	if !a.Enabled() {
+		return true
+	}
+	identity := Identify(c)
+	if identity == nil {
+		return false
+	}
+	if identity.HasRole(roles...) {
+		return true
+	}
+	return identity.HasRole(RolePlayer) && playerID != "" && identity.Subject == playerID
+}
+
+// Identify returns the caller stored by Authenticate, or nil.
+func Identify(c *gin.Context) *Identity {
+	if v, ok := c.Get(identityContextKey); ok {
+		return v.(*Identity)
+	}
+	return nil
+}
+
+// Forbid aborts the request with 403.
+func Forbid(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// Roles carried in the "roles" claim.
+const (
+	RolePlayer   = "player"        // their own wallet, bonuses and payments
+	RoleProvider = "game_provider" // posts transactions, bets and spins
+	RoleOperator = "operator"      // everything, including admin actions
+	RoleAuditor  = "auditor"       // reads everything, changes nothing
+)
+
+// DefaultLeeway is the clock skew allowed on exp, nbf and iat.
+const DefaultLeeway = time.Minute
+
+var (
+	ErrMissingToken = errors.New("missing bearer token")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// SignatureAlgorithms are the algorithms tokens may be signed with. Only
+// asymmetric ones: the service never holds a signing key.
+var SignatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// Identity is the authenticated caller of a request. For players Subject is
+// their player ID; for other roles it names the client.
+type Identity struct {
+	Subject string   `json:"sub"`
+	Roles   []string `json:"roles"`
+}
+
+func (i *Identity) HasRole(roles ...string) bool {
+	for _, have := range i.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Claims are the JWT claims the service reads.
+type Claims struct {
+	jwt.Claims
+	Roles []string `json:"roles"`
+}
+
+// VerifierConfig holds the checks applied to every token. Issuer and
+// Audience are skipped when empty.
+type VerifierConfig struct {
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+// Verifier checks JWS-signed JWTs against a key set.
+type Verifier struct {
+	keys   KeySet
+	config VerifierConfig
+	now    func() time.Time
+}
+
+func NewVerifier(keys KeySet, config VerifierConfig) *Verifier {
+	if config.Leeway <= 0 {
+		config.Leeway = DefaultLeeway
+	}
+	return &Verifier{keys: keys, config: config, now: time.Now}
+}
+
+// Verify checks a compact JWT and returns its caller. Every error wraps
+// ErrInvalidToken.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	parsed, err := jwt.ParseSigned(token, SignatureAlgorithms)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if len(parsed.Headers) != 1 {
+		return nil, fmt.Errorf("%w: expected one signature", ErrInvalidToken)
+	}
+	keys, err := v.keys.Keys(ctx, parsed.Headers[0].KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var claims Claims
+	verified := false
+	for _, key := range keys {
+		if err := parsed.Claims(key, &claims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: signature does not verify", ErrInvalidToken)
+	}
+
+	expected := jwt.Expected{Issuer: v.config.Issuer, Time: v.now()}
+	if v.config.Audience != "" {
+		expected.AnyAudience = jwt.Audience{v.config.Audience}
+	}
+	if err := claims.ValidateWithLeeway(expected, v.config.Leeway); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("%w: exp is required", ErrInvalidToken)
+	}
+	if claims.Subject == "" || len(claims.Roles) == 0 {
+		return nil, fmt.Errorf("%w: sub and roles are required", ErrInvalidToken)
+	}
+	return &Identity{Subject: claims.Subject, Roles: claims.Roles}, nil
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the caller.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the caller of the request, or nil when the request
+// was not authenticated.
+func FromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
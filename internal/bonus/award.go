@@ -0,0 +1,92 @@
+package bonus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"wallet_service/internal/wallet"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrWalletNotConfigured = errors.New("bonus service has no wallet configured")
+
+// WalletService is the part of wallet.Service that bonus payouts go through.
+type WalletService interface {
+	ProcessTransaction(ctx context.Context, req wallet.TransactionRequest) (*wallet.TransactionResponse, error)
+	GetBalance(ctx context.Context, playerID string, walletType string, currency string) (*wallet.Wallet, error)
+	GetTransaction(ctx context.Context, referenceID string, transactionType string) (*wallet.Transaction, error)
+}
+
+// BonusAward describes a bonus granted by a promotion. PlayerBonusID must be
+// derived from the triggering event (a deposit, a cashback period, ...) so
+// that awarding the same event twice is harmless.
+type BonusAward struct {
+	PlayerBonusID      string
+	PlayerID           string
+	BonusID            string // template or promotion the bonus came from
+	Amount             decimal.Decimal
+	WageringMultiplier decimal.Decimal
+	Currency           string
+	ExpiresAt          time.Time
+}
+
+// SetWallet lets the service pay bonus funds into player wallets.
+func (s *BonusService) SetWallet(w WalletService) {
+	s.wallet = w
+}
+
+// AwardBonus creates a PlayerBonus and credits its amount to the player's
+// bonus wallet. Both steps are keyed by PlayerBonusID, so a retry after a
+// partial failure completes the award without duplicating it. If the bonus
+// already exists, its stored amount is what gets credited and subscribers
+// are not told about it again.
+func (s *BonusService) AwardBonus(ctx context.Context, award BonusAward) (*PlayerBonus, error) {
+	if s.wallet == nil {
+		return nil, ErrWalletNotConfigured
+	}
+
+	created := false
+	bonus, err := s.repo.GetBonus(ctx, award.PlayerBonusID)
+	if errors.Is(err, ErrBonusNotFound) {
+		created = true
+		bonus = &PlayerBonus{
+			PlayerBonusID:     award.PlayerBonusID,
+			PlayerID:          award.PlayerID,
+			BonusID:           award.BonusID,
+			Status:            BonusStatusActive,
+			BonusAmount:       award.Amount,
+			Currency:          award.Currency,
+			WageringRequired:  award.Amount.Mul(award.WageringMultiplier),
+			WageringCompleted: decimal.Zero,
+			ExpiresAt:         award.ExpiresAt,
+			CreatedAt:         time.Now(),
+			UpdatedAt:         time.Now(),
+		}
+		err = s.repo.CreatePlayerBonus(ctx, bonus)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to award bonus: %w", err)
+	}
+
+	_, err = s.wallet.ProcessTransaction(ctx, wallet.TransactionRequest{
+		PlayerID:        award.PlayerID,
+		WalletType:      wallet.WalletTypeBonus,
+		TransactionType: wallet.TransactionTypeBonusCredit,
+		Amount:          bonus.BonusAmount,
+		ReferenceID:     "bonus:" + award.PlayerBonusID,
+		Currency:        bonus.Currency,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to credit bonus wallet: %w", err)
+	}
+
+	if created {
+		s.notifyEvent(UpdateEventAwarded, bonus)
+	}
+	log.Printf("Bonus awarded: bonus_id=%s player=%s amount=%s wagering_required=%s",
+		bonus.PlayerBonusID, bonus.PlayerID, bonus.BonusAmount.String(), bonus.WageringRequired.String())
+	return bonus, nil
+}
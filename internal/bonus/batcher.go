@@ -0,0 +1,339 @@
+package bonus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"wallet_service/internal/metrics"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultBatchWindow  = 50 * time.Millisecond
+	DefaultMaxBatchSize = 500
+)
+
+type BatcherConfig struct {
+	Window       time.Duration // how long contributions are held before a flush
+	MaxBatchSize int           // events per bonus that force a flush
+}
+
+type wageringBatch struct {
+	bonus   *PlayerBonus    // snapshot taken when the batch was opened
+	base    decimal.Decimal // latest known progress of the bonus, before this batch
+	events  []*WageringEvent
+	done    []func(error)
+	betIDs  map[string]bool
+	pending decimal.Decimal
+	timer   *time.Timer
+}
+
+// WageringBatcher is a write-behind alternative to BonusService's per-bet
+// transaction. Contributions are collected per PlayerBonus for a short window
+// and written in one transaction: one row lock, one bulk insert of wagering
+// events and one progress update. A batch that would complete the bonus is
+// flushed at once so completion is never delayed.
+//
+// Bets are idempotent by bet_id both inside a batch and against events that
+// are already stored. A bet is only reported done once its batch is
+// committed, so sources acknowledge it after it is durable. If the batch
+// insert fails, events are inserted one at a time so that one bad row only
+// fails its own bet.
+type WageringBatcher struct {
+	service *BonusService
+	cfg     BatcherConfig
+
+	mu      sync.Mutex
+	batches map[string]*wageringBatch
+	flushes sync.WaitGroup
+}
+
+func NewWageringBatcher(service *BonusService, cfg BatcherConfig) *WageringBatcher {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultBatchWindow
+	}
+	if cfg.MaxBatchSize <= 0 {
+		cfg.MaxBatchSize = DefaultMaxBatchSize
+	}
+	return &WageringBatcher{
+		service: service,
+		cfg:     cfg,
+		batches: make(map[string]*wageringBatch),
+	}
+}
+
+// ProcessBetWagering adds a bet to its batch and waits for the batch to be
+// flushed.
+func (b *WageringBatcher) ProcessBetWagering(ctx context.Context, bet BetEvent) error {
+	result := make(chan error, 1)
+	if err := b.ProcessBetWageringDeferred(ctx, bet, func(err error) { result <- err }); err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *WageringBatcher) ProcessBetWageringDeferred(ctx context.Context, bet BetEvent, done func(error)) error {
+	prepared, err := b.service.prepareBet(ctx, bet)
+	if err != nil {
+		return err
+	}
+	if prepared == nil {
+		done(nil)
+		return nil
+	}
+
+	bonusID := prepared.event.PlayerBonusID
+	b.mu.Lock()
+	batch, ok := b.batches[bonusID]
+	if !ok {
+		batch = &wageringBatch{
+			bonus:   prepared.bonus,
+			base:    prepared.bonus.WageringCompleted,
+			betIDs:  make(map[string]bool),
+			pending: decimal.Zero,
+		}
+		opened := batch
+		batch.timer = time.AfterFunc(b.cfg.Window, func() { b.flushBatch(bonusID, opened) })
+		b.batches[bonusID] = batch
+	}
+	if prepared.bonus.WageringCompleted.GreaterThan(batch.base) {
+		batch.base = prepared.bonus.WageringCompleted
+	}
+	if batch.betIDs[bet.BetID] {
+		b.mu.Unlock()
+		metrics.WageringSkipped(metrics.SkipDuplicate)
+		done(nil)
+		return nil
+	}
+
+	batch.betIDs[bet.BetID] = true
+	batch.events = append(batch.events, prepared.event)
+	batch.done = append(batch.done, done)
+	batch.pending = batch.pending.Add(prepared.event.WageringContribution)
+
+	full := b.full(batch)
+	if full {
+		b.detach(bonusID, batch)
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.flush(batch)
+	}
+	return nil
+}
+
+// Flush writes every open batch and waits for in-flight flushes, e.g. on
+// shutdown.
+func (b *WageringBatcher) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	open := make([]*wageringBatch, 0, len(b.batches))
+	for bonusID, batch := range b.batches {
+		b.detach(bonusID, batch)
+		open = append(open, batch)
+	}
+	b.mu.Unlock()
+
+	for _, batch := range open {
+		b.flush(batch)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.flushes.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// full reports whether a batch should be flushed without waiting for its
+// window.
+func (b *WageringBatcher) full(batch *wageringBatch) bool {
+	return len(batch.events) >= b.cfg.MaxBatchSize ||
+		batch.base.Add(batch.pending).GreaterThanOrEqual(batch.bonus.WageringRequired)
+}
+
+// flushBatch is called by the window timer of batch. The batch may already
+// have been flushed early and replaced by a newer one for the same bonus,
+// which is left alone.
+func (b *WageringBatcher) flushBatch(bonusID string, batch *wageringBatch) {
+	b.mu.Lock()
+	open := b.batches[bonusID] == batch
+	if open {
+		b.detach(bonusID, batch)
+	}
+	b.mu.Unlock()
+
+	if open {
+		b.flush(batch)
+	}
+}
+
+// detach removes a batch from the open set. Callers must hold b.mu.
+func (b *WageringBatcher) detach(bonusID string, batch *wageringBatch) {
+	batch.timer.Stop()
+	delete(b.batches, bonusID)
+	b.flushes.Add(1)
+}
+
+func (b *WageringBatcher) flush(batch *wageringBatch) {
+	defer b.flushes.Done()
+
+	ctx := context.Background()
+	s := b.service
+	bonusID := batch.bonus.PlayerBonusID
+
+	var locked *PlayerBonus
+	var applied []*WageringEvent
+	var failed map[string]error
+	var previous, progress decimal.Decimal
+	var bonusCompleted bool
+	start := time.Now()
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		bonus, lockErr := s.repo.GetBonusForUpdate(ctx, tx, bonusID)
+		if lockErr != nil {
+			return lockErr
+		}
+		if bonus.Status != BonusStatusActive {
+			return ErrBonusNotActive
+		}
+		locked = bonus
+
+		betIDs := make([]string, len(batch.events))
+		for i, event := range batch.events {
+			betIDs[i] = event.BetID
+		}
+		existing, err := s.repo.GetExistingBetIDs(ctx, tx, betIDs)
+		if err != nil {
+			return err
+		}
+
+		var fresh []*WageringEvent
+		for _, event := range batch.events {
+			if !existing[event.BetID] {
+				fresh = append(fresh, event)
+			}
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+		applied, failed = b.insertEvents(ctx, tx, fresh)
+		if len(applied) == 0 {
+			return nil
+		}
+
+		contribution := decimal.Zero
+		for _, event := range applied {
+			contribution = contribution.Add(event.WageringContribution)
+		}
+		previous = bonus.WageringCompleted
+		progress = bonus.WageringCompleted.Add(contribution)
+		if progress.GreaterThan(bonus.WageringRequired) {
+			progress = bonus.WageringRequired
+		}
+		if err := s.repo.UpdateWageringProgress(ctx, tx, bonusID, progress); err != nil {
+			return err
+		}
+		if progress.GreaterThanOrEqual(bonus.WageringRequired) {
+			if err := s.repo.UpdateBonusStatus(ctx, tx, bonusID, BonusStatusCompleted); err != nil {
+				return err
+			}
+			bonusCompleted = true
+			log.Printf("Bonus wagering completed! bonus_id=%s player=%s", bonusID, bonus.PlayerID)
+		}
+		return nil
+	})
+	metrics.ObserveDBTransaction("bonus_wagering_batch", start)
+	if err != nil {
+		err = fmt.Errorf("failed to flush wagering batch: %w", err)
+		log.Printf("Wagering batch failed: bonus_id=%s bets=%d: %v", bonusID, len(batch.events), err)
+	} else if len(applied) > 0 {
+		b.advance(bonusID, progress)
+		s.notifyProgress(locked, previous, progress, bonusCompleted)
+		log.Printf("Wagering batch flushed: bonus_id=%s bets=%d applied=%d failed=%d progress=%s completed=%t",
+			bonusID, len(batch.events), len(applied), len(failed), progress.String(), bonusCompleted)
+	}
+	if err == nil {
+		for _, event := range applied {
+			metrics.WageringProcessed(event.PlacedAt)
+		}
+		metrics.WageringEvents.WithLabelValues("skipped", metrics.SkipDuplicate).Add(float64(len(batch.events) - len(applied) - len(failed)))
+	}
+
+	for i, done := range batch.done {
+		if rowErr, ok := failed[batch.events[i].BetID]; ok && err == nil {
+			done(fmt.Errorf("failed to flush wagering batch: %w", rowErr))
+			continue
+		}
+		done(err)
+	}
+}
+
+// insertEvents inserts events in one statement. If that fails, e.g. because
+// another writer recorded one of the bets since GetExistingBetIDs, the
+// statement is rolled back to a savepoint and the events are inserted one at
+// a time, skipping bets that already exist. It returns the inserted events
+// and the errors of the ones that could not be inserted.
+func (b *WageringBatcher) insertEvents(ctx context.Context, tx *gorm.DB, events []*WageringEvent) ([]*WageringEvent, map[string]error) {
+	repo := b.service.repo
+	bulkErr := tx.Transaction(func(sp *gorm.DB) error {
+		return repo.CreateWageringEvents(ctx, sp, events)
+	})
+	if bulkErr == nil {
+		return events, nil
+	}
+	log.Printf("Wagering batch insert failed, inserting events one by one: bets=%d: %v", len(events), bulkErr)
+
+	var applied []*WageringEvent
+	failed := make(map[string]error)
+	for _, event := range events {
+		var inserted bool
+		rowErr := tx.Transaction(func(sp *gorm.DB) error {
+			var err error
+			inserted, err = repo.CreateWageringEventIfAbsent(ctx, sp, event)
+			return err
+		})
+		switch {
+		case rowErr != nil:
+			failed[event.BetID] = rowErr
+		case inserted:
+			applied = append(applied, event)
+		}
+	}
+	return applied, failed
+}
+
+// advance records progress committed by a flush in the open batch of the
+// same bonus, if any, and flushes that batch at once if it now completes
+// the bonus.
+func (b *WageringBatcher) advance(bonusID string, progress decimal.Decimal) {
+	b.mu.Lock()
+	batch, ok := b.batches[bonusID]
+	full := false
+	if ok && progress.GreaterThan(batch.base) {
+		batch.base = progress
+		if full = b.full(batch); full {
+			b.detach(bonusID, batch)
+		}
+	}
+	b.mu.Unlock()
+
+	if full {
+		go b.flush(batch)
+	}
+}
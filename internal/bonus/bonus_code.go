@@ -0,0 +1,350 @@
+package bonus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrBonusCodeNotFound     = errors.New("bonus code not found")
+	ErrBonusCodeNotActive    = errors.New("bonus code is not active")
+	ErrBonusCodeExhausted    = errors.New("bonus code has reached its redemption limit")
+	ErrBonusCodePlayerLimit  = errors.New("player has already redeemed this bonus code")
+	ErrBonusCodeNotEligible  = errors.New("player is not eligible for this bonus code")
+	ErrBonusCodeNeedsDeposit = errors.New("bonus code must be entered with a deposit")
+)
+
+// codeNamespace seeds the IDs of bonus code redemptions.
+var codeNamespace = uuid.MustParse("d27e4f19-8b3a-4c6d-a5e0-94f1c3b8d762")
+
+type BonusCodeRepository interface {
+	CreateCode(ctx context.Context, code *BonusCode) error
+	GetCode(ctx context.Context, code string) (*BonusCode, error)
+	GetCodeForUpdate(ctx context.Context, tx *gorm.DB, code string) (*BonusCode, error)
+	GetRedemption(ctx context.Context, tx *gorm.DB, redemptionID string) (*BonusCodeRedemption, error)
+	// CreateRedemption records a redemption and counts it against the
+	// code's cap. Call it with the code row locked.
+	CreateRedemption(ctx context.Context, tx *gorm.DB, redemption *BonusCodeRedemption) error
+	CountPlayerRedemptions(ctx context.Context, tx *gorm.DB, code string, playerID string) (int64, error)
+	CountPlayerBonuses(ctx context.Context, tx *gorm.DB, playerID string) (int64, error)
+}
+
+type BonusCodeRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewBonusCodeRepository(db *gorm.DB) *BonusCodeRepositoryImpl {
+	return &BonusCodeRepositoryImpl{db: db}
+}
+
+func (r *BonusCodeRepositoryImpl) CreateCode(ctx context.Context, code *BonusCode) error {
+	code.Code = normalizeCode(code.Code)
+	if err := r.db.WithContext(ctx).Create(code).Error; err != nil {
+		return fmt.Errorf("failed to create bonus code: %w", err)
+	}
+	return nil
+}
+
+func (r *BonusCodeRepositoryImpl) GetCode(ctx context.Context, code string) (*BonusCode, error) {
+	return r.getCode(r.db.WithContext(ctx), code)
+}
+
+func (r *BonusCodeRepositoryImpl) GetCodeForUpdate(ctx context.Context, tx *gorm.DB, code string) (*BonusCode, error) {
+	return r.getCode(tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}), code)
+}
+
+func (r *BonusCodeRepositoryImpl) getCode(q *gorm.DB, code string) (*BonusCode, error) {
+	var c BonusCode
+	err := q.Where("code = ?", normalizeCode(code)).First(&c).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBonusCodeNotFound
+		}
+		return nil, fmt.Errorf("failed to get bonus code: %w", err)
+	}
+	return &c, nil
+}
+
+func (r *BonusCodeRepositoryImpl) GetRedemption(ctx context.Context, tx *gorm.DB, redemptionID string) (*BonusCodeRedemption, error) {
+	var redemption BonusCodeRedemption
+	err := tx.WithContext(ctx).
+		Where("redemption_id = ?", redemptionID).
+		First(&redemption).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get bonus code redemption: %w", err)
+	}
+	return &redemption, nil
+}
+
+func (r *BonusCodeRepositoryImpl) CreateRedemption(ctx context.Context, tx *gorm.DB, redemption *BonusCodeRedemption) error {
+	if err := tx.WithContext(ctx).Create(redemption).Error; err != nil {
+		return fmt.Errorf("failed to create bonus code redemption: %w", err)
+	}
+
+	result := tx.WithContext(ctx).
+		Model(&BonusCode{}).
+		Where("code = ?", redemption.Code).
+		Updates(map[string]interface{}{
+			"redemptions": gorm.Expr("redemptions + 1"),
+			"updated_at":  gorm.Expr("NOW()"),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to count bonus code redemption: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrBonusCodeNotFound
+	}
+	return nil
+}
+
+func (r *BonusCodeRepositoryImpl) CountPlayerRedemptions(ctx context.Context, tx *gorm.DB, code string, playerID string) (int64, error) {
+	var count int64
+	err := tx.WithContext(ctx).
+		Model(&BonusCodeRedemption{}).
+		Where("code = ? AND player_id = ?", code, playerID).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count bonus code redemptions: %w", err)
+	}
+	return count, nil
+}
+
+func (r *BonusCodeRepositoryImpl) CountPlayerBonuses(ctx context.Context, tx *gorm.DB, playerID string) (int64, error) {
+	var count int64
+	err := tx.WithContext(ctx).
+		Model(&PlayerBonus{}).
+		Where("player_id = ?", playerID).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count player bonuses: %w", err)
+	}
+	return count, nil
+}
+
+// RedeemRequest asks to redeem a bonus code. Key makes the redemption
+// idempotent: redeeming the same code for the same player with the same key
+// returns the first redemption. Deposit redemptions use the deposit's
+// ReferenceID as key and pass the deposit amount.
+type RedeemRequest struct {
+	Code          string          `json:"code"`
+	PlayerID      string          `json:"player_id"`
+	Country       string          `json:"country"`
+	Currency      string          `json:"currency"`
+	Key           string          `json:"key"`
+	DepositAmount decimal.Decimal `json:"-"`
+}
+
+// BonusCodeService redeems bonus codes. The code row is locked for the whole
+// redemption, so the global and per-player caps hold under concurrent
+// redemptions, and the PlayerBonus is created in the same transaction as the
+// redemption that counts against them.
+type BonusCodeService struct {
+	db        *gorm.DB
+	repo      BonusCodeRepository
+	templates TemplateRepository
+	bonuses   *BonusService
+	freeSpins *FreeSpinService
+}
+
+func NewBonusCodeService(db *gorm.DB, repo BonusCodeRepository, templates TemplateRepository, bonuses *BonusService, freeSpins *FreeSpinService) *BonusCodeService {
+	return &BonusCodeService{db: db, repo: repo, templates: templates, bonuses: bonuses, freeSpins: freeSpins}
+}
+
+func (s *BonusCodeService) CreateCode(ctx context.Context, code *BonusCode) error {
+	if _, err := s.templates.GetTemplate(ctx, code.TemplateID); err != nil {
+		return err
+	}
+	return s.repo.CreateCode(ctx, code)
+}
+
+func (s *BonusCodeService) GetCode(ctx context.Context, code string) (*BonusCode, error) {
+	return s.repo.GetCode(ctx, code)
+}
+
+// Redeem checks a code's limits and eligibility rules and grants its
+// template: a bonus for no-deposit and deposit-match templates, free spins
+// for free spin templates.
+func (s *BonusCodeService) Redeem(ctx context.Context, req RedeemRequest) (*BonusCodeRedemption, error) {
+	req.Code = normalizeCode(req.Code)
+	if req.Key == "" {
+		req.Key = uuid.NewString()
+	}
+	redemptionID := uuid.NewSHA1(codeNamespace, []byte(req.Code+"|"+req.PlayerID+"|"+req.Key)).String()
+
+	var redemption *BonusCodeRedemption
+	var template *BonusTemplate
+	var bonus *PlayerBonus
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		code, err := s.repo.GetCodeForUpdate(ctx, tx, req.Code)
+		if err != nil {
+			return err
+		}
+		template, err = s.templates.GetTemplate(ctx, code.TemplateID)
+		if err != nil {
+			return err
+		}
+
+		redemption, err = s.repo.GetRedemption(ctx, tx, redemptionID)
+		if err != nil || redemption != nil {
+			return err
+		}
+
+		amount, err := s.checkRedemption(ctx, tx, code, template, req)
+		if err != nil {
+			return err
+		}
+
+		redemption = &BonusCodeRedemption{
+			RedemptionID: redemptionID,
+			Code:         code.Code,
+			PlayerID:     req.PlayerID,
+			TemplateID:   template.TemplateID,
+			BonusType:    template.BonusType,
+			Currency:     req.Currency,
+			AwardID:      redemptionID,
+			Reference:    req.Key,
+			CreatedAt:    time.Now(),
+		}
+		if err := s.repo.CreateRedemption(ctx, tx, redemption); err != nil {
+			return err
+		}
+
+		if template.BonusType == BonusTypeFreeSpins {
+			return nil
+		}
+		bonus, err = s.bonuses.CreatePlayerBonusTx(ctx, tx, redemption.AwardID, req.PlayerID, template.TemplateID,
+			amount, req.Currency, template.WageringMultiplier, time.Now().Add(time.Duration(template.ValidityHours)*time.Hour))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.fulfil(ctx, redemption); err != nil {
+		return nil, err
+	}
+	if bonus != nil {
+		s.bonuses.notifyEvent(UpdateEventAwarded, bonus)
+	}
+	log.Printf("Bonus code redeemed: code=%s player=%s template=%s award_id=%s",
+		redemption.Code, redemption.PlayerID, redemption.TemplateID, redemption.AwardID)
+	return redemption, nil
+}
+
+// checkRedemption applies the code's limits and eligibility rules and
+// returns the bonus amount for cash templates.
+func (s *BonusCodeService) checkRedemption(ctx context.Context, tx *gorm.DB, code *BonusCode, template *BonusTemplate, req RedeemRequest) (decimal.Decimal, error) {
+	now := time.Now()
+	if !code.Active || !template.Active ||
+		(code.ValidFrom != nil && now.Before(*code.ValidFrom)) ||
+		(code.ValidUntil != nil && now.After(*code.ValidUntil)) {
+		return decimal.Zero, ErrBonusCodeNotActive
+	}
+	if code.MaxRedemptions > 0 && code.Redemptions >= code.MaxRedemptions {
+		return decimal.Zero, ErrBonusCodeExhausted
+	}
+	if code.MaxPerPlayer > 0 {
+		count, err := s.repo.CountPlayerRedemptions(ctx, tx, code.Code, req.PlayerID)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if count >= int64(code.MaxPerPlayer) {
+			return decimal.Zero, ErrBonusCodePlayerLimit
+		}
+	}
+
+	if len(code.Countries) > 0 && !containsFold(code.Countries, req.Country) {
+		return decimal.Zero, fmt.Errorf("%w: country %q", ErrBonusCodeNotEligible, req.Country)
+	}
+	currencies := code.Currencies
+	if len(currencies) == 0 {
+		currencies = []string{template.Currency}
+	}
+	if !containsFold(currencies, req.Currency) {
+		return decimal.Zero, fmt.Errorf("%w: currency %q", ErrBonusCodeNotEligible, req.Currency)
+	}
+	if code.NewPlayersOnly {
+		count, err := s.repo.CountPlayerBonuses(ctx, tx, req.PlayerID)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if count > 0 {
+			return decimal.Zero, fmt.Errorf("%w: new players only", ErrBonusCodeNotEligible)
+		}
+	}
+	if code.MinDeposits > 0 || template.FirstDepositOnly {
+		// At deposit time the deposit is already credited and counts here
+		deposits, err := s.templates.CountDeposits(ctx, req.PlayerID, req.Currency, "")
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if deposits < int64(code.MinDeposits) {
+			return decimal.Zero, fmt.Errorf("%w: needs %d deposits", ErrBonusCodeNotEligible, code.MinDeposits)
+		}
+		if template.FirstDepositOnly && deposits > 1 {
+			return decimal.Zero, fmt.Errorf("%w: first deposit only", ErrBonusCodeNotEligible)
+		}
+	}
+
+	switch template.BonusType {
+	case BonusTypeNoDeposit:
+		return template.Amount, nil
+	case BonusTypeDepositMatch:
+		if !req.DepositAmount.IsPositive() {
+			return decimal.Zero, ErrBonusCodeNeedsDeposit
+		}
+		amount, ok := matchAmount(template, req.DepositAmount)
+		if !ok {
+			return decimal.Zero, fmt.Errorf("%w: minimum deposit %s", ErrBonusCodeNotEligible, template.MinDeposit.String())
+		}
+		return amount, nil
+	default:
+		return decimal.Zero, nil
+	}
+}
+
+// fulfil pays out a redemption. It is safe to repeat, so a replayed
+// redemption finishes an award that failed after the redemption committed.
+func (s *BonusCodeService) fulfil(ctx context.Context, redemption *BonusCodeRedemption) error {
+	if redemption.BonusType == BonusTypeFreeSpins {
+		if s.freeSpins == nil {
+			return fmt.Errorf("free spins are not configured")
+		}
+		_, err := s.freeSpins.GrantFreeSpins(ctx, redemption.AwardID, redemption.PlayerID, redemption.TemplateID)
+		return err
+	}
+
+	// The bonus already exists; AwardBonus credits its stored amount
+	_, err := s.bonuses.AwardBonus(ctx, BonusAward{
+		PlayerBonusID: redemption.AwardID,
+		PlayerID:      redemption.PlayerID,
+		BonusID:       redemption.TemplateID,
+		Currency:      redemption.Currency,
+	})
+	return err
+}
+
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func containsFold(values []string, v string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+	return false
+}
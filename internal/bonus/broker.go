@@ -0,0 +1,114 @@
+package bonus
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+)
+
+// MemoryBroker is an embedded, append-only bet log. It stands in for Kafka
+// or NATS: producers Publish, and each consumer group reads the log through a
+// BrokerSource starting after its committed offset. Nacked bets are
+// redelivered by the source. The log lives in memory and starts empty on
+// every run, so its offsets should be kept in a MemoryOffsetStore.
+type MemoryBroker struct {
+	name string
+
+	mu     sync.Mutex
+	log    []BetEvent
+	notify chan struct{}
+}
+
+func NewMemoryBroker(name string) *MemoryBroker {
+	return &MemoryBroker{
+		name:   name,
+		notify: make(chan struct{}),
+	}
+}
+
+// Publish appends a bet to the log and returns its offset.
+func (b *MemoryBroker) Publish(bet BetEvent) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.log = append(b.log, bet)
+	close(b.notify)
+	b.notify = make(chan struct{})
+	return int64(len(b.log))
+}
+
+// Subscribe opens a source for a consumer group, positioned after the
+// group's committed offset.
+func (b *MemoryBroker) Subscribe(ctx context.Context, offsets OffsetStore, consumer string) (*BrokerSource, error) {
+	tracker, err := newOffsetTracker(ctx, offsets, consumer, b.name)
+	if err != nil {
+		return nil, err
+	}
+	return &BrokerSource{
+		broker:  b,
+		tracker: tracker,
+		next:    tracker.Committed() + 1,
+		closed:  make(chan struct{}),
+	}, nil
+}
+
+type BrokerSource struct {
+	broker  *MemoryBroker
+	tracker *offsetTracker
+
+	mu     sync.Mutex
+	next   int64
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (s *BrokerSource) Name() string {
+	return s.broker.name
+}
+
+func (s *BrokerSource) Receive(ctx context.Context) (BetMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		changed := s.tracker.changed()
+		if msg, ok := s.tracker.next(); ok {
+			return msg, nil
+		}
+
+		s.broker.mu.Lock()
+		if s.next <= int64(len(s.broker.log)) {
+			msg := BetMessage{Offset: s.next, Bet: s.broker.log[s.next-1], Attempt: 1}
+			s.broker.mu.Unlock()
+			s.next++
+			return msg, nil
+		}
+		wait := s.broker.notify
+		s.broker.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-changed:
+		case <-ctx.Done():
+			return BetMessage{}, ctx.Err()
+		case <-s.closed:
+			return BetMessage{}, io.EOF
+		}
+	}
+}
+
+func (s *BrokerSource) Ack(ctx context.Context, msg BetMessage) error {
+	return s.tracker.ack(ctx, msg.Offset)
+}
+
+func (s *BrokerSource) Nack(ctx context.Context, msg BetMessage, cause error) error {
+	log.Printf("Bet not acknowledged: source=%s offset=%d bet_id=%s attempt=%d: %v", s.broker.name, msg.Offset, msg.Bet.BetID, msg.Attempt, cause)
+	s.tracker.redeliver(msg)
+	return nil
+}
+
+func (s *BrokerSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
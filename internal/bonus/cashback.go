@@ -0,0 +1,484 @@
+package bonus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCashbackPayoutNotFound = errors.New("cashback payout not found")
+	ErrInvalidCashbackPeriod  = errors.New("cashback period must end after it starts")
+	ErrInvalidCashbackTiers   = errors.New("invalid cashback tiers")
+)
+
+// cashbackNamespace seeds the deterministic payout and bonus IDs.
+var cashbackNamespace = uuid.MustParse("6f1c1a52-8f0e-4c8e-9d0a-3f9e5c2b7a10")
+
+// PlayerNetLoss is a player's bet and win turnover over a period.
+type PlayerNetLoss struct {
+	PlayerID  string
+	TotalBets decimal.Decimal
+	TotalWins decimal.Decimal
+}
+
+type CashbackRepository interface {
+	GetNetLosses(ctx context.Context, currency string, start time.Time, end time.Time) ([]PlayerNetLoss, error)
+	// CreatePayout inserts a payout unless one already exists for the same
+	// player, currency and period, and returns the stored row.
+	CreatePayout(ctx context.Context, payout *CashbackPayout) (*CashbackPayout, error)
+	MarkPayoutPaid(ctx context.Context, payoutID string, reference string) error
+	ListPayouts(ctx context.Context, start time.Time, end time.Time) ([]CashbackPayout, error)
+	// SavePeriod records the outcome of a periodic run, replacing an earlier
+	// outcome for the same period.
+	SavePeriod(ctx context.Context, period *CashbackPeriod) error
+	ListPeriods(ctx context.Context, currency string, status string) ([]CashbackPeriod, error)
+	// LastPeriodEnd returns the end of the latest recorded period, or the
+	// zero time when none has been recorded.
+	LastPeriodEnd(ctx context.Context, currency string) (time.Time, error)
+}
+
+type CashbackRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewCashbackRepository(db *gorm.DB) *CashbackRepositoryImpl {
+	return &CashbackRepositoryImpl{db: db}
+}
+
+// GetNetLosses sums completed bets, less rolled back ones, and wins on main
+// wallets. Players who only won are included with a negative net loss and
+// filtered by the engine.
+func (r *CashbackRepositoryImpl) GetNetLosses(ctx context.Context, currency string, start time.Time, end time.Time) ([]PlayerNetLoss, error) {
+	var losses []PlayerNetLoss
+	err := r.db.WithContext(ctx).
+		Table("transactions AS t").
+		Select(`t.player_id AS player_id,
+			COALESCE(SUM(CASE WHEN t.transaction_type = ? THEN t.amount WHEN t.transaction_type = ? THEN -t.amount ELSE 0 END), 0) AS total_bets,
+			COALESCE(SUM(CASE WHEN t.transaction_type = ? THEN t.amount ELSE 0 END), 0) AS total_wins`,
+			wallet.TransactionTypeBet, wallet.TransactionTypeRollback, wallet.TransactionTypeWin).
+		Joins("JOIN wallets AS w ON w.wallet_id = t.wallet_id").
+		Where("w.wallet_type = ? AND w.currency = ?", wallet.WalletTypeMain, currency).
+		Where("t.status = ? AND t.transaction_type IN ?", "completed", []string{wallet.TransactionTypeBet, wallet.TransactionTypeWin, wallet.TransactionTypeRollback}).
+		Where("t.created_at >= ? AND t.created_at < ?", start, end).
+		Group("t.player_id").
+		Scan(&losses).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get net losses: %w", err)
+	}
+	return losses, nil
+}
+
+func (r *CashbackRepositoryImpl) CreatePayout(ctx context.Context, payout *CashbackPayout) (*CashbackPayout, error) {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(payout).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cashback payout: %w", err)
+	}
+
+	var stored CashbackPayout
+	err = r.db.WithContext(ctx).
+		Where("player_id = ? AND currency = ? AND period_start = ? AND period_end = ?",
+			payout.PlayerID, payout.Currency, payout.PeriodStart, payout.PeriodEnd).
+		First(&stored).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCashbackPayoutNotFound
+		}
+		return nil, fmt.Errorf("failed to get cashback payout: %w", err)
+	}
+	return &stored, nil
+}
+
+func (r *CashbackRepositoryImpl) MarkPayoutPaid(ctx context.Context, payoutID string, reference string) error {
+	result := r.db.WithContext(ctx).
+		Model(&CashbackPayout{}).
+		Where("payout_id = ?", payoutID).
+		Updates(map[string]interface{}{
+			"status":    CashbackStatusPaid,
+			"reference": reference,
+			"paid_at":   gorm.Expr("NOW()"),
+		})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark cashback payout paid: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrCashbackPayoutNotFound
+	}
+
+	return nil
+}
+
+func (r *CashbackRepositoryImpl) ListPayouts(ctx context.Context, start time.Time, end time.Time) ([]CashbackPayout, error) {
+	var payouts []CashbackPayout
+	err := r.db.WithContext(ctx).
+		Where("period_start = ? AND period_end = ?", start, end).
+		Order("created_at").
+		Find(&payouts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cashback payouts: %w", err)
+	}
+	return payouts, nil
+}
+
+func (r *CashbackRepositoryImpl) SavePeriod(ctx context.Context, period *CashbackPeriod) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "currency"}, {Name: "period_start"}, {Name: "period_end"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "status"}, Value: period.Status},
+				{Column: clause.Column{Name: "error"}, Value: period.Error},
+				{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("NOW()")},
+			},
+		}).
+		Create(period).Error
+	if err != nil {
+		return fmt.Errorf("failed to save cashback period: %w", err)
+	}
+	return nil
+}
+
+func (r *CashbackRepositoryImpl) ListPeriods(ctx context.Context, currency string, status string) ([]CashbackPeriod, error) {
+	var periods []CashbackPeriod
+	err := r.db.WithContext(ctx).
+		Where("currency = ? AND status = ?", currency, status).
+		Order("period_start").
+		Find(&periods).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cashback periods: %w", err)
+	}
+	return periods, nil
+}
+
+func (r *CashbackRepositoryImpl) LastPeriodEnd(ctx context.Context, currency string) (time.Time, error) {
+	var last *time.Time
+	err := r.db.WithContext(ctx).
+		Model(&CashbackPeriod{}).
+		Where("currency = ?", currency).
+		Select("MAX(period_end)").
+		Scan(&last).Error
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get last cashback period: %w", err)
+	}
+	if last == nil {
+		return time.Time{}, nil
+	}
+	return last.UTC(), nil
+}
+
+// CashbackTier pays Percentage of the net loss to players who lost at least
+// MinLoss, up to Cap (no cap when zero).
+type CashbackTier struct {
+	MinLoss    decimal.Decimal `json:"min_loss"`
+	Percentage decimal.Decimal `json:"percentage"` // 0.0 to 1.0
+	Cap        decimal.Decimal `json:"cap"`
+}
+
+type CashbackConfig struct {
+	Tiers              []CashbackTier
+	Currency           string
+	Period             time.Duration // length of a cashback period, e.g. one week
+	PayoutMode         string        // CashbackModeWallet or CashbackModeBonus
+	WageringMultiplier decimal.Decimal
+	BonusValidity      time.Duration
+	// BonusTemplateID is recorded as the BonusID of cashback paid as a
+	// bonus, so that reports can group it with a configured template. When
+	// empty, each period gets a BonusID of its own, derived from the
+	// currency and period, so one week's cashback bonuses can be told
+	// apart from the next.
+	BonusTemplateID string
+}
+
+// ParseCashbackTiers parses "minLoss:percentage:cap" tiers separated by
+// commas, e.g. "50:0.05:25,500:0.10:200". A cap of 0 means uncapped.
+func ParseCashbackTiers(spec string) ([]CashbackTier, error) {
+	var tiers []CashbackTier
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidCashbackTiers, part)
+		}
+		var values [3]decimal.Decimal
+		for i, f := range fields {
+			v, err := decimal.NewFromString(f)
+			if err != nil || v.IsNegative() {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidCashbackTiers, part)
+			}
+			values[i] = v
+		}
+		if values[1].GreaterThan(decimal.NewFromInt(1)) {
+			return nil, fmt.Errorf("%w: percentage above 1 in %q", ErrInvalidCashbackTiers, part)
+		}
+		tiers = append(tiers, CashbackTier{MinLoss: values[0], Percentage: values[1], Cap: values[2]})
+	}
+	return tiers, nil
+}
+
+// CashbackEngine pays a share of each player's net loss (bets minus wins)
+// over a period, either straight to the main wallet or as a bonus with its
+// own wagering requirement. Payouts are keyed by player, currency and period,
+// so running the same period again only finishes payouts that were
+// interrupted.
+type CashbackEngine struct {
+	repo    CashbackRepository
+	bonuses *BonusService
+	wallet  WalletService
+	cfg     CashbackConfig
+}
+
+func NewCashbackEngine(repo CashbackRepository, bonuses *BonusService, wallet WalletService, cfg CashbackConfig) *CashbackEngine {
+	tiers := append([]CashbackTier(nil), cfg.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinLoss.LessThan(tiers[j].MinLoss) })
+	cfg.Tiers = tiers
+	return &CashbackEngine{repo: repo, bonuses: bonuses, wallet: wallet, cfg: cfg}
+}
+
+// PreviousPeriod returns the last full period that ended at or before now.
+// Periods are aligned to multiples of cfg.Period since the zero time, so
+// weekly periods start on Mondays at midnight UTC.
+func (e *CashbackEngine) PreviousPeriod(now time.Time) (time.Time, time.Time) {
+	end := now.UTC().Truncate(e.cfg.Period)
+	return end.Add(-e.cfg.Period), end
+}
+
+// Calculate returns the payouts due for a period without paying them.
+func (e *CashbackEngine) Calculate(ctx context.Context, start time.Time, end time.Time) ([]CashbackPayout, error) {
+	if !end.After(start) {
+		return nil, ErrInvalidCashbackPeriod
+	}
+
+	losses, err := e.repo.GetNetLosses(ctx, e.cfg.Currency, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var payouts []CashbackPayout
+	for _, l := range losses {
+		netLoss := l.TotalBets.Sub(l.TotalWins)
+		tier, ok := e.tierFor(netLoss)
+		if !ok {
+			continue
+		}
+		amount := netLoss.Mul(tier.Percentage)
+		if tier.Cap.IsPositive() && amount.GreaterThan(tier.Cap) {
+			amount = tier.Cap
+		}
+		amount = amount.Round(2)
+		if !amount.IsPositive() {
+			continue
+		}
+
+		payouts = append(payouts, CashbackPayout{
+			PayoutID:    e.payoutID(l.PlayerID, start, end).String(),
+			PlayerID:    l.PlayerID,
+			Currency:    e.cfg.Currency,
+			PeriodStart: start,
+			PeriodEnd:   end,
+			TotalBets:   l.TotalBets,
+			TotalWins:   l.TotalWins,
+			NetLoss:     netLoss,
+			Percentage:  tier.Percentage,
+			Amount:      amount,
+			PayoutMode:  e.cfg.PayoutMode,
+			Status:      CashbackStatusPending,
+		})
+	}
+	return payouts, nil
+}
+
+// Run calculates and pays cashback for a period. It carries on past
+// individual payout failures and returns the first of them, so one broken
+// wallet does not block everyone else's cashback.
+func (e *CashbackEngine) Run(ctx context.Context, start time.Time, end time.Time) ([]CashbackPayout, error) {
+	due, err := e.Calculate(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var firstErr error
+	paid := make([]CashbackPayout, 0, len(due))
+	for i := range due {
+		payout, err := e.pay(ctx, &due[i])
+		if err != nil {
+			log.Printf("Cashback payout failed: player=%s period=%s/%s: %v",
+				due[i].PlayerID, start.Format(time.RFC3339), end.Format(time.RFC3339), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		paid = append(paid, *payout)
+	}
+	return paid, firstErr
+}
+
+// RunPeriodic calls CatchUp every interval until ctx is cancelled.
+func (e *CashbackEngine) RunPeriodic(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.CatchUp(ctx, time.Now()); err != nil {
+			log.Printf("Cashback run failed: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// DuePeriods returns the periods CatchUp runs at now, oldest first: earlier
+// runs that failed, and every period that closed after the latest recorded
+// one. With nothing recorded yet only the previous period is due, so
+// enabling cashback does not pay out history.
+func (e *CashbackEngine) DuePeriods(ctx context.Context, now time.Time) ([]CashbackPeriod, error) {
+	due, err := e.repo.ListPeriods(ctx, e.cfg.Currency, CashbackPeriodFailed)
+	if err != nil {
+		return nil, err
+	}
+
+	prevStart, prevEnd := e.PreviousPeriod(now)
+	last, err := e.repo.LastPeriodEnd(ctx, e.cfg.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if last.IsZero() {
+		last = prevStart
+	}
+	for start := last; start.Before(prevEnd); start = start.Add(e.cfg.Period) {
+		due = append(due, CashbackPeriod{Currency: e.cfg.Currency, PeriodStart: start, PeriodEnd: start.Add(e.cfg.Period)})
+	}
+	return due, nil
+}
+
+// CatchUp runs every due period and records its outcome. A period with a
+// failed payout is recorded as failed and retried on the next call, without
+// holding up later periods. Payouts are keyed by period, so retrying only
+// pays what is still missing.
+func (e *CashbackEngine) CatchUp(ctx context.Context, now time.Time) error {
+	due, err := e.DuePeriods(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for i := range due {
+		period := &due[i]
+		period.Status = CashbackPeriodCompleted
+		period.Error = ""
+		if _, err := e.Run(ctx, period.PeriodStart, period.PeriodEnd); err != nil {
+			period.Status = CashbackPeriodFailed
+			period.Error = err.Error()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if err := e.repo.SavePeriod(ctx, period); err != nil {
+			return err
+		}
+	}
+	return firstErr
+}
+
+func (e *CashbackEngine) pay(ctx context.Context, due *CashbackPayout) (*CashbackPayout, error) {
+	payout, err := e.repo.CreatePayout(ctx, due)
+	if err != nil {
+		return nil, err
+	}
+	if payout.Status == CashbackStatusPaid {
+		return payout, nil
+	}
+
+	// The stored row wins over the fresh calculation: a late transaction
+	// must not change a payout that may already have been credited.
+	var reference string
+	switch payout.PayoutMode {
+	case CashbackModeBonus:
+		bonus, err := e.bonuses.AwardBonus(ctx, BonusAward{
+			PlayerBonusID:      payout.PayoutID,
+			PlayerID:           payout.PlayerID,
+			BonusID:            e.bonusID(payout),
+			Amount:             payout.Amount,
+			WageringMultiplier: e.cfg.WageringMultiplier,
+			Currency:           payout.Currency,
+			ExpiresAt:          time.Now().Add(e.cfg.BonusValidity),
+		})
+		if err != nil {
+			return nil, err
+		}
+		reference = bonus.PlayerBonusID
+	default:
+		res, err := e.wallet.ProcessTransaction(ctx, wallet.TransactionRequest{
+			PlayerID:        payout.PlayerID,
+			WalletType:      wallet.WalletTypeMain,
+			TransactionType: wallet.TransactionTypeCashback,
+			Amount:          payout.Amount,
+			ReferenceID:     "cashback:" + payout.PayoutID,
+			Currency:        payout.Currency,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to credit cashback: %w", err)
+		}
+		reference = res.TransactionID
+	}
+
+	if err := e.repo.MarkPayoutPaid(ctx, payout.PayoutID, reference); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	payout.Status = CashbackStatusPaid
+	payout.Reference = reference
+	payout.PaidAt = &now
+
+	log.Printf("Cashback paid: player=%s net_loss=%s amount=%s mode=%s",
+		payout.PlayerID, payout.NetLoss.String(), payout.Amount.String(), payout.PayoutMode)
+	return payout, nil
+}
+
+// tierFor returns the highest tier whose minimum loss is met.
+func (e *CashbackEngine) tierFor(netLoss decimal.Decimal) (CashbackTier, bool) {
+	if !netLoss.IsPositive() {
+		return CashbackTier{}, false
+	}
+	for i := len(e.cfg.Tiers) - 1; i >= 0; i-- {
+		if netLoss.GreaterThanOrEqual(e.cfg.Tiers[i].MinLoss) {
+			return e.cfg.Tiers[i], true
+		}
+	}
+	return CashbackTier{}, false
+}
+
+// bonusID is the BonusID of the bonus a payout is paid as; see
+// CashbackConfig.BonusTemplateID.
+func (e *CashbackEngine) bonusID(payout *CashbackPayout) string {
+	if e.cfg.BonusTemplateID != "" {
+		return e.cfg.BonusTemplateID
+	}
+	key := strings.Join([]string{"cashback-bonus", payout.Currency, payout.PeriodStart.UTC().Format(time.RFC3339), payout.PeriodEnd.UTC().Format(time.RFC3339)}, "|")
+	return uuid.NewSHA1(cashbackNamespace, []byte(key)).String()
+}
+
+func (e *CashbackEngine) payoutID(playerID string, start time.Time, end time.Time) uuid.UUID {
+	key := strings.Join([]string{playerID, e.cfg.Currency, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339)}, "|")
+	return uuid.NewSHA1(cashbackNamespace, []byte(key))
+}
@@ -0,0 +1,369 @@
+package bonus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrDeadLetterNotFound    = errors.New("dead letter not found")
+	ErrDeadLetterNotPending  = errors.New("dead letter is not pending")
+	ErrDeadLetterBetMismatch = errors.New("fixed bet must keep the original bet_id")
+)
+
+type DeadLetterRepository interface {
+	// SaveDeadLetter records a failed bet and fills dl with the stored row. A
+	// bet that is already pending has its error, reason and attempt count
+	// updated instead; one that was replayed or discarded is left as it is.
+	SaveDeadLetter(ctx context.Context, dl *DeadLetterBet) error
+	GetDeadLetter(ctx context.Context, deadLetterID string) (*DeadLetterBet, error)
+	ListDeadLetters(ctx context.Context, status string, reason string, limit int, offset int) ([]DeadLetterBet, error)
+	UpdateDeadLetter(ctx context.Context, dl *DeadLetterBet) error
+}
+
+type DeadLetterRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewDeadLetterRepository(db *gorm.DB) *DeadLetterRepositoryImpl {
+	return &DeadLetterRepositoryImpl{db: db}
+}
+
+func (r *DeadLetterRepositoryImpl) SaveDeadLetter(ctx context.Context, dl *DeadLetterBet) error {
+	// Only a pending row takes the new failure; a bet that was already
+	// replayed or discarded stays resolved. RETURNING fills dl with the
+	// stored row, including its original ID.
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "bet_id"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "reason"}, Value: dl.Reason},
+				{Column: clause.Column{Name: "error"}, Value: dl.Error},
+				{Column: clause.Column{Name: "attempts"}, Value: gorm.Expr("dead_letter_bets.attempts + ?", dl.Attempts)},
+				{Column: clause.Column{Name: "last_attempt_at"}, Value: gorm.Expr("NOW()")},
+				{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("NOW()")},
+			},
+			Where: clause.Where{Exprs: []clause.Expression{
+				clause.Eq{Column: clause.Column{Table: "dead_letter_bets", Name: "status"}, Value: DeadLetterStatusPending},
+			}},
+		}, clause.Returning{}).
+		Create(dl)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save dead letter: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		stored, err := r.getDeadLetterByBetID(ctx, dl.BetID)
+		if err != nil {
+			return err
+		}
+		*dl = *stored
+	}
+	return nil
+}
+
+func (r *DeadLetterRepositoryImpl) getDeadLetterByBetID(ctx context.Context, betID string) (*DeadLetterBet, error) {
+	var dl DeadLetterBet
+	err := r.db.WithContext(ctx).
+		Where("bet_id = ?", betID).
+		First(&dl).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeadLetterNotFound
+		}
+		return nil, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+
+	return &dl, nil
+}
+
+func (r *DeadLetterRepositoryImpl) GetDeadLetter(ctx context.Context, deadLetterID string) (*DeadLetterBet, error) {
+	var dl DeadLetterBet
+	err := r.db.WithContext(ctx).
+		Where("dead_letter_id = ?", deadLetterID).
+		First(&dl).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDeadLetterNotFound
+		}
+		return nil, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+
+	return &dl, nil
+}
+
+func (r *DeadLetterRepositoryImpl) ListDeadLetters(ctx context.Context, status string, reason string, limit int, offset int) ([]DeadLetterBet, error) {
+	query := r.db.WithContext(ctx).Order("created_at")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if reason != "" {
+		query = query.Where("reason = ?", reason)
+	}
+
+	var dls []DeadLetterBet
+	if err := query.Limit(limit).Offset(offset).Find(&dls).Error; err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	return dls, nil
+}
+
+func (r *DeadLetterRepositoryImpl) UpdateDeadLetter(ctx context.Context, dl *DeadLetterBet) error {
+	result := r.db.WithContext(ctx).
+		Model(&DeadLetterBet{}).
+		Where("dead_letter_id = ?", dl.DeadLetterID).
+		Updates(map[string]interface{}{
+			"payload":         dl.Payload,
+			"reason":          dl.Reason,
+			"error":           dl.Error,
+			"attempts":        dl.Attempts,
+			"status":          dl.Status,
+			"last_attempt_at": dl.LastAttemptAt,
+			"resolved_at":     dl.ResolvedAt,
+			"updated_at":      gorm.Expr("NOW()"),
+		})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to update dead letter: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	return nil
+}
+
+// DeadLetterQueue wraps a BetProcessor and stores bets that can never
+// succeed as sent in dead_letter_bets, where operators can inspect, fix and
+// replay them. A bet that reaches the dead-letter table counts as handled,
+// so sources acknowledge it. The queue does not retry: a bet that failed
+// for any other reason is returned to its submitter, whose source owns
+// redelivery, and is dead-lettered through DeadLetter once that gives up.
+type DeadLetterQueue struct {
+	processor BetProcessor
+	repo      DeadLetterRepository
+}
+
+func NewDeadLetterQueue(processor BetProcessor, repo DeadLetterRepository) *DeadLetterQueue {
+	return &DeadLetterQueue{processor: processor, repo: repo}
+}
+
+func (q *DeadLetterQueue) ProcessBetWagering(ctx context.Context, bet BetEvent) error {
+	return q.check(ctx, bet, q.processor.ProcessBetWagering(ctx, bet))
+}
+
+// ProcessBetWageringDeferred passes bets through to a DeferredProcessor,
+// checking failures the same way once they are known.
+func (q *DeadLetterQueue) ProcessBetWageringDeferred(ctx context.Context, bet BetEvent, done func(error)) error {
+	deferred, ok := q.processor.(DeferredProcessor)
+	if !ok {
+		done(q.ProcessBetWagering(ctx, bet))
+		return nil
+	}
+
+	err := deferred.ProcessBetWageringDeferred(ctx, bet, func(flushErr error) {
+		done(q.check(context.Background(), bet, flushErr))
+	})
+	if err != nil {
+		done(q.check(ctx, bet, err))
+	}
+	return nil
+}
+
+// check dead-letters a bet whose error is permanent and returns any other
+// error for the source to redeliver.
+func (q *DeadLetterQueue) check(ctx context.Context, bet BetEvent, err error) error {
+	if err == nil || isRejection(err) {
+		return nil
+	}
+	if deadLetterReason(err) == DeadLetterReasonProcessingError {
+		return err
+	}
+	return q.settle(ctx, bet, err, 1)
+}
+
+// DeadLetter stores a bet that a Consumer gave up redelivering.
+func (q *DeadLetterQueue) DeadLetter(ctx context.Context, bet BetEvent, cause error, attempts int) error {
+	return q.settle(ctx, bet, cause, attempts)
+}
+
+// settle dead-letters a bet that failed with err. Successful and rejected
+// bets need nothing further.
+func (q *DeadLetterQueue) settle(ctx context.Context, bet BetEvent, err error, attempts int) error {
+	if err == nil || isRejection(err) {
+		return nil
+	}
+
+	payload, marshalErr := json.Marshal(bet)
+	if marshalErr != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", marshalErr)
+	}
+	betID := bet.BetID
+	if betID == "" {
+		betID = "missing-" + uuid.New().String()
+	}
+	dl := &DeadLetterBet{
+		DeadLetterID:  uuid.New().String(),
+		BetID:         betID,
+		PlayerID:      bet.PlayerID,
+		Payload:       string(payload),
+		Reason:        deadLetterReason(err),
+		Error:         err.Error(),
+		Attempts:      attempts,
+		Status:        DeadLetterStatusPending,
+		LastAttemptAt: time.Now(),
+	}
+	if saveErr := q.repo.SaveDeadLetter(ctx, dl); saveErr != nil {
+		return fmt.Errorf("failed to dead-letter bet after %v: %w", err, saveErr)
+	}
+
+	log.Printf("Bet dead-lettered: dead_letter_id=%s bet_id=%s player=%s reason=%s status=%s attempts=%d: %v",
+		dl.DeadLetterID, bet.BetID, bet.PlayerID, dl.Reason, dl.Status, attempts, err)
+	return nil
+}
+
+func (q *DeadLetterQueue) List(ctx context.Context, status string, reason string, limit int, offset int) ([]DeadLetterBet, error) {
+	return q.repo.ListDeadLetters(ctx, status, reason, limit, offset)
+}
+
+func (q *DeadLetterQueue) Get(ctx context.Context, deadLetterID string) (*DeadLetterBet, error) {
+	return q.repo.GetDeadLetter(ctx, deadLetterID)
+}
+
+// Fix replaces the stored payload of a pending dead letter, e.g. to correct a
+// game ID. The bet ID cannot change because it is the idempotency key, unless
+// the original event had none.
+func (q *DeadLetterQueue) Fix(ctx context.Context, deadLetterID string, bet BetEvent) (*DeadLetterBet, error) {
+	dl, err := q.pending(ctx, deadLetterID)
+	if err != nil {
+		return nil, err
+	}
+	var original BetEvent
+	if err := json.Unmarshal([]byte(dl.Payload), &original); err != nil {
+		return nil, fmt.Errorf("failed to decode dead letter: %w", err)
+	}
+	if original.BetID != "" && bet.BetID != original.BetID {
+		return nil, ErrDeadLetterBetMismatch
+	}
+
+	payload, err := json.Marshal(bet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	dl.Payload = string(payload)
+	if err := q.repo.UpdateDeadLetter(ctx, dl); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+// Replay processes a pending dead letter once more. On failure it stays
+// pending with the new error.
+func (q *DeadLetterQueue) Replay(ctx context.Context, deadLetterID string) (*DeadLetterBet, error) {
+	dl, err := q.pending(ctx, deadLetterID)
+	if err != nil {
+		return nil, err
+	}
+
+	var bet BetEvent
+	if err := json.Unmarshal([]byte(dl.Payload), &bet); err != nil {
+		return nil, fmt.Errorf("failed to decode dead letter: %w", err)
+	}
+
+	procErr := q.processor.ProcessBetWagering(ctx, bet)
+	dl.Attempts++
+	dl.LastAttemptAt = time.Now()
+	if procErr == nil || isRejection(procErr) {
+		dl.Status = DeadLetterStatusReplayed
+		dl.ResolvedAt = &dl.LastAttemptAt
+		if procErr != nil {
+			dl.Error = procErr.Error()
+		}
+	} else {
+		dl.Reason = deadLetterReason(procErr)
+		dl.Error = procErr.Error()
+	}
+	if err := q.repo.UpdateDeadLetter(ctx, dl); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Dead letter replayed: bet_id=%s status=%s attempts=%d", dl.BetID, dl.Status, dl.Attempts)
+	return dl, nil
+}
+
+// ReplayAll replays up to limit pending dead letters with the given reason,
+// or with any reason when reason is empty.
+func (q *DeadLetterQueue) ReplayAll(ctx context.Context, reason string, limit int) ([]DeadLetterBet, error) {
+	dls, err := q.repo.ListDeadLetters(ctx, DeadLetterStatusPending, reason, limit, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	replayed := make([]DeadLetterBet, 0, len(dls))
+	for _, dl := range dls {
+		result, err := q.Replay(ctx, dl.DeadLetterID)
+		if err != nil {
+			return replayed, err
+		}
+		replayed = append(replayed, *result)
+	}
+	return replayed, nil
+}
+
+// Discard closes a pending dead letter without processing it.
+func (q *DeadLetterQueue) Discard(ctx context.Context, deadLetterID string) (*DeadLetterBet, error) {
+	dl, err := q.pending(ctx, deadLetterID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	dl.Status = DeadLetterStatusDiscarded
+	dl.ResolvedAt = &now
+	if err := q.repo.UpdateDeadLetter(ctx, dl); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+func (q *DeadLetterQueue) pending(ctx context.Context, deadLetterID string) (*DeadLetterBet, error) {
+	dl, err := q.repo.GetDeadLetter(ctx, deadLetterID)
+	if err != nil {
+		return nil, err
+	}
+	if dl.Status != DeadLetterStatusPending {
+		return nil, ErrDeadLetterNotPending
+	}
+	return dl, nil
+}
+
+// isRejection reports errors that mean the bet legitimately does not count,
+// such as a bet arriving after its bonus expired or completed.
+func isRejection(err error) bool {
+	return errors.Is(err, ErrBonusExpired) || errors.Is(err, ErrBonusNotActive)
+}
+
+// deadLetterReason classifies an error. Everything other than a processing
+// error is permanent: retrying the same payload cannot succeed.
+func deadLetterReason(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidBet):
+		return DeadLetterReasonInvalidBet
+	case errors.Is(err, ErrGameNotFound):
+		return DeadLetterReasonGameNotFound
+	case errors.Is(err, ErrBetBeforeBonus), errors.Is(err, ErrBetTimestampInFuture), errors.Is(err, ErrBetAfterExpiry):
+		return DeadLetterReasonBetWindow
+	default:
+		return DeadLetterReasonProcessingError
+	}
+}
@@ -0,0 +1,148 @@
+package bonus
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// depositNamespace seeds the player bonus IDs of deposit bonuses.
+var depositNamespace = uuid.MustParse("0b8c7d4e-2a61-4f3b-9c85-61d2e0a4f7c3")
+
+// DepositOffer is the deposit bonus a template gives for one deposit.
+type DepositOffer struct {
+	Template BonusTemplate
+	Amount   decimal.Decimal
+}
+
+// DepositMatcher awards deposit-match bonuses. It is registered as the
+// wallet's DepositHook.
+type DepositMatcher struct {
+	templates TemplateRepository
+	codes     *BonusCodeService
+	bonuses   *BonusService
+}
+
+// NewDepositMatcher creates a DepositMatcher. codes may be nil, in which
+// case bonus codes entered with a deposit are ignored.
+func NewDepositMatcher(templates TemplateRepository, codes *BonusCodeService, bonuses *BonusService) *DepositMatcher {
+	return &DepositMatcher{templates: templates, codes: codes, bonuses: bonuses}
+}
+
+// OnDeposit awards the offer of the bonus code entered with the deposit or,
+// without a code, the best eligible deposit-match offer. Both are keyed by
+// the deposit's ReferenceID, so a replayed deposit finishes an interrupted
+// award instead of granting a second bonus.
+func (m *DepositMatcher) OnDeposit(ctx context.Context, req wallet.TransactionRequest, res *wallet.TransactionResponse) error {
+	if code := strings.TrimSpace(req.BonusCode); code != "" {
+		if m.codes == nil {
+			return nil
+		}
+		_, err := m.codes.Redeem(ctx, RedeemRequest{
+			Code:          code,
+			PlayerID:      req.PlayerID,
+			Country:       req.Country,
+			Currency:      req.Currency,
+			Key:           req.ReferenceID,
+			DepositAmount: req.Amount,
+		})
+		return err
+	}
+
+	offer, err := m.SelectOffer(ctx, req)
+	if err != nil || offer == nil {
+		return err
+	}
+
+	bonus, err := m.bonuses.AwardBonus(ctx, BonusAward{
+		PlayerBonusID:      DepositBonusID(req.ReferenceID).String(),
+		PlayerID:           req.PlayerID,
+		BonusID:            offer.Template.TemplateID,
+		Amount:             offer.Amount,
+		WageringMultiplier: offer.Template.WageringMultiplier,
+		Currency:           req.Currency,
+		ExpiresAt:          time.Now().Add(time.Duration(offer.Template.ValidityHours) * time.Hour),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deposit bonus awarded: template=%s player=%s deposit=%s bonus=%s",
+		offer.Template.TemplateID, req.PlayerID, req.Amount.String(), bonus.BonusAmount.String())
+	return nil
+}
+
+// SelectOffer returns the deposit-match offer that applies to a deposit
+// made without a bonus code, or nil if none does. Templates that require a
+// code are skipped. When several apply, the largest bonus wins.
+func (m *DepositMatcher) SelectOffer(ctx context.Context, req wallet.TransactionRequest) (*DepositOffer, error) {
+	templates, err := m.templates.ListTemplates(ctx, BonusTypeDepositMatch, req.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var firstDeposit *bool
+	var best *DepositOffer
+	for _, t := range templates {
+		if t.RequiresCode {
+			continue
+		}
+		amount, ok := matchAmount(&t, req.Amount)
+		if !ok {
+			continue
+		}
+		if t.FirstDepositOnly {
+			if firstDeposit == nil {
+				first, err := m.isFirstDeposit(ctx, req)
+				if err != nil {
+					return nil, err
+				}
+				firstDeposit = &first
+			}
+			if !*firstDeposit {
+				continue
+			}
+		}
+
+		if best == nil || amount.GreaterThan(best.Amount) {
+			best = &DepositOffer{Template: t, Amount: amount}
+		}
+	}
+	return best, nil
+}
+
+// isFirstDeposit reports whether a deposit is the player's first in its
+// currency. Two first deposits made at once can both count no earlier ones,
+// so the deposit must also win the player's first-deposit claim.
+func (m *DepositMatcher) isFirstDeposit(ctx context.Context, req wallet.TransactionRequest) (bool, error) {
+	count, err := m.templates.CountDeposits(ctx, req.PlayerID, req.Currency, req.ReferenceID)
+	if err != nil || count > 0 {
+		return false, err
+	}
+	return m.templates.ClaimFirstDeposit(ctx, req.PlayerID, req.Currency, req.ReferenceID)
+}
+
+// DepositBonusID returns the player bonus ID awarded for a deposit made
+// without a bonus code.
+func DepositBonusID(depositReference string) uuid.UUID {
+	return uuid.NewSHA1(depositNamespace, []byte(depositReference))
+}
+
+// matchAmount returns the bonus a deposit-match template gives for a
+// deposit, and false if the deposit is below the template's minimum.
+func matchAmount(t *BonusTemplate, deposit decimal.Decimal) (decimal.Decimal, bool) {
+	if deposit.LessThan(t.MinDeposit) {
+		return decimal.Zero, false
+	}
+	amount := deposit.Mul(t.MatchPercentage)
+	if t.MaxBonus.IsPositive() && amount.GreaterThan(t.MaxBonus) {
+		amount = t.MaxBonus
+	}
+	amount = amount.Round(2)
+	return amount, amount.IsPositive()
+}
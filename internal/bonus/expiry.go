@@ -0,0 +1,194 @@
+package bonus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultExpiryInterval is how often bonuses are checked for expiry and
+// reminders.
+const DefaultExpiryInterval = time.Minute
+
+// DefaultReminderWindows are how long before expiry players are reminded of
+// wagering they have left.
+var DefaultReminderWindows = []time.Duration{24 * time.Hour, time.Hour}
+
+// ParseReminderWindows parses a comma separated list of durations such as
+// "24h,1h".
+func ParseReminderWindows(spec string) ([]time.Duration, error) {
+	var windows []time.Duration
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid reminder window %q", part)
+		}
+		windows = append(windows, d)
+	}
+	return windows, nil
+}
+
+// ExpiryWatcher expires active bonuses whose time is up and reminds players
+// of bonuses about to expire with wagering left. Each reminder window is
+// sent at most once per bonus: the reminder is recorded in the outbox under
+// a key of bonus and window, and only the instance that records it notifies
+// the hub.
+type ExpiryWatcher struct {
+	bonuses *BonusService
+	outbox  OutboxRepository
+	windows []time.Duration // ascending
+}
+
+func NewExpiryWatcher(bonuses *BonusService, outbox OutboxRepository, windows []time.Duration) *ExpiryWatcher {
+	sorted := append([]time.Duration(nil), windows...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &ExpiryWatcher{bonuses: bonuses, outbox: outbox, windows: sorted}
+}
+
+// Sweep expires the bonuses that ran out before now and sends reminders for
+// those inside a reminder window. It returns how many bonuses it expired.
+func (w *ExpiryWatcher) Sweep(ctx context.Context, now time.Time) (int, error) {
+	horizon := now
+	if len(w.windows) > 0 {
+		horizon = now.Add(w.windows[len(w.windows)-1])
+	}
+	bonuses, err := w.bonuses.repo.ListActiveBonusesExpiringBefore(ctx, horizon)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for i := range bonuses {
+		b := &bonuses[i]
+		// Bets placed after ExpiresAt never count, but one placed just
+		// before may still be in flight, so expiry waits out the clock skew
+		// tolerance. A bet that arrives even later is dead-lettered.
+		if now.After(b.ExpiresAt.Add(w.bonuses.clockSkew)) {
+			ok, err := w.bonuses.expireBonus(ctx, b.PlayerBonusID)
+			if err != nil {
+				return expired, err
+			}
+			if ok {
+				expired++
+			}
+			continue
+		}
+		if err := w.remind(ctx, b, b.ExpiresAt.Sub(now)); err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// remind sends the reminder for the tightest window a bonus is in. Wider
+// windows that were missed, e.g. while the service was down, are skipped
+// rather than sent late.
+func (w *ExpiryWatcher) remind(ctx context.Context, b *PlayerBonus, left time.Duration) error {
+	if left <= 0 {
+		return nil
+	}
+	var window time.Duration
+	for _, d := range w.windows {
+		if left <= d {
+			window = d
+			break
+		}
+	}
+	if window == 0 {
+		return nil
+	}
+
+	live, err := w.bonuses.progress.GetProgress(ctx, b)
+	if err != nil {
+		return err
+	}
+	if live.GreaterThanOrEqual(b.WageringRequired) {
+		return nil
+	}
+	b.WageringCompleted = live
+
+	update := newWageringUpdate(b)
+	update.EventType = UpdateEventExpiringSoon
+	update.ReminderWindow = formatWindow(window)
+	notification, err := newOutboxNotification(fmt.Sprintf("%s:%s:%s", UpdateEventExpiringSoon, b.PlayerBonusID, update.ReminderWindow), update)
+	if err != nil {
+		return err
+	}
+	queued, err := w.outbox.Enqueue(ctx, notification)
+	if err != nil || !queued {
+		return err
+	}
+
+	w.bonuses.notifier.Event(update)
+	log.Printf("Bonus expiry reminder: bonus_id=%s player=%s window=%s wagering_left=%s",
+		b.PlayerBonusID, b.PlayerID, update.ReminderWindow, update.WageringRemaining.String())
+	return nil
+}
+
+// Run sweeps every interval until ctx is cancelled.
+func (w *ExpiryWatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := w.Sweep(ctx, now); err != nil {
+				log.Printf("Bonus expiry sweep failed: %v", err)
+			}
+		}
+	}
+}
+
+// formatWindow writes a window without zero minutes and seconds: "24h"
+// rather than "24h0m0s".
+func formatWindow(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// expireBonus marks an active bonus as expired and notifies the player. It
+// reports false if the bonus was no longer active.
+func (s *BonusService) expireBonus(ctx context.Context, playerBonusID string) (bool, error) {
+	var bonus *PlayerBonus
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		bonus, err = s.repo.GetBonusForUpdate(ctx, tx, playerBonusID)
+		if err != nil {
+			return err
+		}
+		if bonus.Status != BonusStatusActive {
+			bonus = nil
+			return nil
+		}
+		if err := s.repo.UpdateBonusStatus(ctx, tx, playerBonusID, BonusStatusExpired); err != nil {
+			return err
+		}
+		bonus.Status = BonusStatusExpired
+		return nil
+	})
+	if err != nil || bonus == nil {
+		return false, err
+	}
+
+	s.notifyEvent(UpdateEventExpired, bonus)
+	log.Printf("Bonus expired: bonus_id=%s player=%s wagering=%s/%s",
+		bonus.PlayerBonusID, bonus.PlayerID, bonus.WageringCompleted.String(), bonus.WageringRequired.String())
+	return true, nil
+}
@@ -0,0 +1,186 @@
+package bonus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"wallet_service/internal/wallet"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// Withdrawal rules for players with an active bonus.
+const (
+	WithdrawalRuleAllow   = "allow"   // withdraw without touching the bonus
+	WithdrawalRuleForfeit = "forfeit" // withdrawing forfeits the bonus
+	WithdrawalRuleBlock   = "block"   // withdrawing is refused until wagering is done
+)
+
+// ForfeitBonus ends an active bonus of a player and removes its funds from
+// the bonus wallet. Forfeiting a bonus that is already forfeited retries the
+// wallet debit, which is keyed by the bonus, so an interrupted forfeit can
+// be completed.
+func (s *BonusService) ForfeitBonus(ctx context.Context, playerID string, playerBonusID string, reason string) (*PlayerBonus, error) {
+	var bonus *PlayerBonus
+	forfeited := false
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		bonus, err = s.repo.GetBonusForUpdate(ctx, tx, playerBonusID)
+		if err != nil {
+			return err
+		}
+		if bonus.PlayerID != playerID {
+			return ErrBonusNotFound
+		}
+		switch bonus.Status {
+		case BonusStatusForfeited:
+			return nil
+		case BonusStatusActive:
+		default:
+			return ErrBonusNotActive
+		}
+
+		if err := s.repo.UpdateBonusStatus(ctx, tx, playerBonusID, BonusStatusForfeited); err != nil {
+			return err
+		}
+		bonus.Status = BonusStatusForfeited
+		forfeited = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if forfeited {
+		s.notifyEvent(UpdateEventForfeited, bonus)
+	}
+	removed, err := s.removeBonusFunds(ctx, bonus)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Bonus forfeited: bonus_id=%s player=%s reason=%s removed=%s",
+		bonus.PlayerBonusID, bonus.PlayerID, reason, removed.String())
+	return bonus, nil
+}
+
+// removeBonusFunds debits the forfeited bonus from the bonus wallet. Funds in
+// the bonus wallet are not tracked per bonus, so the whole balance goes when
+// no other bonus is active; otherwise at most the bonus amount does. The
+// amount is worked out once: a repeated forfeit finds the debit already
+// recorded and returns its amount, whatever the balance is by then.
+func (s *BonusService) removeBonusFunds(ctx context.Context, bonus *PlayerBonus) (decimal.Decimal, error) {
+	if s.wallet == nil {
+		return decimal.Zero, ErrWalletNotConfigured
+	}
+
+	reference := "forfeit:" + bonus.PlayerBonusID
+	removed, err := s.wallet.GetTransaction(ctx, reference, wallet.TransactionTypeBonusForfeit)
+	if err == nil {
+		return removed.Amount, nil
+	}
+	if !errors.Is(err, wallet.ErrTransactionNotFound) {
+		return decimal.Zero, err
+	}
+
+	w, err := s.wallet.GetBalance(ctx, bonus.PlayerID, wallet.WalletTypeBonus, bonus.Currency)
+	if err == wallet.ErrWalletNotFound {
+		return decimal.Zero, nil
+	}
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	active, err := s.repo.ListActiveBonuses(ctx, bonus.PlayerID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	amount := w.Balance
+	if len(active) > 0 {
+		amount = decimal.Min(amount, bonus.BonusAmount)
+	}
+	if !amount.IsPositive() {
+		return decimal.Zero, nil
+	}
+
+	_, err = s.wallet.ProcessTransaction(ctx, wallet.TransactionRequest{
+		PlayerID:        bonus.PlayerID,
+		WalletType:      wallet.WalletTypeBonus,
+		TransactionType: wallet.TransactionTypeBonusForfeit,
+		Amount:          amount,
+		ReferenceID:     reference,
+		Currency:        bonus.Currency,
+	})
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to remove bonus funds: %w", err)
+	}
+	return amount, nil
+}
+
+// WithdrawalPolicy applies the withdrawal rule to players with an active
+// bonus. It is registered as the wallet's WithdrawalGuard.
+type WithdrawalPolicy struct {
+	bonuses *BonusService
+	rule    string
+}
+
+func NewWithdrawalPolicy(bonuses *BonusService, rule string) (*WithdrawalPolicy, error) {
+	switch rule {
+	case WithdrawalRuleAllow, WithdrawalRuleForfeit, WithdrawalRuleBlock:
+	default:
+		return nil, fmt.Errorf("unknown withdrawal rule %q", rule)
+	}
+	return &WithdrawalPolicy{bonuses: bonuses, rule: rule}, nil
+}
+
+// BeforeWithdrawal refuses the withdrawal under the block rule. Under the
+// forfeit rule nothing is forfeited yet: the withdrawal may still fail.
+func (p *WithdrawalPolicy) BeforeWithdrawal(ctx context.Context, req wallet.TransactionRequest) error {
+	if p.rule != WithdrawalRuleBlock {
+		return nil
+	}
+
+	active, err := p.bonuses.repo.ListActiveBonuses(ctx, req.PlayerID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, b := range active {
+		if now.After(b.ExpiresAt) {
+			continue
+		}
+		remaining := decimal.Max(b.WageringRequired.Sub(b.WageringCompleted), decimal.Zero)
+		return fmt.Errorf("%w: bonus %s is active with %s wagering left; complete or forfeit it first",
+			wallet.ErrWithdrawalBlocked, b.PlayerBonusID, remaining.String())
+	}
+	return nil
+}
+
+// AfterWithdrawal forfeits, under the forfeit rule, the bonuses that were
+// active when the withdrawal was debited. Bonuses awarded after withdrawnAt
+// are left alone, so replaying the withdrawal only finishes its own
+// forfeits.
+func (p *WithdrawalPolicy) AfterWithdrawal(ctx context.Context, req wallet.TransactionRequest, withdrawnAt time.Time) error {
+	if p.rule != WithdrawalRuleForfeit {
+		return nil
+	}
+
+	active, err := p.bonuses.repo.ListActiveBonuses(ctx, req.PlayerID)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range active {
+		if b.CreatedAt.After(withdrawnAt) || withdrawnAt.After(b.ExpiresAt) {
+			continue
+		}
+		if _, err := p.bonuses.ForfeitBonus(ctx, req.PlayerID, b.PlayerBonusID, "withdrawal "+req.ReferenceID); err != nil {
+			return fmt.Errorf("failed to forfeit bonus after withdrawal: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package bonus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrFreeSpinGrantNotFound = errors.New("free spin grant not found")
+	ErrFreeSpinsNotActive    = errors.New("free spins are not active")
+	ErrFreeSpinsExpired      = errors.New("free spins have expired")
+	ErrNoFreeSpinsLeft       = errors.New("no free spins left")
+	ErrGameNotEligible       = errors.New("game is not eligible for these free spins")
+	ErrInvalidSpin           = errors.New("invalid free spin result")
+	ErrNotFreeSpinTemplate   = errors.New("template is not a free spins offer")
+)
+
+// freeSpinNamespace seeds the player bonus IDs that spin winnings become.
+var freeSpinNamespace = uuid.MustParse("a3d9e2f1-5c47-4b86-8e1a-7f0c2b9d6e54")
+
+// DefaultFreeSpinSettleInterval is how often expired grants are settled.
+const DefaultFreeSpinSettleInterval = time.Minute
+
+type FreeSpinRepository interface {
+	// CreateGrant inserts a grant unless one with the same ID exists, and
+	// returns the stored row.
+	CreateGrant(ctx context.Context, grant *FreeSpinGrant) (*FreeSpinGrant, error)
+	GetGrant(ctx context.Context, grantID string) (*FreeSpinGrant, error)
+	GetGrantForUpdate(ctx context.Context, tx *gorm.DB, grantID string) (*FreeSpinGrant, error)
+	UpdateGrant(ctx context.Context, tx *gorm.DB, grant *FreeSpinGrant) error
+	// CreateRoundIfAbsent records a spin round and reports whether it was
+	// new.
+	CreateRoundIfAbsent(ctx context.Context, tx *gorm.DB, round *FreeSpinRound) (bool, error)
+	// ListUnsettledGrants returns grants that are converting, or active but
+	// expired before now.
+	ListUnsettledGrants(ctx context.Context, now time.Time, limit int) ([]string, error)
+}
+
+type FreeSpinRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewFreeSpinRepository(db *gorm.DB) *FreeSpinRepositoryImpl {
+	return &FreeSpinRepositoryImpl{db: db}
+}
+
+func (r *FreeSpinRepositoryImpl) CreateGrant(ctx context.Context, grant *FreeSpinGrant) (*FreeSpinGrant, error) {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(grant).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to create free spin grant: %w", err)
+	}
+	return r.GetGrant(ctx, grant.GrantID)
+}
+
+func (r *FreeSpinRepositoryImpl) GetGrant(ctx context.Context, grantID string) (*FreeSpinGrant, error) {
+	var grant FreeSpinGrant
+	err := r.db.WithContext(ctx).
+		Where("grant_id = ?", grantID).
+		First(&grant).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFreeSpinGrantNotFound
+		}
+		return nil, fmt.Errorf("failed to get free spin grant: %w", err)
+	}
+
+	return &grant, nil
+}
+
+func (r *FreeSpinRepositoryImpl) GetGrantForUpdate(ctx context.Context, tx *gorm.DB, grantID string) (*FreeSpinGrant, error) {
+	var grant FreeSpinGrant
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("grant_id = ?", grantID).
+		First(&grant).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrFreeSpinGrantNotFound
+		}
+		return nil, fmt.Errorf("failed to lock free spin grant: %w", err)
+	}
+
+	return &grant, nil
+}
+
+func (r *FreeSpinRepositoryImpl) UpdateGrant(ctx context.Context, tx *gorm.DB, grant *FreeSpinGrant) error {
+	result := tx.WithContext(ctx).
+		Model(&FreeSpinGrant{}).
+		Where("grant_id = ?", grant.GrantID).
+		Updates(map[string]interface{}{
+			"spins_used":      grant.SpinsUsed,
+			"winnings":        grant.Winnings,
+			"status":          grant.Status,
+			"player_bonus_id": grant.PlayerBonusID,
+			"updated_at":      gorm.Expr("NOW()"),
+		})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to update free spin grant: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrFreeSpinGrantNotFound
+	}
+
+	return nil
+}
+
+func (r *FreeSpinRepositoryImpl) CreateRoundIfAbsent(ctx context.Context, tx *gorm.DB, round *FreeSpinRound) (bool, error) {
+	result := tx.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(round)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to create free spin round: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *FreeSpinRepositoryImpl) ListUnsettledGrants(ctx context.Context, now time.Time, limit int) ([]string, error) {
+	var ids []string
+	err := r.db.WithContext(ctx).
+		Model(&FreeSpinGrant{}).
+		Where("status = ? OR (status = ? AND expires_at < ?)", FreeSpinStatusConverting, FreeSpinStatusActive, now).
+		Order("expires_at").
+		Limit(limit).
+		Pluck("grant_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unsettled free spin grants: %w", err)
+	}
+	return ids, nil
+}
+
+// SpinResult is a provider's report of one free spin.
+type SpinResult struct {
+	GrantID   string          `json:"grant_id"`
+	RoundID   string          `json:"round_id"` // provider round ID; a repeated callback is ignored
+	GameID    string          `json:"game_id"`
+	WinAmount decimal.Decimal `json:"win_amount"`
+}
+
+// FreeSpinService grants free spins and turns their winnings into a wagering
+// bonus. Winnings accumulate on the grant while the provider reports spins;
+// once the last spin is played, or the grant expires, the grant moves to
+// converting and its winnings are awarded as a PlayerBonus with the
+// template's wagering multiplier.
+type FreeSpinService struct {
+	db        *gorm.DB
+	repo      FreeSpinRepository
+	templates TemplateRepository
+	bonuses   *BonusService
+}
+
+func NewFreeSpinService(db *gorm.DB, repo FreeSpinRepository, templates TemplateRepository, bonuses *BonusService) *FreeSpinService {
+	return &FreeSpinService{db: db, repo: repo, templates: templates, bonuses: bonuses}
+}
+
+// GrantFreeSpins gives a player the free spins of a template. grantID makes
+// the call idempotent; a new one is generated when it is empty.
+func (s *FreeSpinService) GrantFreeSpins(ctx context.Context, grantID string, playerID string, templateID string) (*FreeSpinGrant, error) {
+	template, err := s.templates.GetTemplate(ctx, templateID)
+	if err != nil {
+		return nil, err
+	}
+	if template.BonusType != BonusTypeFreeSpins || template.SpinCount <= 0 {
+		return nil, ErrNotFreeSpinTemplate
+	}
+	if grantID == "" {
+		grantID = uuid.NewString()
+	}
+
+	grant, err := s.repo.CreateGrant(ctx, &FreeSpinGrant{
+		GrantID:       grantID,
+		PlayerID:      playerID,
+		TemplateID:    template.TemplateID,
+		Currency:      template.Currency,
+		SpinCount:     template.SpinCount,
+		SpinValue:     template.SpinValue,
+		EligibleGames: template.EligibleGames,
+		Winnings:      decimal.Zero,
+		Status:        FreeSpinStatusActive,
+		ExpiresAt:     time.Now().Add(time.Duration(template.ValidityHours) * time.Hour),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Free spins granted: grant_id=%s player=%s spins=%d value=%s",
+		grant.GrantID, grant.PlayerID, grant.SpinCount, grant.SpinValue.String())
+	return grant, nil
+}
+
+func (s *FreeSpinService) GetGrant(ctx context.Context, grantID string) (*FreeSpinGrant, error) {
+	return s.repo.GetGrant(ctx, grantID)
+}
+
+// RecordSpin consumes one spin of a grant and adds its winnings. A round
+// that was already recorded is not counted again, but still finishes a
+// conversion that an earlier callback left incomplete.
+func (s *FreeSpinService) RecordSpin(ctx context.Context, spin SpinResult) (*FreeSpinGrant, error) {
+	if spin.GrantID == "" || spin.RoundID == "" || spin.GameID == "" || spin.WinAmount.IsNegative() {
+		return nil, ErrInvalidSpin
+	}
+
+	var grant *FreeSpinGrant
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		grant, err = s.repo.GetGrantForUpdate(ctx, tx, spin.GrantID)
+		if err != nil {
+			return err
+		}
+
+		created, err := s.repo.CreateRoundIfAbsent(ctx, tx, &FreeSpinRound{
+			RoundID:   spin.RoundID,
+			GrantID:   grant.GrantID,
+			GameID:    spin.GameID,
+			WinAmount: spin.WinAmount,
+		})
+		if err != nil {
+			return err
+		}
+		if !created {
+			log.Printf("Free spin round already recorded: round_id=%s", spin.RoundID)
+			return nil
+		}
+
+		if err := checkSpin(grant, spin.GameID); err != nil {
+			return err
+		}
+
+		grant.SpinsUsed++
+		grant.Winnings = grant.Winnings.Add(spin.WinAmount)
+		if grant.SpinsUsed == grant.SpinCount {
+			grant.Status = finishedStatus(grant, FreeSpinStatusCompleted)
+		}
+		return s.repo.UpdateGrant(ctx, tx, grant)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if grant.Status == FreeSpinStatusConverting {
+		return s.convert(ctx, grant)
+	}
+	return grant, nil
+}
+
+// Settle closes grants that expired with spins left and retries conversions
+// that did not finish. It returns the number of grants settled.
+func (s *FreeSpinService) Settle(ctx context.Context) (int, error) {
+	ids, err := s.repo.ListUnsettledGrants(ctx, time.Now(), 500)
+	if err != nil {
+		return 0, err
+	}
+
+	settled := 0
+	for _, id := range ids {
+		if err := s.settle(ctx, id); err != nil {
+			log.Printf("Failed to settle free spin grant %s: %v", id, err)
+			continue
+		}
+		settled++
+	}
+	return settled, nil
+}
+
+// RunSettlement calls Settle every interval until ctx is cancelled.
+func (s *FreeSpinService) RunSettlement(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Settle(ctx); err != nil {
+				log.Printf("Free spin settlement failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *FreeSpinService) settle(ctx context.Context, grantID string) error {
+	var grant *FreeSpinGrant
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		grant, err = s.repo.GetGrantForUpdate(ctx, tx, grantID)
+		if err != nil {
+			return err
+		}
+		if grant.Status != FreeSpinStatusActive || time.Now().Before(grant.ExpiresAt) {
+			return nil
+		}
+		grant.Status = finishedStatus(grant, FreeSpinStatusExpired)
+		return s.repo.UpdateGrant(ctx, tx, grant)
+	})
+	if err != nil {
+		return err
+	}
+
+	if grant.Status == FreeSpinStatusConverting {
+		_, err = s.convert(ctx, grant)
+	}
+	return err
+}
+
+// convert awards the winnings of a finished grant as a bonus. The bonus ID
+// is derived from the grant, so converting twice awards it once.
+func (s *FreeSpinService) convert(ctx context.Context, grant *FreeSpinGrant) (*FreeSpinGrant, error) {
+	template, err := s.templates.GetTemplate(ctx, grant.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+
+	bonus, err := s.bonuses.AwardBonus(ctx, BonusAward{
+		PlayerBonusID:      uuid.NewSHA1(freeSpinNamespace, []byte(grant.GrantID)).String(),
+		PlayerID:           grant.PlayerID,
+		BonusID:            template.TemplateID,
+		Amount:             grant.Winnings,
+		WageringMultiplier: template.WageringMultiplier,
+		Currency:           grant.Currency,
+		ExpiresAt:          time.Now().Add(time.Duration(template.ValidityHours) * time.Hour),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert free spin winnings: %w", err)
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		locked, err := s.repo.GetGrantForUpdate(ctx, tx, grant.GrantID)
+		if err != nil {
+			return err
+		}
+		if locked.Status != FreeSpinStatusConverting {
+			grant = locked
+			return nil
+		}
+		locked.Status = FreeSpinStatusCompleted
+		locked.PlayerBonusID = &bonus.PlayerBonusID
+		grant = locked
+		return s.repo.UpdateGrant(ctx, tx, locked)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Free spin winnings converted: grant_id=%s player=%s winnings=%s bonus_id=%s",
+		grant.GrantID, grant.PlayerID, grant.Winnings.String(), bonus.PlayerBonusID)
+	return grant, nil
+}
+
+func checkSpin(grant *FreeSpinGrant, gameID string) error {
+	if grant.Status != FreeSpinStatusActive {
+		return ErrFreeSpinsNotActive
+	}
+	if time.Now().After(grant.ExpiresAt) {
+		return ErrFreeSpinsExpired
+	}
+	if grant.SpinsUsed >= grant.SpinCount {
+		return ErrNoFreeSpinsLeft
+	}
+	for _, g := range grant.EligibleGames {
+		if g == gameID {
+			return nil
+		}
+	}
+	return ErrGameNotEligible
+}
+
+// finishedStatus is the status of a grant with no spins left to play:
+// converting if anything was won, otherwise noWinnings.
+func finishedStatus(grant *FreeSpinGrant, noWinnings string) string {
+	if grant.Winnings.IsPositive() {
+		return FreeSpinStatusConverting
+	}
+	return noWinnings
+}
@@ -0,0 +1,253 @@
+package bonus
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrIngestorClosed = errors.New("ingestor is closed")
+	ErrQueueFull      = errors.New("ingestion queue is full")
+)
+
+const (
+	DefaultIngestWorkers   = 8
+	DefaultIngestQueueSize = 1024
+)
+
+// BetProcessor applies a single bet to the player's wagering progress.
+// BonusService is the default implementation.
+type BetProcessor interface {
+	ProcessBetWagering(ctx context.Context, bet BetEvent) error
+}
+
+// DeferredProcessor is a BetProcessor that may finish applying a bet after
+// the call returns, for example by batching writes. If
+// ProcessBetWageringDeferred returns an error, done is never called;
+// otherwise done is called exactly once with the final result.
+type DeferredProcessor interface {
+	BetProcessor
+	ProcessBetWageringDeferred(ctx context.Context, bet BetEvent, done func(error)) error
+}
+
+type IngestorConfig struct {
+	Workers   int // number of player shards, each served by one goroutine
+	QueueSize int // buffered bets per shard
+}
+
+type IngestorStats struct {
+	QueueDepth    int   `json:"queue_depth"`
+	QueueCapacity int   `json:"queue_capacity"`
+	Processed     int64 `json:"processed"`
+	Failed        int64 `json:"failed"`
+	LastLagMs     int64 `json:"last_lag_ms"`
+	MaxLagMs      int64 `json:"max_lag_ms"`
+}
+
+type ingestItem struct {
+	bet        BetEvent
+	enqueuedAt time.Time
+	done       func(error)
+}
+
+// Ingestor accepts bet events and processes them asynchronously. Bets are
+// sharded by player so that events for one player are applied in the order
+// they were submitted, while different players are processed in parallel.
+// Only the shard workers touch the database, so callers never wait on the
+// player_bonus row lock.
+type Ingestor struct {
+	processor BetProcessor
+	shards    []chan ingestItem
+	queueSize int
+
+	mu        sync.RWMutex
+	closed    bool
+	closing   chan struct{} // closed when Shutdown starts, releasing blocked submitters
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	processed atomic.Int64
+	failed    atomic.Int64
+	lastLag   atomic.Int64
+	maxLag    atomic.Int64
+}
+
+func NewIngestor(processor BetProcessor, cfg IngestorConfig) *Ingestor {
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultIngestWorkers
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultIngestQueueSize
+	}
+
+	shards := make([]chan ingestItem, cfg.Workers)
+	for i := range shards {
+		shards[i] = make(chan ingestItem, cfg.QueueSize)
+	}
+	return &Ingestor{
+		processor: processor,
+		shards:    shards,
+		queueSize: cfg.QueueSize,
+		closing:   make(chan struct{}),
+	}
+}
+
+// Start launches one worker per shard. Workers run until Shutdown is called
+// and their shard has been drained.
+func (i *Ingestor) Start() {
+	for _, shard := range i.shards {
+		i.wg.Add(1)
+		go i.work(shard)
+	}
+}
+
+// Submit enqueues a bet, blocking while the player's shard is full. This is
+// the back-pressure path for consumers that can afford to wait.
+func (i *Ingestor) Submit(ctx context.Context, bet BetEvent) error {
+	return i.SubmitWithCallback(ctx, bet, nil)
+}
+
+// SubmitWithCallback is Submit, calling done with the processing result once
+// the bet has been applied. done runs on the shard worker, so it should be
+// quick. A submitter blocked on a full shard gives up with
+// ErrIngestorClosed once Shutdown starts, so it never holds up shutdown.
+func (i *Ingestor) SubmitWithCallback(ctx context.Context, bet BetEvent, done func(error)) error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.closed {
+		return ErrIngestorClosed
+	}
+
+	select {
+	case i.shardFor(bet.PlayerID) <- ingestItem{bet: bet, enqueuedAt: time.Now(), done: done}:
+		return nil
+	case <-i.closing:
+		return ErrIngestorClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TrySubmit enqueues a bet without blocking and returns ErrQueueFull when the
+// player's shard has no room.
+func (i *Ingestor) TrySubmit(bet BetEvent) error {
+	return i.TrySubmitWithCallback(bet, nil)
+}
+
+// TrySubmitWithCallback is TrySubmit, calling done as SubmitWithCallback does.
+func (i *Ingestor) TrySubmitWithCallback(bet BetEvent, done func(error)) error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.closed {
+		return ErrIngestorClosed
+	}
+
+	select {
+	case i.shardFor(bet.PlayerID) <- ingestItem{bet: bet, enqueuedAt: time.Now(), done: done}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops accepting bets and waits for everything already queued to
+// be processed, or for ctx to expire.
+func (i *Ingestor) Shutdown(ctx context.Context) error {
+	i.closeOnce.Do(func() { close(i.closing) })
+	i.mu.Lock()
+	if !i.closed {
+		i.closed = true
+		for _, shard := range i.shards {
+			close(shard)
+		}
+	}
+	i.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		i.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (i *Ingestor) Stats() IngestorStats {
+	depth := 0
+	for _, shard := range i.shards {
+		depth += len(shard)
+	}
+	return IngestorStats{
+		QueueDepth:    depth,
+		QueueCapacity: len(i.shards) * i.queueSize,
+		Processed:     i.processed.Load(),
+		Failed:        i.failed.Load(),
+		LastLagMs:     i.lastLag.Load(),
+		MaxLagMs:      i.maxLag.Load(),
+	}
+}
+
+func (i *Ingestor) shardFor(playerID string) chan ingestItem {
+	h := fnv.New32a()
+	h.Write([]byte(playerID))
+	return i.shards[h.Sum32()%uint32(len(i.shards))]
+}
+
+func (i *Ingestor) work(shard <-chan ingestItem) {
+	defer i.wg.Done()
+
+	deferred, isDeferred := i.processor.(DeferredProcessor)
+	for item := range shard {
+		if isDeferred {
+			item := item
+			err := deferred.ProcessBetWageringDeferred(context.Background(), item.bet, func(err error) {
+				i.complete(item, err)
+			})
+			if err != nil {
+				i.complete(item, err)
+			}
+			continue
+		}
+		i.complete(item, i.processor.ProcessBetWagering(context.Background(), item.bet))
+	}
+}
+
+func (i *Ingestor) complete(item ingestItem, err error) {
+	if err != nil {
+		i.failed.Add(1)
+		log.Printf("Ingestor failed to process bet: bet_id=%s player=%s: %v", item.bet.BetID, item.bet.PlayerID, err)
+	} else {
+		i.processed.Add(1)
+	}
+	i.recordLag(item)
+	if item.done != nil {
+		item.done(err)
+	}
+}
+
+// recordLag measures the time from the bet being placed (or enqueued, if the
+// event carries no timestamp) until processing finished.
+func (i *Ingestor) recordLag(item ingestItem) {
+	from := item.bet.Timestamp
+	if from.IsZero() {
+		from = item.enqueuedAt
+	}
+	lag := time.Since(from).Milliseconds()
+	i.lastLag.Store(lag)
+	for {
+		max := i.maxLag.Load()
+		if lag <= max || i.maxLag.CompareAndSwap(max, lag) {
+			return
+		}
+	}
+}
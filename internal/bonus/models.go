@@ -12,6 +12,7 @@ type PlayerBonus struct {
 	BonusID           string          `gorm:"column:bonus_id;type:uuid;not null"`
 	Status            string          `gorm:"column:status;type:varchar(20);not null;default:'active'"` // "active", "completed", "forfeited", "expired"
 	BonusAmount       decimal.Decimal `gorm:"column:bonus_amount;type:numeric(20,2);not null"`
+	Currency          string          `gorm:"column:currency;type:varchar(3);not null;default:'USD'"` // currency of the bonus wallet funds
 	WageringRequired  decimal.Decimal `gorm:"column:wagering_required;type:numeric(20,2);not null"`
 	WageringCompleted decimal.Decimal `gorm:"column:wagering_completed;type:numeric(20,2);not null;default:0"`
 	ExpiresAt         time.Time       `gorm:"column:expires_at;not null"`
@@ -36,8 +37,160 @@ type WageringEvent struct {
 	BetAmount              decimal.Decimal `gorm:"column:bet_amount;type:numeric(20,2);not null"`
 	ContributionPercentage decimal.Decimal `gorm:"column:contribution_percentage;type:numeric(5,4);not null"`
 	WageringContribution   decimal.Decimal `gorm:"column:wagering_contribution;type:numeric(20,2);not null"`
+	PlacedAt               time.Time       `gorm:"column:placed_at;not null;default:now()"` // bet timestamp, judged against the bonus window; events are ordered by created_at
 	CreatedAt              time.Time       `gorm:"column:created_at;not null;default:now()"`
 }
+
+type ConsumerOffset struct {
+	Consumer        string    `gorm:"column:consumer;primaryKey;type:varchar(100)"`
+	Source          string    `gorm:"column:source;primaryKey;type:varchar(255)"`
+	CommittedOffset int64     `gorm:"column:committed_offset;not null;default:0"`
+	UpdatedAt       time.Time `gorm:"column:updated_at;not null;default:now()"`
+}
+
+type DeadLetterBet struct {
+	DeadLetterID  string     `gorm:"column:dead_letter_id;primaryKey;type:uuid;default:uuid_generate_v4()"`
+	BetID         string     `gorm:"column:bet_id;type:varchar(255);not null;unique"`
+	PlayerID      string     `gorm:"column:player_id;type:varchar(255);not null"`
+	Payload       string     `gorm:"column:payload;type:jsonb;not null"`                        // the BetEvent as received, or as fixed by an operator
+	Reason        string     `gorm:"column:reason;type:varchar(50);not null"`                   // "invalid_bet", "game_not_found", "bet_window", "processing_error"
+	Error         string     `gorm:"column:error;type:text;not null"`                           // last error
+	Attempts      int        `gorm:"column:attempts;not null;default:0"`                        // processing attempts, including replays
+	Status        string     `gorm:"column:status;type:varchar(20);not null;default:'pending'"` // "pending", "replayed", "discarded"
+	LastAttemptAt time.Time  `gorm:"column:last_attempt_at;not null;default:now()"`
+	ResolvedAt    *time.Time `gorm:"column:resolved_at"`
+	CreatedAt     time.Time  `gorm:"column:created_at;not null;default:now()"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at;not null;default:now()"`
+}
+
+type BonusTemplate struct {
+	TemplateID         string          `gorm:"column:template_id;primaryKey;type:uuid;default:uuid_generate_v4()" json:"template_id"`
+	Name               string          `gorm:"column:name;type:varchar(100);not null" json:"name"`
+	BonusType          string          `gorm:"column:bonus_type;type:varchar(20);not null" json:"bonus_type"`    // "deposit_match", "free_spins", "no_deposit"
+	RequiresCode       bool            `gorm:"column:requires_code;not null;default:false" json:"requires_code"` // only awarded through a bonus code
+	Currency           string          `gorm:"column:currency;type:varchar(3);not null" json:"currency"`
+	Amount             decimal.Decimal `gorm:"column:amount;type:numeric(20,2);not null;default:0" json:"amount"`                    // no_deposit only
+	MatchPercentage    decimal.Decimal `gorm:"column:match_percentage;type:numeric(6,4);not null;default:0" json:"match_percentage"` // 1.0000 = 100% of the deposit
+	MaxBonus           decimal.Decimal `gorm:"column:max_bonus;type:numeric(20,2);not null;default:0" json:"max_bonus"`              // 0 = uncapped
+	MinDeposit         decimal.Decimal `gorm:"column:min_deposit;type:numeric(20,2);not null;default:0" json:"min_deposit"`
+	FirstDepositOnly   bool            `gorm:"column:first_deposit_only;not null;default:false" json:"first_deposit_only"`
+	SpinCount          int             `gorm:"column:spin_count;not null;default:0" json:"spin_count,omitempty"`                 // free spins only
+	SpinValue          decimal.Decimal `gorm:"column:spin_value;type:numeric(20,2);not null;default:0" json:"spin_value"`        // stake of each free spin
+	EligibleGames      []string        `gorm:"column:eligible_games;type:jsonb;serializer:json" json:"eligible_games,omitempty"` // game IDs the spins can be played on
+	WageringMultiplier decimal.Decimal `gorm:"column:wagering_multiplier;type:numeric(10,2);not null" json:"wagering_multiplier"`
+	ValidityHours      int             `gorm:"column:validity_hours;not null" json:"validity_hours"` // how long the bonus stays valid; for free spins also how long the spins do
+	Active             bool            `gorm:"column:active;not null;default:true" json:"active"`
+	CreatedAt          time.Time       `gorm:"column:created_at;not null;default:now()" json:"created_at"`
+	UpdatedAt          time.Time       `gorm:"column:updated_at;not null;default:now()" json:"updated_at"`
+}
+
+// FirstDepositClaim records which deposit was a player's first in a
+// currency. Its primary key lets only one deposit qualify for first-deposit
+// offers, even when two arrive at once.
+type FirstDepositClaim struct {
+	PlayerID    string    `gorm:"column:player_id;primaryKey;type:uuid"`
+	Currency    string    `gorm:"column:currency;primaryKey;type:varchar(3)"`
+	ReferenceID string    `gorm:"column:reference_id;type:varchar(255);not null"`
+	CreatedAt   time.Time `gorm:"column:created_at;not null;default:now()"`
+}
+
+type BonusCode struct {
+	Code           string     `gorm:"column:code;primaryKey;type:varchar(50)" json:"code"` // stored upper case
+	TemplateID     string     `gorm:"column:template_id;type:uuid;not null" json:"template_id"`
+	MaxRedemptions int        `gorm:"column:max_redemptions;not null;default:0" json:"max_redemptions"` // 0 = unlimited
+	MaxPerPlayer   int        `gorm:"column:max_per_player;not null;default:1" json:"max_per_player"`   // 0 = unlimited
+	Redemptions    int        `gorm:"column:redemptions;not null;default:0" json:"redemptions"`
+	ValidFrom      *time.Time `gorm:"column:valid_from" json:"valid_from,omitempty"`
+	ValidUntil     *time.Time `gorm:"column:valid_until" json:"valid_until,omitempty"`
+	Countries      []string   `gorm:"column:countries;type:jsonb;serializer:json" json:"countries,omitempty"`   // ISO codes; empty = any
+	Currencies     []string   `gorm:"column:currencies;type:jsonb;serializer:json" json:"currencies,omitempty"` // empty = the template's currency
+	NewPlayersOnly bool       `gorm:"column:new_players_only;not null;default:false" json:"new_players_only"`   // players who never had a bonus
+	MinDeposits    int        `gorm:"column:min_deposits;not null;default:0" json:"min_deposits"`               // completed deposits the player needs
+	Active         bool       `gorm:"column:active;not null;default:true" json:"active"`
+	CreatedAt      time.Time  `gorm:"column:created_at;not null;default:now()" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at;not null;default:now()" json:"updated_at"`
+}
+
+type BonusCodeRedemption struct {
+	RedemptionID string    `gorm:"column:redemption_id;primaryKey;type:uuid" json:"redemption_id"` // derived from code, player and redemption key
+	Code         string    `gorm:"column:code;type:varchar(50);not null" json:"code"`
+	PlayerID     string    `gorm:"column:player_id;type:uuid;not null" json:"player_id"`
+	TemplateID   string    `gorm:"column:template_id;type:uuid;not null" json:"template_id"`
+	BonusType    string    `gorm:"column:bonus_type;type:varchar(20);not null" json:"bonus_type"`
+	Currency     string    `gorm:"column:currency;type:varchar(3);not null" json:"currency"`
+	AwardID      string    `gorm:"column:award_id;type:uuid;not null" json:"award_id"`                     // player bonus or free spin grant
+	Reference    string    `gorm:"column:reference;type:varchar(255);not null" json:"reference,omitempty"` // deposit reference or client key
+	CreatedAt    time.Time `gorm:"column:created_at;not null;default:now()" json:"created_at"`
+}
+
+type FreeSpinGrant struct {
+	GrantID       string          `gorm:"column:grant_id;primaryKey;type:uuid" json:"grant_id"`
+	PlayerID      string          `gorm:"column:player_id;type:uuid;not null" json:"player_id"`
+	TemplateID    string          `gorm:"column:template_id;type:uuid;not null" json:"template_id"`
+	Currency      string          `gorm:"column:currency;type:varchar(3);not null" json:"currency"`
+	SpinCount     int             `gorm:"column:spin_count;not null" json:"spin_count"`
+	SpinsUsed     int             `gorm:"column:spins_used;not null;default:0" json:"spins_used"`
+	SpinValue     decimal.Decimal `gorm:"column:spin_value;type:numeric(20,2);not null" json:"spin_value"`
+	EligibleGames []string        `gorm:"column:eligible_games;type:jsonb;serializer:json;not null" json:"eligible_games"`
+	Winnings      decimal.Decimal `gorm:"column:winnings;type:numeric(20,2);not null;default:0" json:"winnings"`
+	Status        string          `gorm:"column:status;type:varchar(20);not null;default:'active'" json:"status"` // "active", "converting", "completed", "expired"
+	PlayerBonusID *string         `gorm:"column:player_bonus_id;type:uuid" json:"player_bonus_id,omitempty"`      // bonus the winnings were converted into
+	ExpiresAt     time.Time       `gorm:"column:expires_at;not null" json:"expires_at"`
+	CreatedAt     time.Time       `gorm:"column:created_at;not null;default:now()" json:"created_at"`
+	UpdatedAt     time.Time       `gorm:"column:updated_at;not null;default:now()" json:"updated_at"`
+}
+
+type FreeSpinRound struct {
+	RoundID   string          `gorm:"column:round_id;primaryKey;type:varchar(255)"` // provider round ID, for idempotency
+	GrantID   string          `gorm:"column:grant_id;type:uuid;not null"`
+	GameID    string          `gorm:"column:game_id;type:uuid;not null"`
+	WinAmount decimal.Decimal `gorm:"column:win_amount;type:numeric(20,2);not null"`
+	CreatedAt time.Time       `gorm:"column:created_at;not null;default:now()"`
+}
+
+type CashbackPayout struct {
+	PayoutID    string          `gorm:"column:payout_id;primaryKey;type:uuid"` // derived from player, currency and period
+	PlayerID    string          `gorm:"column:player_id;type:uuid;not null"`
+	Currency    string          `gorm:"column:currency;type:varchar(3);not null"`
+	PeriodStart time.Time       `gorm:"column:period_start;not null"`
+	PeriodEnd   time.Time       `gorm:"column:period_end;not null"`
+	TotalBets   decimal.Decimal `gorm:"column:total_bets;type:numeric(20,2);not null"`
+	TotalWins   decimal.Decimal `gorm:"column:total_wins;type:numeric(20,2);not null"`
+	NetLoss     decimal.Decimal `gorm:"column:net_loss;type:numeric(20,2);not null"`
+	Percentage  decimal.Decimal `gorm:"column:percentage;type:numeric(5,4);not null"`
+	Amount      decimal.Decimal `gorm:"column:amount;type:numeric(20,2);not null"`
+	PayoutMode  string          `gorm:"column:payout_mode;type:varchar(20);not null"`              // "wallet", "bonus"
+	Status      string          `gorm:"column:status;type:varchar(20);not null;default:'pending'"` // "pending", "paid"
+	Reference   string          `gorm:"column:reference;type:varchar(255)"`                        // wallet transaction or player bonus ID
+	CreatedAt   time.Time       `gorm:"column:created_at;not null;default:now()"`
+	PaidAt      *time.Time      `gorm:"column:paid_at"`
+}
+
+// CashbackPeriod records that the periodic run has handled a cashback
+// period, so periods that closed while the service was down are caught up
+// and failed ones retried.
+type CashbackPeriod struct {
+	Currency    string    `gorm:"column:currency;primaryKey;type:varchar(3)"`
+	PeriodStart time.Time `gorm:"column:period_start;primaryKey"`
+	PeriodEnd   time.Time `gorm:"column:period_end;primaryKey"`
+	Status      string    `gorm:"column:status;type:varchar(20);not null"` // "completed", "failed"
+	Error       string    `gorm:"column:error;type:text"`                  // first payout error of a failed run
+	UpdatedAt   time.Time `gorm:"column:updated_at;not null;default:now()"`
+}
+
+// OutboxNotification is a player notification waiting to be published to
+// an external delivery channel (push, email, CRM) by the OutboxRelay. Rows
+// are written once per dedupe key, so a notification is never queued twice.
+type OutboxNotification struct {
+	NotificationID string     `gorm:"column:notification_id;primaryKey;type:uuid"` // derived from DedupeKey
+	DedupeKey      string     `gorm:"column:dedupe_key;type:varchar(255);not null"`
+	PlayerID       string     `gorm:"column:player_id;type:uuid;not null"`
+	EventType      string     `gorm:"column:event_type;type:varchar(30);not null"`
+	Payload        string     `gorm:"column:payload;type:jsonb;not null"`
+	CreatedAt      time.Time  `gorm:"column:created_at;not null;default:now()"`
+	PublishedAt    *time.Time `gorm:"column:published_at"`
+}
+
 type BetEvent struct {
 	BetID     string          `json:"bet_id"`
 	PlayerID  string          `json:"player_id"`
@@ -61,15 +214,29 @@ type WageringProgress struct {
 }
 
 type WageringUpdate struct {
+	EventType          string          `json:"event_type"` // "progress", "milestone", "bonus_awarded", "expiring_soon", "expired", "forfeited"
 	PlayerBonusID      string          `json:"player_bonus_id"`
 	PlayerID           string          `json:"player_id"`
 	WageringCompleted  decimal.Decimal `json:"wagering_completed"`
 	WageringRequired   decimal.Decimal `json:"wagering_required"`
+	WageringRemaining  decimal.Decimal `json:"wagering_remaining"`
 	PercentageComplete float64         `json:"percentage_complete"`
+	Milestone          int             `json:"milestone,omitempty"`       // percentage reached, for milestone events
+	ReminderWindow     string          `json:"reminder_window,omitempty"` // e.g. "24h", for expiring_soon events
 	Completed          bool            `json:"completed"`
+	ExpiresAt          time.Time       `json:"expires_at"`
 	Timestamp          time.Time       `json:"timestamp"`
 }
 
+const (
+	UpdateEventProgress     = "progress"
+	UpdateEventMilestone    = "milestone"
+	UpdateEventAwarded      = "bonus_awarded"
+	UpdateEventExpiringSoon = "expiring_soon"
+	UpdateEventExpired      = "expired"
+	UpdateEventForfeited    = "forfeited"
+)
+
 const (
 	BonusStatusActive    = "active"
 	BonusStatusCompleted = "completed"
@@ -77,6 +244,47 @@ const (
 	BonusStatusExpired   = "expired"
 )
 
+const (
+	DeadLetterStatusPending   = "pending"
+	DeadLetterStatusReplayed  = "replayed"
+	DeadLetterStatusDiscarded = "discarded"
+)
+
+const (
+	DeadLetterReasonInvalidBet      = "invalid_bet"
+	DeadLetterReasonGameNotFound    = "game_not_found"
+	DeadLetterReasonBetWindow       = "bet_window"
+	DeadLetterReasonProcessingError = "processing_error"
+)
+
+const (
+	BonusTypeDepositMatch = "deposit_match"
+	BonusTypeFreeSpins    = "free_spins"
+	BonusTypeNoDeposit    = "no_deposit"
+)
+
+const (
+	FreeSpinStatusActive     = "active"
+	FreeSpinStatusConverting = "converting" // spins finished, winnings not yet awarded
+	FreeSpinStatusCompleted  = "completed"
+	FreeSpinStatusExpired    = "expired" // ran out of time with nothing won
+)
+
+const (
+	CashbackModeWallet = "wallet"
+	CashbackModeBonus  = "bonus"
+)
+
+const (
+	CashbackStatusPending = "pending"
+	CashbackStatusPaid    = "paid"
+)
+
+const (
+	CashbackPeriodCompleted = "completed"
+	CashbackPeriodFailed    = "failed"
+)
+
 const (
 	GameTypeSlots      = "slots"
 	GameTypeTableGames = "table_games"
@@ -0,0 +1,147 @@
+package bonus
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNotifyThrottle is the shortest gap between two progress updates sent
+// to one player.
+const DefaultNotifyThrottle = 500 * time.Millisecond
+
+// DefaultMilestones are the wagering percentages that are always announced.
+var DefaultMilestones = []int{25, 50, 75, 100}
+
+// NotificationRules decide which wagering updates reach subscribers.
+type NotificationRules struct {
+	Milestones []int         // percentages sent as soon as they are reached
+	Throttle   time.Duration // at most one progress update per player per Throttle; 0 sends all
+}
+
+// ParseMilestones parses a comma separated list of percentages such as
+// "25,50,75,100".
+func ParseMilestones(spec string) ([]int, error) {
+	var milestones []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		m, err := strconv.Atoi(part)
+		if err != nil || m <= 0 || m > 100 {
+			return nil, fmt.Errorf("invalid milestone %q: want a percentage between 1 and 100", part)
+		}
+		milestones = append(milestones, m)
+	}
+	sort.Ints(milestones)
+	return milestones, nil
+}
+
+// Notifier sends wagering updates through a NotificationHub. Milestones and
+// events such as an awarded or expired bonus go out immediately; plain
+// progress updates are throttled per player, and the last one held back is
+// sent when the throttle window closes, so subscribers always end up with the
+// latest state.
+type Notifier struct {
+	hub   *NotificationHub
+	rules NotificationRules
+
+	mu      sync.Mutex
+	windows map[string]*throttleWindow // by player
+}
+
+// throttleWindow is open while a player has had an update within the
+// throttle interval.
+type throttleWindow struct {
+	pending *WageringUpdate
+	timer   *time.Timer
+}
+
+func NewNotifier(hub *NotificationHub, rules NotificationRules) *Notifier {
+	return &Notifier{
+		hub:     hub,
+		rules:   rules,
+		windows: make(map[string]*throttleWindow),
+	}
+}
+
+// Progress reports wagering progress that moved from previousPct to
+// update.PercentageComplete. Crossing a milestone or completing the bonus is
+// sent as a milestone event; anything else is throttled.
+func (n *Notifier) Progress(update WageringUpdate, previousPct float64) {
+	if milestone := n.crossedMilestone(previousPct, update.PercentageComplete); milestone > 0 || update.Completed {
+		if milestone == 0 {
+			milestone = 100
+		}
+		update.EventType = UpdateEventMilestone
+		update.Milestone = milestone
+		n.Event(update)
+		return
+	}
+
+	update.EventType = UpdateEventProgress
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.rules.Throttle <= 0 {
+		n.hub.Notify(update.PlayerID, update)
+		return
+	}
+	if w, ok := n.windows[update.PlayerID]; ok {
+		w.pending = &update
+		return
+	}
+	n.hub.Notify(update.PlayerID, update)
+	n.openWindow(update.PlayerID)
+}
+
+// Event sends an update right away. A progress update held back for the
+// same bonus is dropped, as the event carries newer state.
+func (n *Notifier) Event(update WageringUpdate) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	w, ok := n.windows[update.PlayerID]
+	if ok && w.pending != nil && w.pending.PlayerBonusID == update.PlayerBonusID {
+		w.pending = nil
+	}
+	n.hub.Notify(update.PlayerID, update)
+	if !ok && n.rules.Throttle > 0 {
+		n.openWindow(update.PlayerID)
+	}
+}
+
+// openWindow starts a throttle window for a player. Callers must hold n.mu.
+func (n *Notifier) openWindow(playerID string) {
+	w := &throttleWindow{}
+	w.timer = time.AfterFunc(n.rules.Throttle, func() { n.closeWindow(playerID, w) })
+	n.windows[playerID] = w
+}
+
+// closeWindow sends the update held back during a window, which opens the
+// next one, or forgets the player if there was none.
+func (n *Notifier) closeWindow(playerID string, w *throttleWindow) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.windows[playerID] != w {
+		return
+	}
+	delete(n.windows, playerID)
+	if w.pending != nil {
+		n.hub.Notify(playerID, *w.pending)
+		n.openWindow(playerID)
+	}
+}
+
+// crossedMilestone returns the highest milestone in (previousPct, pct], or 0.
+func (n *Notifier) crossedMilestone(previousPct float64, pct float64) int {
+	crossed := 0
+	for _, m := range n.rules.Milestones {
+		if m > crossed && previousPct < float64(m) && pct >= float64(m) {
+			crossed = m
+		}
+	}
+	return crossed
+}
@@ -0,0 +1,191 @@
+package bonus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// DefaultOutboxInterval is how often the relay looks for notifications
+	// to publish.
+	DefaultOutboxInterval = 5 * time.Second
+	// DefaultOutboxBatchSize is how many notifications the relay reads at
+	// a time.
+	DefaultOutboxBatchSize = 100
+)
+
+// outboxNamespace seeds notification IDs from their dedupe keys.
+var outboxNamespace = uuid.MustParse("5e0f3a9c-7d14-4b6e-a2c8-93f1d7b04e62")
+
+type OutboxRepository interface {
+	// Enqueue stores a notification unless one with the same dedupe key
+	// exists, and reports whether it was stored.
+	Enqueue(ctx context.Context, notification *OutboxNotification) (bool, error)
+	ListUnpublished(ctx context.Context, limit int) ([]OutboxNotification, error)
+	MarkPublished(ctx context.Context, notificationID string) error
+}
+
+type OutboxRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) *OutboxRepositoryImpl {
+	return &OutboxRepositoryImpl{db: db}
+}
+
+func (r *OutboxRepositoryImpl) Enqueue(ctx context.Context, notification *OutboxNotification) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(notification)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to enqueue notification: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *OutboxRepositoryImpl) ListUnpublished(ctx context.Context, limit int) ([]OutboxNotification, error) {
+	var notifications []OutboxNotification
+	err := r.db.WithContext(ctx).
+		Where("published_at IS NULL").
+		Order("created_at").
+		Limit(limit).
+		Find(&notifications).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox notifications: %w", err)
+	}
+	return notifications, nil
+}
+
+func (r *OutboxRepositoryImpl) MarkPublished(ctx context.Context, notificationID string) error {
+	err := r.db.WithContext(ctx).
+		Model(&OutboxNotification{}).
+		Where("notification_id = ? AND published_at IS NULL", notificationID).
+		Update("published_at", gorm.Expr("NOW()")).Error
+	if err != nil {
+		return fmt.Errorf("failed to mark notification published: %w", err)
+	}
+	return nil
+}
+
+// newOutboxNotification wraps a wagering update for the outbox.
+func newOutboxNotification(dedupeKey string, update WageringUpdate) (*OutboxNotification, error) {
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notification: %w", err)
+	}
+	return &OutboxNotification{
+		NotificationID: uuid.NewSHA1(outboxNamespace, []byte(dedupeKey)).String(),
+		DedupeKey:      dedupeKey,
+		PlayerID:       update.PlayerID,
+		EventType:      update.EventType,
+		Payload:        string(payload),
+		CreatedAt:      time.Now(),
+	}, nil
+}
+
+// OutboxPublisher hands a notification to a delivery channel. The relay
+// publishes at least once, so a notification may be published again after a
+// crash; channels should dedupe on NotificationID.
+type OutboxPublisher interface {
+	Publish(ctx context.Context, notification OutboxNotification) error
+}
+
+// OutboxRelay drains the outbox into a publisher, oldest notification first,
+// and marks each published one so it is not sent again.
+type OutboxRelay struct {
+	outbox    OutboxRepository
+	publisher OutboxPublisher
+	batchSize int
+}
+
+func NewOutboxRelay(outbox OutboxRepository, publisher OutboxPublisher, batchSize int) *OutboxRelay {
+	if batchSize <= 0 {
+		batchSize = DefaultOutboxBatchSize
+	}
+	return &OutboxRelay{outbox: outbox, publisher: publisher, batchSize: batchSize}
+}
+
+// Drain publishes unpublished notifications until none are left. It stops
+// at the first one that fails to publish, so later notifications do not
+// overtake it, and returns how many it published.
+func (r *OutboxRelay) Drain(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		notifications, err := r.outbox.ListUnpublished(ctx, r.batchSize)
+		if err != nil {
+			return published, err
+		}
+		for _, n := range notifications {
+			if err := r.publisher.Publish(ctx, n); err != nil {
+				return published, fmt.Errorf("failed to publish notification %s: %w", n.NotificationID, err)
+			}
+			if err := r.outbox.MarkPublished(ctx, n.NotificationID); err != nil {
+				return published, err
+			}
+			published++
+		}
+		if len(notifications) < r.batchSize {
+			return published, nil
+		}
+	}
+}
+
+// Run drains the outbox every interval until ctx is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Drain(ctx); err != nil {
+				log.Printf("Outbox relay failed: %v", err)
+			}
+		}
+	}
+}
+
+// WebhookPublisher POSTs each notification's payload as JSON to a URL, with
+// the notification ID as Idempotency-Key and its event type in
+// X-Event-Type. Any 2xx response counts as delivered.
+type WebhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookPublisher(url string) *WebhookPublisher {
+	return &WebhookPublisher{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, notification OutboxNotification) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader([]byte(notification.Payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", notification.NotificationID)
+	req.Header.Set("X-Event-Type", notification.EventType)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("webhook answered %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
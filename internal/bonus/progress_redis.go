@@ -0,0 +1,242 @@
+package bonus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"wallet_service/internal/metrics"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultCheckpointInterval = 5 * time.Second
+	checkpointBatch           = 500
+)
+
+// applyWageringScript adds a contribution to a bonus counter, at most once
+// per bet. The counter is seeded from Postgres on first use and capped at the
+// requirement. Amounts are integer cents so repeated additions stay exact.
+//
+// KEYS: progress hash, applied-bets set, dirty set
+// ARGV: seed cents, required cents, contribution cents, bet id, bonus id, ttl seconds
+// Returns {completed cents, 1 if the bet was new, completed cents before the bet}
+var applyWageringScript = redis.NewScript(`
+redis.call('HSETNX', KEYS[1], 'completed', ARGV[1])
+redis.call('HSETNX', KEYS[1], 'required', ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[6])
+redis.call('EXPIRE', KEYS[2], ARGV[6])
+
+local completed = tonumber(redis.call('HGET', KEYS[1], 'completed'))
+local required = tonumber(redis.call('HGET', KEYS[1], 'required'))
+if redis.call('SADD', KEYS[2], ARGV[4]) == 0 then
+	return {completed, 0, completed}
+end
+if completed >= required then
+	return {completed, 1, completed}
+end
+
+local before = completed
+completed = math.min(completed + tonumber(ARGV[3]), required)
+redis.call('HSET', KEYS[1], 'completed', completed)
+redis.call('SADD', KEYS[3], ARGV[5])
+return {completed, 1, before}
+`)
+
+// RedisProgressStore keeps the wagering counter of active bonuses in Redis,
+// so bets do not contend for the player_bonus row lock. Every bet is still
+// recorded in wagering_events; player_bonus is brought up to date by
+// Checkpoint, which Run calls periodically, and immediately when a bet
+// completes the bonus.
+type RedisProgressStore struct {
+	client redis.Cmdable
+	db     *gorm.DB
+	repo   BonusRepository
+	prefix string
+}
+
+func NewRedisProgressStore(client redis.Cmdable, db *gorm.DB, repo BonusRepository) *RedisProgressStore {
+	return &RedisProgressStore{
+		client: client,
+		db:     db,
+		repo:   repo,
+		prefix: "wagering",
+	}
+}
+
+func (r *RedisProgressStore) progressKey(bonusID string) string {
+	return r.prefix + ":progress:" + bonusID
+}
+
+func (r *RedisProgressStore) betsKey(bonusID string) string {
+	return r.prefix + ":bets:" + bonusID
+}
+
+func (r *RedisProgressStore) dirtyKey() string {
+	return r.prefix + ":dirty"
+}
+
+// ApplyWagering records the event first, while the bonus is still active
+// under a shared row lock, and only then counts it in Redis, so a bonus that
+// was forfeited or expired since the snapshot was read gains neither. Redis
+// remembers counted bets, so a retry after either step failed cannot count a
+// bet twice.
+func (r *RedisProgressStore) ApplyWagering(ctx context.Context, bonus *PlayerBonus, event *WageringEvent) (*ProgressResult, error) {
+	if bonus.Status != BonusStatusActive {
+		return nil, ErrBonusNotActive
+	}
+
+	inserted, err := r.repo.CreateWageringEventIfActive(ctx, r.db, event)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Until(bonus.ExpiresAt) + 24*time.Hour
+	if ttl < time.Hour {
+		ttl = time.Hour
+	}
+	bonusID := bonus.PlayerBonusID
+	res, err := applyWageringScript.Run(ctx, r.client,
+		[]string{r.progressKey(bonusID), r.betsKey(bonusID), r.dirtyKey()},
+		toCents(bonus.WageringCompleted), toCents(bonus.WageringRequired), toCents(event.WageringContribution),
+		event.BetID, bonusID, int64(ttl.Seconds()),
+	).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply wagering in redis: %w", err)
+	}
+
+	result := &ProgressResult{
+		Previous: fromCents(res[2]),
+		Progress: fromCents(res[0]),
+		Applied:  res[1] == 1 || inserted,
+	}
+	// Also covers a retry of the completing bet whose first attempt failed
+	// before Postgres was updated.
+	if result.Applied && result.Progress.GreaterThanOrEqual(bonus.WageringRequired) {
+		result.Completed, err = r.complete(ctx, bonusID, result.Progress)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (r *RedisProgressStore) GetProgress(ctx context.Context, bonus *PlayerBonus) (decimal.Decimal, error) {
+	cents, err := r.client.HGet(ctx, r.progressKey(bonus.PlayerBonusID), "completed").Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return bonus.WageringCompleted, nil
+		}
+		return decimal.Zero, fmt.Errorf("failed to read wagering progress from redis: %w", err)
+	}
+	live := fromCents(cents)
+	if live.LessThan(bonus.WageringCompleted) {
+		return bonus.WageringCompleted, nil
+	}
+	return live, nil
+}
+
+// Run checkpoints every interval until ctx is cancelled.
+func (r *RedisProgressStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n, err := r.Checkpoint(ctx); err != nil {
+				log.Printf("Wagering checkpoint failed after %d bonuses: %v", n, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Checkpoint copies the Redis counter of every bonus that changed since the
+// last checkpoint into player_bonus and returns how many were written.
+func (r *RedisProgressStore) Checkpoint(ctx context.Context) (int, error) {
+	written := 0
+	for {
+		bonusIDs, err := r.client.SPopN(ctx, r.dirtyKey(), checkpointBatch).Result()
+		if err != nil {
+			return written, fmt.Errorf("failed to read dirty bonuses: %w", err)
+		}
+		if len(bonusIDs) == 0 {
+			return written, nil
+		}
+
+		for i, bonusID := range bonusIDs {
+			if err := r.checkpointBonus(ctx, bonusID); err != nil {
+				// Put back what was not written so the next run retries it.
+				r.client.SAdd(ctx, r.dirtyKey(), toInterfaces(bonusIDs[i:])...)
+				return written, err
+			}
+			written++
+		}
+	}
+}
+
+func (r *RedisProgressStore) checkpointBonus(ctx context.Context, bonusID string) error {
+	cents, err := r.client.HGet(ctx, r.progressKey(bonusID), "completed").Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		return fmt.Errorf("failed to read wagering progress from redis: %w", err)
+	}
+	err = r.repo.CheckpointWageringProgress(ctx, r.db, bonusID, fromCents(cents))
+	if errors.Is(err, ErrBonusNotActive) || errors.Is(err, ErrBonusNotFound) {
+		// The bonus ended since the bets were counted; its counter is no
+		// longer needed and must not be written back.
+		log.Printf("Dropping wagering counter of inactive bonus: bonus_id=%s", bonusID)
+		return r.client.Del(ctx, r.progressKey(bonusID), r.betsKey(bonusID)).Err()
+	}
+	return err
+}
+
+// complete writes the final progress and status as soon as the requirement
+// is met, rather than waiting for the next checkpoint. It reports whether the
+// bonus was still active.
+func (r *RedisProgressStore) complete(ctx context.Context, bonusID string, progress decimal.Decimal) (bool, error) {
+	completed := false
+	defer metrics.ObserveDBTransaction("bonus_wagering_complete", time.Now())
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		bonus, err := r.repo.GetBonusForUpdate(ctx, tx, bonusID)
+		if err != nil {
+			return err
+		}
+		if bonus.Status != BonusStatusActive {
+			return nil
+		}
+		if err := r.repo.UpdateWageringProgress(ctx, tx, bonusID, progress); err != nil {
+			return err
+		}
+		if err := r.repo.UpdateBonusStatus(ctx, tx, bonusID, BonusStatusCompleted); err != nil {
+			return err
+		}
+		completed = true
+		return nil
+	})
+	return completed, err
+}
+
+func toCents(d decimal.Decimal) int64 {
+	return d.Shift(2).Round(0).IntPart()
+}
+
+func fromCents(cents int64) decimal.Decimal {
+	return decimal.New(cents, -2)
+}
+
+func toInterfaces(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package bonus
+
+import (
+	"context"
+	"time"
+	"wallet_service/internal/metrics"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ProgressResult is the outcome of applying one wagering event.
+type ProgressResult struct {
+	Previous  decimal.Decimal // wagering completed before the event
+	Progress  decimal.Decimal // wagering completed after the event, capped at the requirement
+	Completed bool            // true when this event completed the bonus
+	Applied   bool            // false when the bet had already been counted
+}
+
+// ProgressStore owns the wagering counter of active bonuses. The wagering
+// event is always recorded in Postgres; implementations differ in where the
+// running total lives while the bonus is active.
+type ProgressStore interface {
+	ApplyWagering(ctx context.Context, bonus *PlayerBonus, event *WageringEvent) (*ProgressResult, error)
+	// GetProgress returns the live wagering total for a bonus. bonus is the
+	// row as stored in player_bonus.
+	GetProgress(ctx context.Context, bonus *PlayerBonus) (decimal.Decimal, error)
+}
+
+// PostgresProgressStore updates player_bonus under a row lock for every bet.
+type PostgresProgressStore struct {
+	db   *gorm.DB
+	repo BonusRepository
+}
+
+func NewPostgresProgressStore(db *gorm.DB, repo BonusRepository) *PostgresProgressStore {
+	return &PostgresProgressStore{db: db, repo: repo}
+}
+
+func (p *PostgresProgressStore) ApplyWagering(ctx context.Context, snapshot *PlayerBonus, event *WageringEvent) (*ProgressResult, error) {
+	result := &ProgressResult{Applied: true}
+	defer metrics.ObserveDBTransaction("bonus_wagering", time.Now())
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+
+		bonus, lockErr := p.repo.GetBonusForUpdate(ctx, tx, snapshot.PlayerBonusID)
+		if lockErr != nil {
+			return lockErr
+		}
+
+		if bonus.Status != BonusStatusActive {
+			return ErrBonusNotActive
+		}
+		newProgress := bonus.WageringCompleted.Add(event.WageringContribution)
+		if newProgress.GreaterThan(bonus.WageringRequired) {
+			newProgress = bonus.WageringRequired
+		}
+		if updateErr := p.repo.UpdateWageringProgress(ctx, tx, bonus.PlayerBonusID, newProgress); updateErr != nil {
+			return updateErr
+		}
+		if createErr := p.repo.CreateWageringEvent(ctx, tx, event); createErr != nil {
+			return createErr
+		}
+		if newProgress.GreaterThanOrEqual(bonus.WageringRequired) {
+			if statusErr := p.repo.UpdateBonusStatus(ctx, tx, bonus.PlayerBonusID, BonusStatusCompleted); statusErr != nil {
+				return statusErr
+			}
+			result.Completed = true
+		}
+		result.Previous = bonus.WageringCompleted
+		result.Progress = newProgress
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (p *PostgresProgressStore) GetProgress(ctx context.Context, bonus *PlayerBonus) (decimal.Decimal, error) {
+	return bonus.WageringCompleted, nil
+}
@@ -0,0 +1,160 @@
+package bonus
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// ProgressReport compares the stored wagering progress of a bonus with the
+// progress implied by its wagering_events rows.
+type ProgressReport struct {
+	PlayerBonusID    string          `json:"player_bonus_id"`
+	Status           string          `json:"status"`
+	WageringRequired decimal.Decimal `json:"wagering_required"`
+	StoredProgress   decimal.Decimal `json:"stored_progress"`
+	EventCount       int             `json:"event_count"`
+	EventTotal       decimal.Decimal `json:"event_total"`       // sum of logged contributions, uncapped
+	ExpectedProgress decimal.Decimal `json:"expected_progress"` // event total capped at the requirement
+	Difference       decimal.Decimal `json:"difference"`        // stored minus expected
+	Drift            bool            `json:"drift"`
+	NeedsReview      bool            `json:"needs_review"` // drift on a bonus that is no longer active; never repaired automatically
+	Explanations     []string        `json:"explanations"`
+	Repaired         bool            `json:"repaired"`
+}
+
+// RecomputeProgress rebuilds the wagering progress of a bonus from its event
+// log and explains every difference from the stored value. Progress is
+// capped at the requirement while events keep their full contribution, and
+// both are rounded to cents at different points, so not every difference is
+// drift.
+func (s *BonusService) RecomputeProgress(ctx context.Context, playerBonusID string) (*ProgressReport, error) {
+	bonus, err := s.repo.GetBonus(ctx, playerBonusID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.repo.GetWageringEvents(ctx, s.db, playerBonusID)
+	if err != nil {
+		return nil, err
+	}
+	return buildProgressReport(bonus, events), nil
+}
+
+// RepairProgress recomputes a bonus under its row lock and, if the stored
+// progress of an active bonus has really drifted, overwrites it with the
+// value from the event log. A bonus whose events meet the requirement is
+// marked completed. Bonuses that are no longer active are left untouched and
+// reported with NeedsReview, since changing them needs a human decision.
+func (s *BonusService) RepairProgress(ctx context.Context, playerBonusID string) (*ProgressReport, error) {
+	var report *ProgressReport
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		bonus, err := s.repo.GetBonusForUpdate(ctx, tx, playerBonusID)
+		if err != nil {
+			return err
+		}
+		events, err := s.repo.GetWageringEvents(ctx, tx, playerBonusID)
+		if err != nil {
+			return err
+		}
+
+		report = buildProgressReport(bonus, events)
+		if !report.Drift || report.NeedsReview {
+			return nil
+		}
+
+		if !report.Difference.IsZero() {
+			if err := s.repo.UpdateWageringProgress(ctx, tx, playerBonusID, report.ExpectedProgress); err != nil {
+				return err
+			}
+			report.Repaired = true
+		}
+		if bonus.Status == BonusStatusActive && report.ExpectedProgress.GreaterThanOrEqual(bonus.WageringRequired) {
+			if err := s.repo.UpdateBonusStatus(ctx, tx, playerBonusID, BonusStatusCompleted); err != nil {
+				return err
+			}
+			report.Status = BonusStatusCompleted
+			report.Repaired = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to repair wagering progress: %w", err)
+	}
+
+	if report.Repaired {
+		log.Printf("Wagering progress repaired: bonus_id=%s stored=%s expected=%s status=%s",
+			playerBonusID, report.StoredProgress.String(), report.ExpectedProgress.String(), report.Status)
+	}
+	return report, nil
+}
+
+func buildProgressReport(bonus *PlayerBonus, events []WageringEvent) *ProgressReport {
+	required := bonus.WageringRequired
+	total := decimal.Zero
+	// perBet replays ProcessBetWagering, which adds the unrounded
+	// contribution, caps it and stores the result rounded to cents.
+	perBet := decimal.Zero
+	for _, e := range events {
+		total = total.Add(e.WageringContribution)
+		perBet = decimal.Min(perBet.Add(e.BetAmount.Mul(e.ContributionPercentage)), required).Round(2)
+	}
+	expected := decimal.Min(total, required)
+
+	report := &ProgressReport{
+		PlayerBonusID:    bonus.PlayerBonusID,
+		Status:           bonus.Status,
+		WageringRequired: required,
+		StoredProgress:   bonus.WageringCompleted,
+		EventCount:       len(events),
+		EventTotal:       total,
+		ExpectedProgress: expected,
+		Difference:       bonus.WageringCompleted.Sub(expected),
+		Explanations:     []string{},
+	}
+	explain := func(format string, args ...interface{}) {
+		report.Explanations = append(report.Explanations, fmt.Sprintf(format, args...))
+	}
+
+	if total.GreaterThan(required) {
+		explain("events contribute %s, above the requirement of %s; progress is capped at %s",
+			total.String(), required.String(), required.String())
+	}
+
+	switch {
+	case report.Difference.IsZero():
+	case bonus.WageringCompleted.Equal(perBet):
+		explain("stored progress %s differs from the event total %s only by per-bet rounding to cents",
+			bonus.WageringCompleted.String(), expected.String())
+		report.Difference = decimal.Zero
+	case report.Difference.IsNegative():
+		report.Drift = true
+		explain("stored progress %s is %s behind the event log; an update was lost or a checkpoint has not run",
+			bonus.WageringCompleted.String(), report.Difference.Neg().String())
+	default:
+		report.Drift = true
+		explain("stored progress %s is %s ahead of the event log; progress was recorded without wagering events",
+			bonus.WageringCompleted.String(), report.Difference.String())
+	}
+
+	switch {
+	case bonus.Status == BonusStatusActive && expected.GreaterThanOrEqual(required):
+		report.Drift = true
+		explain("events meet the requirement but the bonus is still active")
+	case bonus.Status == BonusStatusCompleted && expected.LessThan(required):
+		report.Drift = true
+		explain("bonus is completed but events only cover %s of %s; needs manual review", expected.String(), required.String())
+	}
+
+	if report.Drift && bonus.Status != BonusStatusActive {
+		report.NeedsReview = true
+		explain("bonus is %s, so it is not repaired automatically", bonus.Status)
+	}
+
+	if len(report.Explanations) == 0 {
+		explain("stored progress matches the event log")
+	}
+	return report
+}
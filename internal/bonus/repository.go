@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
+	"wallet_service/internal/audit"
 
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
@@ -17,32 +19,55 @@ var (
 	ErrGameNotFound          = errors.New("game not found")
 	ErrWageringEventExists   = errors.New("wagering event already exists for this bet")
 	ErrWageringEventNotFound = errors.New("wagering event not found")
+	ErrBetBeforeBonus        = errors.New("bet was placed before the bonus was created")
+	ErrBetTimestampInFuture  = errors.New("bet timestamp is in the future")
+	ErrBetAfterExpiry        = errors.New("bet placed before its bonus expired arrived after expiry")
+	ErrInvalidBet            = errors.New("invalid bet event")
 )
 
 type BonusRepository interface {
 	GetActiveBonus(ctx context.Context, playerID string) (*PlayerBonus, error)
+	ListActiveBonuses(ctx context.Context, playerID string) ([]PlayerBonus, error)
 	GetGame(ctx context.Context, gameID string) (*Game, error)
 	GetEventByBetID(ctx context.Context, betID string) (*WageringEvent, error)
 	GetBonusForUpdate(ctx context.Context, tx *gorm.DB, playerBonusID string) (*PlayerBonus, error)
 	UpdateWageringProgress(ctx context.Context, tx *gorm.DB, playerBonusID string, newProgress decimal.Decimal) error
 	CreateWageringEvent(ctx context.Context, tx *gorm.DB, wageringEvent *WageringEvent) error
+	CreateWageringEvents(ctx context.Context, tx *gorm.DB, wageringEvents []*WageringEvent) error
+	GetExistingBetIDs(ctx context.Context, tx *gorm.DB, betIDs []string) (map[string]bool, error)
+	CreateWageringEventIfAbsent(ctx context.Context, tx *gorm.DB, wageringEvent *WageringEvent) (bool, error)
+	CreateWageringEventIfActive(ctx context.Context, tx *gorm.DB, wageringEvent *WageringEvent) (bool, error)
+	CheckpointWageringProgress(ctx context.Context, tx *gorm.DB, playerBonusID string, progress decimal.Decimal) error
+	GetWageringEvents(ctx context.Context, tx *gorm.DB, playerBonusID string) ([]WageringEvent, error)
+	ListBonusIDs(ctx context.Context, status string) ([]string, error)
+	ListActiveBonusesExpiringBefore(ctx context.Context, before time.Time) ([]PlayerBonus, error)
+	ListBonusesExpiredSince(ctx context.Context, playerID string, since time.Time) ([]PlayerBonus, error)
 	UpdateBonusStatus(ctx context.Context, tx *gorm.DB, playerBonusID string, status string) error
 	GetBonus(ctx context.Context, playerBonusID string) (*PlayerBonus, error)
 	CreatePlayerBonus(ctx context.Context, playerBonus *PlayerBonus) error
+	CreatePlayerBonusTx(ctx context.Context, tx *gorm.DB, playerBonus *PlayerBonus) error
 }
 
 type BonusRepositoryImpl struct {
-	db *gorm.DB
+	db    *gorm.DB
+	audit audit.Recorder
 }
 
 func NewBonusRepository(db *gorm.DB) *BonusRepositoryImpl {
 	return &BonusRepositoryImpl{db: db}
 }
 
+// SetAuditLog records bonus awards and status changes in the audit log, in
+// the transaction that makes them.
+func (r *BonusRepositoryImpl) SetAuditLog(log audit.Recorder) {
+	r.audit = log
+}
+
 func (r *BonusRepositoryImpl) GetActiveBonus(ctx context.Context, playerID string) (*PlayerBonus, error) {
 	var bonus PlayerBonus
 	err := r.db.WithContext(ctx).
 		Where("player_id = ? AND status = ?", playerID, BonusStatusActive).
+		Order("created_at, player_bonus_id").
 		First(&bonus).Error
 
 	if err != nil {
@@ -54,6 +79,18 @@ func (r *BonusRepositoryImpl) GetActiveBonus(ctx context.Context, playerID strin
 
 	return &bonus, nil
 }
+func (r *BonusRepositoryImpl) ListActiveBonuses(ctx context.Context, playerID string) ([]PlayerBonus, error) {
+	var bonuses []PlayerBonus
+	err := r.db.WithContext(ctx).
+		Where("player_id = ? AND status = ?", playerID, BonusStatusActive).
+		Order("created_at, player_bonus_id").
+		Find(&bonuses).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active bonuses: %w", err)
+	}
+	return bonuses, nil
+}
+
 func (r *BonusRepositoryImpl) GetGame(ctx context.Context, gameID string) (*Game, error) {
 	var game Game
 	err := r.db.WithContext(ctx).
@@ -132,7 +169,172 @@ func (r *BonusRepositoryImpl) CreateWageringEvent(ctx context.Context, tx *gorm.
 	return nil
 }
 
+// CreateWageringEvents inserts a batch of events in a single statement.
+func (r *BonusRepositoryImpl) CreateWageringEvents(ctx context.Context, tx *gorm.DB, events []*WageringEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	err := tx.WithContext(ctx).Create(&events).Error
+	if err != nil {
+		return fmt.Errorf("failed to create wagering events: %w", err)
+	}
+	return nil
+}
+
+// CreateWageringEventIfAbsent inserts an event unless one already exists for
+// its bet and reports whether it was inserted.
+func (r *BonusRepositoryImpl) CreateWageringEventIfAbsent(ctx context.Context, tx *gorm.DB, event *WageringEvent) (bool, error) {
+	result := tx.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "bet_id"}}, DoNothing: true}).
+		Create(event)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to create wagering event: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CreateWageringEventIfActive is CreateWageringEventIfAbsent for callers that
+// do not hold the player_bonus row lock. It takes a shared lock on the row,
+// which concurrent bets do not contend for but a status change does, and
+// returns ErrBonusNotActive once the bonus is no longer active.
+func (r *BonusRepositoryImpl) CreateWageringEventIfActive(ctx context.Context, tx *gorm.DB, event *WageringEvent) (bool, error) {
+	inserted := false
+	err := tx.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var bonus PlayerBonus
+		err := tx.Clauses(clause.Locking{Strength: "SHARE"}).
+			Select("status").
+			Where("player_bonus_id = ?", event.PlayerBonusID).
+			First(&bonus).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrBonusNotFound
+			}
+			return fmt.Errorf("failed to lock player bonus: %w", err)
+		}
+		if bonus.Status != BonusStatusActive {
+			return ErrBonusNotActive
+		}
+		inserted, err = r.CreateWageringEventIfAbsent(ctx, tx, event)
+		return err
+	})
+	return inserted, err
+}
+
+// CheckpointWageringProgress stores progress kept outside Postgres. It only
+// touches active bonuses and never lowers the stored value. The update
+// re-checks the status under the row lock and returns ErrBonusNotActive if
+// the bonus has been completed, forfeited or expired.
+func (r *BonusRepositoryImpl) CheckpointWageringProgress(ctx context.Context, tx *gorm.DB, playerBonusID string, progress decimal.Decimal) error {
+	result := tx.WithContext(ctx).
+		Model(&PlayerBonus{}).
+		Where("player_bonus_id = ? AND status = ? AND wagering_completed < ?", playerBonusID, BonusStatusActive, progress).
+		Updates(map[string]interface{}{
+			"wagering_completed": progress,
+			"updated_at":         gorm.Expr("NOW()"),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to checkpoint wagering progress: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	var bonus PlayerBonus
+	err := tx.WithContext(ctx).Select("status").Where("player_bonus_id = ?", playerBonusID).First(&bonus).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrBonusNotFound
+		}
+		return fmt.Errorf("failed to checkpoint wagering progress: %w", err)
+	}
+	if bonus.Status != BonusStatusActive {
+		return ErrBonusNotActive
+	}
+	return nil
+}
+
+func (r *BonusRepositoryImpl) GetExistingBetIDs(ctx context.Context, tx *gorm.DB, betIDs []string) (map[string]bool, error) {
+	var existing []string
+	err := tx.WithContext(ctx).
+		Model(&WageringEvent{}).
+		Where("bet_id IN ?", betIDs).
+		Pluck("bet_id", &existing).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to check existing bets: %w", err)
+	}
+
+	found := make(map[string]bool, len(existing))
+	for _, betID := range existing {
+		found[betID] = true
+	}
+	return found, nil
+}
+
+// GetWageringEvents returns every event of a bonus in processing order.
+func (r *BonusRepositoryImpl) GetWageringEvents(ctx context.Context, tx *gorm.DB, playerBonusID string) ([]WageringEvent, error) {
+	var events []WageringEvent
+	err := tx.WithContext(ctx).
+		Where("player_bonus_id = ?", playerBonusID).
+		Order("created_at, event_id").
+		Find(&events).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wagering events: %w", err)
+	}
+	return events, nil
+}
+
+// ListBonusIDs returns the IDs of all bonuses with the given status, or of
+// all bonuses when status is empty.
+func (r *BonusRepositoryImpl) ListBonusIDs(ctx context.Context, status string) ([]string, error) {
+	query := r.db.WithContext(ctx).Model(&PlayerBonus{}).Order("created_at")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var ids []string
+	if err := query.Pluck("player_bonus_id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to list bonuses: %w", err)
+	}
+	return ids, nil
+}
+
+// ListActiveBonusesExpiringBefore returns the active bonuses of all players
+// whose expires_at is before the given time, soonest first.
+func (r *BonusRepositoryImpl) ListActiveBonusesExpiringBefore(ctx context.Context, before time.Time) ([]PlayerBonus, error) {
+	var bonuses []PlayerBonus
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND expires_at < ?", BonusStatusActive, before).
+		Order("expires_at").
+		Find(&bonuses).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expiring bonuses: %w", err)
+	}
+	return bonuses, nil
+}
+
+// ListBonusesExpiredSince returns the expired bonuses of a player whose
+// expires_at is not before the given time, oldest first.
+func (r *BonusRepositoryImpl) ListBonusesExpiredSince(ctx context.Context, playerID string, since time.Time) ([]PlayerBonus, error) {
+	var bonuses []PlayerBonus
+	err := r.db.WithContext(ctx).
+		Where("player_id = ? AND status = ? AND expires_at >= ?", playerID, BonusStatusExpired, since).
+		Order("created_at, player_bonus_id").
+		Find(&bonuses).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired bonuses: %w", err)
+	}
+	return bonuses, nil
+}
+
 func (r *BonusRepositoryImpl) UpdateBonusStatus(ctx context.Context, tx *gorm.DB, playerBonusID string, status string) error {
+	var before string
+	if r.audit != nil {
+		err := tx.WithContext(ctx).Model(&PlayerBonus{}).Select("status").Where("player_bonus_id = ?", playerBonusID).Scan(&before).Error
+		if err != nil {
+			return fmt.Errorf("failed to get bonus status: %w", err)
+		}
+	}
+
 	result := tx.WithContext(ctx).
 		Model(&PlayerBonus{}).
 		Where("player_bonus_id = ?", playerBonusID).
@@ -149,7 +351,13 @@ func (r *BonusRepositoryImpl) UpdateBonusStatus(ctx context.Context, tx *gorm.DB
 		return ErrBonusNotFound
 	}
 
-	return nil
+	return r.record(ctx, tx, audit.Event{
+		Action:     "bonus.status",
+		EntityType: "player_bonus",
+		EntityID:   playerBonusID,
+		Before:     map[string]string{"status": before},
+		After:      map[string]string{"status": status},
+	})
 }
 
 func (r *BonusRepositoryImpl) GetBonus(ctx context.Context, playerBonusID string) (*PlayerBonus, error) {
@@ -169,9 +377,35 @@ func (r *BonusRepositoryImpl) GetBonus(ctx context.Context, playerBonusID string
 }
 
 func (r *BonusRepositoryImpl) CreatePlayerBonus(ctx context.Context, bonus *PlayerBonus) error {
-	err := r.db.WithContext(ctx).Create(bonus).Error
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return r.CreatePlayerBonusTx(ctx, tx, bonus)
+	})
+}
+
+func (r *BonusRepositoryImpl) CreatePlayerBonusTx(ctx context.Context, tx *gorm.DB, bonus *PlayerBonus) error {
+	err := tx.WithContext(ctx).Create(bonus).Error
 	if err != nil {
 		return fmt.Errorf("failed to create player bonus: %w", err)
 	}
-	return nil
+	return r.record(ctx, tx, audit.Event{
+		Action:     "bonus.award",
+		EntityType: "player_bonus",
+		EntityID:   bonus.PlayerBonusID,
+		After: map[string]interface{}{
+			"player_id":         bonus.PlayerID,
+			"bonus_id":          bonus.BonusID,
+			"status":            bonus.Status,
+			"bonus_amount":      bonus.BonusAmount,
+			"currency":          bonus.Currency,
+			"wagering_required": bonus.WageringRequired,
+			"expires_at":        bonus.ExpiresAt,
+		},
+	})
+}
+
+func (r *BonusRepositoryImpl) record(ctx context.Context, tx *gorm.DB, event audit.Event) error {
+	if r.audit == nil {
+		return nil
+	}
+	return r.audit.Record(ctx, tx, event)
 }
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
+	"wallet_service/internal/metrics"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
@@ -20,14 +22,23 @@ type BonusWageringService interface {
 	CreatePlayerBonus(ctx context.Context, playerID string, bonusID string, bonusAmount decimal.Decimal, wageringMultiplier decimal.Decimal, expiresAt time.Time) error
 }
 
+// DefaultClockSkewTolerance is how far a bet timestamp may drift from our own
+// clock before the bet is treated as outside the bonus window.
+const DefaultClockSkewTolerance = 30 * time.Second
+
 type BonusService struct {
 	db        *gorm.DB
 	repo      BonusRepository
 	notifyHub *NotificationHub
+	notifier  *Notifier
+	clockSkew time.Duration
+	progress  ProgressStore
+	wallet    WalletService
 }
 type NotificationHub struct {
 	mu          sync.RWMutex
 	subscribers map[string][]chan WageringUpdate
+	dropped     atomic.Uint64
 }
 
 func NewNotificationHub() *NotificationHub {
@@ -52,97 +63,228 @@ func (h *NotificationHub) Notify(playerID string, update WageringUpdate) {
 		case ch <- update:
 		default:
 			// Channel full, skip (don't block)
+			h.dropped.Add(1)
 		}
 	}
 }
 
+// Subscribers returns the number of open subscriptions.
+func (h *NotificationHub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := 0
+	for _, chans := range h.subscribers {
+		n += len(chans)
+	}
+	return n
+}
+
+// Dropped returns how many updates were dropped because a subscriber was
+// not keeping up.
+func (h *NotificationHub) Dropped() uint64 {
+	return h.dropped.Load()
+}
+
 func NewBonusService(db *gorm.DB, repo BonusRepository) *BonusService {
+	hub := NewNotificationHub()
 	return &BonusService{
 		db:        db,
 		repo:      repo,
-		notifyHub: NewNotificationHub(),
+		notifyHub: hub,
+		notifier:  NewNotifier(hub, NotificationRules{Milestones: DefaultMilestones, Throttle: DefaultNotifyThrottle}),
+		clockSkew: DefaultClockSkewTolerance,
+		progress:  NewPostgresProgressStore(db, repo),
+	}
+}
+
+// SetProgressStore replaces the default PostgresProgressStore.
+func (s *BonusService) SetProgressStore(store ProgressStore) {
+	s.progress = store
+}
+
+// SetNotificationRules replaces the default milestones and throttle of
+// wagering updates.
+func (s *BonusService) SetNotificationRules(rules NotificationRules) {
+	s.notifier = NewNotifier(s.notifyHub, rules)
+}
+
+// SetClockSkewTolerance overrides DefaultClockSkewTolerance.
+func (s *BonusService) SetClockSkewTolerance(d time.Duration) {
+	s.clockSkew = d
+}
+
+func validateBet(bet BetEvent) error {
+	switch {
+	case bet.BetID == "":
+		return fmt.Errorf("%w: bet_id is required", ErrInvalidBet)
+	case bet.PlayerID == "":
+		return fmt.Errorf("%w: player_id is required", ErrInvalidBet)
+	case bet.GameID == "":
+		return fmt.Errorf("%w: game_id is required", ErrInvalidBet)
+	case !bet.BetAmount.IsPositive():
+		return fmt.Errorf("%w: bet_amount must be positive", ErrInvalidBet)
+	}
+	return nil
+}
+
+// betTime returns the time a bet should be judged at. Events without a
+// timestamp fall back to the processing time.
+func (s *BonusService) betTime(bet BetEvent) (time.Time, error) {
+	now := time.Now()
+	if bet.Timestamp.IsZero() {
+		return now, nil
+	}
+	if bet.Timestamp.After(now.Add(s.clockSkew)) {
+		return time.Time{}, ErrBetTimestampInFuture
+	}
+	return bet.Timestamp, nil
+}
+
+// checkBetWindow verifies that a bet placed at placedAt falls inside the
+// lifetime of the bonus. The clock skew only widens the start of the window:
+// the provider's clock may run behind ours when the bonus is created, but a
+// bet after expiry is late however it is delivered.
+func (s *BonusService) checkBetWindow(bonus *PlayerBonus, placedAt time.Time) error {
+	if placedAt.Before(bonus.CreatedAt.Add(-s.clockSkew)) {
+		return ErrBetBeforeBonus
+	}
+	if placedAt.After(bonus.ExpiresAt) {
+		return ErrBonusExpired
+	}
+	return nil
+}
+
+// bonusForBet picks the bonus a bet wagers towards when a player holds
+// several: the oldest active bonus whose window contains placedAt. If no
+// window does, but the bet belongs to a bonus that has since been expired
+// by the sweep, that bonus is returned with ErrBetAfterExpiry so the bet
+// is dead-lettered rather than dropped. Otherwise the oldest active bonus
+// is returned with its window error.
+func (s *BonusService) bonusForBet(ctx context.Context, playerID string, placedAt time.Time) (*PlayerBonus, error) {
+	bonuses, err := s.repo.ListActiveBonuses(ctx, playerID)
+	if err != nil {
+		return nil, err
 	}
+	for i := range bonuses {
+		if s.checkBetWindow(&bonuses[i], placedAt) == nil {
+			return &bonuses[i], nil
+		}
+	}
+
+	expired, err := s.repo.ListBonusesExpiredSince(ctx, playerID, placedAt)
+	if err != nil {
+		return nil, err
+	}
+	for i := range expired {
+		if s.checkBetWindow(&expired[i], placedAt) == nil {
+			return &expired[i], ErrBetAfterExpiry
+		}
+	}
+
+	if len(bonuses) == 0 {
+		return nil, ErrBonusNotFound
+	}
+	return &bonuses[0], s.checkBetWindow(&bonuses[0], placedAt)
 }
 
 func (s *BonusService) ProcessBetWagering(ctx context.Context, bet BetEvent) error {
+	prepared, err := s.prepareBet(ctx, bet)
+	if err != nil || prepared == nil {
+		return err
+	}
+
+	event := prepared.event
+	result, err := s.progress.ApplyWagering(ctx, prepared.bonus, event)
+	if err != nil {
+		return fmt.Errorf("failed to process wagering: %w", err)
+	}
+	if !result.Applied {
+		log.Printf("Event already exists for bet ID: %s", bet.BetID)
+		metrics.WageringSkipped(metrics.SkipDuplicate)
+		return nil
+	}
+	metrics.WageringProcessed(event.PlacedAt)
+	if result.Completed {
+		log.Printf("Bonus wagering completed! bonus_id=%s player=%s", event.PlayerBonusID, bet.PlayerID)
+	}
+	s.notifyProgress(prepared.bonus, result.Previous, result.Progress, result.Completed)
+
+	log.Printf("Wagering processed: bet_id=%s player=%s contribution=%s completed=%t",
+		bet.BetID, bet.PlayerID, event.WageringContribution.String(), result.Completed)
+
+	return nil
+
+}
+
+// preparedBet is a bet that passed every check that does not need the
+// player_bonus row lock, together with the event it will record.
+type preparedBet struct {
+	bonus *PlayerBonus // read without the lock; status and progress may be stale
+	event *WageringEvent
+}
+
+// prepareBet validates a bet and builds its wagering event. It returns nil
+// without an error when the bet has nothing to contribute to, either because
+// it was already processed or because the player has no active bonus.
+func (s *BonusService) prepareBet(ctx context.Context, bet BetEvent) (*preparedBet, error) {
+	if err := validateBet(bet); err != nil {
+		metrics.WageringSkipped(metrics.SkipRejected)
+		return nil, err
+	}
+
 	_, err := s.repo.GetEventByBetID(ctx, bet.BetID)
 	if err == nil {
 		log.Printf("Event already exists for bet ID: %s", bet.BetID)
-		return nil
+		metrics.WageringSkipped(metrics.SkipDuplicate)
+		return nil, nil
 	}
 	if !errors.Is(err, ErrWageringEventNotFound) {
 		log.Printf("idempotency check is failed")
-		return err
+		return nil, err
 	}
 
-	activeBonus, err := s.repo.GetActiveBonus(ctx, bet.PlayerID)
+	placedAt, err := s.betTime(bet)
+	if err != nil {
+		log.Printf("Rejected bet: bet_id=%s player=%s timestamp=%s: %v", bet.BetID, bet.PlayerID, bet.Timestamp, err)
+		metrics.WageringSkipped(metrics.SkipRejected)
+		return nil, err
+	}
+
+	activeBonus, err := s.bonusForBet(ctx, bet.PlayerID, placedAt)
 	if err != nil {
 		if errors.Is(err, ErrBonusNotFound) {
 			log.Printf("No active bonus found for player ID: %s", bet.PlayerID)
-			return nil
+			metrics.WageringSkipped(metrics.SkipNoActiveBonus)
+			return nil, nil
 		}
-		log.Printf("Error getting active bonus for player ID: %s", bet.PlayerID)
-		return fmt.Errorf("error getting active bonus for player ID: %s", bet.PlayerID)
-	}
-	if time.Now().After(activeBonus.ExpiresAt) {
-		log.Printf("Bonus expired: bonus_id=%s player=%s", activeBonus.PlayerBonusID, bet.PlayerID)
-		return ErrBonusExpired
+		if !errors.Is(err, ErrBetBeforeBonus) && !errors.Is(err, ErrBonusExpired) && !errors.Is(err, ErrBetAfterExpiry) {
+			log.Printf("Error getting active bonus for player ID: %s", bet.PlayerID)
+			return nil, fmt.Errorf("error getting active bonus for player ID: %s", bet.PlayerID)
+		}
+		log.Printf("Bet outside bonus window: bonus_id=%s player=%s bet_id=%s placed_at=%s: %v",
+			activeBonus.PlayerBonusID, bet.PlayerID, bet.BetID, placedAt, err)
+		metrics.WageringSkipped(metrics.SkipRejected)
+		return nil, err
 	}
 	contribution, err := s.getGameContribution(ctx, bet.GameID)
 	if err != nil {
-		return fmt.Errorf("failed to get game contribution: %w", err)
+		return nil, fmt.Errorf("failed to get game contribution: %w", err)
 	}
-	wageringContribution := bet.BetAmount.Mul(contribution)
-	var bonusCompleted bool
-	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 
-		bonus, lockErr := s.repo.GetBonusForUpdate(ctx, tx, activeBonus.PlayerBonusID)
-		if lockErr != nil {
-			return lockErr
-		}
-
-		if bonus.Status != BonusStatusActive {
-			return ErrBonusNotActive
-		}
-		newProgress := bonus.WageringCompleted.Add(wageringContribution)
-		if newProgress.GreaterThan(bonus.WageringRequired) {
-			newProgress = bonus.WageringRequired
-		}
-		if updateErr := s.repo.UpdateWageringProgress(ctx, tx, bonus.PlayerBonusID, newProgress); updateErr != nil {
-			return updateErr
-		}
-		event := &WageringEvent{
+	return &preparedBet{
+		bonus: activeBonus,
+		event: &WageringEvent{
 			EventID:                uuid.New().String(),
-			PlayerBonusID:          bonus.PlayerBonusID,
+			PlayerBonusID:          activeBonus.PlayerBonusID,
 			BetID:                  bet.BetID,
 			GameID:                 bet.GameID,
 			BetAmount:              bet.BetAmount,
 			ContributionPercentage: contribution,
-			WageringContribution:   wageringContribution,
+			WageringContribution:   bet.BetAmount.Mul(contribution),
+			PlacedAt:               placedAt,
 			CreatedAt:              time.Now(),
-		}
-		if createErr := s.repo.CreateWageringEvent(ctx, tx, event); createErr != nil {
-			return createErr
-		}
-		if newProgress.GreaterThanOrEqual(bonus.WageringRequired) {
-			if statusErr := s.repo.UpdateBonusStatus(ctx, tx, bonus.PlayerBonusID, BonusStatusCompleted); statusErr != nil {
-				return statusErr
-			}
-			bonusCompleted = true
-			log.Printf("Bonus wagering completed! bonus_id=%s player=%s", bonus.PlayerBonusID, bet.PlayerID)
-		}
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("failed to process wagering: %w", err)
-	}
-	s.sendWageringUpdate(ctx, bet.PlayerID, activeBonus.PlayerBonusID, bonusCompleted)
-
-	log.Printf("Wagering processed: bet_id=%s player=%s contribution=%s completed=%t",
-		bet.BetID, bet.PlayerID, wageringContribution.String(), bonusCompleted)
-
-	return nil
-
+		},
+	}, nil
 }
 
 func (s *BonusService) GetWageringProgress(ctx context.Context, playerID string, bonusID string) (*WageringProgress, error) {
@@ -159,34 +301,51 @@ func (s *BonusService) GetWageringProgress(ctx context.Context, playerID string,
 	if err != nil {
 		return nil, err
 	}
-	percentComplete := float64(0)
-	if !bonus.WageringRequired.IsZero() {
-		percentComplete = bonus.WageringCompleted.Div(bonus.WageringRequired).
-			Mul(decimal.NewFromInt(100)).
-			InexactFloat64()
+	if bonus.Status == BonusStatusActive {
+		live, err := s.progress.GetProgress(ctx, bonus)
+		if err != nil {
+			return nil, err
+		}
+		bonus.WageringCompleted = live
 	}
-
 	return &WageringProgress{
 		PlayerBonusID:      bonus.PlayerBonusID,
 		WageringRequired:   bonus.WageringRequired,
 		WageringCompleted:  bonus.WageringCompleted,
-		PercentageComplete: percentComplete,
+		PercentageComplete: percentComplete(bonus.WageringCompleted, bonus.WageringRequired),
 		Completed:          bonus.Status == BonusStatusCompleted,
 	}, nil
 }
 
+// NotificationHub returns the hub wagering updates are published on.
+func (s *BonusService) NotificationHub() *NotificationHub {
+	return s.notifyHub
+}
+
 func (s *BonusService) SubscribeToWageringUpdates(playerID string) (<-chan WageringUpdate, error) {
 	ch := s.notifyHub.Subscribe(playerID)
 	return ch, nil
 }
 
 func (s *BonusService) CreatePlayerBonus(ctx context.Context, playerID string, bonusID string, bonusAmount decimal.Decimal, wageringMultiplier decimal.Decimal, expiresAt time.Time) (*PlayerBonus, error) {
+	bonus, err := s.CreatePlayerBonusTx(ctx, s.db, uuid.New().String(), playerID, bonusID, bonusAmount, "USD", wageringMultiplier, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyEvent(UpdateEventAwarded, bonus)
+	return bonus, nil
+}
+
+// CreatePlayerBonusTx creates a player bonus with a given ID inside tx, so
+// that it commits together with whatever granted it.
+func (s *BonusService) CreatePlayerBonusTx(ctx context.Context, tx *gorm.DB, playerBonusID string, playerID string, bonusID string, bonusAmount decimal.Decimal, currency string, wageringMultiplier decimal.Decimal, expiresAt time.Time) (*PlayerBonus, error) {
 	bonus := &PlayerBonus{
-		PlayerBonusID:     uuid.New().String(),
+		PlayerBonusID:     playerBonusID,
 		PlayerID:          playerID,
 		BonusID:           bonusID,
 		Status:            BonusStatusActive,
 		BonusAmount:       bonusAmount,
+		Currency:          currency,
 		WageringRequired:  bonusAmount.Mul(wageringMultiplier), // e.g., 35x wagering requirement
 		WageringCompleted: decimal.Zero,
 		ExpiresAt:         expiresAt,
@@ -194,7 +353,7 @@ func (s *BonusService) CreatePlayerBonus(ctx context.Context, playerID string, b
 		UpdatedAt:         time.Now(),
 	}
 
-	if err := s.repo.CreatePlayerBonus(ctx, bonus); err != nil {
+	if err := s.repo.CreatePlayerBonusTx(ctx, tx, bonus); err != nil {
 		return nil, fmt.Errorf("failed to create player bonus: %w", err)
 	}
 
@@ -213,24 +372,44 @@ func (s *BonusService) getGameContribution(ctx context.Context, gameID string) (
 	return game.Contribution, nil
 }
 
-func (s *BonusService) sendWageringUpdate(ctx context.Context, playerID string, bonusID string, completed bool) {
-	progress, err := s.GetWageringProgress(ctx, playerID, bonusID)
-	if err != nil {
-		log.Printf("Failed to get progress for notification: %v", err)
-		return
-	}
+// notifyProgress reports a change in wagering progress from previous to
+// progress. bonus only needs to carry the IDs, requirement and expiry.
+func (s *BonusService) notifyProgress(bonus *PlayerBonus, previous decimal.Decimal, progress decimal.Decimal, completed bool) {
+	update := newWageringUpdate(bonus)
+	update.WageringCompleted = progress
+	update.WageringRemaining = decimal.Max(bonus.WageringRequired.Sub(progress), decimal.Zero)
+	update.PercentageComplete = percentComplete(progress, bonus.WageringRequired)
+	update.Completed = completed
+	s.notifier.Progress(update, percentComplete(previous, bonus.WageringRequired))
+}
+
+// notifyEvent sends an event about a bonus, such as its award or expiry,
+// without throttling.
+func (s *BonusService) notifyEvent(eventType string, bonus *PlayerBonus) {
+	update := newWageringUpdate(bonus)
+	update.EventType = eventType
+	s.notifier.Event(update)
+}
 
-	update := WageringUpdate{
-		PlayerBonusID:      progress.PlayerBonusID,
-		PlayerID:           playerID,
-		WageringCompleted:  progress.WageringCompleted,
-		WageringRequired:   progress.WageringRequired,
-		PercentageComplete: progress.PercentageComplete,
-		Completed:          completed,
+func newWageringUpdate(bonus *PlayerBonus) WageringUpdate {
+	return WageringUpdate{
+		PlayerBonusID:      bonus.PlayerBonusID,
+		PlayerID:           bonus.PlayerID,
+		WageringCompleted:  bonus.WageringCompleted,
+		WageringRequired:   bonus.WageringRequired,
+		WageringRemaining:  decimal.Max(bonus.WageringRequired.Sub(bonus.WageringCompleted), decimal.Zero),
+		PercentageComplete: percentComplete(bonus.WageringCompleted, bonus.WageringRequired),
+		Completed:          bonus.Status == BonusStatusCompleted,
+		ExpiresAt:          bonus.ExpiresAt,
 		Timestamp:          time.Now(),
 	}
+}
 
-	s.notifyHub.Notify(playerID, update)
+func percentComplete(completed decimal.Decimal, required decimal.Decimal) float64 {
+	if required.IsZero() {
+		return 0
+	}
+	return completed.Div(required).Mul(decimal.NewFromInt(100)).InexactFloat64()
 }
 
 // func (s *BonusService) AddActiveBonus(bonus *PlayerBonus) {
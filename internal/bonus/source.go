@@ -0,0 +1,312 @@
+package bonus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BetMessage is a bet event read from a BetEventSource. Offsets are assigned
+// by the source, start at 1 and increase by one per message. Attempt counts
+// deliveries of the same offset, starting at 1.
+type BetMessage struct {
+	Offset  int64
+	Bet     BetEvent
+	Attempt int
+}
+
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration // delay before the second attempt, doubled each time
+	MaxDelay    time.Duration
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d > p.MaxDelay || d <= 0 {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// DefaultRedeliveryPolicy sets the backoff before a nacked message is
+// delivered again, and how many deliveries a Consumer makes before it
+// dead-letters the message.
+var DefaultRedeliveryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// BetEventSource delivers bet events with at-least-once semantics. A message
+// counts as consumed only once it has been acknowledged; the source commits
+// the highest offset below which every message has been acknowledged, and
+// resumes after that offset when it is reopened.
+type BetEventSource interface {
+	Name() string
+	// Receive blocks until a message is available. It returns io.EOF when
+	// the source is exhausted or closed.
+	Receive(ctx context.Context) (BetMessage, error)
+	Ack(ctx context.Context, msg BetMessage) error
+	// Nack reports that processing failed. The message stays unacknowledged
+	// and is delivered again after a backoff.
+	Nack(ctx context.Context, msg BetMessage, cause error) error
+	Close() error
+}
+
+// OffsetStore persists the committed offset of each consumer group per source.
+type OffsetStore interface {
+	LoadOffset(ctx context.Context, consumer string, source string) (int64, error)
+	CommitOffset(ctx context.Context, consumer string, source string, offset int64) error
+}
+
+type PostgresOffsetStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresOffsetStore(db *gorm.DB) *PostgresOffsetStore {
+	return &PostgresOffsetStore{db: db}
+}
+
+func (s *PostgresOffsetStore) LoadOffset(ctx context.Context, consumer string, source string) (int64, error) {
+	var offset ConsumerOffset
+	err := s.db.WithContext(ctx).
+		Where("consumer = ? AND source = ?", consumer, source).
+		First(&offset).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to load consumer offset: %w", err)
+	}
+
+	return offset.CommittedOffset, nil
+}
+
+// CommitOffset never moves an offset backwards, so late commits from a
+// previous run cannot rewind the consumer.
+func (s *PostgresOffsetStore) CommitOffset(ctx context.Context, consumer string, source string, offset int64) error {
+	err := s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "consumer"}, {Name: "source"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "committed_offset"}, Value: gorm.Expr("GREATEST(consumer_offsets.committed_offset, EXCLUDED.committed_offset)")},
+				{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("NOW()")},
+			},
+		}).
+		Create(&ConsumerOffset{Consumer: consumer, Source: source, CommittedOffset: offset}).Error
+	if err != nil {
+		return fmt.Errorf("failed to commit consumer offset: %w", err)
+	}
+	return nil
+}
+
+// MemoryOffsetStore keeps offsets in process memory, for tests and for
+// sources whose offsets need not survive a restart.
+type MemoryOffsetStore struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+}
+
+func NewMemoryOffsetStore() *MemoryOffsetStore {
+	return &MemoryOffsetStore{offsets: make(map[string]int64)}
+}
+
+func (s *MemoryOffsetStore) LoadOffset(ctx context.Context, consumer string, source string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.offsets[consumer+"/"+source], nil
+}
+
+func (s *MemoryOffsetStore) CommitOffset(ctx context.Context, consumer string, source string, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset > s.offsets[consumer+"/"+source] {
+		s.offsets[consumer+"/"+source] = offset
+	}
+	return nil
+}
+
+// offsetTracker turns out-of-order acknowledgements into a contiguous
+// committed offset, and holds nacked messages until they are due for
+// redelivery.
+type offsetTracker struct {
+	store    OffsetStore
+	consumer string
+	source   string
+
+	mu        sync.Mutex
+	committed int64
+	acked     map[int64]bool // acknowledged offsets above the first unacknowledged one
+	due       []BetMessage   // nacked messages whose backoff has passed
+	wake      chan struct{}  // closed and replaced when committed or due changes
+}
+
+func newOffsetTracker(ctx context.Context, store OffsetStore, consumer string, source string) (*offsetTracker, error) {
+	committed, err := store.LoadOffset(ctx, consumer, source)
+	if err != nil {
+		return nil, err
+	}
+	return &offsetTracker{
+		store:     store,
+		consumer:  consumer,
+		source:    source,
+		committed: committed,
+		acked:     make(map[int64]bool),
+		wake:      make(chan struct{}),
+	}, nil
+}
+
+func (t *offsetTracker) Committed() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.committed
+}
+
+func (t *offsetTracker) ack(ctx context.Context, offset int64) error {
+	t.mu.Lock()
+	if offset <= t.committed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.acked[offset] = true
+	advanced := false
+	for t.acked[t.committed+1] {
+		delete(t.acked, t.committed+1)
+		t.committed++
+		advanced = true
+	}
+	committed := t.committed
+	if advanced {
+		t.signal()
+	}
+	t.mu.Unlock()
+
+	if !advanced {
+		return nil
+	}
+	return t.store.CommitOffset(ctx, t.consumer, t.source, committed)
+}
+
+// redeliver queues msg to be received again once the backoff for its
+// attempt has passed.
+func (t *offsetTracker) redeliver(msg BetMessage) {
+	delay := DefaultRedeliveryPolicy.delay(msg.Attempt)
+	msg.Attempt++
+	time.AfterFunc(delay, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.due = append(t.due, msg)
+		t.signal()
+	})
+}
+
+// next returns a message that is due for redelivery, if any.
+func (t *offsetTracker) next() (BetMessage, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.due) == 0 {
+		return BetMessage{}, false
+	}
+	msg := t.due[0]
+	t.due = t.due[1:]
+	return msg, true
+}
+
+// changed returns a channel that is closed the next time the committed
+// offset advances or a message becomes due. Take it before calling next so
+// that no change is missed.
+func (t *offsetTracker) changed() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.wake
+}
+
+// signal wakes receivers waiting on changed. Callers must hold t.mu.
+func (t *offsetTracker) signal() {
+	close(t.wake)
+	t.wake = make(chan struct{})
+}
+
+// DeadLetterSink stores a bet that kept failing, so that its offset can be
+// committed. DeadLetterQueue is the default implementation.
+type DeadLetterSink interface {
+	DeadLetter(ctx context.Context, bet BetEvent, cause error, attempts int) error
+}
+
+// Consumer feeds a BetEventSource into an Ingestor and acknowledges each
+// message once the ingestor has processed it. A failed message is nacked for
+// redelivery until it has been delivered MaxAttempts times, then handed to
+// the dead-letter sink and acknowledged.
+type Consumer struct {
+	source      BetEventSource
+	ingestor    *Ingestor
+	deadLetters DeadLetterSink
+	maxAttempts int
+}
+
+func NewConsumer(source BetEventSource, ingestor *Ingestor) *Consumer {
+	return &Consumer{source: source, ingestor: ingestor, maxAttempts: DefaultRedeliveryPolicy.MaxAttempts}
+}
+
+// SetDeadLetterSink enables dead-lettering. Without a sink, a failing
+// message is redelivered until it succeeds.
+func (c *Consumer) SetDeadLetterSink(sink DeadLetterSink) {
+	c.deadLetters = sink
+}
+
+// SetMaxAttempts sets how many times a failing message is delivered before
+// it is dead-lettered.
+func (c *Consumer) SetMaxAttempts(n int) {
+	if n > 0 {
+		c.maxAttempts = n
+	}
+}
+
+// Run consumes until the source is exhausted or ctx is cancelled.
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		msg, err := c.source.Receive(ctx)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		err = c.ingestor.SubmitWithCallback(ctx, msg.Bet, func(procErr error) {
+			c.settle(msg, procErr)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Consumer) settle(msg BetMessage, procErr error) {
+	ctx := context.Background()
+	if procErr != nil && c.deadLetters != nil && msg.Attempt >= c.maxAttempts {
+		if err := c.deadLetters.DeadLetter(ctx, msg.Bet, procErr, msg.Attempt); err != nil {
+			log.Printf("Failed to dead-letter bet: source=%s offset=%d: %v", c.source.Name(), msg.Offset, err)
+		} else {
+			procErr = nil
+		}
+	}
+	if procErr != nil {
+		if err := c.source.Nack(ctx, msg, procErr); err != nil {
+			log.Printf("Failed to nack bet: source=%s offset=%d: %v", c.source.Name(), msg.Offset, err)
+		}
+		return
+	}
+	if err := c.source.Ack(ctx, msg); err != nil {
+		log.Printf("Failed to ack bet: source=%s offset=%d: %v", c.source.Name(), msg.Offset, err)
+	}
+}
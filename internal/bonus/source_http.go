@@ -0,0 +1,151 @@
+package bonus
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DefaultHTTPAckTimeout = 10 * time.Second
+
+// HTTPSource accepts bet events pushed with POST requests. Each request is
+// held open until its bet is acknowledged, so the response tells the pusher
+// whether the bet was applied; anything other than 200 should be retried.
+// Bets are deduplicated downstream by bet_id, so retries are safe.
+type HTTPSource struct {
+	name       string
+	tracker    *offsetTracker
+	ackTimeout time.Duration
+
+	incoming chan BetMessage
+	closed   chan struct{}
+	once     sync.Once
+
+	mu      sync.Mutex
+	next    int64
+	waiters map[int64]chan error
+}
+
+func NewHTTPSource(ctx context.Context, name string, offsets OffsetStore, consumer string) (*HTTPSource, error) {
+	tracker, err := newOffsetTracker(ctx, offsets, consumer, name)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPSource{
+		name:       name,
+		tracker:    tracker,
+		ackTimeout: DefaultHTTPAckTimeout,
+		incoming:   make(chan BetMessage),
+		closed:     make(chan struct{}),
+		next:       tracker.Committed(),
+		waiters:    make(map[int64]chan error),
+	}, nil
+}
+
+func (s *HTTPSource) Name() string {
+	return s.name
+}
+
+func (s *HTTPSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	var bet BetEvent
+	if err := json.NewDecoder(r.Body).Decode(&bet); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	result := make(chan error, 1)
+	s.mu.Lock()
+	s.next++
+	msg := BetMessage{Offset: s.next, Bet: bet, Attempt: 1}
+	s.waiters[msg.Offset] = result
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.ackTimeout)
+	defer cancel()
+
+	select {
+	case s.incoming <- msg:
+	case <-ctx.Done():
+		s.abandon(msg)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no consumer available"})
+		return
+	case <-s.closed:
+		s.abandon(msg)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "source is closed"})
+		return
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"bet_id": bet.BetID, "offset": msg.Offset})
+	case <-ctx.Done():
+		writeJSON(w, http.StatusGatewayTimeout, map[string]string{"error": "bet not acknowledged in time"})
+	}
+}
+
+// abandon acknowledges an offset that was never delivered, so it does not
+// hold back the committed offset. The pusher receives an error and retries.
+func (s *HTTPSource) abandon(msg BetMessage) {
+	s.mu.Lock()
+	delete(s.waiters, msg.Offset)
+	s.mu.Unlock()
+	_ = s.tracker.ack(context.Background(), msg.Offset)
+}
+
+func (s *HTTPSource) Receive(ctx context.Context) (BetMessage, error) {
+	select {
+	case msg := <-s.incoming:
+		return msg, nil
+	case <-ctx.Done():
+		return BetMessage{}, ctx.Err()
+	case <-s.closed:
+		return BetMessage{}, io.EOF
+	}
+}
+
+func (s *HTTPSource) Ack(ctx context.Context, msg BetMessage) error {
+	err := s.tracker.ack(ctx, msg.Offset)
+	s.resolve(msg, err)
+	return err
+}
+
+func (s *HTTPSource) Nack(ctx context.Context, msg BetMessage, cause error) error {
+	// The pusher owns redelivery, so a failed bet is settled here and the
+	// error is returned to it.
+	err := s.tracker.ack(ctx, msg.Offset)
+	s.resolve(msg, cause)
+	return err
+}
+
+func (s *HTTPSource) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *HTTPSource) resolve(msg BetMessage, err error) {
+	s.mu.Lock()
+	result, ok := s.waiters[msg.Offset]
+	delete(s.waiters, msg.Offset)
+	s.mu.Unlock()
+	if ok {
+		result <- err
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
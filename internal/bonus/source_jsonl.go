@@ -0,0 +1,125 @@
+package bonus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+)
+
+// JSONLinesSource reads one JSON-encoded BetEvent per line from a file or
+// stdin. The offset of a message is its line number, so a restarted consumer
+// skips every line up to the committed offset. At the end of the input it
+// keeps redelivering nacked lines, and reports io.EOF once every line has
+// been acknowledged.
+type JSONLinesSource struct {
+	name    string
+	tracker *offsetTracker
+
+	mu      sync.Mutex
+	scanner *bufio.Scanner
+	line    int64
+	eof     bool
+	closer  io.Closer
+}
+
+func NewJSONLinesSource(ctx context.Context, name string, r io.Reader, offsets OffsetStore, consumer string) (*JSONLinesSource, error) {
+	tracker, err := newOffsetTracker(ctx, offsets, consumer, name)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	src := &JSONLinesSource{
+		name:    name,
+		tracker: tracker,
+		scanner: scanner,
+	}
+	if c, ok := r.(io.Closer); ok {
+		src.closer = c
+	}
+	return src, nil
+}
+
+func (s *JSONLinesSource) Name() string {
+	return s.name
+}
+
+func (s *JSONLinesSource) Receive(ctx context.Context) (BetMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		changed := s.tracker.changed()
+		if msg, ok := s.tracker.next(); ok {
+			return msg, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return BetMessage{}, err
+		}
+		if s.eof {
+			if s.tracker.Committed() >= s.line {
+				return BetMessage{}, io.EOF
+			}
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return BetMessage{}, ctx.Err()
+			}
+		}
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return BetMessage{}, err
+			}
+			s.eof = true
+			continue
+		}
+		s.line++
+		if s.line <= s.tracker.Committed() {
+			continue
+		}
+
+		line := bytes.TrimSpace(s.scanner.Bytes())
+		if len(line) == 0 {
+			if err := s.tracker.ack(ctx, s.line); err != nil {
+				return BetMessage{}, err
+			}
+			continue
+		}
+
+		var bet BetEvent
+		if err := json.Unmarshal(line, &bet); err != nil {
+			// A malformed line can never succeed, so it is skipped rather
+			// than holding back the committed offset forever.
+			log.Printf("Skipping malformed bet event: source=%s line=%d: %v", s.name, s.line, err)
+			if err := s.tracker.ack(ctx, s.line); err != nil {
+				return BetMessage{}, err
+			}
+			continue
+		}
+		return BetMessage{Offset: s.line, Bet: bet, Attempt: 1}, nil
+	}
+}
+
+func (s *JSONLinesSource) Ack(ctx context.Context, msg BetMessage) error {
+	return s.tracker.ack(ctx, msg.Offset)
+}
+
+func (s *JSONLinesSource) Nack(ctx context.Context, msg BetMessage, cause error) error {
+	log.Printf("Bet not acknowledged: source=%s line=%d bet_id=%s attempt=%d: %v", s.name, msg.Offset, msg.Bet.BetID, msg.Attempt, cause)
+	s.tracker.redeliver(msg)
+	return nil
+}
+
+func (s *JSONLinesSource) Close() error {
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
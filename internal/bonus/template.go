@@ -0,0 +1,104 @@
+package bonus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"wallet_service/internal/wallet"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrTemplateNotFound = errors.New("bonus template not found")
+
+type TemplateRepository interface {
+	CreateTemplate(ctx context.Context, template *BonusTemplate) error
+	GetTemplate(ctx context.Context, templateID string) (*BonusTemplate, error)
+	// ListTemplates returns the active templates of a type and currency.
+	ListTemplates(ctx context.Context, bonusType string, currency string) ([]BonusTemplate, error)
+	// CountDeposits counts a player's completed main-wallet deposits other
+	// than the one with excludeReference.
+	CountDeposits(ctx context.Context, playerID string, currency string, excludeReference string) (int64, error)
+	// ClaimFirstDeposit records referenceID as the player's first deposit in
+	// currency and reports whether it holds the claim: true if this call or
+	// an earlier one with the same reference made it.
+	ClaimFirstDeposit(ctx context.Context, playerID string, currency string, referenceID string) (bool, error)
+}
+
+type TemplateRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewTemplateRepository(db *gorm.DB) *TemplateRepositoryImpl {
+	return &TemplateRepositoryImpl{db: db}
+}
+
+func (r *TemplateRepositoryImpl) CreateTemplate(ctx context.Context, template *BonusTemplate) error {
+	if err := r.db.WithContext(ctx).Create(template).Error; err != nil {
+		return fmt.Errorf("failed to create bonus template: %w", err)
+	}
+	return nil
+}
+
+func (r *TemplateRepositoryImpl) GetTemplate(ctx context.Context, templateID string) (*BonusTemplate, error) {
+	var template BonusTemplate
+	err := r.db.WithContext(ctx).
+		Where("template_id = ?", templateID).
+		First(&template).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrTemplateNotFound
+		}
+		return nil, fmt.Errorf("failed to get bonus template: %w", err)
+	}
+
+	return &template, nil
+}
+
+func (r *TemplateRepositoryImpl) ListTemplates(ctx context.Context, bonusType string, currency string) ([]BonusTemplate, error) {
+	var templates []BonusTemplate
+	err := r.db.WithContext(ctx).
+		Where("bonus_type = ? AND currency = ? AND active", bonusType, currency).
+		Order("created_at, template_id").
+		Find(&templates).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bonus templates: %w", err)
+	}
+	return templates, nil
+}
+
+func (r *TemplateRepositoryImpl) CountDeposits(ctx context.Context, playerID string, currency string, excludeReference string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Table("transactions AS t").
+		Joins("JOIN wallets AS w ON w.wallet_id = t.wallet_id").
+		Where("t.player_id = ? AND t.transaction_type = ? AND t.status = ? AND t.reference_id <> ?",
+			playerID, wallet.TransactionTypeDeposit, "completed", excludeReference).
+		Where("w.wallet_type = ? AND w.currency = ?", wallet.WalletTypeMain, currency).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deposits: %w", err)
+	}
+	return count, nil
+}
+
+func (r *TemplateRepositoryImpl) ClaimFirstDeposit(ctx context.Context, playerID string, currency string, referenceID string) (bool, error) {
+	claim := FirstDepositClaim{PlayerID: playerID, Currency: currency, ReferenceID: referenceID}
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&claim).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to claim first deposit: %w", err)
+	}
+
+	var stored FirstDepositClaim
+	err = r.db.WithContext(ctx).
+		Where("player_id = ? AND currency = ?", playerID, currency).
+		First(&stored).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to get first deposit claim: %w", err)
+	}
+	return stored.ReferenceID == referenceID, nil
+}
@@ -0,0 +1,225 @@
+package bonus
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultTimelinePageSize = 100
+	MaxTimelinePageSize     = 1000
+)
+
+// TimelineEntry is one wagering event of a bonus with the game it was bet
+// on and the bonus's wagering total after it.
+type TimelineEntry struct {
+	EventID                string          `json:"event_id"`
+	BetID                  string          `json:"bet_id"`
+	GameID                 string          `json:"game_id"`
+	GameName               string          `json:"game_name"`
+	GameType               string          `json:"game_type"`
+	BetAmount              decimal.Decimal `json:"bet_amount"`
+	ContributionPercentage decimal.Decimal `json:"contribution_percentage"` // share of the bet that counted, 0 to 1
+	WageringContribution   decimal.Decimal `json:"wagering_contribution"`
+	RunningTotal           decimal.Decimal `json:"running_total"` // sum of contributions so far, before the cap at the requirement
+	PlacedAt               time.Time       `json:"placed_at"`
+	CreatedAt              time.Time       `json:"created_at"`
+}
+
+// GameBreakdown sums a bonus's wagering events per game and contribution
+// rate. A game whose rate changed while the bonus was active has one row per
+// rate.
+type GameBreakdown struct {
+	GameID                 string          `json:"game_id"`
+	GameName               string          `json:"game_name"`
+	GameType               string          `json:"game_type"`
+	ContributionPercentage decimal.Decimal `json:"contribution_percentage"`
+	Bets                   int64           `json:"bets"`
+	BetAmount              decimal.Decimal `json:"bet_amount"`
+	WageringContribution   decimal.Decimal `json:"wagering_contribution"`
+}
+
+// WageringTimeline is a page of a bonus's wagering events in processing
+// order, with totals over all of them.
+type WageringTimeline struct {
+	PlayerBonusID     string          `json:"player_bonus_id"`
+	PlayerID          string          `json:"player_id"`
+	Status            string          `json:"status"`
+	WageringRequired  decimal.Decimal `json:"wagering_required"`
+	WageringCompleted decimal.Decimal `json:"wagering_completed"`
+	TotalEvents       int64           `json:"total_events"`
+	Limit             int             `json:"limit"`
+	Offset            int             `json:"offset"`
+	Games             []GameBreakdown `json:"games"`
+	Events            []TimelineEntry `json:"events"`
+}
+
+type TimelineRepository interface {
+	ListTimelineEntries(ctx context.Context, playerBonusID string, limit int, offset int) ([]TimelineEntry, error)
+	CountWageringEvents(ctx context.Context, playerBonusID string) (int64, error)
+	GetGameBreakdown(ctx context.Context, playerBonusID string) ([]GameBreakdown, error)
+}
+
+type TimelineRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewTimelineRepository(db *gorm.DB) *TimelineRepositoryImpl {
+	return &TimelineRepositoryImpl{db: db}
+}
+
+// ListTimelineEntries returns a page of events in processing order. The
+// running total is computed over all earlier events, not just the page. A
+// negative limit returns every event.
+func (r *TimelineRepositoryImpl) ListTimelineEntries(ctx context.Context, playerBonusID string, limit int, offset int) ([]TimelineEntry, error) {
+	var entries []TimelineEntry
+	err := r.db.WithContext(ctx).
+		Table("wagering_events e").
+		Select(`e.event_id, e.bet_id, e.game_id, COALESCE(g.game_name, '') AS game_name, COALESCE(g.game_type, '') AS game_type,
+			e.bet_amount, e.contribution_percentage, e.wagering_contribution,
+			SUM(e.wagering_contribution) OVER (ORDER BY e.created_at, e.event_id) AS running_total,
+			e.placed_at, e.created_at`).
+		Joins("LEFT JOIN games g ON g.game_id = e.game_id").
+		Where("e.player_bonus_id = ?", playerBonusID).
+		Order("e.created_at, e.event_id").
+		Limit(limit).
+		Offset(offset).
+		Scan(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wagering timeline: %w", err)
+	}
+	return entries, nil
+}
+
+func (r *TimelineRepositoryImpl) CountWageringEvents(ctx context.Context, playerBonusID string) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&WageringEvent{}).
+		Where("player_bonus_id = ?", playerBonusID).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count wagering events: %w", err)
+	}
+	return count, nil
+}
+
+func (r *TimelineRepositoryImpl) GetGameBreakdown(ctx context.Context, playerBonusID string) ([]GameBreakdown, error) {
+	var games []GameBreakdown
+	err := r.db.WithContext(ctx).
+		Table("wagering_events e").
+		Select(`e.game_id, COALESCE(g.game_name, '') AS game_name, COALESCE(g.game_type, '') AS game_type,
+			e.contribution_percentage, COUNT(*) AS bets,
+			SUM(e.bet_amount) AS bet_amount, SUM(e.wagering_contribution) AS wagering_contribution`).
+		Joins("LEFT JOIN games g ON g.game_id = e.game_id").
+		Where("e.player_bonus_id = ?", playerBonusID).
+		Group("e.game_id, g.game_name, g.game_type, e.contribution_percentage").
+		Order("wagering_contribution DESC, e.game_id").
+		Scan(&games).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get game breakdown: %w", err)
+	}
+	return games, nil
+}
+
+// TimelineService answers support questions about how a bonus's wagering
+// came about, bet by bet.
+type TimelineService struct {
+	repo    TimelineRepository
+	bonuses *BonusService
+}
+
+func NewTimelineService(repo TimelineRepository, bonuses *BonusService) *TimelineService {
+	return &TimelineService{repo: repo, bonuses: bonuses}
+}
+
+// BonusOwner returns the player a bonus belongs to, so callers can
+// authorize a request before reading its timeline.
+func (s *TimelineService) BonusOwner(ctx context.Context, playerBonusID string) (string, error) {
+	bonus, err := s.bonuses.repo.GetBonus(ctx, playerBonusID)
+	if err != nil {
+		return "", err
+	}
+	return bonus.PlayerID, nil
+}
+
+// GetTimeline returns a page of a bonus's wagering events. limit is clamped
+// to MaxTimelinePageSize and defaults to DefaultTimelinePageSize; a negative
+// limit returns every event, for exports.
+func (s *TimelineService) GetTimeline(ctx context.Context, playerBonusID string, limit int, offset int) (*WageringTimeline, error) {
+	bonus, err := s.bonuses.repo.GetBonus(ctx, playerBonusID)
+	if err != nil {
+		return nil, err
+	}
+	if bonus.Status == BonusStatusActive {
+		if bonus.WageringCompleted, err = s.bonuses.progress.GetProgress(ctx, bonus); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case limit == 0:
+		limit = DefaultTimelinePageSize
+	case limit > MaxTimelinePageSize:
+		limit = MaxTimelinePageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	total, err := s.repo.CountWageringEvents(ctx, playerBonusID)
+	if err != nil {
+		return nil, err
+	}
+	games, err := s.repo.GetGameBreakdown(ctx, playerBonusID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.repo.ListTimelineEntries(ctx, playerBonusID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WageringTimeline{
+		PlayerBonusID:     bonus.PlayerBonusID,
+		PlayerID:          bonus.PlayerID,
+		Status:            bonus.Status,
+		WageringRequired:  bonus.WageringRequired,
+		WageringCompleted: bonus.WageringCompleted,
+		TotalEvents:       total,
+		Limit:             limit,
+		Offset:            offset,
+		Games:             games,
+		Events:            entries,
+	}, nil
+}
+
+var timelineCSVHeader = []string{
+	"event_id", "bet_id", "placed_at", "game_id", "game_name", "game_type",
+	"bet_amount", "contribution_percentage", "wagering_contribution", "running_total",
+}
+
+// WriteTimelineCSV writes the events of a timeline as CSV with a header row.
+func WriteTimelineCSV(w io.Writer, timeline *WageringTimeline) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(timelineCSVHeader); err != nil {
+		return err
+	}
+	for _, e := range timeline.Events {
+		err := cw.Write([]string{
+			e.EventID, e.BetID, e.PlacedAt.UTC().Format(time.RFC3339), e.GameID, e.GameName, e.GameType,
+			e.BetAmount.StringFixed(2), e.ContributionPercentage.StringFixed(4),
+			e.WageringContribution.StringFixed(2), e.RunningTotal.StringFixed(2),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
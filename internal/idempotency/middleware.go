@@ -0,0 +1,199 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	"wallet_service/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	DefaultTTL           = 24 * time.Hour
+	DefaultLockTimeout   = time.Minute
+	DefaultPurgeInterval = time.Hour
+
+	maxKeyLength = 255
+	maxBody      = 1 << 20
+)
+
+// Config controls how long keys are kept.
+type Config struct {
+	TTL         time.Duration // how long a response is replayed
+	LockTimeout time.Duration // after which an unfinished request may be retried
+}
+
+// Middleware makes mutating requests that carry an Idempotency-Key safe to
+// retry. The first request with a key runs and its response is stored; a
+// repeat with the same method, path and body gets that response back byte
+// for byte, a repeat with anything else gets 422, and a repeat while the
+// first is still running gets 409. Keys are scoped to the authenticated
+// client. Server errors and refusals that say nothing about the request
+// itself, such as 429 from the rate limiter or 403 from a role check, are
+// not stored, so the client can retry them.
+type Middleware struct {
+	store  Store
+	config Config
+}
+
+func NewMiddleware(store Store, config Config) *Middleware {
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+	if config.LockTimeout <= 0 {
+		config.LockTimeout = DefaultLockTimeout
+	}
+	return &Middleware{store: store, config: config}
+}
+
+func (m *Middleware) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderKey)
+		if key == "" || c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxBody+1))
+		if err != nil || len(body) > maxBody {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large for an idempotent request"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		fp := fingerprint(c.Request, body)
+		reserved, claimed, err := m.store.Reserve(ctx, &IdempotencyKey{
+			Scope:       scope(c),
+			Key:         key,
+			Fingerprint: fp,
+			ExpiresAt:   time.Now().Add(m.config.TTL),
+		}, time.Now().Add(-m.config.LockTimeout))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !claimed {
+			m.answerRepeat(c, reserved, fp)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		defer func() {
+			// A panic or a server error leaves nothing worth replaying
+			if p := recover(); p != nil {
+				m.release(reserved)
+				panic(p)
+			}
+			if !replayable(recorder) {
+				m.release(reserved)
+				return
+			}
+			res := Response{Status: recorder.Status(), ContentType: recorder.Header().Get("Content-Type"), Body: recorder.body.Bytes()}
+			if err := m.store.Complete(context.WithoutCancel(ctx), reserved.Scope, reserved.Key, res); err != nil {
+				log.Printf("Idempotent response not stored: scope=%s key=%s: %v", reserved.Scope, reserved.Key, err)
+			}
+		}()
+		c.Next()
+	}
+}
+
+func (m *Middleware) answerRepeat(c *gin.Context, existing *IdempotencyKey, fp string) {
+	switch {
+	case existing.Fingerprint != fp:
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+	case existing.Status != StatusCompleted:
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still in progress"})
+	default:
+		c.Header(HeaderReplayed, "true")
+		if existing.ContentType != "" {
+			c.Header("Content-Type", existing.ContentType)
+		}
+		c.Status(existing.ResponseStatus)
+		_, _ = c.Writer.Write(existing.ResponseBody)
+		c.Abort()
+	}
+}
+
+// replayable reports whether a response is the outcome of the request and
+// may be stored. Server errors, authentication and role failures, rate
+// limiting, and anything asking to be retried later are not: middleware in
+// front of the handler may have answered before it ran, and a retry with the
+// same key must get to run it.
+func replayable(w *responseRecorder) bool {
+	switch w.Status() {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return w.Status() < http.StatusInternalServerError && w.Header().Get("Retry-After") == ""
+}
+
+func (m *Middleware) release(key *IdempotencyKey) {
+	if err := m.store.Release(context.Background(), key.Scope, key.Key); err != nil {
+		log.Printf("Idempotency key not released: scope=%s key=%s: %v", key.Scope, key.Key, err)
+	}
+}
+
+// Run deletes expired keys every interval until ctx is cancelled.
+func (m *Middleware) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := m.store.DeleteExpired(ctx, time.Now()); err != nil {
+				log.Printf("Failed to delete expired idempotency keys: %v", err)
+			}
+		}
+	}
+}
+
+func scope(c *gin.Context) string {
+	if identity := auth.Identify(c); identity != nil {
+		return identity.Subject
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.RequestURI()))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder copies the response body while writing it through.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,31 @@
+package idempotency
+
+import "time"
+
+// IdempotencyKey is an Idempotency-Key seen from a client, with the
+// response that answered it once the request has finished.
+type IdempotencyKey struct {
+	Scope          string     `gorm:"column:scope;primaryKey;type:varchar(255)"` // the client that sent the key
+	Key            string     `gorm:"column:idempotency_key;primaryKey;type:varchar(255)"`
+	Fingerprint    string     `gorm:"column:fingerprint;type:varchar(64);not null"` // hex SHA-256 of method, path and body
+	Status         string     `gorm:"column:status;type:varchar(20);not null;default:'in_flight'"`
+	ResponseStatus int        `gorm:"column:response_status"`
+	ContentType    string     `gorm:"column:content_type;type:varchar(255)"`
+	ResponseBody   []byte     `gorm:"column:response_body;type:bytea"`
+	LockedAt       time.Time  `gorm:"column:locked_at;not null;default:now()"`
+	CreatedAt      time.Time  `gorm:"column:created_at;not null;default:now()"`
+	CompletedAt    *time.Time `gorm:"column:completed_at"`
+	ExpiresAt      time.Time  `gorm:"column:expires_at;not null"`
+}
+
+const (
+	StatusInFlight  = "in_flight"
+	StatusCompleted = "completed"
+)
+
+// Response is what is stored and replayed for a completed request.
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
@@ -0,0 +1,176 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Store keeps idempotency keys and their responses.
+type Store interface {
+	// Reserve claims a key for a request. It returns the new record and
+	// true, or the record already holding the key and false. Expired keys,
+	// and in-flight keys with the same fingerprint locked before staleBefore,
+	// are claimed again.
+	Reserve(ctx context.Context, key *IdempotencyKey, staleBefore time.Time) (*IdempotencyKey, bool, error)
+	// Complete stores the response of a reserved key.
+	Complete(ctx context.Context, scope string, key string, res Response) error
+	// Release drops a reserved key so the request can be retried.
+	Release(ctx context.Context, scope string, key string) error
+	// DeleteExpired removes keys that expired before the given time.
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Reserve(ctx context.Context, key *IdempotencyKey, staleBefore time.Time) (*IdempotencyKey, bool, error) {
+	var claimed []IdempotencyKey
+	err := s.db.WithContext(ctx).Raw(`
+		INSERT INTO idempotency_keys (scope, idempotency_key, fingerprint, status, locked_at, created_at, expires_at)
+		VALUES (?, ?, ?, ?, NOW(), NOW(), ?)
+		ON CONFLICT (scope, idempotency_key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint,
+			status = EXCLUDED.status,
+			response_status = NULL,
+			content_type = NULL,
+			response_body = NULL,
+			locked_at = NOW(),
+			created_at = NOW(),
+			completed_at = NULL,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+			OR (idempotency_keys.status = ? AND idempotency_keys.locked_at < ? AND idempotency_keys.fingerprint = EXCLUDED.fingerprint)
+		RETURNING *`,
+		key.Scope, key.Key, key.Fingerprint, StatusInFlight, key.ExpiresAt, StatusInFlight, staleBefore,
+	).Scan(&claimed).Error
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if len(claimed) == 1 {
+		return &claimed[0], true, nil
+	}
+
+	var existing IdempotencyKey
+	err = s.db.WithContext(ctx).
+		Where("scope = ? AND idempotency_key = ?", key.Scope, key.Key).
+		First(&existing).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Released between the insert and the read; the client retries
+			existing = IdempotencyKey{Scope: key.Scope, Key: key.Key, Fingerprint: key.Fingerprint, Status: StatusInFlight}
+			return &existing, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	return &existing, false, nil
+}
+
+func (s *PostgresStore) Complete(ctx context.Context, scope string, key string, res Response) error {
+	err := s.db.WithContext(ctx).
+		Model(&IdempotencyKey{}).
+		Where("scope = ? AND idempotency_key = ? AND status = ?", scope, key, StatusInFlight).
+		Updates(map[string]interface{}{
+			"status":          StatusCompleted,
+			"response_status": res.Status,
+			"content_type":    res.ContentType,
+			"response_body":   res.Body,
+			"completed_at":    gorm.Expr("NOW()"),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Release(ctx context.Context, scope string, key string) error {
+	err := s.db.WithContext(ctx).
+		Where("scope = ? AND idempotency_key = ? AND status = ?", scope, key, StatusInFlight).
+		Delete(&IdempotencyKey{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	res := s.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&IdempotencyKey{})
+	if res.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", res.Error)
+	}
+	return res.RowsAffected, nil
+}
+
+// MemoryStore keeps keys in process memory, for tests and single-instance
+// development.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]*IdempotencyKey
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]*IdempotencyKey)}
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, key *IdempotencyKey, staleBefore time.Time) (*IdempotencyKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	id := key.Scope + "|" + key.Key
+	if existing, ok := s.keys[id]; ok {
+		stale := existing.Status == StatusInFlight && existing.LockedAt.Before(staleBefore) && existing.Fingerprint == key.Fingerprint
+		if !existing.ExpiresAt.Before(now) && !stale {
+			copied := *existing
+			return &copied, false, nil
+		}
+	}
+	claimed := *key
+	claimed.Status = StatusInFlight
+	claimed.LockedAt, claimed.CreatedAt = now, now
+	s.keys[id] = &claimed
+	copied := claimed
+	return &copied, true, nil
+}
+
+func (s *MemoryStore) Complete(ctx context.Context, scope string, key string, res Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.keys[scope+"|"+key]; ok && existing.Status == StatusInFlight {
+		now := time.Now()
+		existing.Status = StatusCompleted
+		existing.ResponseStatus, existing.ContentType, existing.ResponseBody = res.Status, res.ContentType, res.Body
+		existing.CompletedAt = &now
+	}
+	return nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, scope string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.keys[scope+"|"+key]; ok && existing.Status == StatusInFlight {
+		delete(s.keys, scope+"|"+key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for id, existing := range s.keys {
+		if existing.ExpiresAt.Before(before) {
+			delete(s.keys, id)
+			n++
+		}
+	}
+	return n, nil
+}
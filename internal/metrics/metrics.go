@@ -0,0 +1,170 @@
+// Package metrics holds the Prometheus metrics of the service, served at
+// /metrics by Handler.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of a wallet transaction.
+const (
+	OutcomeCompleted         = "completed"
+	OutcomeReplayed          = "replayed"
+	OutcomeInsufficientFunds = "insufficient_funds"
+	OutcomeConflict          = "conflict"
+	OutcomeBlocked           = "blocked"
+	OutcomeFailed            = "failed"
+)
+
+// TypeInvalid is the type label of a transaction whose type the wallet does
+// not know, so client input cannot add label values.
+const TypeInvalid = "invalid"
+
+// Reasons a bet is skipped by wagering.
+const (
+	SkipDuplicate     = "duplicate"
+	SkipNoActiveBonus = "no_active_bonus"
+	SkipRejected      = "rejected"
+)
+
+// rateWindow is the window events_processed_sec is averaged over.
+const rateWindow = 60
+
+// Registry holds every metric of the service, plus Go runtime and process
+// metrics.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	Transactions = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "wallet_transactions_total",
+		Help: "Wallet transactions by type and outcome.",
+	}, []string{"type", "outcome"})
+
+	InsufficientFunds = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "wallet_insufficient_funds_total",
+		Help: "Wallet transactions refused for insufficient funds, by type.",
+	}, []string{"type"})
+
+	OptimisticLockRetries = factory.NewCounter(prometheus.CounterOpts{
+		Name: "wallet_optimistic_lock_retries_total",
+		Help: "Wallet updates retried after losing an optimistic lock.",
+	})
+
+	OptimisticLockExhausted = factory.NewCounter(prometheus.CounterOpts{
+		Name: "wallet_optimistic_lock_exhausted_total",
+		Help: "Wallet transactions that failed after running out of optimistic lock retries.",
+	})
+
+	DBTransactionDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_transaction_duration_seconds",
+		Help:    "Latency of database transactions, by operation.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	WageringEvents = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "wagering_events_total",
+		Help: "Bet events handled by wagering: processed, or skipped with a reason.",
+	}, []string{"outcome", "reason"})
+
+	WageringLag = factory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "wagering_lag_ms",
+		Help:    "Milliseconds from a bet's timestamp to its wagering being recorded.",
+		Buckets: []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000},
+	})
+
+	processed = &eventRate{}
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "events_processed_sec",
+		Help: "Bet events processed by wagering per second, averaged over the last minute.",
+	}, processed.perSecond)
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveDBTransaction records the latency of a database transaction that
+// started at start; use it as defer ObserveDBTransaction(op, time.Now()).
+func ObserveDBTransaction(operation string, start time.Time) {
+	DBTransactionDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// WageringProcessed records a bet placed at placedAt whose wagering was
+// recorded just now.
+func WageringProcessed(placedAt time.Time) {
+	WageringEvents.WithLabelValues("processed", "").Inc()
+	WageringLag.Observe(float64(time.Since(placedAt).Milliseconds()))
+	processed.add(time.Now())
+}
+
+// WageringSkipped records a bet that did not count towards wagering.
+func WageringSkipped(reason string) {
+	WageringEvents.WithLabelValues("skipped", reason).Inc()
+}
+
+// eventRate counts events per second over the last rateWindow seconds.
+type eventRate struct {
+	mu      sync.Mutex
+	seconds [rateWindow]int64
+	counts  [rateWindow]float64
+}
+
+func (r *eventRate) add(at time.Time) {
+	sec := at.Unix()
+	i := sec % rateWindow
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seconds[i] != sec {
+		r.seconds[i], r.counts[i] = sec, 0
+	}
+	r.counts[i]++
+}
+
+func (r *eventRate) perSecond() float64 {
+	now := time.Now().Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var total float64
+	for i, sec := range r.seconds {
+		if now-sec < rateWindow {
+			total += r.counts[i]
+		}
+	}
+	return total / rateWindow
+}
+
+// Hub is a notification hub whose subscribers and dropped updates are
+// exported.
+type Hub interface {
+	Subscribers() int
+	Dropped() uint64
+}
+
+// RegisterHub exports the state of h.
+func RegisterHub(h Hub) {
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "notification_hub_subscribers",
+		Help: "Open wagering update subscriptions.",
+	}, func() float64 { return float64(h.Subscribers()) })
+	factory.NewCounterFunc(prometheus.CounterOpts{
+		Name: "notification_hub_dropped_updates_total",
+		Help: "Wagering updates dropped because a subscriber was not keeping up.",
+	}, func() float64 { return float64(h.Dropped()) })
+}
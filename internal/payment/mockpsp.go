@@ -0,0 +1,203 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MockPSP is a local stand-in for a payment provider, for tests and local
+// development. It accepts payments and payouts like HTTPProvider sends them
+// and settles them on request by posting signed webhooks:
+//
+//	POST /payments, POST /payouts                   create
+//	GET  /payments/{id}, GET /payouts/{id}          show
+//	POST /payments/{id}/settle?status=succeeded     settle and send a webhook
+//	POST /payments/{id}/resend                      send the last webhook again
+//
+// status may be "succeeded" or "failed". Every settlement of the same
+// payment reuses one event ID, as a real PSP does for redeliveries.
+type MockPSP struct {
+	apiKey        string
+	webhookSecret []byte
+	webhookURL    string
+	client        *http.Client
+
+	mu       sync.Mutex
+	payments map[string]*mockPayment
+	byKey    map[string]string
+}
+
+type mockPayment struct {
+	Object  string          `json:"object"`
+	ID      string          `json:"id"`
+	Status  string          `json:"status"`
+	Request ProviderRequest `json:"request"`
+	Event   *WebhookEvent   `json:"event,omitempty"`
+}
+
+func NewMockPSP(apiKey string, webhookSecret []byte, webhookURL string) *MockPSP {
+	return &MockPSP{
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		webhookURL:    webhookURL,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		payments:      make(map[string]*mockPayment),
+		byKey:         make(map[string]string),
+	}
+}
+
+// SetWebhookURL changes where webhooks are sent, for servers whose address
+// is only known after the mock is created.
+func (m *MockPSP) SetWebhookURL(url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.webhookURL = url
+}
+
+func (m *MockPSP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.apiKey != "" && r.Header.Get("Authorization") != "Bearer "+m.apiKey {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid api key"})
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "payments" && parts[0] != "payouts" {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	object := strings.TrimSuffix(parts[0], "s")
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		m.create(w, r, object)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		if p := m.get(object, parts[1]); p != nil {
+			writeJSON(w, http.StatusOK, p)
+			return
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	case len(parts) == 3 && r.Method == http.MethodPost && (parts[2] == "settle" || parts[2] == "resend"):
+		event, err := m.Settle(object, parts[1], r.URL.Query().Get("status"), parts[2] == "resend")
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, event)
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+func (m *MockPSP) create(w http.ResponseWriter, r *http.Request, object string) {
+	var req ProviderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MerchantReference == "" || !req.Amount.IsPositive() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "merchant_reference and a positive amount are required"})
+		return
+	}
+
+	key := object + "|" + r.Header.Get("Idempotency-Key")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok := m.byKey[key]; ok {
+		writeJSON(w, http.StatusOK, m.answer(m.payments[id]))
+		return
+	}
+	p := &mockPayment{Object: object, ID: object[:3] + "_" + uuid.NewString(), Status: StatusPending, Request: req}
+	m.payments[p.ID] = p
+	if r.Header.Get("Idempotency-Key") != "" {
+		m.byKey[key] = p.ID
+	}
+	writeJSON(w, http.StatusCreated, m.answer(p))
+}
+
+func (m *MockPSP) answer(p *mockPayment) ProviderPayment {
+	res := ProviderPayment{Reference: p.ID, Status: p.Status}
+	if p.Object == "payment" {
+		res.RedirectURL = "https://psp.invalid/checkout/" + p.ID
+	}
+	return res
+}
+
+func (m *MockPSP) get(object string, id string) *mockPayment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.payments[id]; ok && p.Object == object {
+		copied := *p
+		return &copied
+	}
+	return nil
+}
+
+// Settle moves a payment or payout to status and posts the webhook. With
+// resend it posts the previous webhook again instead.
+func (m *MockPSP) Settle(object string, id string, status string, resend bool) (*WebhookEvent, error) {
+	m.mu.Lock()
+	p, ok := m.payments[id]
+	if !ok || p.Object != object {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("unknown %s %s", object, id)
+	}
+	if !resend {
+		if status != StatusSucceeded && status != StatusFailed {
+			m.mu.Unlock()
+			return nil, fmt.Errorf("status must be %s or %s", StatusSucceeded, StatusFailed)
+		}
+		p.Status = status
+		event := WebhookEvent{
+			TransactionID:     "evt_" + p.ID,
+			Object:            p.Object,
+			Reference:         p.ID,
+			MerchantReference: p.Request.MerchantReference,
+			Status:            status,
+			Amount:            p.Request.Amount,
+			Currency:          p.Request.Currency,
+		}
+		if status == StatusFailed {
+			event.FailureReason = "declined by mock PSP"
+		}
+		p.Event = &event
+	}
+	if p.Event == nil {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%s %s has not been settled", object, id)
+	}
+	event := *p.Event
+	webhookURL := m.webhookURL
+	m.mu.Unlock()
+
+	return &event, m.send(webhookURL, &event)
+}
+
+func (m *MockPSP) send(webhookURL string, event *WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookSignature, SignWebhook(m.webhookSecret, time.Now(), body))
+	res, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook delivery failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook delivery failed: %s", res.Status)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
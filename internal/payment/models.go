@@ -0,0 +1,64 @@
+package payment
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PaymentIntent is a deposit or payout that waits for the payment provider
+// to confirm it. The wallet is only credited for a deposit once the PSP
+// reports it succeeded; a payout holds the funds from the start and returns
+// them if the PSP reports a failure.
+type PaymentIntent struct {
+	IntentID            string          `gorm:"column:intent_id;primaryKey;type:uuid" json:"intent_id"`
+	Kind                string          `gorm:"column:kind;type:varchar(20);not null" json:"kind"` // "deposit", "payout"
+	PlayerID            string          `gorm:"column:player_id;type:uuid;not null" json:"player_id"`
+	Currency            string          `gorm:"column:currency;type:varchar(3);not null" json:"currency"`
+	Amount              decimal.Decimal `gorm:"column:amount;type:numeric(20,2);not null" json:"amount"`
+	Status              string          `gorm:"column:status;type:varchar(20);not null;default:'pending'" json:"status"` // "pending", "succeeded", "failed"
+	Provider            string          `gorm:"column:provider;type:varchar(50);not null" json:"provider"`
+	ProviderReference   string          `gorm:"column:provider_reference;type:varchar(255)" json:"provider_reference,omitempty"` // the PSP's payment or payout ID
+	PSPTransactionID    string          `gorm:"column:psp_transaction_id;type:varchar(255)" json:"psp_transaction_id,omitempty"` // from the webhook that settled the intent
+	RedirectURL         string          `gorm:"column:redirect_url;type:text" json:"redirect_url,omitempty"`                     // where the player completes a deposit
+	BonusCode           string          `gorm:"column:bonus_code;type:varchar(50)" json:"bonus_code,omitempty"`                  // deposits only
+	Country             string          `gorm:"column:country;type:varchar(2)" json:"country,omitempty"`                         // deposits only
+	FailureReason       string          `gorm:"column:failure_reason;type:text" json:"failure_reason,omitempty"`
+	WalletTransactionID *string         `gorm:"column:wallet_transaction_id;type:uuid" json:"wallet_transaction_id,omitempty"` // credit or reversal made on settlement
+	CreatedAt           time.Time       `gorm:"column:created_at;not null;default:now()" json:"created_at"`
+	UpdatedAt           time.Time       `gorm:"column:updated_at;not null;default:now()" json:"updated_at"`
+	CompletedAt         *time.Time      `gorm:"column:completed_at" json:"completed_at,omitempty"`
+}
+
+// PaymentWebhook records each PSP notification that settled an intent.
+// PSPTransactionID is the key that makes redelivered webhooks harmless.
+type PaymentWebhook struct {
+	PSPTransactionID string    `gorm:"column:psp_transaction_id;primaryKey;type:varchar(255)"`
+	IntentID         string    `gorm:"column:intent_id;type:uuid;not null"`
+	Status           string    `gorm:"column:status;type:varchar(20);not null"`
+	Payload          string    `gorm:"column:payload;type:jsonb;not null"`
+	ReceivedAt       time.Time `gorm:"column:received_at;not null;default:now()"`
+}
+
+const (
+	KindDeposit = "deposit"
+	KindPayout  = "payout"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// WebhookEvent is a verified PSP notification about a payment or payout.
+type WebhookEvent struct {
+	TransactionID     string          `json:"id"`                 // unique per notification subject, stable across redeliveries
+	Object            string          `json:"object"`             // "payment" or "payout"
+	Reference         string          `json:"reference"`          // the PSP's payment or payout ID
+	MerchantReference string          `json:"merchant_reference"` // our intent ID
+	Status            string          `json:"status"`             // "succeeded" or "failed"
+	Amount            decimal.Decimal `json:"amount"`
+	Currency          string          `json:"currency"`
+	FailureReason     string          `json:"failure_reason,omitempty"`
+}
@@ -0,0 +1,190 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// HeaderWebhookSignature carries "t=<unix seconds>,v1=<hex HMAC-SHA256>"
+	// of "<t>.<body>", signed with the webhook secret.
+	HeaderWebhookSignature = "PSP-Signature"
+
+	DefaultWebhookTolerance = 5 * time.Minute
+)
+
+var (
+	ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+	ErrWebhookExpired          = errors.New("webhook timestamp outside tolerance")
+	ErrInvalidWebhook          = errors.New("invalid webhook payload")
+	ErrProviderUnavailable     = errors.New("payment provider request failed")
+)
+
+// PaymentProvider is a payment service provider. Payments and payouts are
+// created against an intent and settled later by a webhook.
+type PaymentProvider interface {
+	Name() string
+	// CreatePayment asks the PSP to collect a deposit for the intent.
+	CreatePayment(ctx context.Context, intent *PaymentIntent) (*ProviderPayment, error)
+	// CreatePayout asks the PSP to pay a withdrawal out to the player.
+	CreatePayout(ctx context.Context, intent *PaymentIntent) (*ProviderPayment, error)
+	// VerifyWebhook authenticates a webhook and decodes its event.
+	VerifyWebhook(header http.Header, body []byte) (*WebhookEvent, error)
+}
+
+// ProviderPayment is the PSP's answer to a payment or payout request.
+type ProviderPayment struct {
+	Reference   string `json:"id"`
+	Status      string `json:"status"`
+	RedirectURL string `json:"redirect_url,omitempty"`
+}
+
+// ProviderRequest is the body sent to the PSP for a payment or payout.
+type ProviderRequest struct {
+	MerchantReference string          `json:"merchant_reference"`
+	PlayerID          string          `json:"customer_id"`
+	Amount            decimal.Decimal `json:"amount"`
+	Currency          string          `json:"currency"`
+}
+
+// HTTPProvider talks to a PSP over its REST API: POST {base}/payments and
+// POST {base}/payouts authenticated with a bearer API key. Webhooks are
+// signed with a separate shared secret.
+type HTTPProvider struct {
+	name          string
+	baseURL       string
+	apiKey        string
+	webhookSecret []byte
+	tolerance     time.Duration
+	client        *http.Client
+	now           func() time.Time
+}
+
+func NewHTTPProvider(name string, baseURL string, apiKey string, webhookSecret []byte) *HTTPProvider {
+	return &HTTPProvider{
+		name:          name,
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		tolerance:     DefaultWebhookTolerance,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		now:           time.Now,
+	}
+}
+
+func (p *HTTPProvider) Name() string {
+	return p.name
+}
+
+func (p *HTTPProvider) CreatePayment(ctx context.Context, intent *PaymentIntent) (*ProviderPayment, error) {
+	return p.create(ctx, "/payments", intent)
+}
+
+func (p *HTTPProvider) CreatePayout(ctx context.Context, intent *PaymentIntent) (*ProviderPayment, error) {
+	return p.create(ctx, "/payouts", intent)
+}
+
+func (p *HTTPProvider) create(ctx context.Context, path string, intent *PaymentIntent) (*ProviderPayment, error) {
+	body, err := json.Marshal(ProviderRequest{
+		MerchantReference: intent.IntentID,
+		PlayerID:          intent.PlayerID,
+		Amount:            intent.Amount,
+		Currency:          intent.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	// The intent ID lets the PSP answer a retried request with the original
+	req.Header.Set("Idempotency-Key", intent.IntentID)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("%w: %s: %s", ErrProviderUnavailable, res.Status, strings.TrimSpace(string(msg)))
+	}
+	var payment ProviderPayment
+	if err := json.NewDecoder(res.Body).Decode(&payment); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	return &payment, nil
+}
+
+func (p *HTTPProvider) VerifyWebhook(header http.Header, body []byte) (*WebhookEvent, error) {
+	if err := VerifyWebhookSignature(p.webhookSecret, header.Get(HeaderWebhookSignature), body, p.now(), p.tolerance); err != nil {
+		return nil, err
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	if event.TransactionID == "" || event.MerchantReference == "" {
+		return nil, fmt.Errorf("%w: id and merchant_reference are required", ErrInvalidWebhook)
+	}
+	if event.Status != StatusSucceeded && event.Status != StatusFailed {
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidWebhook, event.Status)
+	}
+	return &event, nil
+}
+
+// SignWebhook returns the HeaderWebhookSignature value for body sent at t.
+func SignWebhook(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + webhookMAC(secret, ts, body)
+}
+
+// VerifyWebhookSignature checks a HeaderWebhookSignature value against body
+// and rejects signatures older or newer than tolerance.
+func VerifyWebhookSignature(secret []byte, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts, mac string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			mac = value
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || mac == "" {
+		return ErrInvalidWebhookSignature
+	}
+	if !hmac.Equal([]byte(mac), []byte(webhookMAC(secret, ts, body))) {
+		return ErrInvalidWebhookSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrWebhookExpired
+	}
+	return nil
+}
+
+func webhookMAC(secret []byte, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,168 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrIntentNotFound   = errors.New("payment intent not found")
+	ErrWebhookNotFound  = errors.New("payment webhook not found")
+	ErrInvalidAmount    = errors.New("amount must be positive")
+	ErrWebhookMismatch  = errors.New("webhook does not match the payment intent")
+	ErrProviderMismatch = errors.New("webhook is for another payment provider")
+)
+
+// Settlement is the outcome of a webhook, applied to a pending intent.
+type Settlement struct {
+	Webhook       *PaymentWebhook
+	Status        string
+	FailureReason string
+}
+
+type PaymentRepository interface {
+	CreateIntent(ctx context.Context, intent *PaymentIntent) error
+	GetIntent(ctx context.Context, intentID string) (*PaymentIntent, error)
+	SetProviderReference(ctx context.Context, intentID string, reference string, redirectURL string) error
+	FailIntent(ctx context.Context, intentID string, reason string) error
+	GetWebhook(ctx context.Context, pspTransactionID string) (*PaymentWebhook, error)
+	// SettleIntent records the webhook and, if the intent is still pending,
+	// moves it to the settlement's status. Both happen in one transaction,
+	// with the intent row locked, so of two webhooks for one intent only the
+	// first decides its status.
+	SettleIntent(ctx context.Context, intentID string, settlement Settlement) (*PaymentIntent, error)
+	// SetWalletTransaction records the wallet transaction made for a
+	// settled intent.
+	SetWalletTransaction(ctx context.Context, intentID string, transactionID string) error
+}
+
+type PaymentRepositoryImpl struct {
+	db *gorm.DB
+}
+
+func NewPaymentRepository(db *gorm.DB) *PaymentRepositoryImpl {
+	return &PaymentRepositoryImpl{db: db}
+}
+
+func (r *PaymentRepositoryImpl) CreateIntent(ctx context.Context, intent *PaymentIntent) error {
+	if err := r.db.WithContext(ctx).Create(intent).Error; err != nil {
+		return fmt.Errorf("failed to create payment intent: %w", err)
+	}
+	return nil
+}
+
+func (r *PaymentRepositoryImpl) GetIntent(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	var intent PaymentIntent
+	err := r.db.WithContext(ctx).Where("intent_id = ?", intentID).First(&intent).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrIntentNotFound
+		}
+		return nil, fmt.Errorf("failed to get payment intent: %w", err)
+	}
+	return &intent, nil
+}
+
+func (r *PaymentRepositoryImpl) SetProviderReference(ctx context.Context, intentID string, reference string, redirectURL string) error {
+	err := r.db.WithContext(ctx).
+		Model(&PaymentIntent{}).
+		Where("intent_id = ?", intentID).
+		Updates(map[string]interface{}{
+			"provider_reference": reference,
+			"redirect_url":       redirectURL,
+			"updated_at":         gorm.Expr("NOW()"),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update payment intent: %w", err)
+	}
+	return nil
+}
+
+func (r *PaymentRepositoryImpl) FailIntent(ctx context.Context, intentID string, reason string) error {
+	err := r.db.WithContext(ctx).
+		Model(&PaymentIntent{}).
+		Where("intent_id = ? AND status = ?", intentID, StatusPending).
+		Updates(map[string]interface{}{
+			"status":         StatusFailed,
+			"failure_reason": reason,
+			"updated_at":     gorm.Expr("NOW()"),
+			"completed_at":   gorm.Expr("NOW()"),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to fail payment intent: %w", err)
+	}
+	return nil
+}
+
+func (r *PaymentRepositoryImpl) GetWebhook(ctx context.Context, pspTransactionID string) (*PaymentWebhook, error) {
+	var webhook PaymentWebhook
+	err := r.db.WithContext(ctx).Where("psp_transaction_id = ?", pspTransactionID).First(&webhook).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("failed to get payment webhook: %w", err)
+	}
+	return &webhook, nil
+}
+
+func (r *PaymentRepositoryImpl) SettleIntent(ctx context.Context, intentID string, settlement Settlement) (*PaymentIntent, error) {
+	var intent PaymentIntent
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("intent_id = ?", intentID).
+			First(&intent).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrIntentNotFound
+			}
+			return err
+		}
+
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(settlement.Webhook).Error; err != nil {
+			return err
+		}
+		if intent.Status != StatusPending {
+			return nil
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{
+			"status":             settlement.Status,
+			"psp_transaction_id": settlement.Webhook.PSPTransactionID,
+			"failure_reason":     settlement.FailureReason,
+			"updated_at":         now,
+			"completed_at":       now,
+		}
+		if err := tx.Model(&intent).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Where("intent_id = ?", intentID).First(&intent).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrIntentNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to settle payment intent: %w", err)
+	}
+	return &intent, nil
+}
+
+func (r *PaymentRepositoryImpl) SetWalletTransaction(ctx context.Context, intentID string, transactionID string) error {
+	err := r.db.WithContext(ctx).
+		Model(&PaymentIntent{}).
+		Where("intent_id = ?", intentID).
+		Updates(map[string]interface{}{
+			"wallet_transaction_id": transactionID,
+			"updated_at":            gorm.Expr("NOW()"),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record wallet transaction of payment intent: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+var ErrInvalidRequest = errors.New("invalid payment request")
+
+// Wallet is the part of wallet.Service that payments move money with.
+type Wallet interface {
+	ProcessTransaction(ctx context.Context, req wallet.TransactionRequest) (*wallet.TransactionResponse, error)
+}
+
+// IntentRequest starts a deposit or a payout.
+type IntentRequest struct {
+	PlayerID  string          `json:"player_id" binding:"required"`
+	Currency  string          `json:"currency" binding:"required"`
+	Amount    decimal.Decimal `json:"amount" binding:"required"`
+	BonusCode string          `json:"bonus_code,omitempty"` // deposits only
+	Country   string          `json:"country,omitempty"`    // deposits only
+}
+
+// Service moves money in and out of main wallets through a payment
+// provider. A deposit is credited only when a verified webhook reports it
+// succeeded. A payout is debited when it is requested, so the funds cannot
+// be spent while the PSP pays them out, and is reversed if the PSP reports
+// it failed.
+type Service struct {
+	repo     PaymentRepository
+	provider PaymentProvider
+	wallet   Wallet
+}
+
+func NewService(repo PaymentRepository, provider PaymentProvider, w Wallet) *Service {
+	return &Service{repo: repo, provider: provider, wallet: w}
+}
+
+// DepositReference is the wallet reference of the credit for a deposit.
+func DepositReference(intentID string) string {
+	return "payment:" + intentID
+}
+
+// PayoutReference is the wallet reference of a payout's withdrawal and of
+// its reversal.
+func PayoutReference(intentID string) string {
+	return "payout:" + intentID
+}
+
+func (s *Service) GetIntent(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	return s.repo.GetIntent(ctx, intentID)
+}
+
+// CreateDeposit records a pending deposit and asks the PSP to collect it.
+// The player finishes the payment at the returned intent's RedirectURL.
+func (s *Service) CreateDeposit(ctx context.Context, req IntentRequest) (*PaymentIntent, error) {
+	intent, err := s.newIntent(ctx, KindDeposit, req)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := s.provider.CreatePayment(ctx, intent)
+	if err != nil {
+		// Nothing has moved yet, so the intent can simply be closed
+		if failErr := s.repo.FailIntent(ctx, intent.IntentID, err.Error()); failErr != nil {
+			log.Printf("Failed to close deposit intent: intent=%s: %v", intent.IntentID, failErr)
+		}
+		return nil, err
+	}
+	if err := s.repo.SetProviderReference(ctx, intent.IntentID, payment.Reference, payment.RedirectURL); err != nil {
+		return nil, err
+	}
+	intent.ProviderReference = payment.Reference
+	intent.RedirectURL = payment.RedirectURL
+	return intent, nil
+}
+
+// CreatePayout withdraws the amount from the player's main wallet and asks
+// the PSP to pay it out. If the PSP cannot be reached the funds stay held
+// and the intent stays pending: the request carries the intent ID as its
+// idempotency key, and the PSP may still settle it with a webhook.
+func (s *Service) CreatePayout(ctx context.Context, req IntentRequest) (*PaymentIntent, error) {
+	req.BonusCode, req.Country = "", ""
+	intent, err := s.newIntent(ctx, KindPayout, req)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.wallet.ProcessTransaction(ctx, wallet.TransactionRequest{
+		PlayerID:        intent.PlayerID,
+		WalletType:      wallet.WalletTypeMain,
+		TransactionType: wallet.TransactionTypeWithdrawal,
+		Amount:          intent.Amount,
+		ReferenceID:     PayoutReference(intent.IntentID),
+		Currency:        intent.Currency,
+	})
+	if err != nil {
+		if failErr := s.repo.FailIntent(ctx, intent.IntentID, err.Error()); failErr != nil {
+			log.Printf("Failed to close payout intent: intent=%s: %v", intent.IntentID, failErr)
+		}
+		return nil, err
+	}
+
+	payout, err := s.provider.CreatePayout(ctx, intent)
+	if err != nil {
+		log.Printf("Payout request failed, funds held: intent=%s player=%s: %v", intent.IntentID, intent.PlayerID, err)
+		return intent, err
+	}
+	if err := s.repo.SetProviderReference(ctx, intent.IntentID, payout.Reference, payout.RedirectURL); err != nil {
+		return nil, err
+	}
+	intent.ProviderReference = payout.Reference
+	return intent, nil
+}
+
+// HandleWebhook verifies a PSP webhook and settles its intent. Webhooks are
+// idempotent by PSP transaction ID: a redelivery returns the intent as it
+// is, after finishing its wallet movement if that was interrupted. Wallet
+// movements are keyed by the intent, so they happen at most once.
+func (s *Service) HandleWebhook(ctx context.Context, header http.Header, body []byte) (*PaymentIntent, error) {
+	event, err := s.provider.VerifyWebhook(header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	seen, err := s.repo.GetWebhook(ctx, event.TransactionID)
+	if err != nil && !errors.Is(err, ErrWebhookNotFound) {
+		return nil, err
+	}
+	if seen != nil {
+		intent, err := s.repo.GetIntent(ctx, seen.IntentID)
+		if err != nil {
+			return nil, err
+		}
+		return s.settleWallet(ctx, intent)
+	}
+
+	intent, err := s.repo.GetIntent(ctx, event.MerchantReference)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEvent(intent, event, s.provider.Name()); err != nil {
+		log.Printf("Payment webhook rejected: intent=%s psp_transaction=%s: %v", intent.IntentID, event.TransactionID, err)
+		return nil, err
+	}
+
+	// Settle the intent before moving money: its status is decided under
+	// the row lock, and the wallet follows that status, not the event's
+	intent, err = s.repo.SettleIntent(ctx, intent.IntentID, Settlement{
+		Webhook: &PaymentWebhook{
+			PSPTransactionID: event.TransactionID,
+			IntentID:         intent.IntentID,
+			Status:           event.Status,
+			Payload:          string(body),
+		},
+		Status:        event.Status,
+		FailureReason: event.FailureReason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.settleWallet(ctx, intent)
+}
+
+// settleWallet credits a succeeded deposit or returns a failed payout once
+// a webhook has settled the intent. The other outcomes, and intents closed
+// without a webhook, move no money.
+func (s *Service) settleWallet(ctx context.Context, intent *PaymentIntent) (*PaymentIntent, error) {
+	if intent.PSPTransactionID == "" || intent.WalletTransactionID != nil {
+		return intent, nil
+	}
+	req := wallet.TransactionRequest{
+		PlayerID:   intent.PlayerID,
+		WalletType: wallet.WalletTypeMain,
+		Amount:     intent.Amount,
+		Currency:   intent.Currency,
+	}
+	switch {
+	case intent.Kind == KindDeposit && intent.Status == StatusSucceeded:
+		req.TransactionType = wallet.TransactionTypeDeposit
+		req.ReferenceID = DepositReference(intent.IntentID)
+		req.BonusCode = intent.BonusCode
+		req.Country = intent.Country
+	case intent.Kind == KindPayout && intent.Status == StatusFailed:
+		req.TransactionType = wallet.TransactionTypeWithdrawalReversal
+		req.ReferenceID = PayoutReference(intent.IntentID)
+	default:
+		return intent, nil
+	}
+
+	res, err := s.wallet.ProcessTransaction(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetWalletTransaction(ctx, intent.IntentID, res.TransactionID); err != nil {
+		return nil, err
+	}
+	intent.WalletTransactionID = &res.TransactionID
+	return intent, nil
+}
+
+func (s *Service) newIntent(ctx context.Context, kind string, req IntentRequest) (*PaymentIntent, error) {
+	if req.PlayerID == "" || req.Currency == "" {
+		return nil, fmt.Errorf("%w: player_id and currency are required", ErrInvalidRequest)
+	}
+	if _, err := uuid.Parse(req.PlayerID); err != nil {
+		return nil, fmt.Errorf("%w: player_id must be a UUID", ErrInvalidRequest)
+	}
+	if !req.Amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+	intent := &PaymentIntent{
+		IntentID:  uuid.NewString(),
+		Kind:      kind,
+		PlayerID:  req.PlayerID,
+		Currency:  req.Currency,
+		Amount:    req.Amount,
+		Status:    StatusPending,
+		Provider:  s.provider.Name(),
+		BonusCode: req.BonusCode,
+		Country:   req.Country,
+	}
+	if err := s.repo.CreateIntent(ctx, intent); err != nil {
+		return nil, err
+	}
+	return intent, nil
+}
+
+// checkEvent makes sure a webhook describes the intent it names.
+func checkEvent(intent *PaymentIntent, event *WebhookEvent, provider string) error {
+	if intent.Provider != provider {
+		return ErrProviderMismatch
+	}
+	object := "payment"
+	if intent.Kind == KindPayout {
+		object = "payout"
+	}
+	if event.Object != object {
+		return fmt.Errorf("%w: %s event for a %s intent", ErrWebhookMismatch, event.Object, intent.Kind)
+	}
+	if intent.ProviderReference != "" && event.Reference != intent.ProviderReference {
+		return fmt.Errorf("%w: reference %s", ErrWebhookMismatch, event.Reference)
+	}
+	if !event.Amount.Equal(intent.Amount) || event.Currency != intent.Currency {
+		return fmt.Errorf("%w: %s %s", ErrWebhookMismatch, event.Amount, event.Currency)
+	}
+	return nil
+}
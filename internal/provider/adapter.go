@@ -0,0 +1,217 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"wallet_service/internal/wallet"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrRolledBack     = errors.New("transaction was rolled back")
+)
+
+// Wallet is the part of wallet.Service the provider API calls.
+type Wallet interface {
+	ProcessTransaction(ctx context.Context, req wallet.TransactionRequest) (*wallet.TransactionResponse, error)
+	GetBalance(ctx context.Context, playerID string, walletType string, currency string) (*wallet.Wallet, error)
+	GetTransaction(ctx context.Context, referenceID string, transactionType string) (*wallet.Transaction, error)
+}
+
+// Adapter implements the seamless-wallet calls of game providers on top of
+// the main wallet. Every provider transaction becomes one wallet transaction
+// whose ReferenceID is built from the provider, round and transaction IDs,
+// so provider retries are answered from the original transaction.
+type Adapter struct {
+	wallet Wallet
+	tokens *LaunchTokens
+}
+
+func NewAdapter(w Wallet, tokens *LaunchTokens) *Adapter {
+	return &Adapter{wallet: w, tokens: tokens}
+}
+
+// supersededSuffix marks the reference of a rollback that refunds a bet
+// already covered by a zero rollback.
+const supersededSuffix = ":superseded"
+
+// ReferenceID returns the wallet reference of a provider transaction.
+func ReferenceID(providerID string, roundID string, transactionID string) string {
+	return providerID + ":" + roundID + ":" + transactionID
+}
+
+// Authenticate exchanges a launch token for the player and their balance.
+func (a *Adapter) Authenticate(ctx context.Context, req AuthenticateRequest) (*Response, error) {
+	session, err := a.tokens.Resolve(req.Token)
+	if err != nil {
+		return nil, err
+	}
+	return a.balance(ctx, session.PlayerID, session.Currency)
+}
+
+func (a *Adapter) Balance(ctx context.Context, req BalanceRequest) (*Response, error) {
+	if req.PlayerID == "" || req.Currency == "" {
+		return nil, fmt.Errorf("%w: player_id and currency are required", ErrInvalidRequest)
+	}
+	return a.balance(ctx, req.PlayerID, req.Currency)
+}
+
+// Debit takes a bet from the player's main wallet. A bet whose rollback
+// arrived first is refused, so the rollback cannot be overtaken.
+func (a *Adapter) Debit(ctx context.Context, providerID string, req DebitRequest) (*Response, error) {
+	if err := validateTransaction(req); err != nil {
+		return nil, err
+	}
+	if !req.Amount.IsPositive() {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidRequest)
+	}
+	reference := ReferenceID(providerID, req.RoundID, req.TransactionID)
+	_, err := a.wallet.GetTransaction(ctx, reference, wallet.TransactionTypeRollback)
+	switch {
+	case err == nil:
+		return nil, ErrRolledBack
+	case !errors.Is(err, wallet.ErrTransactionNotFound):
+		return nil, err
+	}
+	// Checked again by the wallet while it holds the player's wallet row,
+	// so a rollback recorded since the lookup still refuses the bet
+	res, err := a.transact(ctx, req, wallet.TransactionTypeBet, req.Amount, reference, wallet.TransactionTypeRollback)
+	if errors.Is(err, wallet.ErrReferenceClosed) {
+		return nil, ErrRolledBack
+	}
+	return res, err
+}
+
+// Credit pays a win into the player's main wallet. Providers close losing
+// rounds with a zero credit, which is recorded like any other.
+func (a *Adapter) Credit(ctx context.Context, providerID string, req CreditRequest) (*Response, error) {
+	if err := validateTransaction(req); err != nil {
+		return nil, err
+	}
+	if req.Amount.IsNegative() {
+		return nil, fmt.Errorf("%w: amount must not be negative", ErrInvalidRequest)
+	}
+	return a.transact(ctx, req, wallet.TransactionTypeWin, req.Amount, ReferenceID(providerID, req.RoundID, req.TransactionID), "")
+}
+
+// Rollback returns a bet to the player. The rollback is keyed by the bet it
+// cancels, so repeating it, under any rollback transaction ID, is harmless.
+// A rollback of a bet not seen yet is recorded as a zero rollback under the
+// bet's reference, which makes Debit refuse the bet if it arrives later.
+func (a *Adapter) Rollback(ctx context.Context, providerID string, req RollbackRequest) (*Response, error) {
+	if req.PlayerID == "" || req.Currency == "" || req.RoundID == "" || req.ReferenceTransactionID == "" {
+		return nil, fmt.Errorf("%w: player_id, currency, round_id and reference_transaction_id are required", ErrInvalidRequest)
+	}
+	reference := ReferenceID(providerID, req.RoundID, req.ReferenceTransactionID)
+	bet, err := a.wallet.GetTransaction(ctx, reference, wallet.TransactionTypeBet)
+	if errors.Is(err, wallet.ErrTransactionNotFound) {
+		// The wallet refuses the zero rollback if the bet is recorded
+		// meanwhile, in which case it is rolled back as usual
+		_, err = a.transact(ctx, DebitRequest{PlayerID: req.PlayerID, Currency: req.Currency}, wallet.TransactionTypeRollback, decimal.Zero, reference, wallet.TransactionTypeBet)
+		if err == nil {
+			return nil, wallet.ErrTransactionNotFound
+		}
+		if !errors.Is(err, wallet.ErrReferenceClosed) {
+			return nil, err
+		}
+		bet, err = a.wallet.GetTransaction(ctx, reference, wallet.TransactionTypeBet)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bet.PlayerID != req.PlayerID {
+		return nil, wallet.ErrTransactionNotFound
+	}
+
+	rollback := DebitRequest{PlayerID: req.PlayerID, Currency: req.Currency}
+	res, err := a.transact(ctx, rollback, wallet.TransactionTypeRollback, bet.Amount, bet.ReferenceID, "")
+	if !errors.Is(err, wallet.ErrReferenceConflict) {
+		return res, err
+	}
+	// A bet that got past a zero rollback, e.g. one placed in another
+	// currency, is refunded next to it under a reference of its own
+	marker, markerErr := a.wallet.GetTransaction(ctx, bet.ReferenceID, wallet.TransactionTypeRollback)
+	if markerErr != nil || !marker.Amount.IsZero() {
+		return nil, err
+	}
+	return a.transact(ctx, rollback, wallet.TransactionTypeRollback, bet.Amount, bet.ReferenceID+supersededSuffix, "")
+}
+
+// transact records one wallet transaction. exclusiveWith, if set, is the
+// transaction type that must not already be recorded under reference.
+func (a *Adapter) transact(ctx context.Context, req DebitRequest, transactionType string, amount decimal.Decimal, reference string, exclusiveWith string) (*Response, error) {
+	res, err := a.wallet.ProcessTransaction(ctx, wallet.TransactionRequest{
+		PlayerID:        req.PlayerID,
+		WalletType:      wallet.WalletTypeMain,
+		TransactionType: transactionType,
+		Amount:          amount,
+		ReferenceID:     reference,
+		Currency:        req.Currency,
+		ExclusiveWith:   exclusiveWith,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Status:        CodeOK,
+		ErrorCode:     CodeOK,
+		PlayerID:      req.PlayerID,
+		Currency:      req.Currency,
+		Balance:       &res.Balance,
+		TransactionID: res.TransactionID,
+	}, nil
+}
+
+func (a *Adapter) balance(ctx context.Context, playerID string, currency string) (*Response, error) {
+	balance := decimal.Zero
+	w, err := a.wallet.GetBalance(ctx, playerID, wallet.WalletTypeMain, currency)
+	switch {
+	case err == nil:
+		balance = w.Balance
+	case !errors.Is(err, wallet.ErrWalletNotFound):
+		return nil, err
+	}
+	return &Response{Status: CodeOK, ErrorCode: CodeOK, PlayerID: playerID, Currency: currency, Balance: &balance}, nil
+}
+
+func validateTransaction(req DebitRequest) error {
+	if req.PlayerID == "" || req.Currency == "" || req.RoundID == "" || req.TransactionID == "" {
+		return fmt.Errorf("%w: player_id, currency, round_id and transaction_id are required", ErrInvalidRequest)
+	}
+	return nil
+}
+
+// errorCode maps an error to the code and HTTP status returned to the
+// provider. Business errors are sent with 200 so providers do not retry
+// them; 5xx tells the provider to retry.
+func errorCode(err error) (string, int) {
+	switch {
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, wallet.ErrInvalidAmount):
+		return CodeInvalidRequest, http.StatusBadRequest
+	case errors.Is(err, ErrUnknownProvider):
+		return CodeUnknownProvider, http.StatusUnauthorized
+	case errors.Is(err, ErrInvalidSignature):
+		return CodeInvalidSignature, http.StatusUnauthorized
+	case errors.Is(err, ErrRequestExpired):
+		return CodeRequestExpired, http.StatusUnauthorized
+	case errors.Is(err, ErrReplayedRequest):
+		return CodeDuplicateRequest, http.StatusConflict
+	case errors.Is(err, ErrInvalidToken):
+		return CodeInvalidToken, http.StatusOK
+	case errors.Is(err, ErrTokenExpired):
+		return CodeTokenExpired, http.StatusOK
+	case errors.Is(err, wallet.ErrInsufficientFunds):
+		return CodeInsufficientFunds, http.StatusOK
+	case errors.Is(err, wallet.ErrTransactionNotFound):
+		return CodeTransactionNotFound, http.StatusOK
+	case errors.Is(err, wallet.ErrReferenceConflict):
+		return CodeTransactionConflict, http.StatusConflict
+	case errors.Is(err, ErrRolledBack):
+		return CodeRolledBack, http.StatusOK
+	}
+	return CodeInternalError, http.StatusInternalServerError
+}
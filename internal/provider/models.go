@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// Error codes returned to game providers in the error_code field.
+const (
+	CodeOK                  = "OK"
+	CodeInvalidRequest      = "INVALID_REQUEST"
+	CodeUnknownProvider     = "UNKNOWN_PROVIDER"
+	CodeInvalidSignature    = "INVALID_SIGNATURE"
+	CodeRequestExpired      = "REQUEST_EXPIRED"
+	CodeDuplicateRequest    = "DUPLICATE_REQUEST"
+	CodeInvalidToken        = "INVALID_TOKEN"
+	CodeTokenExpired        = "TOKEN_EXPIRED"
+	CodeInsufficientFunds   = "INSUFFICIENT_FUNDS"
+	CodeTransactionNotFound = "TRANSACTION_NOT_FOUND"
+	CodeTransactionConflict = "TRANSACTION_CONFLICT"
+	CodeRolledBack          = "TRANSACTION_ROLLED_BACK"
+	CodeInternalError       = "INTERNAL_ERROR"
+)
+
+type AuthenticateRequest struct {
+	Token string `json:"token"`
+}
+
+type BalanceRequest struct {
+	PlayerID string `json:"player_id"`
+	Currency string `json:"currency"`
+}
+
+// DebitRequest places a bet; CreditRequest pays a win. Provider transaction
+// IDs are unique per provider and are retried with the same ID.
+type DebitRequest struct {
+	PlayerID      string          `json:"player_id"`
+	Currency      string          `json:"currency"`
+	Amount        decimal.Decimal `json:"amount"`
+	GameID        string          `json:"game_id"`
+	RoundID       string          `json:"round_id"`
+	TransactionID string          `json:"transaction_id"`
+}
+
+type CreditRequest = DebitRequest
+
+// RollbackRequest cancels an earlier debit of the same round.
+type RollbackRequest struct {
+	PlayerID               string `json:"player_id"`
+	Currency               string `json:"currency"`
+	RoundID                string `json:"round_id"`
+	TransactionID          string `json:"transaction_id"`
+	ReferenceTransactionID string `json:"reference_transaction_id"` // the debit to cancel
+}
+
+// Response is the body of every provider API reply. Errors carry a code and
+// a message; successful calls carry the balance after the call.
+type Response struct {
+	Status        string           `json:"status"` // "OK" or "ERROR"
+	ErrorCode     string           `json:"error_code"`
+	Message       string           `json:"message,omitempty"`
+	PlayerID      string           `json:"player_id,omitempty"`
+	Currency      string           `json:"currency,omitempty"`
+	Balance       *decimal.Decimal `json:"balance,omitempty"`
+	TransactionID string           `json:"transaction_id,omitempty"` // the operator's transaction ID
+}
@@ -0,0 +1,67 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NonceStore remembers request nonces for a while.
+type NonceStore interface {
+	// Use records a nonce for ttl and reports whether it was unused.
+	Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error)
+}
+
+// MemoryNonceStore keeps nonces in process memory. It only protects a single
+// instance; use RedisNonceStore when the API runs on several.
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time // expiry
+	pruned time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+func (s *MemoryNonceStore) Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.pruned) > ttl {
+		for n, expiry := range s.nonces {
+			if now.After(expiry) {
+				delete(s.nonces, n)
+			}
+		}
+		s.pruned = now
+	}
+
+	if expiry, ok := s.nonces[nonce]; ok && now.Before(expiry) {
+		return false, nil
+	}
+	s.nonces[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// RedisNonceStore shares nonces between instances.
+type RedisNonceStore struct {
+	client redis.Cmdable
+	prefix string
+}
+
+func NewRedisNonceStore(client redis.Cmdable) *RedisNonceStore {
+	return &RedisNonceStore{client: client, prefix: "provider:nonce:"}
+}
+
+func (s *RedisNonceStore) Use(ctx context.Context, nonce string, ttl time.Duration) (bool, error) {
+	fresh, err := s.client.SetNX(ctx, s.prefix+nonce, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record nonce: %w", err)
+	}
+	return fresh, nil
+}
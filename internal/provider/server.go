@@ -0,0 +1,114 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"wallet_service/internal/audit"
+)
+
+const maxRequestBody = 1 << 20
+
+// Server serves the provider API: POST /authenticate, /balance, /debit,
+// /credit and /rollback, each signed as checked by Verifier.
+type Server struct {
+	adapter  *Adapter
+	verifier *Verifier
+}
+
+func NewServer(adapter *Adapter, verifier *Verifier) *Server {
+	return &Server{adapter: adapter, verifier: verifier}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, fmt.Errorf("%w: method not allowed", ErrInvalidRequest))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+	if err != nil {
+		writeError(w, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	ctx := r.Context()
+	providerID, err := s.verifier.Verify(ctx, r.Header, body)
+	if err != nil {
+		log.Printf("Provider request rejected: provider=%s path=%s: %v", r.Header.Get(HeaderProvider), r.URL.Path, err)
+		writeError(w, err)
+		return
+	}
+
+	ctx = audit.WithActor(ctx, "provider:"+providerID)
+	res, err := s.dispatch(ctx, providerID, path.Base(r.URL.Path), body)
+	if err != nil {
+		if code, _ := errorCode(err); code == CodeInternalError {
+			log.Printf("Provider call failed: provider=%s path=%s: %v", providerID, r.URL.Path, err)
+		}
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) dispatch(ctx context.Context, providerID string, call string, body []byte) (*Response, error) {
+	switch call {
+	case "authenticate":
+		var req AuthenticateRequest
+		if err := decode(body, &req); err != nil {
+			return nil, err
+		}
+		return s.adapter.Authenticate(ctx, req)
+	case "balance":
+		var req BalanceRequest
+		if err := decode(body, &req); err != nil {
+			return nil, err
+		}
+		return s.adapter.Balance(ctx, req)
+	case "debit":
+		var req DebitRequest
+		if err := decode(body, &req); err != nil {
+			return nil, err
+		}
+		return s.adapter.Debit(ctx, providerID, req)
+	case "credit":
+		var req CreditRequest
+		if err := decode(body, &req); err != nil {
+			return nil, err
+		}
+		return s.adapter.Credit(ctx, providerID, req)
+	case "rollback":
+		var req RollbackRequest
+		if err := decode(body, &req); err != nil {
+			return nil, err
+		}
+		return s.adapter.Rollback(ctx, providerID, req)
+	}
+	return nil, fmt.Errorf("%w: unknown call %q", ErrInvalidRequest, call)
+}
+
+func decode(body []byte, v interface{}) error {
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code, status := errorCode(err)
+	res := Response{Status: "ERROR", ErrorCode: code, Message: err.Error()}
+	if code == CodeInternalError {
+		res.Message = "internal error"
+	}
+	writeJSON(w, status, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
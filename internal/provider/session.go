@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DefaultLaunchTokenTTL is how long a game launch token can be exchanged.
+const DefaultLaunchTokenTTL = 4 * time.Hour
+
+var (
+	ErrInvalidToken = errors.New("invalid launch token")
+	ErrTokenExpired = errors.New("launch token expired")
+)
+
+// Session is what a launch token stands for.
+type Session struct {
+	PlayerID  string    `json:"player_id"`
+	Currency  string    `json:"currency"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// LaunchTokens issues the tokens passed to a game when it is launched. The
+// provider exchanges the token for the player with an authenticate call.
+// Tokens are signed rather than stored, so any instance can check them.
+type LaunchTokens struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewLaunchTokens(secret []byte, ttl time.Duration) *LaunchTokens {
+	return &LaunchTokens{secret: secret, ttl: ttl}
+}
+
+func (t *LaunchTokens) Issue(playerID string, currency string) (string, error) {
+	payload, err := json.Marshal(Session{PlayerID: playerID, Currency: currency, ExpiresAt: time.Now().Add(t.ttl)})
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + t.sign(encoded), nil
+}
+
+func (t *LaunchTokens) Resolve(token string) (*Session, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(t.sign(encoded))) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var session Session
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	return &session, nil
+}
+
+func (t *LaunchTokens) sign(encoded string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers every provider request must carry.
+const (
+	HeaderProvider  = "X-Provider-Id"
+	HeaderTimestamp = "X-Timestamp" // unix seconds
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature" // hex HMAC-SHA256, see Sign
+)
+
+// DefaultReplayWindow is how far a request timestamp may be from our clock.
+const DefaultReplayWindow = 5 * time.Minute
+
+var (
+	ErrUnknownProvider  = errors.New("unknown provider")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrRequestExpired   = errors.New("request timestamp outside the allowed window")
+	ErrReplayedRequest  = errors.New("nonce already used")
+)
+
+// Sign returns the signature of a request: the hex HMAC-SHA256 of
+// "timestamp.nonce.body" under the provider's shared secret.
+func Sign(secret []byte, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseSecrets parses provider secrets given as "provider:secret,...".
+func ParseSecrets(spec string) (map[string][]byte, error) {
+	secrets := make(map[string][]byte)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(part, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid provider secret %q: want provider:secret", part)
+		}
+		secrets[id] = []byte(secret)
+	}
+	return secrets, nil
+}
+
+// Verifier authenticates provider requests. A request is accepted once:
+// its timestamp must be within the window and its nonce unseen for as long
+// as that timestamp would be accepted.
+type Verifier struct {
+	secrets map[string][]byte
+	nonces  NonceStore
+	window  time.Duration
+	now     func() time.Time
+}
+
+func NewVerifier(secrets map[string][]byte, nonces NonceStore, window time.Duration) *Verifier {
+	return &Verifier{secrets: secrets, nonces: nonces, window: window, now: time.Now}
+}
+
+// Verify checks the signature headers of a request against its body and
+// returns the provider that sent it.
+func (v *Verifier) Verify(ctx context.Context, header http.Header, body []byte) (string, error) {
+	providerID := header.Get(HeaderProvider)
+	secret, ok := v.secrets[providerID]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+
+	timestamp := header.Get(HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrRequestExpired
+	}
+	skew := v.now().Sub(time.Unix(unix, 0))
+	if skew > v.window || skew < -v.window {
+		return "", ErrRequestExpired
+	}
+
+	nonce := header.Get(HeaderNonce)
+	signature, err := hex.DecodeString(header.Get(HeaderSignature))
+	if err != nil || nonce == "" {
+		return "", ErrInvalidSignature
+	}
+	expected, _ := hex.DecodeString(Sign(secret, timestamp, nonce, body))
+	if !hmac.Equal(signature, expected) {
+		return "", ErrInvalidSignature
+	}
+
+	// Only signed requests reach the nonce store, so nobody else can use up
+	// a provider's nonces.
+	fresh, err := v.nonces.Use(ctx, providerID+":"+nonce, 2*v.window)
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", ErrReplayedRequest
+	}
+	return providerID, nil
+}
@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"wallet_service/internal/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes a route's requests are counted in.
+const (
+	ScopeClient = "client" // the authenticated caller, or the client IP
+	ScopePlayer = "player" // the player the request is about
+)
+
+// DefaultLimits allow about 100 requests per second per player, and a
+// generous allowance per client, since a game provider speaks for all of
+// its players.
+var DefaultLimits = map[string]Limit{
+	ScopeClient: {Rate: 1000, Burst: 2000},
+	ScopePlayer: {Rate: 100, Burst: 100},
+}
+
+const maxInspectedBody = 1 << 20
+
+// ParseLimits parses comma separated "<key>=<limit>" pairs. A key is a
+// scope, setting the default of every route, or "<route>.<scope>":
+//
+//	client=1000/s:2000,player=100/s,balance.player=20/s,bets.client=off
+func ParseLimits(spec string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: want key=limit", part)
+		}
+		scope := key
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			scope = key[i+1:]
+		}
+		if scope != ScopeClient && scope != ScopePlayer {
+			return nil, fmt.Errorf("invalid rate limit key %q: scope must be %s or %s", key, ScopeClient, ScopePlayer)
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		limits[key] = limit
+	}
+	return limits, nil
+}
+
+// Policy applies client and player limits to routes.
+type Policy struct {
+	limiter Limiter
+	limits  map[string]Limit
+}
+
+// NewPolicy uses limits on top of DefaultLimits.
+func NewPolicy(limiter Limiter, limits map[string]Limit) *Policy {
+	merged := make(map[string]Limit, len(DefaultLimits)+len(limits))
+	for k, v := range DefaultLimits {
+		merged[k] = v
+	}
+	for k, v := range limits {
+		merged[k] = v
+	}
+	return &Policy{limiter: limiter, limits: merged}
+}
+
+// Limit returns the limit of a route in a scope.
+func (p *Policy) Limit(route string, scope string) Limit {
+	if limit, ok := p.limits[route+"."+scope]; ok {
+		return limit
+	}
+	return p.limits[scope]
+}
+
+// Route limits requests to a named route, first per client and then per
+// player. The player comes from the player_id path parameter, then the
+// player_id field of a JSON body, then a player's own token. Over-limit
+// requests get 429 with Retry-After. When the limiter fails the request is
+// let through: an outage of the limiter must not stop payments.
+func (p *Policy) Route(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !p.take(c, route, ScopeClient, clientKey(c)) {
+			return
+		}
+		if playerID := playerKey(c); playerID != "" && !p.take(c, route, ScopePlayer, playerID) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func (p *Policy) take(c *gin.Context, route string, scope string, id string) bool {
+	limit := p.Limit(route, scope)
+	if limit.Unlimited() {
+		return true
+	}
+	res, err := p.limiter.Allow(c.Request.Context(), route+":"+scope+":"+id, limit)
+	if err != nil {
+		log.Printf("Rate limiter unavailable, request allowed: route=%s %s=%s: %v", route, scope, id, err)
+		return true
+	}
+	if res.Allowed {
+		return true
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(res.RetryAfter.Seconds())))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded", "scope": scope, "retry_after_ms": res.RetryAfter.Milliseconds()})
+	return false
+}
+
+func clientKey(c *gin.Context) string {
+	if identity := auth.Identify(c); identity != nil {
+		return identity.Subject
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func playerKey(c *gin.Context) string {
+	if playerID := c.Param("player_id"); playerID != "" {
+		return playerID
+	}
+	if c.Request.Body != nil && strings.HasPrefix(c.ContentType(), "application/json") {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxInspectedBody))
+		c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		if err == nil {
+			var req struct {
+				PlayerID string `json:"player_id"`
+			}
+			if json.Unmarshal(body, &req) == nil && req.PlayerID != "" {
+				return req.PlayerID
+			}
+		}
+	}
+	if identity := auth.Identify(c); identity != nil && identity.HasRole(auth.RolePlayer) {
+		return identity.Subject
+	}
+	return ""
+}
@@ -21,14 +21,16 @@ type Transaction struct {
 	TransactionID   string          `gorm:"column:transaction_id;primaryKey;type:uuid;default:uuid_generate_v4()"`
 	WalletID        string          `gorm:"column:wallet_id;type:uuid;not null"`
 	PlayerID        string          `gorm:"column:player_id;type:uuid;not null"`
-	TransactionType string          `gorm:"column:transaction_type;type:varchar(20);not null"` // "deposit", "withdrawal", "bet", "win"
+	TransactionType string          `gorm:"column:transaction_type;type:varchar(20);not null"` // "deposit", "withdrawal", "bet", "win", "cashback", "bonus_credit", "bonus_forfeit", "rollback", "withdrawal_reversal", "adjustment"
 	Amount          decimal.Decimal `gorm:"column:amount;type:numeric(20,2);not null"`
 	BalanceBefore   decimal.Decimal `gorm:"column:balance_before;type:numeric(20,2);not null"`
 	BalanceAfter    decimal.Decimal `gorm:"column:balance_after;type:numeric(20,2);not null"`
-	ReferenceID     string          `gorm:"column:reference_id;type:varchar(255);not null"` // external reference (game round, payment ID)
+	ReferenceID     string          `gorm:"column:reference_id;type:varchar(255);not null"` // external reference (game round, payment ID); a rollback reuses the bet's
 	Status          string          `gorm:"column:status;type:varchar(20);not null"`        // "pending", "completed", "failed"
 	CreatedAt       time.Time       `gorm:"column:created_at;not null;default:now()"`
 	CompletedAt     *time.Time      `gorm:"column:completed_at"`
+
+	ExclusiveWith string `gorm:"-" json:"-"` // see TransactionRequest
 }
 
 type TransactionRequest struct {
@@ -38,6 +40,14 @@ type TransactionRequest struct {
 	Amount          decimal.Decimal `json:"amount"`
 	ReferenceID     string          `json:"reference_id"`
 	Currency        string          `json:"currency"`
+	BonusCode       string          `json:"bonus_code,omitempty"` // deposits only: selects a deposit bonus offer
+	Country         string          `json:"country,omitempty"`    // deposits only: checked against bonus code eligibility
+
+	// ExclusiveWith names a transaction type that may not share this
+	// request's reference. If one is recorded, the request fails with
+	// ErrReferenceClosed. The check runs while the wallet row is locked, so
+	// of two exclusive requests on one wallet only the first is recorded.
+	ExclusiveWith string `json:"-"`
 }
 
 type TransactionResponse struct {
@@ -45,3 +55,22 @@ type TransactionResponse struct {
 	Balance       decimal.Decimal `json:"balance"`
 	Status        string          `json:"status"`
 }
+
+const (
+	TransactionTypeDeposit      = "deposit"
+	TransactionTypeWithdrawal   = "withdrawal"
+	TransactionTypeBet          = "bet"
+	TransactionTypeWin          = "win"
+	TransactionTypeCashback     = "cashback"
+	TransactionTypeBonusCredit  = "bonus_credit"
+	TransactionTypeBonusForfeit = "bonus_forfeit"
+	TransactionTypeRollback     = "rollback" // returns a bet cancelled by the game provider
+
+	TransactionTypeWithdrawalReversal = "withdrawal_reversal" // returns a withdrawal the payment provider failed to pay out
+	TransactionTypeAdjustment         = "adjustment"          // approved manual correction; a negative amount debits
+)
+
+const (
+	WalletTypeMain  = "main"
+	WalletTypeBonus = "bonus"
+)
@@ -4,17 +4,29 @@ import (
 	"context"
 	"errors"
 	"time"
+	"wallet_service/internal/audit"
+	"wallet_service/internal/metrics"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
 )
 
 var (
-	ErrInsufficientFunds = errors.New("insufficient funds")
-	ErrWalletNotFound    = errors.New("wallet not found")
-	ErrOptimisticLock    = errors.New("optimistic lock error")
+	ErrInsufficientFunds   = errors.New("insufficient funds")
+	ErrWalletNotFound      = errors.New("wallet not found")
+	ErrOptimisticLock      = errors.New("optimistic lock error")
+	ErrWithdrawalBlocked   = errors.New("withdrawal blocked")
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrReferenceConflict   = errors.New("reference already used for a different transaction")
+	ErrDuplicateReference  = errors.New("transaction already recorded for reference")
+	ErrInvalidAmount       = errors.New("amount must be positive")
+	ErrReferenceClosed     = errors.New("reference is closed by another transaction")
 )
 
+// uniqueViolation is the Postgres error code of a unique constraint failure.
+const uniqueViolation = "23505"
+
 type WalletRepository interface {
 	GetBalance(ctx context.Context, playerId string, walletType string, currency string) (*Wallet, error)
 	GetTransactionByReference(ctx context.Context, referenceId string, transactionType string) (*Transaction, error)
@@ -24,13 +36,20 @@ type WalletRepository interface {
 }
 
 type WalletRepositoryImpl struct {
-	db *gorm.DB
+	db    *gorm.DB
+	audit audit.Recorder
 }
 
 func NewWalletRepositoryImpl(db *gorm.DB) WalletRepository {
 	return &WalletRepositoryImpl{db: db}
 }
 
+// NewAuditedWalletRepository records every wallet it creates and every
+// balance change in the audit log, in the same database transaction.
+func NewAuditedWalletRepository(db *gorm.DB, log audit.Recorder) WalletRepository {
+	return &WalletRepositoryImpl{db: db, audit: log}
+}
+
 func (r *WalletRepositoryImpl) GetBalance(ctx context.Context, playerId string, walletType string, currency string) (*Wallet, error) {
 
 	var w Wallet
@@ -64,7 +83,17 @@ func (r *WalletRepositoryImpl) CreateWallet(ctx context.Context, playerId string
 		Currency:   currency,
 	}
 
-	err := r.db.WithContext(ctx).Create(&w).Error
+	err := r.db.WithContext(ctx).Transaction(func(dbtx *gorm.DB) error {
+		if err := dbtx.Create(&w).Error; err != nil {
+			return err
+		}
+		return r.record(ctx, dbtx, audit.Event{
+			Action:     "wallet.create",
+			EntityType: "wallet",
+			EntityID:   w.WalletID,
+			After:      map[string]interface{}{"player_id": w.PlayerID, "wallet_type": w.WalletType, "currency": w.Currency},
+		})
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -73,6 +102,7 @@ func (r *WalletRepositoryImpl) CreateWallet(ctx context.Context, playerId string
 }
 
 func (r *WalletRepositoryImpl) Debit(ctx context.Context, tx *Transaction) error {
+	defer metrics.ObserveDBTransaction("wallet_debit", time.Now())
 	return r.db.WithContext(ctx).Transaction(func(dbtx *gorm.DB) error {
 		var w Wallet
 		if err := dbtx.Where("wallet_id = ?", tx.WalletID).First(&w).Error; err != nil {
@@ -97,6 +127,9 @@ func (r *WalletRepositoryImpl) Debit(ctx context.Context, tx *Transaction) error
 		if result.RowsAffected == 0 {
 			return ErrOptimisticLock
 		}
+		if err := checkExclusive(dbtx, tx); err != nil {
+			return err
+		}
 
 		tx.TransactionID = uuid.New().String()
 		tx.BalanceBefore = w.Balance
@@ -106,20 +139,31 @@ func (r *WalletRepositoryImpl) Debit(ctx context.Context, tx *Transaction) error
 		tx.CompletedAt = &now
 
 		if err := dbtx.Create(tx).Error; err != nil {
+			// Another request recorded the reference since the caller
+			// checked; rolling back undoes this balance change
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+				return ErrDuplicateReference
+			}
 			return err
 		}
 
-		return nil
+		return r.record(ctx, dbtx, balanceEvent(&w, tx))
 	})
 }
 
 func (r *WalletRepositoryImpl) Credit(ctx context.Context, tx *Transaction) error {
+	defer metrics.ObserveDBTransaction("wallet_credit", time.Now())
 	return r.db.WithContext(ctx).Transaction(func(dbtx *gorm.DB) error {
 		var w Wallet
 		if err := dbtx.Where("wallet_id = ?", tx.WalletID).First(&w).Error; err != nil {
 			return err
 		}
 		newBalance := w.Balance.Add(tx.Amount)
+		if newBalance.IsNegative() {
+			// Only an adjustment credits a negative amount
+			return ErrInsufficientFunds
+		}
 
 		result := dbtx.Model(&Wallet{}).Where("wallet_id = ? AND version = ?", w.WalletID, w.Version).
 			Updates(map[string]interface{}{
@@ -133,6 +177,9 @@ func (r *WalletRepositoryImpl) Credit(ctx context.Context, tx *Transaction) erro
 		if result.RowsAffected == 0 {
 			return ErrOptimisticLock
 		}
+		if err := checkExclusive(dbtx, tx); err != nil {
+			return err
+		}
 
 		tx.TransactionID = uuid.New().String()
 		tx.BalanceBefore = w.Balance
@@ -142,10 +189,60 @@ func (r *WalletRepositoryImpl) Credit(ctx context.Context, tx *Transaction) erro
 		tx.CompletedAt = &now
 
 		if err := dbtx.Create(tx).Error; err != nil {
+			// Another request recorded the reference since the caller
+			// checked; rolling back undoes this balance change
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+				return ErrDuplicateReference
+			}
 			return err
 		}
 
-		return nil
+		return r.record(ctx, dbtx, balanceEvent(&w, tx))
 	})
 
 }
+
+// checkExclusive refuses tx if a transaction of the type it is exclusive
+// with shares its reference. Callers hold the wallet row, which a competing
+// request on the same wallet must also update.
+func checkExclusive(dbtx *gorm.DB, tx *Transaction) error {
+	if tx.ExclusiveWith == "" {
+		return nil
+	}
+	var count int64
+	err := dbtx.Model(&Transaction{}).
+		Where("reference_id = ? AND transaction_type = ?", tx.ReferenceID, tx.ExclusiveWith).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrReferenceClosed
+	}
+	return nil
+}
+
+// balanceEvent describes the balance change of w recorded as tx.
+func balanceEvent(w *Wallet, tx *Transaction) audit.Event {
+	return audit.Event{
+		Action:     "wallet." + tx.TransactionType,
+		EntityType: "wallet",
+		EntityID:   w.WalletID,
+		Before:     map[string]interface{}{"balance": tx.BalanceBefore, "version": w.Version},
+		After: map[string]interface{}{
+			"balance":        tx.BalanceAfter,
+			"version":        w.Version + 1,
+			"transaction_id": tx.TransactionID,
+			"amount":         tx.Amount,
+			"reference_id":   tx.ReferenceID,
+		},
+	}
+}
+
+func (r *WalletRepositoryImpl) record(ctx context.Context, dbtx *gorm.DB, event audit.Event) error {
+	if r.audit == nil {
+		return nil
+	}
+	return r.audit.Record(ctx, dbtx, event)
+}
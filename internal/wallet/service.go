@@ -3,7 +3,11 @@ package wallet
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
+	"strings"
 	"time"
+	"wallet_service/internal/metrics"
 )
 
 const (
@@ -16,37 +20,88 @@ type WalletService interface {
 	GetBalance(ctx context.Context, playerId string, game string, currency string) (*Wallet, error)
 }
 
+// DepositHook runs after a deposit to a main wallet has been credited, for
+// example to award a deposit bonus. It is also called when a deposit is
+// replayed, so it must be idempotent on the request's ReferenceID.
+type DepositHook interface {
+	OnDeposit(ctx context.Context, req TransactionRequest, res *TransactionResponse) error
+}
+
+// WithdrawalGuard is consulted before a withdrawal from a main wallet. An
+// error wrapping ErrWithdrawalBlocked from BeforeWithdrawal rejects the
+// withdrawal. AfterWithdrawal runs once the withdrawal has been debited,
+// with the time it was recorded, and again when it is replayed, so it must
+// be idempotent on the request's ReferenceID.
+type WithdrawalGuard interface {
+	BeforeWithdrawal(ctx context.Context, req TransactionRequest) error
+	AfterWithdrawal(ctx context.Context, req TransactionRequest, withdrawnAt time.Time) error
+}
+
 type Service struct {
-	repo WalletRepository
+	repo            WalletRepository
+	depositHook     DepositHook
+	withdrawalGuard WithdrawalGuard
 }
 
 func NewService(repo WalletRepository) *Service {
 	return &Service{repo: repo}
 }
 
+func (s *Service) SetDepositHook(h DepositHook) {
+	s.depositHook = h
+}
+
+func (s *Service) SetWithdrawalGuard(g WithdrawalGuard) {
+	s.withdrawalGuard = g
+}
+
 func (s *Service) GetBalance(ctx context.Context, playerId string, game string, currency string) (*Wallet, error) {
 	return s.repo.GetBalance(ctx, playerId, game, currency)
 
 }
 
+// GetTransaction returns the transaction of a type recorded for a reference.
+func (s *Service) GetTransaction(ctx context.Context, referenceID string, transactionType string) (*Transaction, error) {
+	tx, err := s.repo.GetTransactionByReference(ctx, referenceID, transactionType)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, ErrTransactionNotFound
+	}
+	return tx, nil
+}
+
 func (s *Service) ProcessTransaction(ctx context.Context, req TransactionRequest) (*TransactionResponse, error) {
+	res, err := s.processTransaction(ctx, req)
+	if err != nil {
+		outcome := failureOutcome(err)
+		metrics.Transactions.WithLabelValues(metricType(req.TransactionType), outcome).Inc()
+		if outcome == metrics.OutcomeInsufficientFunds {
+			metrics.InsufficientFunds.WithLabelValues(metricType(req.TransactionType)).Inc()
+		}
+	}
+	return res, err
+}
+
+func (s *Service) processTransaction(ctx context.Context, req TransactionRequest) (*TransactionResponse, error) {
+	if err := checkAmount(req); err != nil {
+		return nil, err
+	}
+
 	//idempotency check
 	existingTx, err := s.repo.GetTransactionByReference(ctx, req.ReferenceID, req.TransactionType)
 	if err != nil {
 		return nil, err
 	}
 	if existingTx != nil {
-		return &TransactionResponse{
-			TransactionID: existingTx.TransactionID,
-			Balance:       existingTx.BalanceAfter,
-			Status:        existingTx.Status,
-		}, nil
+		return s.replay(ctx, existingTx, req)
 	}
 
 	wallet, err := s.repo.GetBalance(ctx, req.PlayerID, req.WalletType, req.Currency)
 	if err != nil {
 		if err == ErrWalletNotFound {
-			if req.TransactionType == "withdrawal" || req.TransactionType == "bet" {
+			if isDebit(req.TransactionType) || req.Amount.IsNegative() {
 				return nil, ErrInsufficientFunds
 			}
 			wallet, err = s.repo.CreateWallet(ctx, req.PlayerID, req.WalletType, req.Currency)
@@ -58,35 +113,198 @@ func (s *Service) ProcessTransaction(ctx context.Context, req TransactionRequest
 		}
 	}
 
+	if s.withdrawalGuard != nil && req.TransactionType == TransactionTypeWithdrawal && req.WalletType == WalletTypeMain {
+		// Checked up front so that a withdrawal that would fail anyway
+		// is not reported as blocked
+		if wallet.Balance.LessThan(req.Amount) {
+			return nil, ErrInsufficientFunds
+		}
+		if err := s.withdrawalGuard.BeforeWithdrawal(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
 	tx := &Transaction{
 		WalletID:        wallet.WalletID,
 		PlayerID:        req.PlayerID,
 		TransactionType: req.TransactionType,
 		Amount:          req.Amount,
 		ReferenceID:     req.ReferenceID,
+		ExclusiveWith:   req.ExclusiveWith,
 	}
 
 	for i := 0; i < MaxRetries; i++ {
-		if req.TransactionType == "deposit" || req.TransactionType == "win" {
+		if isCredit(req.TransactionType) {
 			err = s.repo.Credit(ctx, tx)
-		} else if req.TransactionType == "withdrawal" || req.TransactionType == "bet" {
+		} else if isDebit(req.TransactionType) {
 			err = s.repo.Debit(ctx, tx)
 		} else {
 			return nil, errors.New("Invalid Transaction Type")
 		}
 		if err == nil {
-			return &TransactionResponse{
+			res := &TransactionResponse{
 				TransactionID: tx.TransactionID,
 				Balance:       tx.BalanceAfter,
 				Status:        tx.Status,
-			}, nil
+			}
+			metrics.Transactions.WithLabelValues(req.TransactionType, metrics.OutcomeCompleted).Inc()
+			s.afterDeposit(ctx, req, res)
+			s.afterWithdrawal(ctx, req, tx)
+			return res, nil
 		}
 		if err == ErrOptimisticLock {
-			time.Sleep(RetryDelay)
+			if i+1 < MaxRetries {
+				metrics.OptimisticLockRetries.Inc()
+				time.Sleep(RetryDelay)
+			}
 			continue
 		}
+		if err == ErrDuplicateReference || err == ErrInsufficientFunds {
+			// A concurrent request with the same reference got there
+			// first; it may also have spent the funds this one saw
+			existingTx, findErr := s.repo.GetTransactionByReference(ctx, req.ReferenceID, req.TransactionType)
+			if findErr != nil {
+				return nil, findErr
+			}
+			if existingTx != nil {
+				return s.replay(ctx, existingTx, req)
+			}
+		}
 		return nil, err
 
 	}
+	metrics.OptimisticLockExhausted.Inc()
 	return nil, err
 }
+
+// replay answers a request whose reference is already recorded with the
+// recorded transaction.
+func (s *Service) replay(ctx context.Context, existingTx *Transaction, req TransactionRequest) (*TransactionResponse, error) {
+	if err := s.checkReplay(ctx, existingTx, req); err != nil {
+		if errors.Is(err, ErrReferenceConflict) {
+			log.Printf("Replay does not match the recorded transaction: reference=%s type=%s player=%s: %v",
+				req.ReferenceID, req.TransactionType, req.PlayerID, err)
+		}
+		return nil, err
+	}
+	res := &TransactionResponse{
+		TransactionID: existingTx.TransactionID,
+		Balance:       existingTx.BalanceAfter,
+		Status:        existingTx.Status,
+	}
+	metrics.Transactions.WithLabelValues(req.TransactionType, metrics.OutcomeReplayed).Inc()
+	s.afterDeposit(ctx, req, res)
+	s.afterWithdrawal(ctx, req, existingTx)
+	return res, nil
+}
+
+// checkReplay makes sure a request that reuses a reference describes the
+// transaction recorded for it. A mismatch is a client bug, not a retry, and
+// must not be answered with the other transaction's success.
+func (s *Service) checkReplay(ctx context.Context, existing *Transaction, req TransactionRequest) error {
+	var mismatched []string
+	if existing.PlayerID != req.PlayerID {
+		mismatched = append(mismatched, "player_id")
+	}
+	if !existing.Amount.Equal(req.Amount) {
+		mismatched = append(mismatched, "amount")
+	}
+	if len(mismatched) == 0 {
+		// Wallet type and currency are only recorded through the wallet
+		w, err := s.repo.GetBalance(ctx, req.PlayerID, req.WalletType, req.Currency)
+		switch {
+		case err == nil && w.WalletID == existing.WalletID:
+		case err == nil, errors.Is(err, ErrWalletNotFound):
+			mismatched = append(mismatched, "wallet_type or currency")
+		default:
+			return err
+		}
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("%w: %s differ from transaction %s", ErrReferenceConflict, strings.Join(mismatched, ", "), existing.TransactionID)
+	}
+	return nil
+}
+
+// afterDeposit runs the deposit hook. The money is already in the wallet, so
+// a failing hook is logged rather than failing the deposit; replaying the
+// deposit retries it.
+func (s *Service) afterDeposit(ctx context.Context, req TransactionRequest, res *TransactionResponse) {
+	if s.depositHook == nil || req.TransactionType != TransactionTypeDeposit || req.WalletType != WalletTypeMain {
+		return
+	}
+	if err := s.depositHook.OnDeposit(ctx, req, res); err != nil {
+		log.Printf("Deposit hook failed: player=%s reference=%s: %v", req.PlayerID, req.ReferenceID, err)
+	}
+}
+
+// afterWithdrawal runs the withdrawal guard's follow-up once the money has
+// left the wallet. Like the deposit hook, a failure is logged and retried by
+// replaying the withdrawal.
+func (s *Service) afterWithdrawal(ctx context.Context, req TransactionRequest, tx *Transaction) {
+	if s.withdrawalGuard == nil || req.TransactionType != TransactionTypeWithdrawal || req.WalletType != WalletTypeMain {
+		return
+	}
+	withdrawnAt := tx.CreatedAt
+	if withdrawnAt.IsZero() {
+		withdrawnAt = time.Now()
+	}
+	if err := s.withdrawalGuard.AfterWithdrawal(ctx, req, withdrawnAt); err != nil {
+		log.Printf("Withdrawal guard failed: player=%s reference=%s: %v", req.PlayerID, req.ReferenceID, err)
+	}
+}
+
+// checkAmount refuses amounts whose sign would turn a debit into a credit or
+// the other way round. Adjustments are the only signed type. Providers close
+// losing rounds with a zero win and mark rollbacks of unseen bets with a
+// zero rollback; every other amount must be positive.
+func checkAmount(req TransactionRequest) error {
+	switch {
+	case req.TransactionType == TransactionTypeAdjustment:
+		return nil
+	case req.Amount.IsZero() && (req.TransactionType == TransactionTypeWin || req.TransactionType == TransactionTypeRollback):
+		return nil
+	case !req.Amount.IsPositive():
+		return fmt.Errorf("%w: %s of %s", ErrInvalidAmount, req.TransactionType, req.Amount.String())
+	}
+	return nil
+}
+
+// failureOutcome classifies a failed transaction for metrics.
+func failureOutcome(err error) string {
+	switch {
+	case errors.Is(err, ErrInsufficientFunds):
+		return metrics.OutcomeInsufficientFunds
+	case errors.Is(err, ErrReferenceConflict), errors.Is(err, ErrReferenceClosed):
+		return metrics.OutcomeConflict
+	case errors.Is(err, ErrWithdrawalBlocked):
+		return metrics.OutcomeBlocked
+	}
+	return metrics.OutcomeFailed
+}
+
+// metricType is the type label of a transaction: its type if the wallet
+// knows it, metrics.TypeInvalid otherwise.
+func metricType(transactionType string) string {
+	if isCredit(transactionType) || isDebit(transactionType) {
+		return transactionType
+	}
+	return metrics.TypeInvalid
+}
+
+func isCredit(transactionType string) bool {
+	switch transactionType {
+	case TransactionTypeDeposit, TransactionTypeWin, TransactionTypeCashback, TransactionTypeBonusCredit, TransactionTypeRollback,
+		TransactionTypeWithdrawalReversal, TransactionTypeAdjustment:
+		return true
+	}
+	return false
+}
+
+func isDebit(transactionType string) bool {
+	switch transactionType {
+	case TransactionTypeWithdrawal, TransactionTypeBet, TransactionTypeBonusForfeit:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"wallet_service/internal/adjustment"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryAdjustmentRepo keeps adjustments in memory with the same state
+// transitions as the Postgres repository
+type memoryAdjustmentRepo struct {
+	mu          sync.Mutex
+	adjustments map[string]*adjustment.Adjustment
+}
+
+func newMemoryAdjustmentRepo() *memoryAdjustmentRepo {
+	return &memoryAdjustmentRepo{adjustments: make(map[string]*adjustment.Adjustment)}
+}
+
+func (r *memoryAdjustmentRepo) CreateAdjustment(ctx context.Context, adj *adjustment.Adjustment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *adj
+	copied.CreatedAt = time.Now()
+	r.adjustments[adj.AdjustmentID] = &copied
+	return nil
+}
+
+func (r *memoryAdjustmentRepo) GetAdjustment(ctx context.Context, adjustmentID string) (*adjustment.Adjustment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	adj, ok := r.adjustments[adjustmentID]
+	if !ok {
+		return nil, adjustment.ErrAdjustmentNotFound
+	}
+	copied := *adj
+	return &copied, nil
+}
+
+func (r *memoryAdjustmentRepo) ListAdjustments(ctx context.Context, status string, limit int, offset int) ([]adjustment.Adjustment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var adjs []adjustment.Adjustment
+	for _, adj := range r.adjustments {
+		if status == "" || adj.Status == status {
+			adjs = append(adjs, *adj)
+		}
+	}
+	return adjs, nil
+}
+
+func (r *memoryAdjustmentRepo) ClaimApproval(ctx context.Context, adjustmentID string, approver string, note string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	adj := r.adjustments[adjustmentID]
+	unposted := adj.Status == adjustment.StatusApproved && adj.TransactionID == nil && adj.ReviewedBy == approver
+	if adj.Status != adjustment.StatusPending && !unposted {
+		return adjustment.ErrAdjustmentNotPending
+	}
+	now := time.Now()
+	adj.Status, adj.ReviewedBy, adj.ReviewNote, adj.ReviewedAt = adjustment.StatusApproved, approver, note, &now
+	return nil
+}
+
+func (r *memoryAdjustmentRepo) ReleaseApproval(ctx context.Context, adjustmentID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if adj := r.adjustments[adjustmentID]; adj.Status == adjustment.StatusApproved && adj.TransactionID == nil {
+		adj.Status, adj.ReviewedBy, adj.ReviewNote, adj.ReviewedAt = adjustment.StatusPending, "", "", nil
+	}
+	return nil
+}
+
+func (r *memoryAdjustmentRepo) SetTransaction(ctx context.Context, adjustmentID string, transactionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.adjustments[adjustmentID].TransactionID = &transactionID
+	return nil
+}
+
+func (r *memoryAdjustmentRepo) Reject(ctx context.Context, adjustmentID string, reviewer string, note string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	adj, ok := r.adjustments[adjustmentID]
+	if !ok || adj.Status != adjustment.StatusPending {
+		return adjustment.ErrAdjustmentNotPending
+	}
+	now := time.Now()
+	adj.Status, adj.ReviewedBy, adj.ReviewNote, adj.ReviewedAt = adjustment.StatusRejected, reviewer, note, &now
+	return nil
+}
+
+// TestAdjustmentApproval checks that an adjustment only posts once a second
+// operator approves it, posts once, and that a debit the balance cannot
+// cover stays pending until it is rejected
+func TestAdjustmentApproval(t *testing.T) {
+	ctx := context.Background()
+	wal := newMemoryWallet()
+	playerID := uuid.NewString()
+	wal.balances[playerID] = decimal.NewFromInt(50)
+	service := adjustment.NewService(newMemoryAdjustmentRepo(), wal)
+
+	_, err := service.RequestAdjustment(ctx, "alice", adjustment.Request{
+		PlayerID: playerID, Currency: "USD", Amount: decimal.NewFromInt(10), ReasonCode: "bribe", Justification: "because",
+	})
+	require.ErrorIs(t, err, adjustment.ErrInvalidAdjustment)
+	_, err = service.RequestAdjustment(ctx, "alice", adjustment.Request{
+		PlayerID: playerID, Currency: "USD", Amount: decimal.NewFromInt(10), ReasonCode: adjustment.ReasonGoodwill,
+	})
+	require.ErrorIs(t, err, adjustment.ErrInvalidAdjustment)
+
+	credit, err := service.RequestAdjustment(ctx, "alice", adjustment.Request{
+		PlayerID: playerID, Currency: "USD", Amount: decimal.NewFromInt(25), ReasonCode: adjustment.ReasonGoodwill, Justification: "outage on 3 Oct",
+	})
+	require.NoError(t, err)
+	require.Equal(t, adjustment.StatusPending, credit.Status)
+	require.Equal(t, wallet.WalletTypeMain, credit.WalletType)
+	require.Equal(t, "50", wal.balances[playerID].String())
+
+	// The requester cannot approve their own adjustment
+	_, err = service.Approve(ctx, credit.AdjustmentID, "alice", "")
+	require.ErrorIs(t, err, adjustment.ErrSelfApproval)
+	require.Equal(t, "50", wal.balances[playerID].String())
+
+	approved, err := service.Approve(ctx, credit.AdjustmentID, "bob", "checked the incident")
+	require.NoError(t, err)
+	require.Equal(t, adjustment.StatusApproved, approved.Status)
+	require.Equal(t, "alice", approved.RequestedBy)
+	require.Equal(t, "bob", approved.ReviewedBy)
+	require.NotNil(t, approved.TransactionID)
+	require.Equal(t, "75", wal.balances[playerID].String())
+
+	posted, err := wal.GetTransaction(ctx, adjustment.ReferenceID(credit.AdjustmentID), wallet.TransactionTypeAdjustment)
+	require.NoError(t, err)
+	require.Equal(t, *approved.TransactionID, posted.TransactionID)
+
+	// Approving again does not post twice
+	_, err = service.Approve(ctx, credit.AdjustmentID, "bob", "")
+	require.ErrorIs(t, err, adjustment.ErrAdjustmentNotPending)
+	_, err = service.Approve(ctx, credit.AdjustmentID, "carol", "")
+	require.ErrorIs(t, err, adjustment.ErrAdjustmentNotPending)
+	require.Equal(t, "75", wal.balances[playerID].String())
+
+	// A chargeback larger than the balance goes back to pending
+	debit, err := service.RequestAdjustment(ctx, "alice", adjustment.Request{
+		PlayerID: playerID, Currency: "USD", Amount: decimal.NewFromInt(-100), ReasonCode: adjustment.ReasonChargeback, Justification: "card chargeback",
+	})
+	require.NoError(t, err)
+	_, err = service.Approve(ctx, debit.AdjustmentID, "bob", "")
+	require.ErrorIs(t, err, wallet.ErrInsufficientFunds)
+	pending, err := service.GetAdjustment(ctx, debit.AdjustmentID)
+	require.NoError(t, err)
+	require.Equal(t, adjustment.StatusPending, pending.Status)
+	require.Empty(t, pending.ReviewedBy)
+	require.Equal(t, "75", wal.balances[playerID].String())
+
+	rejected, err := service.Reject(ctx, debit.AdjustmentID, "bob", "balance already spent")
+	require.NoError(t, err)
+	require.Equal(t, adjustment.StatusRejected, rejected.Status)
+	_, err = service.Approve(ctx, debit.AdjustmentID, "carol", "")
+	require.ErrorIs(t, err, adjustment.ErrAdjustmentNotPending)
+
+	_, err = service.Reject(ctx, uuid.NewString(), "bob", "")
+	require.ErrorIs(t, err, adjustment.ErrAdjustmentNotFound)
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet_service/internal/audit"
+	"wallet_service/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAuditChain checks that admin requests are recorded with their actor,
+// IP and request ID, and that verifying the sealed chain catches edited,
+// removed, reordered and rewritten entries
+func TestAuditChain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	log := audit.NewMemoryLog()
+
+	r := gin.New()
+	r.Use(audit.RequestContext())
+	r.Use(func(c *gin.Context) {
+		identity := &auth.Identity{Subject: "operator-1", Roles: []string{auth.RoleOperator}}
+		c.Request = c.Request.WithContext(auth.WithIdentity(c.Request.Context(), identity))
+	})
+	admin := r.Group("/admin", audit.AdminActions(log))
+	admin.POST("/bonus-codes", func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"code": "WELCOME"})
+	})
+	admin.GET("/bonus-codes/:code", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"code": c.Param("code")})
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/bonus-codes", strings.NewReader(`{"code":"WELCOME","max_redemptions":100}`))
+	req.Header.Set(audit.HeaderRequestID, "req-1")
+	req.RemoteAddr = "203.0.113.7:4711"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "req-1", w.Header().Get(audit.HeaderRequestID))
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/bonus-codes/WELCOME", nil))
+	require.NotEmpty(t, w.Header().Get(audit.HeaderRequestID), "a request ID is generated when missing")
+
+	entries := log.Entries()
+	require.Len(t, entries, 1, "reads are not recorded")
+	require.Equal(t, "operator-1", entries[0].Actor)
+	require.Equal(t, "203.0.113.7", entries[0].SourceIP)
+	require.Equal(t, "req-1", entries[0].RequestID)
+	require.Equal(t, "admin.POST /admin/bonus-codes", entries[0].Action)
+	require.JSONEq(t, `{"query":"","body":{"code":"WELCOME","max_redemptions":100}}`, entries[0].Before)
+	require.JSONEq(t, `{"status":201}`, entries[0].After)
+
+	ctx := audit.WithActor(context.Background(), "provider:agg")
+	for _, balance := range []string{"10", "15", "5"} {
+		require.NoError(t, log.Record(ctx, nil, audit.Event{
+			Action: "wallet.bet", EntityType: "wallet", EntityID: "w1",
+			After: map[string]string{"balance": balance},
+		}))
+	}
+	require.NoError(t, log.Record(context.Background(), nil, audit.Event{Action: "bonus.status", EntityType: "player_bonus", EntityID: "b1"}))
+	log.Seal()
+	entries = log.Entries()
+	require.Len(t, entries, 5)
+	require.Equal(t, "provider:agg", entries[1].Actor)
+	require.Equal(t, audit.SystemActor, entries[4].Actor)
+	require.NoError(t, audit.VerifyChain(entries))
+
+	// Sealing again leaves sealed entries alone
+	log.Seal()
+	require.Equal(t, entries, log.Entries())
+
+	edited := log.Entries()
+	edited[2].After = `{"balance":"1500"}`
+	require.ErrorIs(t, audit.VerifyChain(edited), audit.ErrChainBroken)
+
+	removed := append(log.Entries()[:2], log.Entries()[3:]...)
+	require.ErrorIs(t, audit.VerifyChain(removed), audit.ErrChainBroken)
+
+	reordered := log.Entries()
+	reordered[1], reordered[2] = reordered[2], reordered[1]
+	require.ErrorIs(t, audit.VerifyChain(reordered), audit.ErrChainBroken)
+
+	// Rehashing the chain after an edit passes on its own, but not against
+	// a head kept from before
+	seq, head := *entries[4].Seq, *entries[4].Hash
+	rewritten := audit.NewMemoryLog()
+	for _, e := range edited {
+		require.NoError(t, rewritten.Record(context.Background(), nil, audit.Event{Action: e.Action, EntityType: e.EntityType, EntityID: e.EntityID}))
+	}
+	rewritten.Seal()
+	require.NoError(t, audit.VerifyChain(rewritten.Entries()))
+	chain := audit.NewChain()
+	chain.Expect(seq, head)
+	var err error
+	for _, e := range rewritten.Entries() {
+		if err = chain.Add(e); err != nil {
+			break
+		}
+	}
+	require.ErrorIs(t, err, audit.ErrChainBroken)
+
+	// A chain cut short never reaches the expected head
+	chain = audit.NewChain()
+	chain.Expect(seq, head)
+	for _, e := range entries[:3] {
+		require.NoError(t, chain.Add(e))
+	}
+	require.ErrorIs(t, chain.Finish(), audit.ErrChainBroken)
+}
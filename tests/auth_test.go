@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"wallet_service/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+// issueToken signs a JWT for sub with roles, valid for ttl
+func issueToken(t *testing.T, key *ecdsa.PrivateKey, kid string, sub string, ttl time.Duration, roles ...string) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid))
+	require.NoError(t, err)
+	now := time.Now()
+	token, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Subject:  sub,
+		Issuer:   "pam",
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(ttl)),
+	}).Claims(map[string]interface{}{"roles": roles}).Serialize()
+	require.NoError(t, err)
+	return token
+}
+
+// TestAuthRoles checks token verification against a key file and a JWKS
+// endpoint, and that each role reaches only its routes
+func TestAuthRoles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key, KeyID: "k1", Algorithm: string(jose.ES256), Use: "sig"}}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwks, 0o600))
+	fileKeys, err := auth.LoadKeyFile(path)
+	require.NoError(t, err)
+
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(jwks)
+	}))
+	defer jwksServer.Close()
+
+	for name, keys := range map[string]auth.KeySet{"file": fileKeys, "jwks": auth.NewRemoteKeySet(jwksServer.URL, time.Minute)} {
+		verifier := auth.NewVerifier(keys, auth.VerifierConfig{Issuer: "pam"})
+		identity, err := verifier.Verify(context.Background(), issueToken(t, key, "k1", "p1", time.Minute, auth.RolePlayer))
+		require.NoError(t, err, name)
+		require.Equal(t, "p1", identity.Subject)
+
+		_, err = verifier.Verify(context.Background(), issueToken(t, other, "k1", "p1", time.Minute, auth.RolePlayer))
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "%s: signed with an unknown key", name)
+		_, err = verifier.Verify(context.Background(), issueToken(t, key, "k1", "p1", -time.Hour, auth.RolePlayer))
+		require.ErrorIs(t, err, auth.ErrInvalidToken, "%s: expired", name)
+	}
+
+	authz := auth.NewAuthorizer(auth.NewVerifier(fileKeys, auth.VerifierConfig{Issuer: "pam"}))
+	r := gin.New()
+	api := r.Group("", authz.Authenticate())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	api.GET("/balance/:player_id", authz.RequirePlayer("player_id", auth.RoleProvider, auth.RoleOperator, auth.RoleAuditor), ok)
+	api.POST("/transaction", authz.Require(auth.RoleProvider, auth.RoleOperator), ok)
+	admin := api.Group("/admin", authz.Require(auth.RoleOperator, auth.RoleAuditor), authz.RequireWrite(auth.RoleOperator))
+	admin.GET("/bonus-codes/:code", ok)
+	admin.POST("/bonus-codes", ok)
+
+	do := func(method string, target string, token string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	player := issueToken(t, key, "k1", "p1", time.Minute, auth.RolePlayer)
+	provider := issueToken(t, key, "k1", "agg", time.Minute, auth.RoleProvider)
+	operator := issueToken(t, key, "k1", "ops", time.Minute, auth.RoleOperator)
+	auditor := issueToken(t, key, "k1", "audit", time.Minute, auth.RoleAuditor)
+
+	cases := []struct {
+		method, target, token string
+		want                  int
+	}{
+		{http.MethodGet, "/balance/p1", "", http.StatusUnauthorized},
+		{http.MethodGet, "/balance/p1", "not-a-jwt", http.StatusUnauthorized},
+		{http.MethodGet, "/balance/p1", player, http.StatusOK},
+		{http.MethodGet, "/balance/p2", player, http.StatusForbidden},
+		{http.MethodGet, "/balance/p2", auditor, http.StatusOK},
+		{http.MethodPost, "/transaction", player, http.StatusForbidden},
+		{http.MethodPost, "/transaction", auditor, http.StatusForbidden},
+		{http.MethodPost, "/transaction", provider, http.StatusOK},
+		{http.MethodGet, "/admin/bonus-codes/X", provider, http.StatusForbidden},
+		{http.MethodGet, "/admin/bonus-codes/X", auditor, http.StatusOK},
+		{http.MethodPost, "/admin/bonus-codes", auditor, http.StatusForbidden},
+		{http.MethodPost, "/admin/bonus-codes", operator, http.StatusOK},
+	}
+	for _, tc := range cases {
+		require.Equal(t, tc.want, do(tc.method, tc.target, tc.token), "%s %s", tc.method, tc.target)
+	}
+}
@@ -63,12 +63,15 @@ package tests
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 	"wallet_service/internal/bonus"
+	"wallet_service/internal/wallet"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
@@ -232,3 +235,677 @@ func TestWageringIdempotency(t *testing.T) {
 
 	t.Logf("Wagering idempotency test passed: $%s wagered", progress.WageringCompleted.String())
 }
+
+// TestWageringUsesBetTimestamp tests that eligibility is judged at the time the bet was placed
+// A bet placed before expiry but delivered after it still counts
+// A bet placed before the bonus existed is rejected
+func TestWageringUsesBetTimestamp(t *testing.T) {
+	_, service, err := setupBonusTest(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test: %v", err)
+	}
+	service.SetClockSkewTolerance(0)
+
+	ctx := context.Background()
+	playerID := uuid.New().String()
+	slotsGameID := "11111111-1111-1111-1111-111111111111"
+
+	playerBonus, err := service.CreatePlayerBonus(
+		ctx,
+		playerID,
+		uuid.New().String(),
+		decimal.NewFromInt(100),
+		decimal.NewFromInt(10),
+		time.Now().Add(500*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create bonus: %v", err)
+	}
+
+	early := bonus.BetEvent{
+		BetID:     "early-bet-" + uuid.New().String(),
+		PlayerID:  playerID,
+		GameID:    slotsGameID,
+		BetAmount: decimal.NewFromInt(50),
+		Timestamp: playerBonus.CreatedAt.Add(-time.Hour),
+	}
+	if err := service.ProcessBetWagering(ctx, early); !errors.Is(err, bonus.ErrBetBeforeBonus) {
+		t.Errorf("Expected ErrBetBeforeBonus, got %v", err)
+	}
+
+	// Placed now, delivered after the bonus has expired
+	delayed := bonus.BetEvent{
+		BetID:     "delayed-bet-" + uuid.New().String(),
+		PlayerID:  playerID,
+		GameID:    slotsGameID,
+		BetAmount: decimal.NewFromInt(50),
+		Timestamp: time.Now(),
+	}
+	time.Sleep(time.Second)
+	if err := service.ProcessBetWagering(ctx, delayed); err != nil {
+		t.Fatalf("Delayed bet failed: %v", err)
+	}
+
+	progress, err := service.GetWageringProgress(ctx, playerID, playerBonus.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to get progress: %v", err)
+	}
+	expectedWagering := decimal.NewFromInt(50)
+	if !progress.WageringCompleted.Equal(expectedWagering) {
+		t.Errorf("Expected wagering $%s, got $%s", expectedWagering.String(), progress.WageringCompleted.String())
+	}
+}
+
+// TestDeadLetterReplayAfterFix tests that a bet for an unknown game is dead-lettered instead of lost
+// and counts towards wagering once it has been fixed and replayed
+func TestDeadLetterReplayAfterFix(t *testing.T) {
+	_, service, err := setupBonusTest(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test: %v", err)
+	}
+	dlRepo := bonus.NewDeadLetterRepository(db)
+	dlq := bonus.NewDeadLetterQueue(service, dlRepo)
+
+	ctx := context.Background()
+	playerID := uuid.New().String()
+	playerBonus, err := service.CreatePlayerBonus(ctx, playerID, uuid.New().String(),
+		decimal.NewFromInt(100), decimal.NewFromInt(10), time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create bonus: %v", err)
+	}
+
+	bet := bonus.BetEvent{
+		BetID:     "dead-letter-bet-" + uuid.New().String(),
+		PlayerID:  playerID,
+		GameID:    uuid.New().String(), // unknown game
+		BetAmount: decimal.NewFromInt(40),
+		Timestamp: time.Now(),
+	}
+	if err := dlq.ProcessBetWagering(ctx, bet); err != nil {
+		t.Fatalf("Expected bet to be dead-lettered, got %v", err)
+	}
+
+	dls, err := dlq.List(ctx, bonus.DeadLetterStatusPending, bonus.DeadLetterReasonGameNotFound, 1000, 0)
+	if err != nil {
+		t.Fatalf("Failed to list dead letters: %v", err)
+	}
+	var dl *bonus.DeadLetterBet
+	for i := range dls {
+		if dls[i].BetID == bet.BetID {
+			dl = &dls[i]
+		}
+	}
+	if dl == nil {
+		t.Fatalf("Bet %s was not dead-lettered", bet.BetID)
+	}
+
+	// Failing again updates the pending row and reports its stored ID
+	again := &bonus.DeadLetterBet{DeadLetterID: uuid.New().String(), BetID: bet.BetID, PlayerID: playerID,
+		Payload: dl.Payload, Reason: dl.Reason, Error: "failed again", Attempts: 1, Status: bonus.DeadLetterStatusPending}
+	if err := dlRepo.SaveDeadLetter(ctx, again); err != nil {
+		t.Fatalf("Failed to save dead letter: %v", err)
+	}
+	if again.DeadLetterID != dl.DeadLetterID || again.Attempts != dl.Attempts+1 {
+		t.Errorf("Expected dead letter %s with %d attempts, got %s with %d", dl.DeadLetterID, dl.Attempts+1, again.DeadLetterID, again.Attempts)
+	}
+
+	bet.GameID = "11111111-1111-1111-1111-111111111111"
+	if _, err := dlq.Fix(ctx, dl.DeadLetterID, bet); err != nil {
+		t.Fatalf("Failed to fix dead letter: %v", err)
+	}
+	replayed, err := dlq.Replay(ctx, dl.DeadLetterID)
+	if err != nil {
+		t.Fatalf("Failed to replay dead letter: %v", err)
+	}
+	if replayed.Status != bonus.DeadLetterStatusReplayed {
+		t.Errorf("Expected status %s, got %s (%s)", bonus.DeadLetterStatusReplayed, replayed.Status, replayed.Error)
+	}
+
+	// A late failure of the same bet does not reopen the replayed row
+	late := &bonus.DeadLetterBet{DeadLetterID: uuid.New().String(), BetID: bet.BetID, PlayerID: playerID,
+		Payload: replayed.Payload, Reason: bonus.DeadLetterReasonProcessingError, Error: "late failure", Attempts: 1, Status: bonus.DeadLetterStatusPending}
+	if err := dlRepo.SaveDeadLetter(ctx, late); err != nil {
+		t.Fatalf("Failed to save dead letter: %v", err)
+	}
+	if late.DeadLetterID != dl.DeadLetterID || late.Status != bonus.DeadLetterStatusReplayed {
+		t.Errorf("Expected dead letter %s to stay %s, got %s %s", dl.DeadLetterID, bonus.DeadLetterStatusReplayed, late.DeadLetterID, late.Status)
+	}
+
+	progress, err := service.GetWageringProgress(ctx, playerID, playerBonus.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to get progress: %v", err)
+	}
+	if !progress.WageringCompleted.Equal(decimal.NewFromInt(40)) {
+		t.Errorf("Expected wagering $40, got $%s", progress.WageringCompleted.String())
+	}
+}
+
+// TestBatchedWagering tests write-behind batching of wagering progress
+// Duplicate bet IDs are counted once and the bonus completes as soon as the requirement is met
+func TestBatchedWagering(t *testing.T) {
+	_, service, err := setupBonusTest(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test: %v", err)
+	}
+	batcher := bonus.NewWageringBatcher(service, bonus.BatcherConfig{Window: time.Hour})
+
+	ctx := context.Background()
+	playerID := uuid.New().String()
+	playerBonus, err := service.CreatePlayerBonus(ctx, playerID, uuid.New().String(),
+		decimal.NewFromInt(100), decimal.NewFromInt(10), time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create bonus: %v", err)
+	}
+
+	slotsGameID := "11111111-1111-1111-1111-111111111111"
+	var flushed sync.WaitGroup
+	submit := func(betID string) {
+		flushed.Add(1)
+		err := batcher.ProcessBetWageringDeferred(ctx, bonus.BetEvent{
+			BetID:     betID,
+			PlayerID:  playerID,
+			GameID:    slotsGameID,
+			BetAmount: decimal.NewFromInt(100),
+			Timestamp: time.Now(),
+		}, func(err error) {
+			defer flushed.Done()
+			if err != nil {
+				t.Errorf("Bet %s failed: %v", betID, err)
+			}
+		})
+		if err != nil {
+			t.Fatalf("Failed to submit bet: %v", err)
+		}
+	}
+
+	duplicate := "batched-bet-" + uuid.New().String()
+	submit(duplicate)
+	submit(duplicate)
+	for i := 0; i < 9; i++ {
+		submit("batched-bet-" + uuid.New().String())
+	}
+
+	// The tenth distinct bet reaches the $1000 requirement, so the batch
+	// flushes without waiting for the hour-long window.
+	flushed.Wait()
+
+	progress, err := service.GetWageringProgress(ctx, playerID, playerBonus.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to get progress: %v", err)
+	}
+	if !progress.Completed {
+		t.Errorf("Expected bonus to be completed")
+	}
+	if !progress.WageringCompleted.Equal(decimal.NewFromInt(1000)) {
+		t.Errorf("Expected wagering $1000, got $%s", progress.WageringCompleted.String())
+	}
+}
+
+// TestRecomputeProgress tests that capped progress is not reported as drift
+// and that real drift is detected and repaired from the event log
+func TestRecomputeProgress(t *testing.T) {
+	_, service, err := setupBonusTest(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test: %v", err)
+	}
+
+	ctx := context.Background()
+	playerID := uuid.New().String()
+	playerBonus, err := service.CreatePlayerBonus(ctx, playerID, uuid.New().String(),
+		decimal.NewFromInt(10), decimal.NewFromInt(10), time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create bonus: %v", err)
+	}
+
+	// $60 + $60 on slots against a $100 requirement: capped at $100
+	for i := 0; i < 2; i++ {
+		err := service.ProcessBetWagering(ctx, bonus.BetEvent{
+			BetID:     "recompute-bet-" + uuid.New().String(),
+			PlayerID:  playerID,
+			GameID:    "11111111-1111-1111-1111-111111111111",
+			BetAmount: decimal.NewFromInt(60),
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Bet failed: %v", err)
+		}
+	}
+
+	report, err := service.RecomputeProgress(ctx, playerBonus.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to recompute: %v", err)
+	}
+	if report.Drift {
+		t.Errorf("Capped progress reported as drift: %v", report.Explanations)
+	}
+	if !report.EventTotal.Equal(decimal.NewFromInt(120)) {
+		t.Errorf("Expected event total $120, got $%s", report.EventTotal.String())
+	}
+
+	err = db.Model(&bonus.PlayerBonus{}).
+		Where("player_bonus_id = ?", playerBonus.PlayerBonusID).
+		Update("wagering_completed", decimal.NewFromInt(70)).Error
+	if err != nil {
+		t.Fatalf("Failed to corrupt progress: %v", err)
+	}
+
+	// The bonus is completed, so the drift is reported but left alone
+	report, err = service.RepairProgress(ctx, playerBonus.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	if !report.Drift || !report.NeedsReview || report.Repaired {
+		t.Errorf("Expected drift on a completed bonus to need review: %v", report.Explanations)
+	}
+	progress, err := service.GetWageringProgress(ctx, playerID, playerBonus.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to get progress: %v", err)
+	}
+	if !progress.WageringCompleted.Equal(decimal.NewFromInt(70)) {
+		t.Errorf("Expected completed bonus to keep $70, got $%s", progress.WageringCompleted.String())
+	}
+
+	// Drift on an active bonus is repaired from the event log
+	active, err := service.CreatePlayerBonus(ctx, playerID, uuid.New().String(),
+		decimal.NewFromInt(10), decimal.NewFromInt(10), time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create bonus: %v", err)
+	}
+	err = service.ProcessBetWagering(ctx, bonus.BetEvent{
+		BetID:     "recompute-bet-" + uuid.New().String(),
+		PlayerID:  playerID,
+		GameID:    "11111111-1111-1111-1111-111111111111",
+		BetAmount: decimal.NewFromInt(60),
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Bet failed: %v", err)
+	}
+	err = db.Model(&bonus.PlayerBonus{}).
+		Where("player_bonus_id = ?", active.PlayerBonusID).
+		Update("wagering_completed", decimal.NewFromInt(10)).Error
+	if err != nil {
+		t.Fatalf("Failed to corrupt progress: %v", err)
+	}
+	report, err = service.RepairProgress(ctx, active.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	if !report.Drift || !report.Repaired {
+		t.Errorf("Expected drift to be repaired: %v", report.Explanations)
+	}
+	progress, err = service.GetWageringProgress(ctx, playerID, active.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to get progress: %v", err)
+	}
+	if !progress.WageringCompleted.Equal(decimal.NewFromInt(60)) {
+		t.Errorf("Expected repaired wagering $60, got $%s", progress.WageringCompleted.String())
+	}
+}
+
+// TestFreeSpinsConvertToBonus tests that free spin winnings become a wagering bonus
+// Three spins, one repeated provider callback and one spin on an ineligible game
+// Expected: The winnings are awarded once, with the template's wagering multiplier
+func TestFreeSpinsConvertToBonus(t *testing.T) {
+	repo, service, err := setupBonusTest(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test: %v", err)
+	}
+	walletService := wallet.NewService(wallet.NewWalletRepositoryImpl(db))
+	service.SetWallet(walletService)
+
+	ctx := context.Background()
+	templates := bonus.NewTemplateRepository(db)
+	template := &bonus.BonusTemplate{
+		TemplateID:         uuid.New().String(),
+		Name:               "Test Free Spins",
+		BonusType:          bonus.BonusTypeFreeSpins,
+		Currency:           "USD",
+		SpinCount:          3,
+		SpinValue:          decimal.RequireFromString("0.20"),
+		EligibleGames:      []string{"11111111-1111-1111-1111-111111111111"},
+		WageringMultiplier: decimal.NewFromInt(40),
+		ValidityHours:      24,
+		Active:             true,
+	}
+	if err := templates.CreateTemplate(ctx, template); err != nil {
+		t.Fatalf("Failed to create template: %v", err)
+	}
+
+	freeSpins := bonus.NewFreeSpinService(db, bonus.NewFreeSpinRepository(db), templates, service)
+	playerID := uuid.New().String()
+	grant, err := freeSpins.GrantFreeSpins(ctx, "", playerID, template.TemplateID)
+	if err != nil {
+		t.Fatalf("Failed to grant free spins: %v", err)
+	}
+
+	spin := func(roundID string, gameID string, win string) (*bonus.FreeSpinGrant, error) {
+		return freeSpins.RecordSpin(ctx, bonus.SpinResult{
+			GrantID:   grant.GrantID,
+			RoundID:   roundID,
+			GameID:    gameID,
+			WinAmount: decimal.RequireFromString(win),
+		})
+	}
+
+	rounds := []struct{ id, win string }{
+		{"fs-" + uuid.New().String(), "1.50"},
+		{"fs-" + uuid.New().String(), "0"},
+	}
+	for _, r := range rounds {
+		if _, err := spin(r.id, "11111111-1111-1111-1111-111111111111", r.win); err != nil {
+			t.Fatalf("Spin failed: %v", err)
+		}
+	}
+
+	// Repeated callback and a spin on blackjack do not use up spins
+	if _, err := spin(rounds[0].id, "11111111-1111-1111-1111-111111111111", rounds[0].win); err != nil {
+		t.Fatalf("Repeated callback failed: %v", err)
+	}
+	if _, err := spin("fs-"+uuid.New().String(), "22222222-2222-2222-2222-222222222222", "5.00"); !errors.Is(err, bonus.ErrGameNotEligible) {
+		t.Fatalf("Expected ErrGameNotEligible, got %v", err)
+	}
+
+	grant, err = spin("fs-"+uuid.New().String(), "11111111-1111-1111-1111-111111111111", "2.50")
+	if err != nil {
+		t.Fatalf("Last spin failed: %v", err)
+	}
+	if grant.Status != bonus.FreeSpinStatusCompleted || grant.PlayerBonusID == nil {
+		t.Fatalf("Expected completed grant with a bonus, got status %s", grant.Status)
+	}
+
+	playerBonus, err := repo.GetBonus(ctx, *grant.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to get bonus: %v", err)
+	}
+	if !playerBonus.BonusAmount.Equal(decimal.NewFromInt(4)) {
+		t.Errorf("Expected bonus $4.00, got $%s", playerBonus.BonusAmount.String())
+	}
+	if !playerBonus.WageringRequired.Equal(decimal.NewFromInt(160)) {
+		t.Errorf("Expected wagering requirement $160, got $%s", playerBonus.WageringRequired.String())
+	}
+
+	if _, err := spin("fs-"+uuid.New().String(), "11111111-1111-1111-1111-111111111111", "1.00"); !errors.Is(err, bonus.ErrFreeSpinsNotActive) {
+		t.Errorf("Expected ErrFreeSpinsNotActive after the last spin, got %v", err)
+	}
+
+	w, err := walletService.GetBalance(ctx, playerID, wallet.WalletTypeBonus, "USD")
+	if err != nil {
+		t.Fatalf("Failed to get bonus wallet: %v", err)
+	}
+	if !w.Balance.Equal(decimal.NewFromInt(4)) {
+		t.Errorf("Expected bonus wallet $4.00, got $%s", w.Balance.String())
+	}
+}
+
+// TestBonusCodeRedemptionCap tests that concurrent redemptions respect the code's caps
+// 20 players redeem a code capped at 5 redemptions at the same time
+// Expected: Exactly 5 bonuses are created and the counter stops at 5
+func TestBonusCodeRedemptionCap(t *testing.T) {
+	_, service, err := setupBonusTest(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test: %v", err)
+	}
+	service.SetWallet(wallet.NewService(wallet.NewWalletRepositoryImpl(db)))
+
+	ctx := context.Background()
+	templates := bonus.NewTemplateRepository(db)
+	template := &bonus.BonusTemplate{
+		TemplateID:         uuid.New().String(),
+		Name:               "Test No Deposit",
+		BonusType:          bonus.BonusTypeNoDeposit,
+		RequiresCode:       true,
+		Currency:           "USD",
+		Amount:             decimal.NewFromInt(5),
+		WageringMultiplier: decimal.NewFromInt(20),
+		ValidityHours:      24,
+		Active:             true,
+	}
+	if err := templates.CreateTemplate(ctx, template); err != nil {
+		t.Fatalf("Failed to create template: %v", err)
+	}
+
+	codes := bonus.NewBonusCodeService(db, bonus.NewBonusCodeRepository(db), templates, service, nil)
+	code := &bonus.BonusCode{
+		Code:           "CAP" + uuid.New().String()[:8],
+		TemplateID:     template.TemplateID,
+		MaxRedemptions: 5,
+		MaxPerPlayer:   1,
+		Countries:      []string{"DE", "GB"},
+		Active:         true,
+	}
+	if err := codes.CreateCode(ctx, code); err != nil {
+		t.Fatalf("Failed to create code: %v", err)
+	}
+
+	_, err = codes.Redeem(ctx, bonus.RedeemRequest{Code: code.Code, PlayerID: uuid.New().String(), Country: "US", Currency: "USD"})
+	if !errors.Is(err, bonus.ErrBonusCodeNotEligible) {
+		t.Fatalf("Expected ErrBonusCodeNotEligible for country US, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var redeemed, exhausted atomic.Int32
+	players := make([]string, 20)
+	for i := range players {
+		players[i] = uuid.New().String()
+		wg.Add(1)
+		go func(playerID string) {
+			defer wg.Done()
+			_, err := codes.Redeem(ctx, bonus.RedeemRequest{Code: strings.ToLower(code.Code), PlayerID: playerID, Country: "DE", Currency: "USD"})
+			switch {
+			case err == nil:
+				redeemed.Add(1)
+			case errors.Is(err, bonus.ErrBonusCodeExhausted):
+				exhausted.Add(1)
+			default:
+				t.Errorf("Unexpected redemption error: %v", err)
+			}
+		}(players[i])
+	}
+	wg.Wait()
+
+	if redeemed.Load() != 5 || exhausted.Load() != 15 {
+		t.Errorf("Expected 5 redemptions and 15 rejections, got %d and %d", redeemed.Load(), exhausted.Load())
+	}
+
+	stored, err := codes.GetCode(ctx, code.Code)
+	if err != nil {
+		t.Fatalf("Failed to get code: %v", err)
+	}
+	if stored.Redemptions != 5 {
+		t.Errorf("Expected redemption counter 5, got %d", stored.Redemptions)
+	}
+
+	var bonuses int64
+	db.Model(&bonus.PlayerBonus{}).Where("bonus_id = ?", template.TemplateID).Count(&bonuses)
+	if bonuses != 5 {
+		t.Errorf("Expected 5 player bonuses, got %d", bonuses)
+	}
+}
+
+// TestWithdrawalWithActiveBonus tests the withdrawal rules for players with an active bonus
+// A blocked withdrawal is refused; a forfeiting withdrawal ends the bonus first
+// Expected: The bonus is forfeited and its funds removed from the bonus wallet
+func TestWithdrawalWithActiveBonus(t *testing.T) {
+	repo, service, err := setupBonusTest(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test: %v", err)
+	}
+	walletService := wallet.NewService(wallet.NewWalletRepositoryImpl(db))
+	service.SetWallet(walletService)
+
+	ctx := context.Background()
+	playerID := uuid.New().String()
+	_, err = walletService.ProcessTransaction(ctx, wallet.TransactionRequest{
+		PlayerID:        playerID,
+		WalletType:      wallet.WalletTypeMain,
+		TransactionType: wallet.TransactionTypeDeposit,
+		Amount:          decimal.NewFromInt(100),
+		ReferenceID:     uuid.New().String(),
+		Currency:        "USD",
+	})
+	if err != nil {
+		t.Fatalf("Deposit failed: %v", err)
+	}
+	playerBonus, err := service.AwardBonus(ctx, bonus.BonusAward{
+		PlayerBonusID:      uuid.New().String(),
+		PlayerID:           playerID,
+		BonusID:            uuid.New().String(),
+		Amount:             decimal.NewFromInt(50),
+		WageringMultiplier: decimal.NewFromInt(10),
+		Currency:           "USD",
+		ExpiresAt:          time.Now().Add(24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Failed to award bonus: %v", err)
+	}
+
+	withdraw := func(rule string) error {
+		policy, err := bonus.NewWithdrawalPolicy(service, rule)
+		if err != nil {
+			t.Fatalf("Failed to create policy: %v", err)
+		}
+		walletService.SetWithdrawalGuard(policy)
+		_, err = walletService.ProcessTransaction(ctx, wallet.TransactionRequest{
+			PlayerID:        playerID,
+			WalletType:      wallet.WalletTypeMain,
+			TransactionType: wallet.TransactionTypeWithdrawal,
+			Amount:          decimal.NewFromInt(40),
+			ReferenceID:     uuid.New().String(),
+			Currency:        "USD",
+		})
+		return err
+	}
+
+	if err := withdraw(bonus.WithdrawalRuleBlock); !errors.Is(err, wallet.ErrWithdrawalBlocked) {
+		t.Fatalf("Expected ErrWithdrawalBlocked, got %v", err)
+	}
+	if err := withdraw(bonus.WithdrawalRuleForfeit); err != nil {
+		t.Fatalf("Withdrawal failed: %v", err)
+	}
+
+	forfeited, err := repo.GetBonus(ctx, playerBonus.PlayerBonusID)
+	if err != nil {
+		t.Fatalf("Failed to get bonus: %v", err)
+	}
+	if forfeited.Status != bonus.BonusStatusForfeited {
+		t.Errorf("Expected forfeited bonus, got %s", forfeited.Status)
+	}
+	bonusWallet, err := walletService.GetBalance(ctx, playerID, wallet.WalletTypeBonus, "USD")
+	if err != nil {
+		t.Fatalf("Failed to get bonus wallet: %v", err)
+	}
+	if !bonusWallet.Balance.IsZero() {
+		t.Errorf("Expected empty bonus wallet, got $%s", bonusWallet.Balance.String())
+	}
+	mainWallet, err := walletService.GetBalance(ctx, playerID, wallet.WalletTypeMain, "USD")
+	if err != nil {
+		t.Fatalf("Failed to get main wallet: %v", err)
+	}
+	if !mainWallet.Balance.Equal(decimal.NewFromInt(60)) {
+		t.Errorf("Expected main wallet $60, got $%s", mainWallet.Balance.String())
+	}
+
+	if _, err := service.ForfeitBonus(ctx, playerID, playerBonus.PlayerBonusID, "player"); err != nil {
+		t.Errorf("Repeated forfeit should be a no-op, got %v", err)
+	}
+}
+
+// TestRepeatedForfeit tests that forfeiting a bonus again after its funds were removed
+// Another bonus is credited to the bonus wallet between the two calls
+// Expected: The second call succeeds and leaves the new bonus funds alone
+func TestRepeatedForfeit(t *testing.T) {
+	ctx := context.Background()
+	noDB, err := gorm.Open(postgres.New(postgres.Config{Conn: noDBPool{}}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("Failed to open gorm: %v", err)
+	}
+	playerID := uuid.New().String()
+	playerBonus := &bonus.PlayerBonus{PlayerBonusID: uuid.New().String(), PlayerID: playerID, Status: bonus.BonusStatusActive,
+		BonusAmount: decimal.NewFromInt(100), Currency: "USD", ExpiresAt: time.Now().Add(24 * time.Hour)}
+	repo := &memoryBonusRepo{bonuses: map[string]*bonus.PlayerBonus{playerBonus.PlayerBonusID: playerBonus}}
+	service := bonus.NewBonusService(noDB, repo)
+	bonusWallet := newMemoryWallet()
+	bonusWallet.balances[playerID] = decimal.NewFromInt(100)
+	service.SetWallet(bonusWallet)
+
+	if _, err := service.ForfeitBonus(ctx, playerID, playerBonus.PlayerBonusID, "player"); err != nil {
+		t.Fatalf("Failed to forfeit bonus: %v", err)
+	}
+	bonusWallet.balances[playerID] = decimal.NewFromInt(50)
+	if _, err := service.ForfeitBonus(ctx, playerID, playerBonus.PlayerBonusID, "player"); err != nil {
+		t.Fatalf("Repeated forfeit should be a no-op, got %v", err)
+	}
+	if !bonusWallet.balances[playerID].Equal(decimal.NewFromInt(50)) {
+		t.Errorf("Expected bonus wallet $50, got $%s", bonusWallet.balances[playerID].String())
+	}
+}
+
+// TestWageringTimeline tests the per-bet history support agents see for a bonus
+// Slots and blackjack bets, read in two pages and as CSV
+// Expected: Running totals span pages and blackjack counts at 10%
+func TestWageringTimeline(t *testing.T) {
+	_, service, err := setupBonusTest(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test: %v", err)
+	}
+
+	ctx := context.Background()
+	playerID := uuid.New().String()
+	playerBonus, err := service.CreatePlayerBonus(ctx, playerID, uuid.New().String(),
+		decimal.NewFromInt(100), decimal.NewFromInt(10), time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create bonus: %v", err)
+	}
+
+	slots := "11111111-1111-1111-1111-111111111111"
+	blackjack := "22222222-2222-2222-2222-222222222222"
+	for _, gameID := range []string{slots, blackjack, blackjack} {
+		err := service.ProcessBetWagering(ctx, bonus.BetEvent{
+			BetID:     "timeline-bet-" + uuid.New().String(),
+			PlayerID:  playerID,
+			GameID:    gameID,
+			BetAmount: decimal.NewFromInt(50),
+			Timestamp: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Bet failed: %v", err)
+		}
+	}
+
+	timeline := bonus.NewTimelineService(bonus.NewTimelineRepository(db), service)
+	page, err := timeline.GetTimeline(ctx, playerBonus.PlayerBonusID, 2, 1)
+	if err != nil {
+		t.Fatalf("Failed to get timeline: %v", err)
+	}
+	if page.TotalEvents != 3 || len(page.Events) != 2 {
+		t.Fatalf("Expected 2 of 3 events, got %d of %d", len(page.Events), page.TotalEvents)
+	}
+	// $50 slots, then $5 and $5 from blackjack
+	for i, want := range []int64{55, 60} {
+		e := page.Events[i]
+		if !e.RunningTotal.Equal(decimal.NewFromInt(want)) {
+			t.Errorf("Event %d: expected running total $%d, got $%s", i, want, e.RunningTotal.String())
+		}
+		if e.GameName != "Blackjack" || !e.ContributionPercentage.Equal(decimal.RequireFromString("0.1")) {
+			t.Errorf("Event %d: expected blackjack at 10%%, got %s at %s", i, e.GameName, e.ContributionPercentage.String())
+		}
+	}
+	if len(page.Games) != 2 || page.Games[0].GameID != slots || page.Games[1].Bets != 2 ||
+		!page.Games[1].WageringContribution.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("Unexpected game breakdown: %+v", page.Games)
+	}
+
+	all, err := timeline.GetTimeline(ctx, playerBonus.PlayerBonusID, -1, 0)
+	if err != nil {
+		t.Fatalf("Failed to get timeline: %v", err)
+	}
+	var out strings.Builder
+	if err := bonus.WriteTimelineCSV(&out, all); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[3], ",50.00,0.1000,5.00,60.00") {
+		t.Errorf("Unexpected CSV:\n%s", out.String())
+	}
+}
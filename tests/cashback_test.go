@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"wallet_service/internal/bonus"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryCashbackRepo serves fixed net losses and keeps payouts in memory
+type memoryCashbackRepo struct {
+	mu      sync.Mutex
+	losses  []bonus.PlayerNetLoss
+	payouts map[string]*bonus.CashbackPayout
+	periods []bonus.CashbackPeriod
+}
+
+func (r *memoryCashbackRepo) GetNetLosses(ctx context.Context, currency string, start time.Time, end time.Time) ([]bonus.PlayerNetLoss, error) {
+	return r.losses, nil
+}
+
+func (r *memoryCashbackRepo) CreatePayout(ctx context.Context, payout *bonus.CashbackPayout) (*bonus.CashbackPayout, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if stored, ok := r.payouts[payout.PayoutID]; ok {
+		copied := *stored
+		return &copied, nil
+	}
+	copied := *payout
+	r.payouts[payout.PayoutID] = &copied
+	return payout, nil
+}
+
+func (r *memoryCashbackRepo) MarkPayoutPaid(ctx context.Context, payoutID string, reference string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.payouts[payoutID].Status = bonus.CashbackStatusPaid
+	r.payouts[payoutID].Reference = reference
+	return nil
+}
+
+func (r *memoryCashbackRepo) ListPayouts(ctx context.Context, start time.Time, end time.Time) ([]bonus.CashbackPayout, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var payouts []bonus.CashbackPayout
+	for _, p := range r.payouts {
+		payouts = append(payouts, *p)
+	}
+	return payouts, nil
+}
+
+func (r *memoryCashbackRepo) SavePeriod(ctx context.Context, period *bonus.CashbackPeriod) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.periods {
+		if p.PeriodStart.Equal(period.PeriodStart) && p.PeriodEnd.Equal(period.PeriodEnd) {
+			r.periods[i] = *period
+			return nil
+		}
+	}
+	r.periods = append(r.periods, *period)
+	return nil
+}
+
+func (r *memoryCashbackRepo) ListPeriods(ctx context.Context, currency string, status string) ([]bonus.CashbackPeriod, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var periods []bonus.CashbackPeriod
+	for _, p := range r.periods {
+		if p.Status == status {
+			periods = append(periods, p)
+		}
+	}
+	return periods, nil
+}
+
+func (r *memoryCashbackRepo) LastPeriodEnd(ctx context.Context, currency string) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var last time.Time
+	for _, p := range r.periods {
+		if p.PeriodEnd.After(last) {
+			last = p.PeriodEnd
+		}
+	}
+	return last, nil
+}
+
+// recordingWallet applies each reference at most once, like wallet.Service
+type recordingWallet struct {
+	mu       sync.Mutex
+	requests map[string]wallet.TransactionRequest
+}
+
+func (w *recordingWallet) ProcessTransaction(ctx context.Context, req wallet.TransactionRequest) (*wallet.TransactionResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.requests[req.ReferenceID]; !ok {
+		w.requests[req.ReferenceID] = req
+	}
+	return &wallet.TransactionResponse{TransactionID: req.ReferenceID, Status: "completed"}, nil
+}
+
+func (w *recordingWallet) GetBalance(ctx context.Context, playerID string, walletType string, currency string) (*wallet.Wallet, error) {
+	return nil, wallet.ErrWalletNotFound
+}
+
+func (w *recordingWallet) GetTransaction(ctx context.Context, referenceID string, transactionType string) (*wallet.Transaction, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	req, ok := w.requests[referenceID]
+	if !ok || req.TransactionType != transactionType {
+		return nil, wallet.ErrTransactionNotFound
+	}
+	return &wallet.Transaction{TransactionID: referenceID, PlayerID: req.PlayerID, TransactionType: req.TransactionType, Amount: req.Amount, ReferenceID: referenceID}, nil
+}
+
+// TestCashbackTiersAndIdempotency checks tier selection, caps and that a
+// period is paid only once however often it is run
+func TestCashbackTiersAndIdempotency(t *testing.T) {
+	ctx := context.Background()
+	small, large, capped, winner := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+	repo := &memoryCashbackRepo{
+		losses: []bonus.PlayerNetLoss{
+			{PlayerID: small, TotalBets: decimal.NewFromInt(150), TotalWins: decimal.NewFromInt(50)},   // loss 100, tier 1
+			{PlayerID: large, TotalBets: decimal.NewFromInt(1500), TotalWins: decimal.NewFromInt(500)}, // loss 1000, tier 2
+			{PlayerID: capped, TotalBets: decimal.NewFromInt(9000), TotalWins: decimal.Zero},           // loss 9000, capped
+			{PlayerID: winner, TotalBets: decimal.NewFromInt(100), TotalWins: decimal.NewFromInt(300)}, // net win
+		},
+		payouts: make(map[string]*bonus.CashbackPayout),
+	}
+	wal := &recordingWallet{requests: make(map[string]wallet.TransactionRequest)}
+
+	tiers, err := bonus.ParseCashbackTiers("50:0.05:25, 500:0.10:200")
+	require.NoError(t, err)
+	engine := bonus.NewCashbackEngine(repo, nil, wal, bonus.CashbackConfig{
+		Tiers:      tiers,
+		Currency:   "USD",
+		Period:     7 * 24 * time.Hour,
+		PayoutMode: bonus.CashbackModeWallet,
+	})
+
+	start, end := engine.PreviousPeriod(time.Now())
+	require.Equal(t, 7*24*time.Hour, end.Sub(start))
+
+	paid, err := engine.Run(ctx, start, end)
+	require.NoError(t, err)
+	require.Len(t, paid, 3, "a player with a net win gets no cashback")
+
+	amounts := make(map[string]decimal.Decimal)
+	for _, p := range paid {
+		amounts[p.PlayerID] = p.Amount
+		require.Equal(t, bonus.CashbackStatusPaid, p.Status)
+	}
+	require.True(t, decimal.NewFromInt(5).Equal(amounts[small]), "got %s", amounts[small])
+	require.True(t, decimal.NewFromInt(100).Equal(amounts[large]), "got %s", amounts[large])
+	require.True(t, decimal.NewFromInt(200).Equal(amounts[capped]), "got %s", amounts[capped])
+
+	// Running the period again pays nothing new
+	_, err = engine.Run(ctx, start, end)
+	require.NoError(t, err)
+	require.Len(t, wal.requests, 3)
+	for _, req := range wal.requests {
+		require.Equal(t, wallet.TransactionTypeCashback, req.TransactionType)
+		require.Equal(t, wallet.WalletTypeMain, req.WalletType)
+	}
+}
+
+// TestCashbackCatchUp checks that periods which closed while the service was
+// down are paid, and that a caught-up engine pays nothing twice
+func TestCashbackCatchUp(t *testing.T) {
+	ctx := context.Background()
+	player := uuid.NewString()
+	repo := &memoryCashbackRepo{
+		losses:  []bonus.PlayerNetLoss{{PlayerID: player, TotalBets: decimal.NewFromInt(100)}},
+		payouts: make(map[string]*bonus.CashbackPayout),
+	}
+	wal := &recordingWallet{requests: make(map[string]wallet.TransactionRequest)}
+	tiers, err := bonus.ParseCashbackTiers("50:0.10:0")
+	require.NoError(t, err)
+	day := 24 * time.Hour
+	engine := bonus.NewCashbackEngine(repo, nil, wal, bonus.CashbackConfig{
+		Tiers: tiers, Currency: "USD", Period: day, PayoutMode: bonus.CashbackModeWallet,
+	})
+
+	// The first run only pays the previous period
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, engine.CatchUp(ctx, now))
+	require.Len(t, wal.requests, 1)
+
+	// Down for three days: the three periods closed since are all paid
+	now = now.Add(3 * day)
+	due, err := engine.DuePeriods(ctx, now)
+	require.NoError(t, err)
+	require.Len(t, due, 3)
+	require.NoError(t, engine.CatchUp(ctx, now))
+	require.Len(t, wal.requests, 4)
+
+	require.NoError(t, engine.CatchUp(ctx, now))
+	require.Len(t, wal.requests, 4)
+	due, err = engine.DuePeriods(ctx, now)
+	require.NoError(t, err)
+	require.Empty(t, due)
+}
+
+// TestCashbackBonusID checks that cashback paid as a bonus carries a BonusID
+// per period, or the configured template ID
+func TestCashbackBonusID(t *testing.T) {
+	ctx := context.Background()
+	player := uuid.NewString()
+	tiers, err := bonus.ParseCashbackTiers("50:0.10:0")
+	require.NoError(t, err)
+	day := 24 * time.Hour
+	first := time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+
+	bonusIDs := func(templateID string) []string {
+		repo := &memoryCashbackRepo{
+			losses:  []bonus.PlayerNetLoss{{PlayerID: player, TotalBets: decimal.NewFromInt(100)}},
+			payouts: make(map[string]*bonus.CashbackPayout),
+		}
+		bonusRepo := &memoryBonusRepo{bonuses: make(map[string]*bonus.PlayerBonus)}
+		bonuses := bonus.NewBonusService(nil, bonusRepo)
+		wal := &recordingWallet{requests: make(map[string]wallet.TransactionRequest)}
+		bonuses.SetWallet(wal)
+		engine := bonus.NewCashbackEngine(repo, bonuses, wal, bonus.CashbackConfig{
+			Tiers: tiers, Currency: "USD", Period: day, PayoutMode: bonus.CashbackModeBonus,
+			WageringMultiplier: decimal.NewFromInt(1), BonusValidity: day, BonusTemplateID: templateID,
+		})
+		var ids []string
+		for start := first; start.Before(first.Add(2 * day)); start = start.Add(day) {
+			paid, err := engine.Run(ctx, start, start.Add(day))
+			require.NoError(t, err)
+			require.Len(t, paid, 1)
+			awarded, err := bonusRepo.GetBonus(ctx, paid[0].Reference)
+			require.NoError(t, err)
+			ids = append(ids, awarded.BonusID)
+		}
+		return ids
+	}
+
+	perPeriod := bonusIDs("")
+	require.NotEqual(t, perPeriod[0], perPeriod[1])
+	require.Equal(t, perPeriod, bonusIDs(""), "derived IDs are stable")
+
+	templateID := uuid.NewString()
+	require.Equal(t, []string{templateID, templateID}, bonusIDs(templateID))
+}
@@ -0,0 +1,321 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"wallet_service/internal/bonus"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// memoryTemplateRepo serves fixed templates and counts the deposits the
+// test has made
+type memoryTemplateRepo struct {
+	templates []bonus.BonusTemplate
+	deposits  map[string][]string // player -> deposit references
+	claims    map[string]string   // player -> first deposit reference
+}
+
+func (r *memoryTemplateRepo) CreateTemplate(ctx context.Context, template *bonus.BonusTemplate) error {
+	r.templates = append(r.templates, *template)
+	return nil
+}
+
+func (r *memoryTemplateRepo) GetTemplate(ctx context.Context, templateID string) (*bonus.BonusTemplate, error) {
+	for _, t := range r.templates {
+		if t.TemplateID == templateID {
+			return &t, nil
+		}
+	}
+	return nil, bonus.ErrTemplateNotFound
+}
+
+func (r *memoryTemplateRepo) ListTemplates(ctx context.Context, bonusType string, currency string) ([]bonus.BonusTemplate, error) {
+	return r.templates, nil
+}
+
+func (r *memoryTemplateRepo) CountDeposits(ctx context.Context, playerID string, currency string, excludeReference string) (int64, error) {
+	var count int64
+	for _, ref := range r.deposits[playerID] {
+		if ref != excludeReference {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryTemplateRepo) ClaimFirstDeposit(ctx context.Context, playerID string, currency string, referenceID string) (bool, error) {
+	if _, ok := r.claims[playerID]; !ok {
+		r.claims[playerID] = referenceID
+	}
+	return r.claims[playerID] == referenceID, nil
+}
+
+// memoryBonusRepo keeps player bonuses in memory. Methods not needed to
+// award a bonus are left to the nil embedded interface.
+type memoryBonusRepo struct {
+	bonus.BonusRepository
+
+	mu      sync.Mutex
+	bonuses map[string]*bonus.PlayerBonus
+}
+
+func (r *memoryBonusRepo) GetBonus(ctx context.Context, playerBonusID string) (*bonus.PlayerBonus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.bonuses[playerBonusID]; ok {
+		return b, nil
+	}
+	return nil, bonus.ErrBonusNotFound
+}
+
+func (r *memoryBonusRepo) CreatePlayerBonus(ctx context.Context, b *bonus.PlayerBonus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bonuses[b.PlayerBonusID] = b
+	return nil
+}
+
+func (r *memoryBonusRepo) GetBonusForUpdate(ctx context.Context, tx *gorm.DB, playerBonusID string) (*bonus.PlayerBonus, error) {
+	b, err := r.GetBonus(ctx, playerBonusID)
+	if err != nil {
+		return nil, err
+	}
+	copied := *b
+	return &copied, nil
+}
+
+func (r *memoryBonusRepo) UpdateBonusStatus(ctx context.Context, tx *gorm.DB, playerBonusID string, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bonuses[playerBonusID].Status = status
+	return nil
+}
+
+func (r *memoryBonusRepo) CreatePlayerBonusTx(ctx context.Context, tx *gorm.DB, b *bonus.PlayerBonus) error {
+	return r.CreatePlayerBonus(ctx, b)
+}
+
+// memoryCodeRepo keeps bonus codes and their redemptions in memory and
+// ignores the transaction it is given. Methods not needed to redeem a code
+// are left to the nil embedded interface.
+type memoryCodeRepo struct {
+	bonus.BonusCodeRepository
+
+	codes       map[string]*bonus.BonusCode
+	redemptions map[string]*bonus.BonusCodeRedemption
+}
+
+func (r *memoryCodeRepo) GetCodeForUpdate(ctx context.Context, tx *gorm.DB, code string) (*bonus.BonusCode, error) {
+	if c, ok := r.codes[code]; ok {
+		return c, nil
+	}
+	return nil, bonus.ErrBonusCodeNotFound
+}
+
+func (r *memoryCodeRepo) GetRedemption(ctx context.Context, tx *gorm.DB, redemptionID string) (*bonus.BonusCodeRedemption, error) {
+	return r.redemptions[redemptionID], nil
+}
+
+func (r *memoryCodeRepo) CreateRedemption(ctx context.Context, tx *gorm.DB, redemption *bonus.BonusCodeRedemption) error {
+	r.redemptions[redemption.RedemptionID] = redemption
+	r.codes[redemption.Code].Redemptions++
+	return nil
+}
+
+func (r *memoryCodeRepo) CountPlayerRedemptions(ctx context.Context, tx *gorm.DB, code string, playerID string) (int64, error) {
+	var count int64
+	for _, redemption := range r.redemptions {
+		if redemption.Code == code && redemption.PlayerID == playerID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// noDBPool lets gorm begin and commit transactions without a database, for
+// services whose repositories are faked. Any statement sent to it fails.
+type noDBPool struct{}
+
+var errNoDB = errors.New("no database in this test")
+
+func (noDBPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errNoDB
+}
+
+func (noDBPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, errNoDB
+}
+
+func (noDBPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errNoDB
+}
+
+func (noDBPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func (p noDBPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return p, nil
+}
+
+func (noDBPool) Commit() error   { return nil }
+func (noDBPool) Rollback() error { return nil }
+
+// TestDepositMatchBonus checks that deposit offers follow their template
+// rules and that a replayed deposit does not award a second bonus
+func TestDepositMatchBonus(t *testing.T) {
+	ctx := context.Background()
+	templates := &memoryTemplateRepo{
+		templates: []bonus.BonusTemplate{
+			{
+				TemplateID: uuid.NewString(), BonusType: bonus.BonusTypeDepositMatch, Currency: "USD",
+				MatchPercentage: decimal.NewFromInt(1), MaxBonus: decimal.NewFromInt(200), MinDeposit: decimal.NewFromInt(20),
+				FirstDepositOnly: true, WageringMultiplier: decimal.NewFromInt(35), ValidityHours: 720, Active: true,
+			},
+			{
+				TemplateID: uuid.NewString(), BonusType: bonus.BonusTypeDepositMatch, RequiresCode: true, Currency: "USD",
+				MatchPercentage: decimal.RequireFromString("0.5"), MaxBonus: decimal.NewFromInt(100), MinDeposit: decimal.NewFromInt(20),
+				WageringMultiplier: decimal.NewFromInt(30), ValidityHours: 168, Active: true,
+			},
+		},
+		deposits: make(map[string][]string),
+		claims:   make(map[string]string),
+	}
+	bonusRepo := &memoryBonusRepo{bonuses: make(map[string]*bonus.PlayerBonus)}
+	bonusService := bonus.NewBonusService(nil, bonusRepo)
+	wal := &recordingWallet{requests: make(map[string]wallet.TransactionRequest)}
+	bonusService.SetWallet(wal)
+	matcher := bonus.NewDepositMatcher(templates, nil, bonusService)
+
+	playerID := uuid.NewString()
+	deposit := func(reference string, amount int64) *bonus.PlayerBonus {
+		templates.deposits[playerID] = append(templates.deposits[playerID], reference)
+		req := wallet.TransactionRequest{
+			PlayerID:        playerID,
+			WalletType:      wallet.WalletTypeMain,
+			TransactionType: wallet.TransactionTypeDeposit,
+			Amount:          decimal.NewFromInt(amount),
+			ReferenceID:     reference,
+			Currency:        "USD",
+		}
+		require.NoError(t, matcher.OnDeposit(ctx, req, &wallet.TransactionResponse{}))
+		b, err := bonusRepo.GetBonus(ctx, bonus.DepositBonusID(reference).String())
+		if err == bonus.ErrBonusNotFound {
+			return nil
+		}
+		require.NoError(t, err)
+		return b
+	}
+
+	// Below the minimum deposit: no bonus, and it still counts as a deposit
+	require.Nil(t, deposit("dep-1", 10))
+
+	// A second deposit no longer qualifies for the welcome offer
+	require.Nil(t, deposit("dep-2", 150), "welcome offer is for the first deposit only")
+
+	playerID = uuid.NewString()
+	welcome := deposit("dep-3", 500)
+	require.NotNil(t, welcome)
+	require.True(t, decimal.NewFromInt(200).Equal(welcome.BonusAmount), "capped at 200, got %s", welcome.BonusAmount)
+	require.True(t, decimal.NewFromInt(7000).Equal(welcome.WageringRequired))
+	require.WithinDuration(t, time.Now().Add(720*time.Hour), welcome.ExpiresAt, time.Minute)
+
+	// A replay of the same deposit awards nothing new
+	require.NotNil(t, deposit("dep-3", 500))
+	require.Len(t, bonusRepo.bonuses, 1)
+	require.Len(t, wal.requests, 1)
+
+	// A later deposit without a code gets nothing: the reload offer needs one
+	require.Nil(t, deposit("dep-4", 120))
+	require.Len(t, wal.requests, 1)
+
+	// Two first deposits whose hooks both count no earlier deposit: only the
+	// one that claims the first deposit gets the welcome offer
+	playerID = uuid.NewString()
+	for _, reference := range []string{"dep-5", "dep-6"} {
+		require.NoError(t, matcher.OnDeposit(ctx, wallet.TransactionRequest{
+			PlayerID: playerID, WalletType: wallet.WalletTypeMain, TransactionType: wallet.TransactionTypeDeposit,
+			Amount: decimal.NewFromInt(100), ReferenceID: reference, Currency: "USD",
+		}, &wallet.TransactionResponse{}))
+	}
+	_, err := bonusRepo.GetBonus(ctx, bonus.DepositBonusID("dep-5").String())
+	require.NoError(t, err)
+	_, err = bonusRepo.GetBonus(ctx, bonus.DepositBonusID("dep-6").String())
+	require.ErrorIs(t, err, bonus.ErrBonusNotFound)
+	require.Len(t, wal.requests, 2)
+}
+
+// TestDepositWithBonusCode checks that a code entered with a deposit is
+// redeemed under the deposit's reference, so a replayed deposit returns the
+// first redemption instead of redeeming the code again
+func TestDepositWithBonusCode(t *testing.T) {
+	ctx := context.Background()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: noDBPool{}}), &gorm.Config{DisableAutomaticPing: true})
+	require.NoError(t, err)
+
+	reload := bonus.BonusTemplate{
+		TemplateID: uuid.NewString(), BonusType: bonus.BonusTypeDepositMatch, RequiresCode: true, Currency: "USD",
+		MatchPercentage: decimal.RequireFromString("0.5"), MaxBonus: decimal.NewFromInt(100), MinDeposit: decimal.NewFromInt(20),
+		WageringMultiplier: decimal.NewFromInt(30), ValidityHours: 168, Active: true,
+	}
+	templates := &memoryTemplateRepo{
+		templates: []bonus.BonusTemplate{reload},
+		deposits:  make(map[string][]string),
+		claims:    make(map[string]string),
+	}
+	codeRepo := &memoryCodeRepo{
+		codes: map[string]*bonus.BonusCode{
+			"RELOAD50": {Code: "RELOAD50", TemplateID: reload.TemplateID, MaxPerPlayer: 1, Active: true},
+		},
+		redemptions: make(map[string]*bonus.BonusCodeRedemption),
+	}
+	bonusRepo := &memoryBonusRepo{bonuses: make(map[string]*bonus.PlayerBonus)}
+	bonusService := bonus.NewBonusService(nil, bonusRepo)
+	wal := &recordingWallet{requests: make(map[string]wallet.TransactionRequest)}
+	bonusService.SetWallet(wal)
+	codes := bonus.NewBonusCodeService(db, codeRepo, templates, bonusService, nil)
+	matcher := bonus.NewDepositMatcher(templates, codes, bonusService)
+
+	playerID := uuid.NewString()
+	deposit := func(reference string) error {
+		templates.deposits[playerID] = append(templates.deposits[playerID], reference)
+		return matcher.OnDeposit(ctx, wallet.TransactionRequest{
+			PlayerID: playerID, WalletType: wallet.WalletTypeMain, TransactionType: wallet.TransactionTypeDeposit,
+			Amount: decimal.NewFromInt(120), ReferenceID: reference, Currency: "USD", BonusCode: " reload50 ",
+		}, &wallet.TransactionResponse{})
+	}
+
+	require.NoError(t, deposit("dep-1"))
+	require.Len(t, codeRepo.redemptions, 1)
+	var redemption *bonus.BonusCodeRedemption
+	for _, r := range codeRepo.redemptions {
+		redemption = r
+	}
+	require.Equal(t, "dep-1", redemption.Reference)
+	awarded, err := bonusRepo.GetBonus(ctx, redemption.AwardID)
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(60).Equal(awarded.BonusAmount), "half of the deposit, got %s", awarded.BonusAmount)
+	require.True(t, decimal.NewFromInt(1800).Equal(awarded.WageringRequired))
+	require.Contains(t, wal.requests, "bonus:"+redemption.AwardID)
+
+	// A replay of the deposit finds the redemption made under its reference
+	require.NoError(t, deposit("dep-1"))
+	require.Len(t, codeRepo.redemptions, 1)
+	require.Equal(t, 1, codeRepo.codes["RELOAD50"].Redemptions)
+	require.Len(t, bonusRepo.bonuses, 1)
+	require.Len(t, wal.requests, 1)
+
+	// A new deposit is a new redemption, and the code allows one per player
+	require.ErrorIs(t, deposit("dep-2"), bonus.ErrBonusCodePlayerLimit)
+	require.Len(t, bonusRepo.bonuses, 1)
+}
@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"wallet_service/internal/bonus"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func (r *memoryBonusRepo) ListActiveBonusesExpiringBefore(ctx context.Context, before time.Time) ([]bonus.PlayerBonus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var bonuses []bonus.PlayerBonus
+	for _, b := range r.bonuses {
+		if b.Status == bonus.BonusStatusActive && b.ExpiresAt.Before(before) {
+			bonuses = append(bonuses, *b)
+		}
+	}
+	sort.Slice(bonuses, func(i, j int) bool { return bonuses[i].ExpiresAt.Before(bonuses[j].ExpiresAt) })
+	return bonuses, nil
+}
+
+func (r *memoryBonusRepo) ListActiveBonuses(ctx context.Context, playerID string) ([]bonus.PlayerBonus, error) {
+	return r.list(playerID, bonus.BonusStatusActive, time.Time{}), nil
+}
+
+func (r *memoryBonusRepo) ListBonusesExpiredSince(ctx context.Context, playerID string, since time.Time) ([]bonus.PlayerBonus, error) {
+	return r.list(playerID, bonus.BonusStatusExpired, since), nil
+}
+
+func (r *memoryBonusRepo) list(playerID string, status string, expiringFrom time.Time) []bonus.PlayerBonus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var bonuses []bonus.PlayerBonus
+	for _, b := range r.bonuses {
+		if b.PlayerID == playerID && b.Status == status && !b.ExpiresAt.Before(expiringFrom) {
+			bonuses = append(bonuses, *b)
+		}
+	}
+	sort.Slice(bonuses, func(i, j int) bool { return bonuses[i].CreatedAt.Before(bonuses[j].CreatedAt) })
+	return bonuses
+}
+
+func (r *memoryBonusRepo) GetEventByBetID(ctx context.Context, betID string) (*bonus.WageringEvent, error) {
+	return nil, bonus.ErrWageringEventNotFound
+}
+
+// memoryOutbox keeps outbox notifications by dedupe key
+type memoryOutbox struct {
+	mu            sync.Mutex
+	notifications map[string]bonus.OutboxNotification
+}
+
+func (o *memoryOutbox) Enqueue(ctx context.Context, n *bonus.OutboxNotification) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.notifications[n.DedupeKey]; ok {
+		return false, nil
+	}
+	o.notifications[n.DedupeKey] = *n
+	return true, nil
+}
+
+func (o *memoryOutbox) ListUnpublished(ctx context.Context, limit int) ([]bonus.OutboxNotification, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var unpublished []bonus.OutboxNotification
+	for _, n := range o.notifications {
+		if n.PublishedAt == nil {
+			unpublished = append(unpublished, n)
+		}
+	}
+	sort.Slice(unpublished, func(i, j int) bool { return unpublished[i].CreatedAt.Before(unpublished[j].CreatedAt) })
+	if len(unpublished) > limit {
+		unpublished = unpublished[:limit]
+	}
+	return unpublished, nil
+}
+
+func (o *memoryOutbox) MarkPublished(ctx context.Context, notificationID string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for key, n := range o.notifications {
+		if n.NotificationID == notificationID && n.PublishedAt == nil {
+			now := time.Now()
+			n.PublishedAt = &now
+			o.notifications[key] = n
+		}
+	}
+	return nil
+}
+
+// TestExpiryReminders checks that reminders go out once per window, only to
+// bonuses with wagering left, and say how much is left
+func TestExpiryReminders(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	playerID := uuid.NewString()
+	newBonus := func(expiresIn time.Duration, completed int64) *bonus.PlayerBonus {
+		return &bonus.PlayerBonus{
+			PlayerBonusID: uuid.NewString(), PlayerID: playerID, Status: bonus.BonusStatusActive,
+			WageringRequired: decimal.NewFromInt(100), WageringCompleted: decimal.NewFromInt(completed),
+			ExpiresAt: now.Add(expiresIn),
+		}
+	}
+	tomorrow := newBonus(20*time.Hour, 10)
+	soon := newBonus(30*time.Minute, 40)
+	wagered := newBonus(20*time.Hour, 100)
+	later := newBonus(48*time.Hour, 0)
+
+	repo := &memoryBonusRepo{bonuses: make(map[string]*bonus.PlayerBonus)}
+	for _, b := range []*bonus.PlayerBonus{tomorrow, soon, wagered, later} {
+		repo.bonuses[b.PlayerBonusID] = b
+	}
+	service := bonus.NewBonusService(nil, repo)
+	updates, err := service.SubscribeToWageringUpdates(playerID)
+	require.NoError(t, err)
+	outbox := &memoryOutbox{notifications: make(map[string]bonus.OutboxNotification)}
+	watcher := bonus.NewExpiryWatcher(service, outbox, []time.Duration{time.Hour, 24 * time.Hour})
+
+	drain := func() map[string]bonus.WageringUpdate {
+		got := make(map[string]bonus.WageringUpdate)
+		for {
+			select {
+			case u := <-updates:
+				got[u.PlayerBonusID+"/"+u.ReminderWindow] = u
+			default:
+				return got
+			}
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := watcher.Sweep(ctx, now)
+		require.NoError(t, err)
+	}
+	got := drain()
+	require.Len(t, got, 2, "one reminder each, however often the sweep runs")
+	require.Equal(t, bonus.UpdateEventExpiringSoon, got[tomorrow.PlayerBonusID+"/24h"].EventType)
+	require.True(t, decimal.NewFromInt(90).Equal(got[tomorrow.PlayerBonusID+"/24h"].WageringRemaining))
+	require.True(t, decimal.NewFromInt(60).Equal(got[soon.PlayerBonusID+"/1h"].WageringRemaining),
+		"a bonus first seen inside the 1h window gets only that reminder")
+	require.Len(t, outbox.notifications, 2)
+
+	soon.Status = bonus.BonusStatusCompleted
+	_, err = watcher.Sweep(ctx, now.Add(19*time.Hour+30*time.Minute))
+	require.NoError(t, err)
+	got = drain()
+	require.Len(t, got, 1)
+	require.Contains(t, got, tomorrow.PlayerBonusID+"/1h")
+}
+
+// TestBetAfterExpirySweep checks that a bet placed before its bonus expired,
+// but delivered after the sweep expired it, is dead-lettered instead of
+// dropped, while a bet placed after expiry is still just rejected
+func TestBetAfterExpirySweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	playerID := uuid.NewString()
+	expired := &bonus.PlayerBonus{
+		PlayerBonusID: uuid.NewString(), PlayerID: playerID, Status: bonus.BonusStatusExpired,
+		WageringRequired: decimal.NewFromInt(100), CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour),
+	}
+	repo := &memoryBonusRepo{bonuses: map[string]*bonus.PlayerBonus{expired.PlayerBonusID: expired}}
+	deadLetters := &memoryDeadLetterRepo{attempts: make(map[string]int)}
+	dlq := bonus.NewDeadLetterQueue(bonus.NewBonusService(nil, repo), deadLetters)
+
+	bet := func(id string, placedAt time.Time) bonus.BetEvent {
+		return bonus.BetEvent{BetID: id, PlayerID: playerID, GameID: uuid.NewString(), BetAmount: decimal.NewFromInt(10), Timestamp: placedAt}
+	}
+	require.NoError(t, dlq.ProcessBetWagering(ctx, bet("in-window", now.Add(-90*time.Minute))))
+	require.NoError(t, dlq.ProcessBetWagering(ctx, bet("after-expiry", now.Add(-30*time.Minute))))
+	require.Equal(t, map[string]int{"in-window": 1}, deadLetters.attempts)
+}
+
+// TestOutboxRelay checks that the relay publishes notifications oldest
+// first, stops at a failed delivery and picks up from it on the next drain
+func TestOutboxRelay(t *testing.T) {
+	ctx := context.Background()
+	outbox := &memoryOutbox{notifications: make(map[string]bonus.OutboxNotification)}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		id := uuid.NewString()
+		outbox.notifications[id] = bonus.OutboxNotification{
+			NotificationID: id, DedupeKey: id, EventType: bonus.UpdateEventExpiringSoon,
+			Payload: fmt.Sprintf(`{"seq":%d}`, i), CreatedAt: start.Add(time.Duration(i) * time.Second),
+		}
+	}
+
+	var mu sync.Mutex
+	var received []string
+	failAt := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		require.NotEmpty(t, r.Header.Get("Idempotency-Key"))
+		require.Equal(t, bonus.UpdateEventExpiringSoon, r.Header.Get("X-Event-Type"))
+		if len(received) == failAt {
+			failAt = -1
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, string(body))
+	}))
+	defer server.Close()
+
+	relay := bonus.NewOutboxRelay(outbox, bonus.NewWebhookPublisher(server.URL), 2)
+	published, err := relay.Drain(ctx)
+	require.Error(t, err)
+	require.Equal(t, 2, published)
+
+	published, err = relay.Drain(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, published)
+	require.Equal(t, []string{`{"seq":0}`, `{"seq":1}`, `{"seq":2}`, `{"seq":3}`, `{"seq":4}`}, received)
+
+	published, err = relay.Drain(ctx)
+	require.NoError(t, err)
+	require.Zero(t, published)
+}
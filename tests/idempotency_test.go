@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"wallet_service/internal/idempotency"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// TestIdempotencyKey checks that a retried request replays the stored
+// response, a different body under the same key is refused, a concurrent
+// duplicate conflicts and server errors can be retried
+func TestIdempotencyKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	failNext := atomic.Bool{}
+
+	r := gin.New()
+	r.Use(idempotency.NewMiddleware(idempotency.NewMemoryStore(), idempotency.Config{}).Handler())
+	r.POST("/transfers", func(c *gin.Context) {
+		n := calls.Add(1)
+		if c.Query("wait") != "" {
+			<-release
+		}
+		if failNext.CompareAndSwap(true, false) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "boom"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"call": n, "at": time.Now().UnixNano()})
+	})
+
+	post := func(target string, key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(idempotency.HeaderKey, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("/transfers", "k1", `{"amount":10}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+	again := post("/transfers", "k1", `{"amount":10}`)
+	require.Equal(t, http.StatusCreated, again.Code)
+	require.Equal(t, first.Body.Bytes(), again.Body.Bytes(), "the stored response is replayed byte for byte")
+	require.Equal(t, "true", again.Header().Get(idempotency.HeaderReplayed))
+	require.Equal(t, int32(1), calls.Load())
+
+	require.Equal(t, http.StatusUnprocessableEntity, post("/transfers", "k1", `{"amount":11}`).Code)
+	require.Equal(t, http.StatusCreated, post("/transfers", "", `{"amount":10}`).Code, "requests without a key are not deduplicated")
+	require.Equal(t, int32(2), calls.Load())
+
+	// A duplicate of a request that is still running conflicts
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post("/transfers?wait=1", "k2", `{}`) }()
+	require.Eventually(t, func() bool { return calls.Load() == 3 }, time.Second, time.Millisecond)
+	require.Equal(t, http.StatusConflict, post("/transfers?wait=1", "k2", `{}`).Code)
+	close(release)
+	require.Equal(t, http.StatusCreated, (<-done).Code)
+
+	// Server errors are not stored
+	failNext.Store(true)
+	require.Equal(t, http.StatusInternalServerError, post("/transfers", "k3", `{}`).Code)
+	retried := post("/transfers", "k3", `{}`)
+	require.Equal(t, http.StatusCreated, retried.Code)
+	require.Contains(t, retried.Body.String(), fmt.Sprintf(`"call":%d`, calls.Load()))
+}
+
+// TestIdempotencyKeyAfterRateLimit checks that refusals from middleware
+// behind the idempotency check, here a rate limit and a role check, are not
+// replayed: a retry with the same key runs the handler
+func TestIdempotencyKeyAfterRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var calls atomic.Int32
+	var limited, forbidden atomic.Bool
+
+	r := gin.New()
+	r.Use(idempotency.NewMiddleware(idempotency.NewMemoryStore(), idempotency.Config{}).Handler())
+	r.POST("/payments", func(c *gin.Context) {
+		if forbidden.CompareAndSwap(true, false) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		if limited.CompareAndSwap(true, false) {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+	}, func(c *gin.Context) {
+		calls.Add(1)
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	post := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(`{"amount":10}`))
+		req.Header.Set(idempotency.HeaderKey, "pay-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	limited.Store(true)
+	require.Equal(t, http.StatusTooManyRequests, post())
+	forbidden.Store(true)
+	require.Equal(t, http.StatusForbidden, post())
+	require.Zero(t, calls.Load())
+
+	require.Equal(t, http.StatusCreated, post())
+	require.Equal(t, int32(1), calls.Load())
+	require.Equal(t, http.StatusCreated, post(), "the handler's response is stored and replayed")
+	require.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"wallet_service/internal/bonus"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingProcessor struct {
+	mu   sync.Mutex
+	seen map[string][]string
+}
+
+func (p *recordingProcessor) ProcessBetWagering(ctx context.Context, bet bonus.BetEvent) error {
+	time.Sleep(time.Millisecond)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seen[bet.PlayerID] = append(p.seen[bet.PlayerID], bet.BetID)
+	return nil
+}
+
+// TestIngestorPreservesPerPlayerOrder tests that bets for one player are applied in submission order
+// and that Shutdown drains everything already queued
+func TestIngestorPreservesPerPlayerOrder(t *testing.T) {
+	processor := &recordingProcessor{seen: make(map[string][]string)}
+	ingestor := bonus.NewIngestor(processor, bonus.IngestorConfig{Workers: 4, QueueSize: 8})
+	ingestor.Start()
+
+	numPlayers := 10
+	betsPerPlayer := 20
+
+	var wg sync.WaitGroup
+	for p := 0; p < numPlayers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for b := 0; b < betsPerPlayer; b++ {
+				err := ingestor.Submit(context.Background(), bonus.BetEvent{
+					BetID:     fmt.Sprintf("bet-%d", b),
+					PlayerID:  fmt.Sprintf("player-%d", p),
+					BetAmount: decimal.NewFromInt(1),
+					Timestamp: time.Now(),
+				})
+				require.NoError(t, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, ingestor.Shutdown(ctx))
+
+	for p := 0; p < numPlayers; p++ {
+		seen := processor.seen[fmt.Sprintf("player-%d", p)]
+		require.Len(t, seen, betsPerPlayer)
+		for b, betID := range seen {
+			require.Equal(t, fmt.Sprintf("bet-%d", b), betID)
+		}
+	}
+
+	stats := ingestor.Stats()
+	require.Equal(t, int64(numPlayers*betsPerPlayer), stats.Processed)
+	require.Equal(t, 0, stats.QueueDepth)
+	require.ErrorIs(t, ingestor.TrySubmit(bonus.BetEvent{PlayerID: "late"}), bonus.ErrIngestorClosed)
+}
+
+type gatedProcessor struct {
+	gate chan struct{}
+}
+
+func (p *gatedProcessor) ProcessBetWagering(ctx context.Context, bet bonus.BetEvent) error {
+	<-p.gate
+	return nil
+}
+
+// TestIngestorShutdownReleasesBlockedSubmit tests that Shutdown does not wait on a submitter
+// blocked by a full shard, and that the blocked submitter is told the ingestor closed
+func TestIngestorShutdownReleasesBlockedSubmit(t *testing.T) {
+	processor := &gatedProcessor{gate: make(chan struct{})}
+	ingestor := bonus.NewIngestor(processor, bonus.IngestorConfig{Workers: 1, QueueSize: 1})
+	ingestor.Start()
+
+	bet := bonus.BetEvent{PlayerID: "p1", BetAmount: decimal.NewFromInt(1)}
+	require.NoError(t, ingestor.Submit(context.Background(), bet))
+	// The worker holds the first bet; wait until the queue has room for one more
+	require.Eventually(t, func() bool { return ingestor.Stats().QueueDepth == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, ingestor.Submit(context.Background(), bet))
+
+	blocked := make(chan error, 1)
+	go func() { blocked <- ingestor.Submit(context.Background(), bet) }()
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() { shutdown <- ingestor.Shutdown(ctx) }()
+
+	select {
+	case err := <-blocked:
+		require.ErrorIs(t, err, bonus.ErrIngestorClosed)
+	case <-time.After(time.Second):
+		t.Fatal("blocked Submit was not released by Shutdown")
+	}
+	close(processor.gate)
+	require.NoError(t, <-shutdown)
+	require.Equal(t, int64(2), ingestor.Stats().Processed)
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"wallet_service/internal/bonus"
+	"wallet_service/internal/metrics"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+// lockingWalletRepo is a wallet repository in memory that loses the
+// optimistic lock a set number of times before each write succeeds
+type lockingWalletRepo struct {
+	mu         sync.Mutex
+	wallets    map[string]*wallet.Wallet
+	lockLosses int
+}
+
+func (r *lockingWalletRepo) GetBalance(ctx context.Context, playerID string, walletType string, currency string) (*wallet.Wallet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.wallets[playerID]
+	if !ok {
+		return nil, wallet.ErrWalletNotFound
+	}
+	copied := *w
+	return &copied, nil
+}
+
+func (r *lockingWalletRepo) GetTransactionByReference(ctx context.Context, referenceID string, transactionType string) (*wallet.Transaction, error) {
+	return nil, nil
+}
+
+func (r *lockingWalletRepo) CreateWallet(ctx context.Context, playerID string, walletType string, currency string) (*wallet.Wallet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w := &wallet.Wallet{WalletID: uuid.NewString(), PlayerID: playerID, WalletType: walletType, Currency: currency}
+	r.wallets[playerID] = w
+	copied := *w
+	return &copied, nil
+}
+
+func (r *lockingWalletRepo) Credit(ctx context.Context, tx *wallet.Transaction) error {
+	return r.apply(tx, tx.Amount)
+}
+
+func (r *lockingWalletRepo) Debit(ctx context.Context, tx *wallet.Transaction) error {
+	return r.apply(tx, tx.Amount.Neg())
+}
+
+func (r *lockingWalletRepo) apply(tx *wallet.Transaction, delta decimal.Decimal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lockLosses > 0 {
+		r.lockLosses--
+		return wallet.ErrOptimisticLock
+	}
+	w := r.wallets[tx.PlayerID]
+	if w.Balance.Add(delta).IsNegative() {
+		return wallet.ErrInsufficientFunds
+	}
+	tx.TransactionID = uuid.NewString()
+	tx.BalanceBefore, tx.BalanceAfter = w.Balance, w.Balance.Add(delta)
+	tx.Status = "completed"
+	w.Balance = tx.BalanceAfter
+	return nil
+}
+
+// scrapeMetrics reads /metrics into a map from series to value
+func scrapeMetrics(t *testing.T) map[string]float64 {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, w.Code)
+	series := make(map[string]float64)
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		require.NoError(t, err)
+		series[line[:i]] = value
+	}
+	return series
+}
+
+// TestMetrics checks that wallet outcomes, optimistic lock retries and
+// exhaustion, DB latency and notification hub state show up on /metrics
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	repo := &lockingWalletRepo{wallets: make(map[string]*wallet.Wallet)}
+	service := wallet.NewService(repo)
+	playerID := uuid.NewString()
+	deposit := func(amount int64) error {
+		_, err := service.ProcessTransaction(ctx, wallet.TransactionRequest{
+			PlayerID: playerID, WalletType: wallet.WalletTypeMain, Currency: "USD",
+			TransactionType: wallet.TransactionTypeDeposit, Amount: decimal.NewFromInt(amount), ReferenceID: uuid.NewString(),
+		})
+		return err
+	}
+
+	hub := bonus.NewNotificationHub()
+	metrics.RegisterHub(hub)
+	before := scrapeMetrics(t)
+
+	// Two lost locks are retried; a third attempt succeeds
+	repo.lockLosses = 2
+	require.NoError(t, deposit(10))
+	// Losing every attempt exhausts the retries
+	repo.lockLosses = wallet.MaxRetries
+	require.ErrorIs(t, deposit(10), wallet.ErrOptimisticLock)
+	_, err := service.ProcessTransaction(ctx, wallet.TransactionRequest{
+		PlayerID: playerID, WalletType: wallet.WalletTypeMain, Currency: "USD",
+		TransactionType: wallet.TransactionTypeWithdrawal, Amount: decimal.NewFromInt(50), ReferenceID: uuid.NewString(),
+	})
+	require.ErrorIs(t, err, wallet.ErrInsufficientFunds)
+	_, err = service.ProcessTransaction(ctx, wallet.TransactionRequest{
+		PlayerID: playerID, WalletType: wallet.WalletTypeMain, Currency: "USD",
+		TransactionType: "made-up-" + uuid.NewString(), Amount: decimal.NewFromInt(1), ReferenceID: uuid.NewString(),
+	})
+	require.Error(t, err)
+
+	updates := hub.Subscribe(playerID)
+	for i := 0; i < cap(updates)+3; i++ {
+		hub.Notify(playerID, bonus.WageringUpdate{PlayerID: playerID})
+	}
+
+	after := scrapeMetrics(t)
+	delta := func(series string) float64 { return after[series] - before[series] }
+	require.Equal(t, 1.0, delta(`wallet_transactions_total{outcome="completed",type="deposit"}`))
+	require.Equal(t, 1.0, delta(`wallet_transactions_total{outcome="failed",type="deposit"}`))
+	require.Equal(t, 1.0, delta(`wallet_transactions_total{outcome="insufficient_funds",type="withdrawal"}`))
+	require.Equal(t, 1.0, delta(`wallet_insufficient_funds_total{type="withdrawal"}`))
+	require.Equal(t, 1.0, delta(`wallet_transactions_total{outcome="failed",type="invalid"}`), "unknown types share one label value")
+	require.Equal(t, 4.0, delta(`wallet_optimistic_lock_retries_total`))
+	require.Equal(t, 1.0, delta(`wallet_optimistic_lock_exhausted_total`))
+	require.Equal(t, 1.0, after[`notification_hub_subscribers`])
+	require.Equal(t, 3.0, after[`notification_hub_dropped_updates_total`])
+	require.Contains(t, after, `wagering_lag_ms_count`)
+	require.Contains(t, after, `events_processed_sec`)
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"testing"
+	"time"
+	"wallet_service/internal/bonus"
+
+	"github.com/stretchr/testify/require"
+)
+
+func progressUpdate(playerID string, pct float64) bonus.WageringUpdate {
+	return bonus.WageringUpdate{PlayerBonusID: "b1", PlayerID: playerID, PercentageComplete: pct, Timestamp: time.Now()}
+}
+
+func receive(t *testing.T, ch <-chan bonus.WageringUpdate, timeout time.Duration) bonus.WageringUpdate {
+	t.Helper()
+	select {
+	case update := <-ch:
+		return update
+	case <-time.After(timeout):
+		t.Fatal("no update received")
+		return bonus.WageringUpdate{}
+	}
+}
+
+func requireNoUpdate(t *testing.T, ch <-chan bonus.WageringUpdate) {
+	t.Helper()
+	select {
+	case update := <-ch:
+		t.Fatalf("unexpected update: %+v", update)
+	default:
+	}
+}
+
+// TestNotifierThrottlesProgress checks that a burst of progress updates is
+// cut down to the first and the latest, while milestones and events are
+// delivered at once
+func TestNotifierThrottlesProgress(t *testing.T) {
+	const throttle = 100 * time.Millisecond
+	hub := bonus.NewNotificationHub()
+	notifier := bonus.NewNotifier(hub, bonus.NotificationRules{Milestones: bonus.DefaultMilestones, Throttle: throttle})
+	ch := hub.Subscribe("p1")
+
+	for _, pct := range []float64{1, 2, 3, 4} {
+		notifier.Progress(progressUpdate("p1", pct), pct-1)
+	}
+	first := receive(t, ch, time.Second)
+	require.Equal(t, bonus.UpdateEventProgress, first.EventType)
+	require.Equal(t, 1.0, first.PercentageComplete)
+	requireNoUpdate(t, ch)
+
+	latest := receive(t, ch, time.Second)
+	require.Equal(t, 4.0, latest.PercentageComplete, "the held back update is the latest one")
+	requireNoUpdate(t, ch)
+
+	// A milestone is not throttled and replaces a held back update
+	notifier.Progress(progressUpdate("p1", 10), 4)
+	notifier.Progress(progressUpdate("p1", 30), 10)
+	milestone := receive(t, ch, 10*time.Millisecond)
+	require.Equal(t, bonus.UpdateEventMilestone, milestone.EventType)
+	require.Equal(t, 25, milestone.Milestone)
+	time.Sleep(2 * throttle)
+	requireNoUpdate(t, ch)
+
+	// Jumping past several milestones announces the highest
+	notifier.Progress(progressUpdate("p1", 80), 30)
+	require.Equal(t, 75, receive(t, ch, 10*time.Millisecond).Milestone)
+
+	notifier.Event(bonus.WageringUpdate{EventType: bonus.UpdateEventForfeited, PlayerBonusID: "b1", PlayerID: "p1"})
+	require.Equal(t, bonus.UpdateEventForfeited, receive(t, ch, 10*time.Millisecond).EventType)
+
+	// Other players have their own window
+	other := hub.Subscribe("p2")
+	notifier.Progress(progressUpdate("p2", 1), 0)
+	require.Equal(t, "p2", receive(t, other, 10*time.Millisecond).PlayerID)
+}
+
+// TestParseMilestones checks the NOTIFY_MILESTONES format
+func TestParseMilestones(t *testing.T) {
+	milestones, err := bonus.ParseMilestones("50, 25,100")
+	require.NoError(t, err)
+	require.Equal(t, []int{25, 50, 100}, milestones)
+
+	_, err = bonus.ParseMilestones("25,150")
+	require.Error(t, err)
+}
@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"wallet_service/internal/payment"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryPaymentRepo keeps payment intents and webhooks in memory
+type memoryPaymentRepo struct {
+	mu       sync.Mutex
+	intents  map[string]*payment.PaymentIntent
+	webhooks map[string]*payment.PaymentWebhook
+}
+
+func newMemoryPaymentRepo() *memoryPaymentRepo {
+	return &memoryPaymentRepo{intents: make(map[string]*payment.PaymentIntent), webhooks: make(map[string]*payment.PaymentWebhook)}
+}
+
+func (r *memoryPaymentRepo) CreateIntent(ctx context.Context, intent *payment.PaymentIntent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *intent
+	r.intents[intent.IntentID] = &copied
+	return nil
+}
+
+func (r *memoryPaymentRepo) GetIntent(ctx context.Context, intentID string) (*payment.PaymentIntent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	intent, ok := r.intents[intentID]
+	if !ok {
+		return nil, payment.ErrIntentNotFound
+	}
+	copied := *intent
+	return &copied, nil
+}
+
+func (r *memoryPaymentRepo) SetProviderReference(ctx context.Context, intentID string, reference string, redirectURL string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.intents[intentID].ProviderReference = reference
+	r.intents[intentID].RedirectURL = redirectURL
+	return nil
+}
+
+func (r *memoryPaymentRepo) FailIntent(ctx context.Context, intentID string, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if intent := r.intents[intentID]; intent.Status == payment.StatusPending {
+		intent.Status, intent.FailureReason = payment.StatusFailed, reason
+	}
+	return nil
+}
+
+func (r *memoryPaymentRepo) GetWebhook(ctx context.Context, pspTransactionID string) (*payment.PaymentWebhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if webhook, ok := r.webhooks[pspTransactionID]; ok {
+		return webhook, nil
+	}
+	return nil, payment.ErrWebhookNotFound
+}
+
+func (r *memoryPaymentRepo) SettleIntent(ctx context.Context, intentID string, settlement payment.Settlement) (*payment.PaymentIntent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	intent, ok := r.intents[intentID]
+	if !ok {
+		return nil, payment.ErrIntentNotFound
+	}
+	if _, ok := r.webhooks[settlement.Webhook.PSPTransactionID]; !ok {
+		r.webhooks[settlement.Webhook.PSPTransactionID] = settlement.Webhook
+	}
+	if intent.Status == payment.StatusPending {
+		intent.Status = settlement.Status
+		intent.PSPTransactionID = settlement.Webhook.PSPTransactionID
+		intent.FailureReason = settlement.FailureReason
+	}
+	copied := *intent
+	return &copied, nil
+}
+
+func (r *memoryPaymentRepo) SetWalletTransaction(ctx context.Context, intentID string, transactionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.intents[intentID].WalletTransactionID = &transactionID
+	return nil
+}
+
+// TestPaymentFlow runs deposits and payouts against the mock PSP: a deposit
+// is credited only by its webhook, redelivered webhooks change nothing,
+// forged webhooks are refused and a failed payout is returned to the player
+func TestPaymentFlow(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("webhook-secret")
+	wal := newMemoryWallet()
+	repo := newMemoryPaymentRepo()
+	playerID := uuid.NewString()
+
+	mock := payment.NewMockPSP("api-key", secret, "")
+	pspServer := httptest.NewServer(mock)
+	defer pspServer.Close()
+
+	payments := payment.NewService(repo, payment.NewHTTPProvider("mock", pspServer.URL, "api-key", secret), wal)
+	webhooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if _, err := payments.HandleWebhook(r.Context(), r.Header, body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer webhooks.Close()
+	mock.SetWebhookURL(webhooks.URL)
+
+	balance := func() decimal.Decimal {
+		w, err := wal.GetBalance(ctx, playerID, wallet.WalletTypeMain, "EUR")
+		if err != nil {
+			return decimal.Zero
+		}
+		return w.Balance
+	}
+
+	deposit, err := payments.CreateDeposit(ctx, payment.IntentRequest{PlayerID: playerID, Currency: "EUR", Amount: decimal.NewFromInt(100)})
+	require.NoError(t, err)
+	require.Equal(t, payment.StatusPending, deposit.Status)
+	require.NotEmpty(t, deposit.RedirectURL)
+	require.True(t, balance().IsZero(), "a pending deposit credits nothing")
+
+	_, err = mock.Settle("payment", deposit.ProviderReference, payment.StatusSucceeded, false)
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(100).Equal(balance()))
+	deposit, err = payments.GetIntent(ctx, deposit.IntentID)
+	require.NoError(t, err)
+	require.Equal(t, payment.StatusSucceeded, deposit.Status)
+	require.NotNil(t, deposit.WalletTransactionID)
+
+	// The PSP redelivers the same event
+	_, err = mock.Settle("payment", deposit.ProviderReference, "", true)
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(100).Equal(balance()))
+
+	// A webhook signed with another secret, or not at all, is refused
+	forged, err := json.Marshal(payment.WebhookEvent{
+		TransactionID: "evt_forged", Object: "payment", Reference: deposit.ProviderReference,
+		MerchantReference: deposit.IntentID, Status: payment.StatusSucceeded, Amount: deposit.Amount, Currency: "EUR",
+	})
+	require.NoError(t, err)
+	for _, signature := range []string{payment.SignWebhook([]byte("wrong"), time.Now(), forged), ""} {
+		req, err := http.NewRequest(http.MethodPost, webhooks.URL, bytes.NewReader(forged))
+		require.NoError(t, err)
+		req.Header.Set(payment.HeaderWebhookSignature, signature)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	}
+	require.True(t, decimal.NewFromInt(100).Equal(balance()))
+
+	// A failed deposit credits nothing
+	declined, err := payments.CreateDeposit(ctx, payment.IntentRequest{PlayerID: playerID, Currency: "EUR", Amount: decimal.NewFromInt(50)})
+	require.NoError(t, err)
+	_, err = mock.Settle("payment", declined.ProviderReference, payment.StatusFailed, false)
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(100).Equal(balance()))
+
+	// A payout holds the funds at once and returns them when it fails
+	payout, err := payments.CreatePayout(ctx, payment.IntentRequest{PlayerID: playerID, Currency: "EUR", Amount: decimal.NewFromInt(40)})
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(60).Equal(balance()))
+	_, err = mock.Settle("payout", payout.ProviderReference, payment.StatusFailed, false)
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(100).Equal(balance()))
+	_, err = mock.Settle("payout", payout.ProviderReference, "", true)
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(100).Equal(balance()), "a failed payout is returned once")
+
+	payout, err = payments.CreatePayout(ctx, payment.IntentRequest{PlayerID: playerID, Currency: "EUR", Amount: decimal.NewFromInt(30)})
+	require.NoError(t, err)
+	_, err = mock.Settle("payout", payout.ProviderReference, payment.StatusSucceeded, false)
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(70).Equal(balance()))
+
+	_, err = payments.CreatePayout(ctx, payment.IntentRequest{PlayerID: playerID, Currency: "EUR", Amount: decimal.NewFromInt(500)})
+	require.ErrorIs(t, err, wallet.ErrInsufficientFunds)
+
+	// Conflicting events for one payout delivered at once: whichever
+	// settles the intent decides whether the funds come back
+	payout, err = payments.CreatePayout(ctx, payment.IntentRequest{PlayerID: playerID, Currency: "EUR", Amount: decimal.NewFromInt(20)})
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(50).Equal(balance()))
+	var wg sync.WaitGroup
+	for _, status := range []string{payment.StatusSucceeded, payment.StatusFailed} {
+		body, err := json.Marshal(payment.WebhookEvent{
+			TransactionID: "evt_" + status, Object: "payout", Reference: payout.ProviderReference,
+			MerchantReference: payout.IntentID, Status: status, Amount: payout.Amount, Currency: "EUR",
+		})
+		require.NoError(t, err)
+		header := http.Header{}
+		header.Set(payment.HeaderWebhookSignature, payment.SignWebhook(secret, time.Now(), body))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := payments.HandleWebhook(ctx, header, body)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	payout, err = payments.GetIntent(ctx, payout.IntentID)
+	require.NoError(t, err)
+	if payout.Status == payment.StatusSucceeded {
+		require.True(t, decimal.NewFromInt(50).Equal(balance()), "a succeeded payout is not refunded")
+	} else {
+		require.True(t, decimal.NewFromInt(70).Equal(balance()), "a failed payout is refunded once")
+	}
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+	"wallet_service/internal/bonus"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// checkpointRepo records what the Redis store writes back to Postgres.
+// Methods the store does not call on this path are left to the nil
+// embedded interface.
+type checkpointRepo struct {
+	bonus.BonusRepository
+
+	mu          sync.Mutex
+	events      map[string]bool
+	checkpoints map[string]decimal.Decimal
+	ended       map[string]bool // bonuses no longer active in Postgres
+}
+
+func (r *checkpointRepo) CreateWageringEventIfAbsent(ctx context.Context, tx *gorm.DB, event *bonus.WageringEvent) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.events[event.BetID] {
+		return false, nil
+	}
+	r.events[event.BetID] = true
+	return true, nil
+}
+
+func (r *checkpointRepo) CreateWageringEventIfActive(ctx context.Context, tx *gorm.DB, event *bonus.WageringEvent) (bool, error) {
+	r.mu.Lock()
+	ended := r.ended[event.PlayerBonusID]
+	r.mu.Unlock()
+	if ended {
+		return false, bonus.ErrBonusNotActive
+	}
+	return r.CreateWageringEventIfAbsent(ctx, tx, event)
+}
+
+func (r *checkpointRepo) CheckpointWageringProgress(ctx context.Context, tx *gorm.DB, playerBonusID string, progress decimal.Decimal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ended[playerBonusID] {
+		return bonus.ErrBonusNotActive
+	}
+	r.checkpoints[playerBonusID] = progress
+	return nil
+}
+
+func testRedisProgressStore(t *testing.T, client *redis.Client) {
+	ctx := context.Background()
+	repo := &checkpointRepo{events: make(map[string]bool), checkpoints: make(map[string]decimal.Decimal), ended: make(map[string]bool)}
+	store := bonus.NewRedisProgressStore(client, nil, repo)
+
+	playerBonus := &bonus.PlayerBonus{
+		PlayerBonusID:     uuid.NewString(),
+		Status:            bonus.BonusStatusActive,
+		WageringRequired:  decimal.NewFromInt(1000),
+		WageringCompleted: decimal.NewFromInt(100), // progress made before Redis took over
+		ExpiresAt:         time.Now().Add(24 * time.Hour),
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = store.ApplyWagering(ctx, playerBonus, &bonus.WageringEvent{
+				BetID:                uuid.NewString(),
+				PlayerBonusID:        playerBonus.PlayerBonusID,
+				WageringContribution: decimal.RequireFromString("10.10"),
+			})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	// A redelivered bet is not counted again
+	duplicate := &bonus.WageringEvent{BetID: uuid.NewString(), PlayerBonusID: playerBonus.PlayerBonusID, WageringContribution: decimal.NewFromInt(5)}
+	first, err := store.ApplyWagering(ctx, playerBonus, duplicate)
+	require.NoError(t, err)
+	require.True(t, first.Applied)
+	second, err := store.ApplyWagering(ctx, playerBonus, duplicate)
+	require.NoError(t, err)
+	require.False(t, second.Applied)
+
+	expected := decimal.RequireFromString("307.00") // 100 + 20 * 10.10 + 5
+	progress, err := store.GetProgress(ctx, playerBonus)
+	require.NoError(t, err)
+	require.True(t, expected.Equal(progress), "expected %s, got %s", expected, progress)
+
+	written, err := store.Checkpoint(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, written)
+	require.True(t, expected.Equal(repo.checkpoints[playerBonus.PlayerBonusID]))
+
+	written, err = store.Checkpoint(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, written, "nothing changed since the last checkpoint")
+
+	// Forfeited in Postgres while the caller still holds an active snapshot
+	repo.mu.Lock()
+	repo.ended[playerBonus.PlayerBonusID] = true
+	repo.mu.Unlock()
+	late := &bonus.WageringEvent{BetID: uuid.NewString(), PlayerBonusID: playerBonus.PlayerBonusID, WageringContribution: decimal.NewFromInt(50)}
+	_, err = store.ApplyWagering(ctx, playerBonus, late)
+	require.ErrorIs(t, err, bonus.ErrBonusNotActive)
+	progress, err = store.GetProgress(ctx, playerBonus)
+	require.NoError(t, err)
+	require.True(t, expected.Equal(progress), "an ended bonus gains no progress, got %s", progress)
+	require.False(t, repo.events[late.BetID])
+
+	// A counter left dirty for an ended bonus is dropped rather than written
+	_, err = client.SAdd(ctx, "wagering:dirty", playerBonus.PlayerBonusID).Result()
+	require.NoError(t, err)
+	_, err = store.Checkpoint(ctx)
+	require.NoError(t, err)
+	exists, err := client.Exists(ctx, "wagering:progress:"+playerBonus.PlayerBonusID).Result()
+	require.NoError(t, err)
+	require.Zero(t, exists)
+}
+
+// TestRedisProgressStoreInMemory runs the Redis progress store against an in-memory fake
+func TestRedisProgressStoreInMemory(t *testing.T) {
+	server := miniredis.RunT(t)
+	testRedisProgressStore(t, redis.NewClient(&redis.Options{Addr: server.Addr()}))
+}
+
+// TestRedisProgressStoreLocal runs the Redis progress store against the redis-server from docker-compose
+func TestRedisProgressStoreLocal(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6380"
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Redis not available at %s: %v", addr, err)
+	}
+	testRedisProgressStore(t, client)
+}
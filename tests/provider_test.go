@@ -0,0 +1,262 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"wallet_service/internal/provider"
+	"wallet_service/internal/wallet"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryWallet keeps main wallet balances and transactions in memory, with
+// the same reference and type idempotency as wallet.Service. Bets,
+// withdrawals and bonus forfeits are debits, everything else a credit.
+type memoryWallet struct {
+	mu           sync.Mutex
+	balances     map[string]decimal.Decimal
+	transactions map[string]*wallet.Transaction
+}
+
+func newMemoryWallet() *memoryWallet {
+	return &memoryWallet{balances: make(map[string]decimal.Decimal), transactions: make(map[string]*wallet.Transaction)}
+}
+
+func (w *memoryWallet) ProcessTransaction(ctx context.Context, req wallet.TransactionRequest) (*wallet.TransactionResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := req.ReferenceID + "|" + req.TransactionType
+	if tx, ok := w.transactions[key]; ok {
+		if tx.PlayerID != req.PlayerID || !tx.Amount.Equal(req.Amount) {
+			return nil, wallet.ErrReferenceConflict
+		}
+		return &wallet.TransactionResponse{TransactionID: tx.TransactionID, Balance: tx.BalanceAfter, Status: tx.Status}, nil
+	}
+	if _, ok := w.transactions[req.ReferenceID+"|"+req.ExclusiveWith]; ok && req.ExclusiveWith != "" {
+		return nil, wallet.ErrReferenceClosed
+	}
+	before := w.balances[req.PlayerID]
+	after := before.Add(req.Amount)
+	switch req.TransactionType {
+	case wallet.TransactionTypeBet, wallet.TransactionTypeWithdrawal, wallet.TransactionTypeBonusForfeit:
+		if before.LessThan(req.Amount) {
+			return nil, wallet.ErrInsufficientFunds
+		}
+		after = before.Sub(req.Amount)
+	}
+	if after.IsNegative() {
+		return nil, wallet.ErrInsufficientFunds
+	}
+	tx := &wallet.Transaction{
+		TransactionID: uuid.NewString(), PlayerID: req.PlayerID, TransactionType: req.TransactionType,
+		Amount: req.Amount, BalanceBefore: before, BalanceAfter: after, ReferenceID: req.ReferenceID, Status: "completed",
+	}
+	w.transactions[key] = tx
+	w.balances[req.PlayerID] = after
+	return &wallet.TransactionResponse{TransactionID: tx.TransactionID, Balance: after, Status: tx.Status}, nil
+}
+
+func (w *memoryWallet) GetBalance(ctx context.Context, playerID string, walletType string, currency string) (*wallet.Wallet, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	balance, ok := w.balances[playerID]
+	if !ok {
+		return nil, wallet.ErrWalletNotFound
+	}
+	return &wallet.Wallet{PlayerID: playerID, WalletType: walletType, Currency: currency, Balance: balance}, nil
+}
+
+func (w *memoryWallet) GetTransaction(ctx context.Context, referenceID string, transactionType string) (*wallet.Transaction, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if tx, ok := w.transactions[referenceID+"|"+transactionType]; ok {
+		return tx, nil
+	}
+	return nil, wallet.ErrTransactionNotFound
+}
+
+// TestProviderAPI checks signed provider calls end to end: a launch token is
+// authenticated, a bet is debited, retried and rolled back, and forged or
+// replayed requests are refused
+func TestProviderAPI(t *testing.T) {
+	secret := []byte("aggregator-secret")
+	wal := newMemoryWallet()
+	playerID := uuid.NewString()
+	wal.balances[playerID] = decimal.NewFromInt(100)
+
+	tokens := provider.NewLaunchTokens([]byte("launch-secret"), time.Hour)
+	server := httptest.NewServer(provider.NewServer(
+		provider.NewAdapter(wal, tokens),
+		provider.NewVerifier(map[string][]byte{"agg": secret}, provider.NewMemoryNonceStore(), time.Minute),
+	))
+	defer server.Close()
+
+	type signed struct {
+		timestamp time.Time
+		nonce     string
+		key       []byte
+	}
+	call := func(path string, body interface{}, s signed) (int, provider.Response) {
+		payload, err := json.Marshal(body)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, server.URL+path, bytes.NewReader(payload))
+		require.NoError(t, err)
+		ts := strconv.FormatInt(s.timestamp.Unix(), 10)
+		req.Header.Set(provider.HeaderProvider, "agg")
+		req.Header.Set(provider.HeaderTimestamp, ts)
+		req.Header.Set(provider.HeaderNonce, s.nonce)
+		req.Header.Set(provider.HeaderSignature, provider.Sign(s.key, ts, s.nonce, payload))
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var out provider.Response
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&out))
+		return res.StatusCode, out
+	}
+	valid := func() signed { return signed{time.Now(), uuid.NewString(), secret} }
+
+	token, err := tokens.Issue(playerID, "EUR")
+	require.NoError(t, err)
+	status, res := call("/authenticate", provider.AuthenticateRequest{Token: token}, valid())
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, playerID, res.PlayerID)
+	require.True(t, decimal.NewFromInt(100).Equal(*res.Balance))
+
+	_, res = call("/authenticate", provider.AuthenticateRequest{Token: token + "x"}, valid())
+	require.Equal(t, provider.CodeInvalidToken, res.ErrorCode)
+
+	bet := provider.DebitRequest{
+		PlayerID: playerID, Currency: "EUR", Amount: decimal.NewFromInt(30),
+		GameID: "slots", RoundID: "r1", TransactionID: "t1",
+	}
+	_, res = call("/debit", bet, valid())
+	require.Equal(t, provider.CodeOK, res.ErrorCode)
+	require.True(t, decimal.NewFromInt(70).Equal(*res.Balance))
+	firstID := res.TransactionID
+
+	// A provider retry is a new request for the same transaction
+	retry := valid()
+	_, res = call("/debit", bet, retry)
+	require.Equal(t, firstID, res.TransactionID)
+	require.True(t, decimal.NewFromInt(70).Equal(*res.Balance))
+	_, err = wal.GetTransaction(context.Background(), provider.ReferenceID("agg", "r1", "t1"), wallet.TransactionTypeBet)
+	require.NoError(t, err)
+
+	// Reusing the transaction ID for another amount is not a retry
+	changed := bet
+	changed.Amount = decimal.NewFromInt(31)
+	status, res = call("/debit", changed, valid())
+	require.Equal(t, http.StatusConflict, status)
+	require.Equal(t, provider.CodeTransactionConflict, res.ErrorCode)
+
+	// Replaying the exact request is refused
+	status, res = call("/debit", bet, retry)
+	require.Equal(t, http.StatusConflict, status)
+	require.Equal(t, provider.CodeDuplicateRequest, res.ErrorCode)
+
+	status, res = call("/debit", bet, signed{time.Now(), uuid.NewString(), []byte("wrong")})
+	require.Equal(t, http.StatusUnauthorized, status)
+	require.Equal(t, provider.CodeInvalidSignature, res.ErrorCode)
+
+	status, res = call("/debit", bet, signed{time.Now().Add(-time.Hour), uuid.NewString(), secret})
+	require.Equal(t, http.StatusUnauthorized, status)
+	require.Equal(t, provider.CodeRequestExpired, res.ErrorCode)
+
+	big := bet
+	big.TransactionID, big.Amount = "t2", decimal.NewFromInt(500)
+	status, res = call("/debit", big, valid())
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, provider.CodeInsufficientFunds, res.ErrorCode)
+
+	rollback := provider.RollbackRequest{PlayerID: playerID, Currency: "EUR", RoundID: "r1", TransactionID: "t3", ReferenceTransactionID: "t1"}
+	for i := 0; i < 2; i++ {
+		_, res = call("/rollback", rollback, valid())
+		require.Equal(t, provider.CodeOK, res.ErrorCode)
+		require.True(t, decimal.NewFromInt(100).Equal(*res.Balance), "a repeated rollback returns the bet once")
+	}
+
+	rollback.ReferenceTransactionID = "unknown"
+	_, res = call("/rollback", rollback, valid())
+	require.Equal(t, provider.CodeTransactionNotFound, res.ErrorCode)
+
+	// The bet the rollback was for arrives late and is refused
+	late := bet
+	late.TransactionID = "unknown"
+	_, res = call("/debit", late, valid())
+	require.Equal(t, provider.CodeRolledBack, res.ErrorCode)
+	_, res = call("/rollback", rollback, valid())
+	require.Equal(t, provider.CodeTransactionNotFound, res.ErrorCode)
+	require.True(t, decimal.NewFromInt(100).Equal(wal.balances[playerID]))
+}
+
+// interleavedWallet runs hook once, right after the first transaction
+// lookup, i.e. between an adapter's check and its write
+type interleavedWallet struct {
+	*memoryWallet
+	once sync.Once
+	hook func()
+}
+
+func (w *interleavedWallet) GetTransaction(ctx context.Context, referenceID string, transactionType string) (*wallet.Transaction, error) {
+	tx, err := w.memoryWallet.GetTransaction(ctx, referenceID, transactionType)
+	w.once.Do(w.hook)
+	return tx, err
+}
+
+// TestProviderRollbackRace checks that a bet and its rollback that overlap
+// leave the balance unchanged whichever checks first, and that a bet that
+// got past a zero rollback can still be rolled back
+func TestProviderRollbackRace(t *testing.T) {
+	ctx := context.Background()
+	wal := newMemoryWallet()
+	playerID := uuid.NewString()
+	wal.balances[playerID] = decimal.NewFromInt(100)
+	tokens := provider.NewLaunchTokens([]byte("launch-secret"), time.Hour)
+	adapter := provider.NewAdapter(wal, tokens)
+
+	bet := provider.DebitRequest{PlayerID: playerID, Currency: "EUR", Amount: decimal.NewFromInt(10), GameID: "g", RoundID: "r1", TransactionID: "bet"}
+	rollback := provider.RollbackRequest{PlayerID: playerID, Currency: "EUR", RoundID: "r1", TransactionID: "rb", ReferenceTransactionID: "bet"}
+
+	// The rollback lands after the bet checked for one
+	var rollbackErr error
+	racing := provider.NewAdapter(&interleavedWallet{memoryWallet: wal, hook: func() {
+		_, rollbackErr = adapter.Rollback(ctx, "agg", rollback)
+	}}, tokens)
+	_, err := racing.Debit(ctx, "agg", bet)
+	require.ErrorIs(t, err, provider.ErrRolledBack)
+	require.ErrorIs(t, rollbackErr, wallet.ErrTransactionNotFound)
+	require.True(t, decimal.NewFromInt(100).Equal(wal.balances[playerID]))
+
+	// The bet lands after the rollback checked for it
+	bet.RoundID, rollback.RoundID = "r2", "r2"
+	var debitErr error
+	racing = provider.NewAdapter(&interleavedWallet{memoryWallet: wal, hook: func() {
+		_, debitErr = adapter.Debit(ctx, "agg", bet)
+	}}, tokens)
+	res, err := racing.Rollback(ctx, "agg", rollback)
+	require.NoError(t, debitErr)
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(100).Equal(*res.Balance), "the bet is rolled back")
+
+	// A zero rollback and its bet both recorded, as the race could leave them
+	reference := provider.ReferenceID("agg", "legacy", "bet")
+	_, err = wal.ProcessTransaction(ctx, wallet.TransactionRequest{PlayerID: playerID, TransactionType: wallet.TransactionTypeRollback, Amount: decimal.Zero, ReferenceID: reference, Currency: "EUR"})
+	require.NoError(t, err)
+	_, err = wal.ProcessTransaction(ctx, wallet.TransactionRequest{PlayerID: playerID, TransactionType: wallet.TransactionTypeBet, Amount: decimal.NewFromInt(10), ReferenceID: reference, Currency: "EUR"})
+	require.NoError(t, err)
+	rollback.RoundID = "legacy"
+	for i := 0; i < 2; i++ {
+		res, err := adapter.Rollback(ctx, "agg", rollback)
+		require.NoError(t, err)
+		require.True(t, decimal.NewFromInt(100).Equal(*res.Balance), "the bet is returned once")
+	}
+}
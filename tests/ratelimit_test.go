@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wallet_service/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRateLimitRoutes checks that a route is limited per player across
+// clients, that over-limit requests get 429 with Retry-After, and that
+// route overrides replace the defaults
+func TestRateLimitRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limits, err := ratelimit.ParseLimits("client=100/s,player=2/h:2,balance.player=off")
+	require.NoError(t, err)
+	policy := ratelimit.NewPolicy(ratelimit.NewMemoryLimiter(), limits)
+
+	r := gin.New()
+	r.POST("/transaction", policy.Route("transaction"), func(c *gin.Context) {
+		var req struct {
+			PlayerID string `json:"player_id"`
+		}
+		require.NoError(t, c.ShouldBindJSON(&req), "the handler still reads the body")
+		c.JSON(http.StatusOK, req)
+	})
+	r.GET("/balance/:player_id", policy.Route("balance"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	post := func(ip string, playerID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/transaction", strings.NewReader(`{"player_id":"`+playerID+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, post("10.0.0.1", "p1").Code)
+	require.Contains(t, post("10.0.0.2", "p1").Body.String(), `"player_id":"p1"`)
+	w := post("10.0.0.3", "p1")
+	require.Equal(t, http.StatusTooManyRequests, w.Code, "the player's bucket is shared by every client")
+	require.Equal(t, "1800", w.Header().Get("Retry-After"))
+	require.Equal(t, http.StatusOK, post("10.0.0.3", "p2").Code)
+
+	for i := 0; i < 5; i++ {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/balance/p1", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+// TestParseLimit checks limit specs and the token bucket refill
+func TestParseLimit(t *testing.T) {
+	limit, err := ratelimit.ParseLimit("6000/m")
+	require.NoError(t, err)
+	require.Equal(t, ratelimit.Limit{Rate: 100, Burst: 100}, limit)
+	limit, err = ratelimit.ParseLimit("1/h:3")
+	require.NoError(t, err)
+	require.Equal(t, 3, limit.Burst)
+	for _, bad := range []string{"100", "0/s", "10/d", "10/s:0", "x/s"} {
+		_, err := ratelimit.ParseLimit(bad)
+		require.Error(t, err, bad)
+	}
+	_, err = ratelimit.ParseLimits("transaction.wallet=1/s")
+	require.Error(t, err)
+
+	limiter := ratelimit.NewMemoryLimiter()
+	fast := ratelimit.Limit{Rate: 50, Burst: 1}
+	res, err := limiter.Allow(context.Background(), "k", fast)
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+	res, _ = limiter.Allow(context.Background(), "k", fast)
+	require.False(t, res.Allowed)
+	require.InDelta(t, 20*time.Millisecond, res.RetryAfter, float64(5*time.Millisecond))
+	time.Sleep(25 * time.Millisecond)
+	res, _ = limiter.Allow(context.Background(), "k", fast)
+	require.True(t, res.Allowed, "the bucket refills")
+}
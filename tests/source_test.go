@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"wallet_service/internal/bonus"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failingProcessor fails each bet in failOn that many times before it succeeds,
+// or forever when the count is negative. Bets in invalid always fail with
+// an error that retrying cannot fix
+type failingProcessor struct {
+	mu      sync.Mutex
+	failOn  map[string]int
+	invalid map[string]bool
+	seen    []string
+}
+
+func (p *failingProcessor) ProcessBetWagering(ctx context.Context, bet bonus.BetEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seen = append(p.seen, bet.BetID)
+	if p.invalid[bet.BetID] {
+		return bonus.ErrGameNotFound
+	}
+	if n := p.failOn[bet.BetID]; n != 0 {
+		p.failOn[bet.BetID] = n - 1
+		return errors.New("processing failed")
+	}
+	return nil
+}
+
+func (p *failingProcessor) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.seen)
+}
+
+type memoryDeadLetters struct {
+	mu   sync.Mutex
+	bets map[string]int
+}
+
+func (d *memoryDeadLetters) DeadLetter(ctx context.Context, bet bonus.BetEvent, cause error, attempts int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bets[bet.BetID] = attempts
+	return nil
+}
+
+// memoryDeadLetterRepo adds up the attempts stored per bet
+type memoryDeadLetterRepo struct {
+	bonus.DeadLetterRepository
+	mu       sync.Mutex
+	attempts map[string]int
+	saves    int
+}
+
+func (r *memoryDeadLetterRepo) SaveDeadLetter(ctx context.Context, dl *bonus.DeadLetterBet) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts[dl.BetID] += dl.Attempts
+	r.saves++
+	return nil
+}
+
+// consumeBroker runs a consumer of a fresh broker holding five bets until
+// the committed offset reaches want
+func consumeBroker(t *testing.T, processor bonus.BetProcessor, sink bonus.DeadLetterSink, want int64) *bonus.MemoryOffsetStore {
+	broker := bonus.NewMemoryBroker("bets")
+	offsets := bonus.NewMemoryOffsetStore()
+	for i := 1; i <= 5; i++ {
+		broker.Publish(bonus.BetEvent{BetID: fmt.Sprintf("bet-%d", i), PlayerID: "player"})
+	}
+
+	ingestor := bonus.NewIngestor(processor, bonus.IngestorConfig{Workers: 1, QueueSize: 10})
+	ingestor.Start()
+	source, err := broker.Subscribe(context.Background(), offsets, "wagering")
+	require.NoError(t, err)
+	consumer := bonus.NewConsumer(source, ingestor)
+	if sink != nil {
+		consumer.SetDeadLetterSink(sink)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- consumer.Run(ctx) }()
+
+	committed := func() int64 {
+		offset, err := offsets.LoadOffset(context.Background(), "wagering", "bets")
+		require.NoError(t, err)
+		return offset
+	}
+	require.Eventually(t, func() bool { return committed() == want }, 10*time.Second, 10*time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	require.NoError(t, ingestor.Shutdown(context.Background()))
+	return offsets
+}
+
+// TestBrokerRedeliversNackedBets tests at-least-once delivery through the in-memory broker
+// A failed bet is delivered again until it succeeds, and the committed offset then moves past it
+func TestBrokerRedeliversNackedBets(t *testing.T) {
+	processor := &failingProcessor{failOn: map[string]int{"bet-3": 2}}
+	consumeBroker(t, processor, nil, 5)
+	require.Equal(t, 7, processor.count())
+}
+
+// TestBrokerDeadLettersBetsThatKeepFailing tests that a bet failing on every delivery is
+// dead-lettered after the maximum number of deliveries instead of holding back the offset
+func TestBrokerDeadLettersBetsThatKeepFailing(t *testing.T) {
+	processor := &failingProcessor{failOn: map[string]int{"bet-3": -1}}
+	sink := &memoryDeadLetters{bets: make(map[string]int)}
+	consumeBroker(t, processor, sink, 5)
+	require.Equal(t, map[string]int{"bet-3": bonus.DefaultRedeliveryPolicy.MaxAttempts}, sink.bets)
+	require.Equal(t, 4+bonus.DefaultRedeliveryPolicy.MaxAttempts, processor.count())
+}
+
+// TestDeadLetterQueueLeavesRetriesToTheSource tests that the dead-letter queue processes
+// each delivery once: a bet that can never succeed is stored at once, and one that keeps
+// failing is redelivered by the source and stored once when the consumer gives up
+func TestDeadLetterQueueLeavesRetriesToTheSource(t *testing.T) {
+	processor := &failingProcessor{failOn: map[string]int{"bet-3": -1}, invalid: map[string]bool{"bet-2": true}}
+	repo := &memoryDeadLetterRepo{attempts: make(map[string]int)}
+	dlq := bonus.NewDeadLetterQueue(processor, repo)
+	consumeBroker(t, dlq, dlq, 5)
+	require.Equal(t, map[string]int{"bet-2": 1, "bet-3": bonus.DefaultRedeliveryPolicy.MaxAttempts}, repo.attempts)
+	require.Equal(t, 2, repo.saves)
+	require.Equal(t, 4+bonus.DefaultRedeliveryPolicy.MaxAttempts, processor.count())
+}
+
+// TestJSONLinesSourceResumesAfterCommittedOffset tests that a reopened file source skips acknowledged lines
+func TestJSONLinesSourceResumesAfterCommittedOffset(t *testing.T) {
+	input := strings.Join([]string{
+		`{"bet_id":"bet-1","player_id":"p","bet_amount":"5"}`,
+		``,
+		`not json`,
+		`{"bet_id":"bet-2","player_id":"p","bet_amount":"5"}`,
+		`{"bet_id":"bet-3","player_id":"p","bet_amount":"5"}`,
+	}, "\n")
+	offsets := bonus.NewMemoryOffsetStore()
+	ctx := context.Background()
+
+	source, err := bonus.NewJSONLinesSource(ctx, "bets.jsonl", strings.NewReader(input), offsets, "wagering")
+	require.NoError(t, err)
+	first, err := source.Receive(ctx)
+	require.NoError(t, err)
+	require.NoError(t, source.Ack(ctx, first))
+	second, err := source.Receive(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "bet-2", second.Bet.BetID)
+	require.NoError(t, source.Ack(ctx, second))
+
+	committed, err := offsets.LoadOffset(ctx, "wagering", "bets.jsonl")
+	require.NoError(t, err)
+	require.Equal(t, int64(4), committed)
+
+	reopened, err := bonus.NewJSONLinesSource(ctx, "bets.jsonl", strings.NewReader(input), offsets, "wagering")
+	require.NoError(t, err)
+	msg, err := reopened.Receive(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "bet-3", msg.Bet.BetID)
+}
+
+// TestJSONLinesSourceRedeliversBeforeEOF tests that a file source delivers a nacked line
+// again, and only reports the end of the input once every line is acknowledged
+func TestJSONLinesSourceRedeliversBeforeEOF(t *testing.T) {
+	input := `{"bet_id":"bet-1","player_id":"p","bet_amount":"5"}`
+	offsets := bonus.NewMemoryOffsetStore()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	source, err := bonus.NewJSONLinesSource(ctx, "bets.jsonl", strings.NewReader(input), offsets, "wagering")
+	require.NoError(t, err)
+	msg, err := source.Receive(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, msg.Attempt)
+	require.NoError(t, source.Nack(ctx, msg, errors.New("processing failed")))
+
+	again, err := source.Receive(ctx)
+	require.NoError(t, err)
+	require.Equal(t, msg.Offset, again.Offset)
+	require.Equal(t, 2, again.Attempt)
+	require.NoError(t, source.Ack(ctx, again))
+
+	_, err = source.Receive(ctx)
+	require.ErrorIs(t, err, io.EOF)
+}
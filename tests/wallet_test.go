@@ -2,6 +2,7 @@ package tests
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
@@ -196,3 +197,115 @@ func TestRaceCondition(t *testing.T) {
 	require.True(t, exactBalance.Equal(finalWallet.Balance), "finalBalance: expected %s, got %s", exactBalance, finalWallet.Balance)
 
 }
+
+// TestReplayMismatch checks that reusing a reference for another player,
+// amount or currency is refused instead of answered with the original
+// transaction
+func TestReplayMismatch(t *testing.T) {
+	w := setUpWallet(t, decimal.NewFromInt(50))
+	other := setUpWallet(t, decimal.NewFromInt(50))
+	service := wallet.NewService(wallet.NewWalletRepositoryImpl(db))
+	tx := wallet.TransactionRequest{
+		PlayerID:        w.PlayerID,
+		WalletType:      "main",
+		TransactionType: "withdrawal",
+		Amount:          decimal.NewFromInt(10),
+		ReferenceID:     uuid.NewString(),
+		Currency:        "USD",
+	}
+	_, err := service.ProcessTransaction(context.Background(), tx)
+	require.NoError(t, err)
+
+	otherPlayer := tx
+	otherPlayer.PlayerID = other.PlayerID
+	otherAmount := tx
+	otherAmount.Amount = decimal.NewFromInt(20)
+	otherCurrency := tx
+	otherCurrency.Currency = "EUR"
+	for name, req := range map[string]wallet.TransactionRequest{"player": otherPlayer, "amount": otherAmount, "currency": otherCurrency} {
+		_, err := service.ProcessTransaction(context.Background(), req)
+		if !errors.Is(err, wallet.ErrReferenceConflict) {
+			t.Fatalf("%s: expected ErrReferenceConflict, got %v", name, err)
+		}
+	}
+
+	balance, err := service.GetBalance(context.Background(), w.PlayerID, "main", "USD")
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(40).Equal(balance.Balance), "balance: expected 40, got %s", balance.Balance)
+	balance, err = service.GetBalance(context.Background(), other.PlayerID, "main", "USD")
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(50).Equal(balance.Balance), "other balance: expected 50, got %s", balance.Balance)
+}
+
+// TestParallelSameReference fires one reference from many goroutines at
+// once. Requests that pass the idempotency check together must still move
+// the balance once and all answer with the same transaction
+func TestParallelSameReference(t *testing.T) {
+	w := setUpWallet(t, decimal.NewFromInt(50))
+	service := wallet.NewService(wallet.NewWalletRepositoryImpl(db))
+
+	for _, transactionType := range []string{"withdrawal", "deposit"} {
+		tx := wallet.TransactionRequest{
+			PlayerID:        w.PlayerID,
+			WalletType:      "main",
+			TransactionType: transactionType,
+			Amount:          decimal.NewFromInt(10),
+			ReferenceID:     uuid.NewString(),
+			Currency:        "USD",
+		}
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		results := make([]*wallet.TransactionResponse, 20)
+		errs := make([]error, len(results))
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				results[i], errs[i] = service.ProcessTransaction(context.Background(), tx)
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+
+		for i := range results {
+			if errs[i] != nil {
+				t.Fatalf("%s %d: %v", transactionType, i, errs[i])
+			}
+			require.Equal(t, results[0].TransactionID, results[i].TransactionID, transactionType)
+		}
+	}
+
+	finalWallet, err := service.GetBalance(context.Background(), w.PlayerID, "main", "USD")
+	require.NoError(t, err)
+	require.True(t, decimal.NewFromInt(50).Equal(finalWallet.Balance), "finalBalance: expected 50, got %s", finalWallet.Balance)
+}
+
+// TestTransactionAmountSign checks that a negative withdrawal or bet cannot
+// credit a wallet, nor a negative deposit debit it, while the zero wins and
+// signed adjustments that are allowed still go through
+func TestTransactionAmountSign(t *testing.T) {
+	ctx := context.Background()
+	repo := &lockingWalletRepo{wallets: make(map[string]*wallet.Wallet)}
+	service := wallet.NewService(repo)
+	playerID := uuid.NewString()
+	transact := func(transactionType string, amount int64) error {
+		_, err := service.ProcessTransaction(ctx, wallet.TransactionRequest{
+			PlayerID: playerID, WalletType: wallet.WalletTypeMain, Currency: "USD",
+			TransactionType: transactionType, Amount: decimal.NewFromInt(amount), ReferenceID: uuid.NewString(),
+		})
+		return err
+	}
+
+	require.NoError(t, transact(wallet.TransactionTypeDeposit, 100))
+	require.ErrorIs(t, transact(wallet.TransactionTypeWithdrawal, -50), wallet.ErrInvalidAmount)
+	require.ErrorIs(t, transact(wallet.TransactionTypeBet, -50), wallet.ErrInvalidAmount)
+	require.ErrorIs(t, transact(wallet.TransactionTypeDeposit, -50), wallet.ErrInvalidAmount)
+	require.ErrorIs(t, transact(wallet.TransactionTypeWithdrawal, 0), wallet.ErrInvalidAmount)
+	require.True(t, decimal.NewFromInt(100).Equal(repo.wallets[playerID].Balance), "refused amounts leave the balance alone")
+
+	require.NoError(t, transact(wallet.TransactionTypeWin, 0))
+	require.NoError(t, transact(wallet.TransactionTypeAdjustment, -30))
+	require.True(t, decimal.NewFromInt(70).Equal(repo.wallets[playerID].Balance))
+}